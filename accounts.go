@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var affectedAccountsFile string
+
+// maxAffectedAccountsLine is the longest line read from the affected accounts
+// file. Accounts with many affected hostnames have very long lines.
+const maxAffectedAccountsLine = 64 << 20
+
+func init() {
+	flag.StringVar(&affectedAccountsFile, "affected-accounts-file", "", "Optional path to the companion dataset published by Let's Encrypt that lists the affected hostnames of each ACME account. If set, the Issuers and ClusterIssuers whose ACME accounts are affected are reported. Files ending in .gz are decompressed.")
+}
+
+// affectedAccount is an Issuer or ClusterIssuer whose ACME account has
+// affected hostnames.
+type affectedAccount struct {
+	// Kind is either Issuer or ClusterIssuer. Namespace is empty for
+	// ClusterIssuers.
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Server    string `json:"server"`
+	// Account is the ID of the ACME account, and AccountURI its URI as
+	// recorded in the status of the issuer.
+	Account    string `json:"account"`
+	AccountURI string `json:"accountURI"`
+	// Hostnames is the number of affected hostnames in the account.
+	Hostnames int `json:"hostnames"`
+}
+
+// acmeAccountID returns the ID of the ACME account with the given URI, or of
+// an account in the dataset, which may be listed by ID or by URI.
+func acmeAccountID(account string) string {
+	account = strings.TrimSuffix(strings.TrimSpace(account), "/")
+	if i := strings.LastIndex(account, "/"); i >= 0 {
+		account = account[i+1:]
+	}
+	return account
+}
+
+// findAffectedAccounts lists the ACME Issuers and ClusterIssuers in the
+// cluster and returns those whose accounts appear in
+// --affected-accounts-file, sorted by the number of affected hostnames.
+func findAffectedAccounts(ctx context.Context, cl client.Reader) ([]affectedAccount, error) {
+	var candidates []affectedAccount
+	var issuers capi.IssuerList
+	if err := cl.List(ctx, &issuers); err != nil {
+		return nil, fmt.Errorf("error listing Issuers: %w", err)
+	}
+	for _, iss := range issuers.Items {
+		if a, ok := acmeAccount("Issuer", iss.Namespace, iss.Name, iss.Spec.IssuerConfig, iss.Status); ok {
+			candidates = append(candidates, a)
+		}
+	}
+	var clusterIssuers capi.ClusterIssuerList
+	if err := cl.List(ctx, &clusterIssuers); err != nil {
+		return nil, fmt.Errorf("error listing ClusterIssuers: %w", err)
+	}
+	for _, iss := range clusterIssuers.Items {
+		if a, ok := acmeAccount("ClusterIssuer", "", iss.Name, iss.Spec.IssuerConfig, iss.Status); ok {
+			candidates = append(candidates, a)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Printf("No ACME Issuers or ClusterIssuers with a registered account were found")
+		return nil, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, a := range candidates {
+		wanted[a.Account] = true
+	}
+	hostnames, err := loadAffectedAccounts(affectedAccountsFile, wanted)
+	if err != nil {
+		return nil, err
+	}
+	var affected []affectedAccount
+	for _, a := range candidates {
+		if n := len(hostnames[a.Account]); n > 0 {
+			a.Hostnames = n
+			affected = append(affected, a)
+		}
+	}
+	sortAffectedAccounts(affected)
+	return affected, nil
+}
+
+// sortAffectedAccounts sorts accounts by the number of affected hostnames,
+// and then by kind, namespace and name.
+func sortAffectedAccounts(accounts []affectedAccount) {
+	sort.Slice(accounts, func(i, j int) bool {
+		a, b := accounts[i], accounts[j]
+		if a.Hostnames != b.Hostnames {
+			return a.Hostnames > b.Hostnames
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+// acmeAccount returns the ACME account of an issuer, if it has registered
+// one.
+func acmeAccount(kind, namespace, name string, spec capi.IssuerConfig, status capi.IssuerStatus) (affectedAccount, bool) {
+	if spec.ACME == nil || status.ACME == nil || status.ACME.URI == "" {
+		return affectedAccount{}, false
+	}
+	return affectedAccount{
+		Kind:       kind,
+		Namespace:  namespace,
+		Name:       name,
+		Server:     spec.ACME.Server,
+		Account:    acmeAccountID(status.ACME.URI),
+		AccountURI: status.ACME.URI,
+	}, true
+}
+
+// loadAffectedAccounts reads the dataset at path, in which each line lists an
+// ACME account followed by its affected hostnames, separated by whitespace or
+// commas, and returns the distinct hostnames of each of the wanted accounts.
+// Other accounts are ignored, as the dataset is too large to hold in memory.
+func loadAffectedAccounts(path string, wanted map[string]bool) (map[string]map[string]struct{}, error) {
+	log.Printf("Loading affected hostnames by ACME account from %q", path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading affected accounts file: %w", err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing affected accounts file: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	hostnames := make(map[string]map[string]struct{})
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxAffectedAccountsLine)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+		if len(fields) == 0 {
+			continue
+		}
+		account := acmeAccountID(strings.TrimSuffix(fields[0], ":"))
+		if !wanted[account] {
+			continue
+		}
+		if hostnames[account] == nil {
+			hostnames[account] = make(map[string]struct{})
+		}
+		for _, h := range fields[1:] {
+			hostnames[account][strings.ToLower(h)] = struct{}{}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading affected accounts file: %w", err)
+	}
+	return hostnames, nil
+}
+
+// logAffectedAccounts logs the issuers whose ACME accounts are affected.
+func logAffectedAccounts(accounts []affectedAccount) {
+	if len(accounts) == 0 {
+		log.Printf("  Issuers with affected ACME accounts: 0")
+		return
+	}
+	log.Printf("  Issuers with affected ACME accounts: %d", len(accounts))
+	for _, a := range accounts {
+		name := a.Name
+		if a.Namespace != "" {
+			name = a.Namespace + "/" + a.Name
+		}
+		log.Printf("    %s %s: account %s with %d affected hostname(s)", a.Kind, name, a.Account, a.Hostnames)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	adoptOrphanedSecrets bool
+	adoptIssuer          string
+	adoptIssuersFile     string
+
+	// adoptIssuers is the issuer of the Certificates created in each
+	// namespace, loaded from --adopt-issuer and --adopt-issuers-file.
+	adoptIssuers *adoptIssuerMapping
+)
+
+func init() {
+	flag.BoolVar(&adoptOrphanedSecrets, "adopt-orphaned-secrets", false, "If true with --secrets-only, a cert-manager Certificate is generated for each affected TLS Secret that no Certificate manages, using the issuer given by --adopt-issuer or --adopt-issuers-file, so that cert-manager takes over the Secret and renews it. The Certificates are only created, and renewed, if --renew is also set.")
+	flag.StringVar(&adoptIssuer, "adopt-issuer", "", "The issuer of the Certificates created by --adopt-orphaned-secrets, such as 'ClusterIssuer/letsencrypt-prod', or 'Issuer/letsencrypt' for an Issuer in the namespace of each Secret.")
+	flag.StringVar(&adoptIssuersFile, "adopt-issuers-file", "", "Optional path to a YAML file giving the issuer of the Certificates created by --adopt-orphaned-secrets in each namespace, overriding --adopt-issuer.")
+}
+
+// adoptIssuerMapping is the format of --adopt-issuers-file. For example:
+//
+//	default:
+//	  kind: ClusterIssuer
+//	  name: letsencrypt-prod
+//	namespaces:
+//	  team-a:
+//	    kind: Issuer
+//	    name: letsencrypt
+//
+// Secrets in namespaces that are not listed use default, if it is set, or
+// else --adopt-issuer. Secrets with no issuer are not adopted.
+type adoptIssuerMapping struct {
+	Default    *cmmeta.ObjectReference           `json:"default,omitempty"`
+	Namespaces map[string]cmmeta.ObjectReference `json:"namespaces,omitempty"`
+}
+
+// issuerFor returns the issuer of the Certificates created in namespace.
+func (m *adoptIssuerMapping) issuerFor(namespace string) (cmmeta.ObjectReference, bool) {
+	if ref, ok := m.Namespaces[namespace]; ok {
+		return ref, true
+	}
+	if m.Default != nil {
+		return *m.Default, true
+	}
+	return cmmeta.ObjectReference{}, false
+}
+
+func validateAdoptFlags() error {
+	if !adoptOrphanedSecrets {
+		if adoptIssuer != "" || adoptIssuersFile != "" {
+			return fmt.Errorf("--adopt-issuer and --adopt-issuers-file require --adopt-orphaned-secrets")
+		}
+		return nil
+	}
+	if !secretsOnly {
+		return fmt.Errorf("--adopt-orphaned-secrets requires --secrets-only, as only Secrets found by it may not be managed by a Certificate")
+	}
+	if adoptIssuer == "" && adoptIssuersFile == "" {
+		return fmt.Errorf("--adopt-orphaned-secrets requires --adopt-issuer or --adopt-issuers-file, to choose the issuer of the Certificates it creates")
+	}
+	adoptIssuers = &adoptIssuerMapping{}
+	if adoptIssuersFile != "" {
+		data, err := ioutil.ReadFile(adoptIssuersFile)
+		if err != nil {
+			return fmt.Errorf("error reading --adopt-issuers-file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, adoptIssuers); err != nil {
+			return fmt.Errorf("error decoding --adopt-issuers-file %q: %w", adoptIssuersFile, err)
+		}
+	}
+	if adoptIssuer != "" && adoptIssuers.Default == nil {
+		parts := strings.Split(adoptIssuer, "/")
+		if len(parts) != 2 || (parts[0] != capi.IssuerKind && parts[0] != capi.ClusterIssuerKind) || parts[1] == "" {
+			return fmt.Errorf("invalid --adopt-issuer %q, expected ClusterIssuer/<name> or Issuer/<name>", adoptIssuer)
+		}
+		adoptIssuers.Default = &cmmeta.ObjectReference{Kind: parts[0], Name: parts[1]}
+	}
+	refs := []cmmeta.ObjectReference{}
+	if adoptIssuers.Default != nil {
+		refs = append(refs, *adoptIssuers.Default)
+	}
+	for _, ref := range adoptIssuers.Namespaces {
+		refs = append(refs, ref)
+	}
+	for _, ref := range refs {
+		if ref.Name == "" || (ref.Kind != "" && ref.Kind != capi.IssuerKind && ref.Kind != capi.ClusterIssuerKind) {
+			return fmt.Errorf("invalid issuer %+v for --adopt-orphaned-secrets, each must have a name and a kind of Issuer or ClusterIssuer", ref)
+		}
+	}
+	return nil
+}
+
+// adoptSecrets creates a Certificate for each affected Secret found by a
+// --secrets-only scan that no Certificate manages, and triggers its renewal,
+// if --renew is set. Otherwise, the Certificates are only logged.
+func adoptSecrets(ctx context.Context, cl client.Client, results *scanResults) error {
+	log.Println()
+	if !renew {
+		log.Printf("Will NOT create Certificates for orphaned Secrets as --renew set to false, the following would be created:")
+	} else {
+		log.Printf("Creating Certificates for orphaned Secrets:")
+	}
+	managed := make(map[string]map[string]string)
+	adopted := 0
+	for _, a := range results.Affected {
+		secretName := a.Certificate.Namespace + "/" + a.Certificate.Spec.SecretName
+		if a.ReplicatedFrom != "" {
+			log.Printf("Secret %s is a replica of Secret %s, so it is not adopted, and will be fixed by replication once the source is", secretName, a.ReplicatedFrom)
+			continue
+		}
+		if a.Warning != "" {
+			log.Printf("WARNING: NOT adopting Secret %s, as it matched the affected serials, but %s", secretName, a.Warning)
+			continue
+		}
+		crt, reason, err := adoptionCertificate(ctx, cl, managed, a.Certificate.Namespace, a.Certificate.Spec.SecretName)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			log.Printf("WARNING: NOT adopting Secret %s, as %s", secretName, reason)
+			continue
+		}
+		log.Printf("  * Certificate %s/%s for Secret %s, issued by %s %q for %s", crt.Namespace, crt.Name, secretName, crt.Spec.IssuerRef.Kind, crt.Spec.IssuerRef.Name, strings.Join(crt.Spec.DNSNames, ", "))
+		adopted++
+		if !renew {
+			continue
+		}
+		err = cl.Create(ctx, crt)
+		if auditErr := auditLog.record("create", "Certificate", crt.Namespace, crt.Name, crt.Spec, "adopt orphaned Secret "+crt.Spec.SecretName, err); auditErr != nil {
+			return auditErr
+		}
+		if apierrors.IsAlreadyExists(err) {
+			log.Printf("WARNING: NOT adopting Secret %s, as a Certificate named %s/%s already exists", secretName, crt.Namespace, crt.Name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error creating Certificate %s/%s: %w", crt.Namespace, crt.Name, err)
+		}
+		// cert-manager may not reissue a certificate that already matches
+		// the new Certificate, so a renewal is triggered as usual.
+		results.startRotation(ctx, cl, a.Certificate, outcomeAdoption)
+		log.Printf("Triggering renewal of adopted Certificate %s/%s", crt.Namespace, crt.Name)
+		err = renewCertificate(ctx, cl, *crt)
+		results.recordRenewal(a.Certificate, err)
+		if err != nil {
+			metricRenewals.WithLabelValues("failed").Inc()
+			log.Printf("Failed to renew adopted Certificate %s/%s: %v", crt.Namespace, crt.Name, err)
+			return err
+		}
+		metricRenewals.WithLabelValues("triggered").Inc()
+	}
+	if adopted == 0 {
+		log.Printf("  (none)")
+	}
+	return nil
+}
+
+// adoptionCertificate returns the Certificate that would adopt the Secret
+// with the given namespace and name, or why it should not be adopted. managed
+// caches the names of the Secrets managed by the Certificates in each
+// namespace, and the Certificates managing them.
+func adoptionCertificate(ctx context.Context, cl client.Reader, managed map[string]map[string]string, namespace, name string) (*capi.Certificate, string, error) {
+	if managed[namespace] == nil {
+		var list capi.CertificateList
+		if err := cl.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+			return nil, "", fmt.Errorf("error listing Certificate resources in namespace %q: %w", namespace, err)
+		}
+		managed[namespace] = make(map[string]string)
+		for _, crt := range list.Items {
+			managed[namespace][crt.Spec.SecretName] = crt.Name
+		}
+	}
+	if crt, ok := managed[namespace][name]; ok {
+		return nil, fmt.Sprintf("it is already managed by Certificate %q", crt), nil
+	}
+	var secret core.Secret
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &secret); err != nil {
+		return nil, fmt.Sprintf("it could not be retrieved: %v", err), nil
+	}
+	if crt := secret.Annotations[capi.CertificateNameKey]; crt != "" {
+		return nil, fmt.Sprintf("it was issued by cert-manager for Certificate %q, which has been deleted. Recreate the Certificate instead", crt), nil
+	}
+	if owner := metav1.GetControllerOf(&secret); owner != nil {
+		return nil, fmt.Sprintf("it is managed by %s %q, which would conflict with cert-manager", owner.Kind, owner.Name), nil
+	}
+	issuer, ok := adoptIssuers.issuerFor(namespace)
+	if !ok {
+		return nil, "no issuer is given for its namespace by --adopt-issuers-file", nil
+	}
+	if issuer.Kind == "" {
+		issuer.Kind = capi.IssuerKind
+	}
+	cert, err := scanner.DecodeCertificate(&secret)
+	if err != nil {
+		return nil, fmt.Sprintf("its certificate could not be decoded: %v", err), nil
+	}
+	if len(cert.DNSNames) == 0 {
+		return nil, "its certificate has no DNS names", nil
+	}
+	crt := &capi.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{runIDAnnotation: runID},
+		},
+		Spec: capi.CertificateSpec{
+			SecretName: name,
+			CommonName: cert.Subject.CommonName,
+			DNSNames:   cert.DNSNames,
+			IssuerRef:  issuer,
+		},
+	}
+	setKeyAlgorithm(&crt.Spec, cert)
+	return crt, "", nil
+}
+
+// setKeyAlgorithm sets the key algorithm and size of spec to those of cert,
+// so that services using the Secret are not given a different kind of key.
+func setKeyAlgorithm(spec *capi.CertificateSpec, cert *x509.Certificate) {
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		spec.KeyAlgorithm, spec.KeySize = capi.RSAKeyAlgorithm, k.N.BitLen()
+	case *ecdsa.PublicKey:
+		spec.KeyAlgorithm, spec.KeySize = capi.ECDSAKeyAlgorithm, k.Curve.Params().BitSize
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+var (
+	pagerDutyRoutingKeyFile string
+	opsgenieAPIKeyFile      string
+	opsgenieAPIURL          string
+	alertIncident           string
+)
+
+func init() {
+	flag.StringVar(&pagerDutyRoutingKeyFile, "pagerduty-routing-key-file", "", "The path to a file containing a PagerDuty Events API v2 routing key. If set, an alert is triggered when a scan finds affected certificates or renewals fail, and resolved once a scan finds none.")
+	flag.StringVar(&opsgenieAPIKeyFile, "opsgenie-api-key-file", "", "The path to a file containing an Opsgenie API key. If set, an alert is created when a scan finds affected certificates or renewals fail, and closed once a scan finds none.")
+	flag.StringVar(&opsgenieAPIURL, "opsgenie-api-url", "https://api.opsgenie.com", "The Opsgenie API URL. Set to https://api.eu.opsgenie.com for accounts in the EU.")
+	flag.StringVar(&alertIncident, "alert-incident", "letsencrypt-caa-2020", "Identifies the incident in alert deduplication keys, so that alerts for the same cluster and incident are grouped together.")
+}
+
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	// alertMaxFailures is the number of failed renewals included in alert
+	// details.
+	alertMaxFailures = 10
+)
+
+// alertDedupKey identifies the alert for this cluster and incident, so that
+// repeated scans update one alert rather than opening new ones.
+func alertDedupKey() string {
+	key := "letsencrypt-caa-bug-checker:" + alertIncident
+	if clusterName != "" {
+		key += ":" + clusterName
+	}
+	return key
+}
+
+// alertSource identifies where alerts come from.
+func alertSource() string {
+	if clusterName != "" {
+		return clusterName
+	}
+	return "letsencrypt-caa-bug-checker"
+}
+
+// alertAction is what a scan means for the alert.
+type alertAction int
+
+const (
+	alertNone alertAction = iota
+	alertTrigger
+	alertResolve
+)
+
+// alertActionFor returns whether n should trigger or resolve the alert. A
+// failed scan does neither, as it is not known whether the cluster is clean.
+func alertActionFor(n *scanNotification) alertAction {
+	switch {
+	case n.Summary.Error != "":
+		return alertNone
+	case n.Summary.Affected > 0 || n.Summary.RenewalFailed > 0:
+		return alertTrigger
+	default:
+		return alertResolve
+	}
+}
+
+// alertDetails returns the details attached to alerts.
+func alertDetails(n *scanNotification) map[string]string {
+	details := map[string]string{
+		"checked":          fmt.Sprint(n.Summary.Checked),
+		"skipped":          fmt.Sprint(n.Summary.Skipped),
+		"affected":         fmt.Sprint(n.Summary.Affected),
+		"renewalTriggered": fmt.Sprint(n.Summary.RenewalTriggered),
+		"renewalFailed":    fmt.Sprint(n.Summary.RenewalFailed),
+	}
+	var top []string
+	for _, ns := range n.topNamespaces(slackTopNamespaces) {
+		top = append(top, fmt.Sprintf("%s (%d)", ns.Namespace, ns.Affected))
+	}
+	if len(top) > 0 {
+		details["topNamespaces"] = strings.Join(top, ", ")
+	}
+	var failed []string
+	for _, c := range n.Certificates {
+		if c.RenewalError == "" {
+			continue
+		}
+		if len(failed) == alertMaxFailures {
+			failed = append(failed, fmt.Sprintf("...and %d more", n.Summary.RenewalFailed-alertMaxFailures))
+			break
+		}
+		failed = append(failed, fmt.Sprintf("%s/%s: %s", c.Namespace, c.Name, c.RenewalError))
+	}
+	if len(failed) > 0 {
+		details["failedRenewals"] = strings.Join(failed, "\n")
+	}
+	return details
+}
+
+// readKeyFile reads a credential from path.
+func readKeyFile(path, what string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s file: %w", what, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// pagerDutyNotifier triggers and resolves a PagerDuty alert using the Events
+// API v2.
+type pagerDutyNotifier struct {
+	routingKey string
+}
+
+func newPagerDutyNotifier() (*pagerDutyNotifier, error) {
+	key, err := readKeyFile(pagerDutyRoutingKeyFile, "PagerDuty routing key")
+	if err != nil {
+		return nil, err
+	}
+	return &pagerDutyNotifier{routingKey: key}, nil
+}
+
+func (p *pagerDutyNotifier) name() string { return "PagerDuty" }
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	CustomDetails map[string]string `json:"custom_details"`
+}
+
+func (p *pagerDutyNotifier) scanComplete(ctx context.Context, n *scanNotification) error {
+	event := pagerDutyEvent{RoutingKey: p.routingKey, DedupKey: alertDedupKey()}
+	switch alertActionFor(n) {
+	case alertTrigger:
+		severity := "warning"
+		if n.Summary.RenewalFailed > 0 {
+			severity = "error"
+		}
+		event.EventAction = "trigger"
+		event.Payload = &pagerDutyPayload{Summary: n.title(), Source: alertSource(), Severity: severity, CustomDetails: alertDetails(n)}
+	case alertResolve:
+		event.EventAction = "resolve"
+	default:
+		return nil
+	}
+	return postJSON(ctx, pagerDutyEventsURL, event, nil, nil)
+}
+
+// certificateAffected does nothing, as alerts are raised per scan.
+func (p *pagerDutyNotifier) certificateAffected(context.Context, finding) error {
+	return nil
+}
+
+// opsgenieNotifier creates and closes an Opsgenie alert.
+type opsgenieNotifier struct {
+	apiURL string
+	apiKey string
+}
+
+func newOpsgenieNotifier() (*opsgenieNotifier, error) {
+	key, err := readKeyFile(opsgenieAPIKeyFile, "Opsgenie API key")
+	if err != nil {
+		return nil, err
+	}
+	return &opsgenieNotifier{apiURL: strings.TrimSuffix(opsgenieAPIURL, "/"), apiKey: key}, nil
+}
+
+func (o *opsgenieNotifier) name() string { return "Opsgenie" }
+
+type opsgenieAlert struct {
+	Message  string            `json:"message"`
+	Alias    string            `json:"alias"`
+	Source   string            `json:"source"`
+	Priority string            `json:"priority"`
+	Details  map[string]string `json:"details"`
+}
+
+func (o *opsgenieNotifier) scanComplete(ctx context.Context, n *scanNotification) error {
+	headers := map[string]string{"Authorization": "GenieKey " + o.apiKey}
+	switch alertActionFor(n) {
+	case alertTrigger:
+		// Opsgenie deduplicates open alerts with the same alias, so this
+		// updates the existing alert if there is one.
+		priority := "P3"
+		if n.Summary.RenewalFailed > 0 {
+			priority = "P2"
+		}
+		alert := opsgenieAlert{Message: n.title(), Alias: alertDedupKey(), Source: alertSource(), Priority: priority, Details: alertDetails(n)}
+		return postJSON(ctx, o.apiURL+"/v2/alerts", alert, headers, nil)
+	case alertResolve:
+		u := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", o.apiURL, url.PathEscape(alertDedupKey()))
+		return postJSON(ctx, u, map[string]string{"source": alertSource()}, headers, nil)
+	default:
+		return nil
+	}
+}
+
+// certificateAffected does nothing, as alerts are raised per scan.
+func (o *opsgenieNotifier) certificateAffected(context.Context, finding) error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	lecaa "github.com/jetstack/letsencrypt-caa-bug-checker/pkg/apis/lecaa/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	apiAddr      string
+	apiTokenFile string
+)
+
+func init() {
+	flag.StringVar(&apiAddr, "api-addr", "", "If set, the 'serve' command will serve an HTTP API returning the current findings as JSON on this address.")
+	flag.StringVar(&apiTokenFile, "api-token-file", "", "The path to a file containing the bearer token that clients of the HTTP API must present. Required if --api-addr is set.")
+}
+
+// apiServer serves the current findings of the 'serve' command over HTTP.
+type apiServer struct {
+	client   client.Reader
+	findings *findings
+	history  *runHistory
+	token    []byte
+}
+
+// startAPIServer starts serving the HTTP API on --api-addr, if set.
+func startAPIServer(cl client.Reader, f *findings, h *runHistory) error {
+	if apiAddr == "" {
+		return nil
+	}
+	if apiTokenFile == "" {
+		return fmt.Errorf("--api-token-file must be set when --api-addr is set")
+	}
+	token, err := ioutil.ReadFile(apiTokenFile)
+	if err != nil {
+		return fmt.Errorf("error reading API token file: %w", err)
+	}
+	s := &apiServer{client: cl, findings: f, history: h, token: []byte(strings.TrimSpace(string(token)))}
+	if len(s.token) == 0 {
+		return fmt.Errorf("API token file %q is empty", apiTokenFile)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/affected", s.handler(s.serveAffected))
+	mux.Handle("/api/v1/scans", s.handler(s.serveScans))
+	mux.Handle("/api/v1/runs", s.handler(s.serveRuns))
+	go func() {
+		log.Printf("Serving HTTP API on %s", apiAddr)
+		if err := http.ListenAndServe(apiAddr, mux); err != nil {
+			log.Printf("HTTP API server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// handler wraps fn with authentication, and only calls it for GET requests
+// once the controllers have started.
+func (s *apiServer) handler(fn func(ctx context.Context) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !s.findings.isActive() {
+			writeJSONError(w, http.StatusServiceUnavailable, "this replica is not currently checking Certificates")
+			return
+		}
+		resp, err := fn(r.Context())
+		if err != nil {
+			log.Printf("Failed to serve %s: %v", r.URL.Path, err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+func (s *apiServer) authorized(r *http.Request) bool {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), s.token) == 1
+}
+
+// affectedResponse is returned by /api/v1/affected.
+type affectedResponse struct {
+	Cluster string `json:"cluster,omitempty"`
+	// Clean is true if no Certificates are currently affected.
+	Clean    bool      `json:"clean"`
+	Affected []finding `json:"affected"`
+}
+
+func (s *apiServer) serveAffected(_ context.Context) (interface{}, error) {
+	affected := s.findings.list()
+	return affectedResponse{Cluster: clusterName, Clean: len(affected) == 0, Affected: affected}, nil
+}
+
+// scansResponse is returned by /api/v1/scans.
+type scansResponse struct {
+	Scans []scanSummary `json:"scans"`
+}
+
+type scanSummary struct {
+	Name   string                 `json:"name"`
+	Status lecaa.CAABugScanStatus `json:"status"`
+}
+
+func (s *apiServer) serveScans(ctx context.Context) (interface{}, error) {
+	resp := scansResponse{Scans: []scanSummary{}}
+	var scans lecaa.CAABugScanList
+	if err := s.client.List(ctx, &scans); err != nil {
+		if meta.IsNoMatchError(err) {
+			return resp, nil
+		}
+		return nil, fmt.Errorf("error listing CAABugScan resources: %w", err)
+	}
+	for _, scan := range scans.Items {
+		resp.Scans = append(resp.Scans, scanSummary{Name: scan.Name, Status: scan.Status})
+	}
+	return resp, nil
+}
+
+// runsResponse is returned by /api/v1/runs.
+type runsResponse struct {
+	Runs []runSummary `json:"runs"`
+}
+
+func (s *apiServer) serveRuns(_ context.Context) (interface{}, error) {
+	return runsResponse{Runs: s.history.list()}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write HTTP response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
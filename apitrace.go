@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var apiTraceFile string
+
+func init() {
+	flag.StringVar(&apiTraceFile, "api-trace-file", "", "Optional path to a file that a JSON record of every request made to the Kubernetes API server, including reads, is appended to.")
+}
+
+// apiTraceRecord is a line in --api-trace-file.
+type apiTraceRecord struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Time          time.Time `json:"time"`
+	RunID         string    `json:"runId"`
+	// Verb is the Kubernetes verb of the request, such as get, list, watch,
+	// create, update, patch or delete.
+	Verb        string `json:"verb"`
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	// Path is set instead of Resource for requests that are not for a
+	// resource, such as discovery.
+	Path string `json:"path,omitempty"`
+	// Status is the HTTP status of the response, or 0 if none was received,
+	// in which case Error is set.
+	Status          int     `json:"status"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// apiTracer appends an apiTraceRecord for every request to a file.
+type apiTracer struct {
+	lock sync.Mutex
+	file *os.File
+	// failed is set once a record could not be written.
+	failed bool
+}
+
+// apiTrace is set by openAPITrace if --api-trace-file is set.
+var apiTrace *apiTracer
+
+// openAPITrace opens --api-trace-file for appending, returning a function
+// that closes it. It must be called before restConfig, which only traces
+// requests if it is open.
+func openAPITrace() (func(), error) {
+	if apiTraceFile == "" {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(apiTraceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening API trace file: %w", err)
+	}
+	apiTrace = &apiTracer{file: f}
+	log.Printf("Recording every request to the API server in %q", apiTraceFile)
+	return func() {
+		apiTrace.lock.Lock()
+		defer apiTrace.lock.Unlock()
+		if err := f.Close(); err != nil {
+			log.Printf("Failed to close API trace file: %v", err)
+		}
+	}, nil
+}
+
+// apiTraceTransport records each request sent to the API server. It is the
+// innermost wrapper, so that each retry is recorded, and requests refused by
+// --read-only, which are never sent, are not.
+type apiTraceTransport struct {
+	next   http.RoundTripper
+	tracer *apiTracer
+}
+
+func wrapAPITrace(rt http.RoundTripper) http.RoundTripper {
+	return &apiTraceTransport{next: rt, tracer: apiTrace}
+}
+
+func (t *apiTraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.tracer.broken(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	r := newAPITraceRecord(req)
+	r.Time = start.UTC()
+	r.DurationSeconds = time.Since(start).Seconds()
+	if err != nil {
+		r.Error = scrubSecrets(err.Error())
+	} else {
+		r.Status = resp.StatusCode
+	}
+	t.tracer.record(r)
+	return resp, err
+}
+
+// newAPITraceRecord describes req, working out the resource it is for from
+// its path in the same way as the API server.
+func newAPITraceRecord(req *http.Request) apiTraceRecord {
+	r := apiTraceRecord{SchemaVersion: apiTraceRecordSchemaVersion, RunID: runID}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		r.Version, parts = parts[1], parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		r.Group, r.Version, parts = parts[1], parts[2], parts[3:]
+	default:
+		r.Verb = strings.ToLower(req.Method)
+		r.Path = req.URL.Path
+		return r
+	}
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		r.Namespace, parts = parts[1], parts[2:]
+	}
+	r.Resource, parts = parts[0], parts[1:]
+	if len(parts) > 0 {
+		r.Name, parts = parts[0], parts[1:]
+	}
+	if len(parts) > 0 {
+		r.Subresource = strings.Join(parts, "/")
+	}
+	if r.Resource == "namespaces" && r.Name == "" && r.Namespace != "" {
+		// A request for the Namespace itself, such as GET
+		// /api/v1/namespaces/default.
+		r.Name, r.Namespace = r.Namespace, ""
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		switch {
+		case req.URL.Query().Get("watch") == "true" || req.URL.Query().Get("watch") == "1":
+			r.Verb = "watch"
+		case r.Name == "":
+			r.Verb = "list"
+		default:
+			r.Verb = "get"
+		}
+	case http.MethodPost:
+		r.Verb = "create"
+	case http.MethodPut:
+		r.Verb = "update"
+	case http.MethodPatch:
+		r.Verb = "patch"
+	case http.MethodDelete:
+		if r.Name == "" {
+			r.Verb = "deletecollection"
+		} else {
+			r.Verb = "delete"
+		}
+	default:
+		r.Verb = strings.ToLower(req.Method)
+	}
+	return r
+}
+
+// record appends r to the trace. The request has already been made, so a
+// failure is logged, and any further requests are refused by broken.
+func (a *apiTracer) record(r apiTraceRecord) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	line, err := json.Marshal(r)
+	if err == nil {
+		_, err = a.file.Write(append(line, '\n'))
+	}
+	if err != nil && !a.failed {
+		a.failed = true
+		log.Printf("Failed to write to the API trace file, no further requests will be made: %v", err)
+	}
+}
+
+// broken returns an error if a record could not be written, so that the
+// trace is never silently incomplete.
+func (a *apiTracer) broken() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.failed {
+		return fmt.Errorf("refusing to make further requests to the API server, as the API trace file could not be written")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var auditLogFile string
+
+func init() {
+	flag.StringVar(&auditLogFile, "audit-log-file", "", "Optional path to a file that a JSON record of every change made to the cluster is appended to. If a record cannot be written, the renewal making the change fails.")
+}
+
+// auditRecord is a line in --audit-log-file.
+type auditRecord struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Time          time.Time `json:"time"`
+	RunID         string    `json:"runId"`
+	Action        string    `json:"action"`
+	Kind          string    `json:"kind"`
+	Namespace     string    `json:"namespace,omitempty"`
+	Name          string    `json:"name"`
+	// Patch describes the change made, as a JSON merge patch. It is not set
+	// for deletions.
+	Patch json.RawMessage `json:"patch,omitempty"`
+	// Reason describes why the change was made.
+	Reason string `json:"reason,omitempty"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditLogger appends auditRecords to a file. A nil *auditLogger discards
+// records, so that callers do not need to check whether auditing is enabled.
+type auditLogger struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// auditLog is set by openAuditLog if --audit-log-file is set.
+var auditLog *auditLogger
+
+// openAuditLog opens --audit-log-file for appending, returning a function
+// that closes it.
+func openAuditLog() (func(), error) {
+	if auditLogFile == "" {
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(auditLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log file: %w", err)
+	}
+	auditLog = &auditLogger{file: f}
+	log.Printf("Recording changes made to the cluster in %q", auditLogFile)
+	return func() {
+		auditLog.lock.Lock()
+		defer auditLog.lock.Unlock()
+		if err := f.Close(); err != nil {
+			log.Printf("Failed to close audit log file: %v", err)
+		}
+	}, nil
+}
+
+// record appends a record of a change to the object of the given kind,
+// namespace and name. patch is encoded as JSON, and err is the result of
+// making the change. The record is synced to disk before returning.
+func (a *auditLogger) record(action, kind, namespace, name string, patch interface{}, reason string, err error) error {
+	if a == nil {
+		return nil
+	}
+	r := auditRecord{SchemaVersion: auditRecordSchemaVersion, Time: time.Now().UTC(), RunID: runID, Action: action, Kind: kind, Namespace: namespace, Name: name, Reason: reason, Result: "success"}
+	if err != nil {
+		r.Result = "error"
+		r.Error = scrubSecrets(err.Error())
+	}
+	if patch != nil {
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return fmt.Errorf("error encoding audit record: %w", err)
+		}
+		r.Patch = data
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error encoding audit record: %w", err)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing audit record: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("error writing audit record: %w", err)
+	}
+	return nil
+}
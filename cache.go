@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/metadata"
+)
+
+// secretCache records the serial number of the certificate stored in each
+// Secret, along with the resourceVersion of the Secret it was read from. It
+// is persisted to disk between runs, so that Secrets that have not changed do
+// not need to be fetched and decoded again. It is safe for concurrent use.
+//
+// Only serial numbers are cached, not whether they are affected, so the cache
+// remains valid if the affected serials file is updated between runs.
+type secretCache struct {
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+	// seen records the keys looked up or stored during this run. Only these
+	// are saved, so entries for deleted Secrets do not accumulate.
+	seen map[string]struct{}
+}
+
+type cacheEntry struct {
+	ResourceVersion string `json:"resourceVersion"`
+	Serial          string `json:"serial"`
+}
+
+// loadSecretCache reads the cache file at path. If the file does not exist,
+// an empty cache is returned.
+func loadSecretCache(path string) (*secretCache, error) {
+	c := &secretCache{
+		entries: make(map[string]cacheEntry),
+		seen:    make(map[string]struct{}),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// lookup returns the cached serial number for the Secret with the given
+// namespace/name key, if the Secret is unchanged since it was cached.
+func (c *secretCache) lookup(key, resourceVersion string) (*big.Int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.ResourceVersion != resourceVersion {
+		return nil, false
+	}
+	serial, ok := new(big.Int).SetString(entry.Serial, 16)
+	if !ok {
+		return nil, false
+	}
+	c.seen[key] = struct{}{}
+	return serial, true
+}
+
+func (c *secretCache) store(key, resourceVersion string, serial *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = cacheEntry{ResourceVersion: resourceVersion, Serial: serial.Text(16)}
+	c.seen[key] = struct{}{}
+}
+
+// save writes the cache to path, replacing it atomically.
+func (c *secretCache) save(path string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries := make(map[string]cacheEntry, len(c.seen))
+	for key := range c.seen {
+		entries[key] = c.entries[key]
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// metadataSerialCache implements scanner.SerialCache using a secretCache,
+// fetching only the metadata of each Secret to check whether it has changed.
+type metadataSerialCache struct {
+	cache    *secretCache
+	metadata metadata.Interface
+}
+
+func (c *metadataSerialCache) Lookup(ctx context.Context, namespace, name string) (*big.Int, bool) {
+	_, sp := startSpan(ctx, "api.get-secret-metadata")
+	meta, err := c.metadata.Resource(core.SchemeGroupVersion.WithResource("secrets")).Namespace(namespace).Get(name, metav1.GetOptions{})
+	sp.finish(err)
+	if err != nil {
+		return nil, false
+	}
+	return c.cache.lookup(namespace+"/"+name, meta.ResourceVersion)
+}
+
+func (c *metadataSerialCache) Store(secret *core.Secret, serial *big.Int) {
+	c.cache.store(secret.Namespace+"/"+secret.Name, secret.ResourceVersion, serial)
+}
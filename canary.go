@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	canaryCount   int
+	canaryTimeout time.Duration
+)
+
+func init() {
+	flag.IntVar(&canaryCount, "canary", 0, "If set with --renew, this many Certificates are renewed first, drawn from as many issuers as possible, and the rest are only renewed once each canary has been issued a new certificate that is not affected. Protects against a misconfigured issuer turning a bulk renewal into a bulk outage.")
+	flag.DurationVar(&canaryTimeout, "canary-timeout", 15*time.Minute, "How long to wait for each --canary Certificate to be issued a new certificate before giving up on the renewal.")
+}
+
+func validateCanaryFlags() error {
+	if canaryCount < 0 {
+		return fmt.Errorf("--canary must not be negative")
+	}
+	if canaryCount > 0 && !renew {
+		return fmt.Errorf("--canary requires --renew")
+	}
+	if canaryTimeout <= 0 {
+		return fmt.Errorf("--canary-timeout must be positive")
+	}
+	return nil
+}
+
+// pickCanaries returns up to n of targets to be renewed first, and the rest.
+// Canaries are taken from each issuer in turn, so that a broken issuer is
+// noticed even if most Certificates use another one.
+func pickCanaries(targets []capi.Certificate, n int) (canaries, rest []capi.Certificate) {
+	var issuers []string
+	byIssuer := make(map[string][]int)
+	for i := range targets {
+		key := scanner.IssuerKey(&targets[i])
+		if _, ok := byIssuer[key]; !ok {
+			issuers = append(issuers, key)
+		}
+		byIssuer[key] = append(byIssuer[key], i)
+	}
+	picked := make(map[int]bool)
+	for round, more := 0, true; more && len(picked) < n; round++ {
+		more = false
+		for _, key := range issuers {
+			if len(picked) == n {
+				break
+			}
+			// Canaries are renewed together, so no more than the
+			// --issuer-concurrency limit are taken from each issuer.
+			if limit := issuerLimit(&targets[byIssuer[key][0]]); limit > 0 && round >= limit {
+				continue
+			}
+			if round < len(byIssuer[key]) {
+				picked[byIssuer[key][round]] = true
+				more = true
+			}
+		}
+	}
+	for i, crt := range targets {
+		if picked[i] {
+			canaries = append(canaries, crt)
+		} else {
+			rest = append(rest, crt)
+		}
+	}
+	return canaries, rest
+}
+
+// renewCanaries renews --canary of targets and waits for each to be issued a
+// new certificate that is not affected, returning the targets left to renew.
+// It returns an error if any canary fails, in which case no other
+// Certificate should be renewed.
+func renewCanaries(ctx context.Context, cl client.Client, results *scanResults, targets []capi.Certificate) ([]capi.Certificate, error) {
+	canaries, rest := pickCanaries(targets, canaryCount)
+	log.Printf("Renewing %d canary Certificate(s) first, the remaining renewals will only be triggered once they have been issued new certificates", len(canaries))
+	type triggered struct {
+		crt      capi.Certificate
+		previous string
+		since    time.Time
+	}
+	var waiting []triggered
+	failed := 0
+	for _, crt := range canaries {
+		since := time.Now()
+		previous := results.startRotation(ctx, cl, crt, outcomeCanaryRenewal)
+		log.Printf("Triggering renewal of canary Certificate %s/%s", crt.Namespace, crt.Name)
+		err := renewCertificate(ctx, cl, crt)
+		results.recordRenewal(crt, err)
+		if err != nil {
+			metricRenewals.WithLabelValues("failed").Inc()
+			log.Printf("Failed to renew canary Certificate %s/%s: %v", crt.Namespace, crt.Name, err)
+			failed++
+			continue
+		}
+		metricRenewals.WithLabelValues("triggered").Inc()
+		waiting = append(waiting, triggered{crt: crt, previous: previous, since: since})
+	}
+
+	if fixturesDir != "" {
+		log.Printf("[simulation] Not waiting for the canaries to be issued new certificates, as cert-manager is not running")
+	} else if len(waiting) > 0 {
+		log.Printf("Waiting up to %s for %d canary Certificate(s) to be issued new certificates...", canaryTimeout, len(waiting))
+		r := newRenewer(cl)
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		for _, t := range waiting {
+			wg.Add(1)
+			go func(t triggered) {
+				defer wg.Done()
+				err := verifyCanary(ctx, r, results.scanner, t.crt, t.previous, t.since)
+				lock.Lock()
+				defer lock.Unlock()
+				if err != nil {
+					log.Printf("Canary Certificate %s/%s FAILED: %v", t.crt.Namespace, t.crt.Name, err)
+					results.recordRenewal(t.crt, err)
+					failed++
+				}
+			}(t)
+		}
+		wg.Wait()
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("%d of %d canary renewal(s) failed, so the remaining %d Certificate(s) were NOT renewed. Fix the cause and run again", failed, len(canaries), len(rest))
+	}
+	if len(rest) > 0 {
+		log.Printf("All %d canary renewal(s) succeeded, renewing the remaining %d Certificate(s)", len(canaries), len(rest))
+	}
+	return rest, nil
+}
+
+// verifyCanary waits for crt, whose Secret held the certificate with the
+// serial number previous when its renewal was triggered at since, to be
+// issued a new certificate, and checks the new certificate with s.
+func verifyCanary(ctx context.Context, r *renewer.Renewer, s *scanner.Scanner, crt capi.Certificate, previous string, since time.Time) error {
+	secret, err := r.WaitForIssuance(ctx, crt, previous, since, canaryTimeout)
+	if err != nil {
+		return err
+	}
+	serial, verdict, err := s.CheckSecret(ctx, crt, secret)
+	if err != nil {
+		return &scanner.Error{Code: renewer.CodeIssuanceFailed, Err: fmt.Errorf("unable to check the new certificate: %w", err)}
+	}
+	if verdict.Affected {
+		return &scanner.Error{Code: renewer.CodeIssuanceFailed, Err: fmt.Errorf("the new certificate (serial number: %x) is also affected: %s", serial, verdict.Reason)}
+	}
+	log.Printf("Canary Certificate %s/%s was issued a new certificate (serial number: %x), which is not affected", crt.Namespace, crt.Name, serial)
+	return nil
+}
+
+// secretSerial returns the serial number of the certificate in the Secret of
+// crt, or "" if it cannot be read.
+func secretSerial(ctx context.Context, cl client.Reader, crt capi.Certificate) string {
+	cert := secretCertificate(ctx, cl, crt)
+	if cert == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", cert.SerialNumber)
+}
+
+// secretCertificate returns the certificate in the Secret of crt, or nil if it
+// cannot be read.
+func secretCertificate(ctx context.Context, cl client.Reader, crt capi.Certificate) *x509.Certificate {
+	var secret core.Secret
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: crt.Namespace, Name: crt.Spec.SecretName}, &secret); err != nil {
+		return nil
+	}
+	cert, err := scanner.DecodeCertificate(&secret)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
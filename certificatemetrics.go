@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var serveMetricsAddr string
+
+func init() {
+	flag.StringVar(&serveMetricsAddr, "metrics-addr", "", "If set, the 'serve' command will serve Prometheus metrics on this address at /metrics, including a series for each affected Certificate.")
+}
+
+// Renewal states of affected Certificates, used as the value of the state
+// label of lecaa_certificate_renewal_state.
+const (
+	renewalStatePending   = "pending"
+	renewalStateTriggered = "triggered"
+	renewalStateFailed    = "failed"
+	renewalStateBlocked   = "blocked"
+	renewalStateResolved  = "resolved"
+)
+
+var renewalStates = []string{renewalStatePending, renewalStateTriggered, renewalStateFailed, renewalStateBlocked, renewalStateResolved}
+
+var certificateLabels = []string{"namespace", "name", "issuer", "issuer_kind"}
+
+var (
+	descCertificateAffected = prometheus.NewDesc("lecaa_certificate_affected",
+		"1 if the Certificate is affected, or 0 if it was affected but has been fixed.",
+		certificateLabels, nil)
+	descCertificateRenewalState = prometheus.NewDesc("lecaa_certificate_renewal_state",
+		"1 for the current renewal state of each affected Certificate: pending, triggered, failed, blocked (it cannot be renewed automatically) or resolved.",
+		append(certificateLabels, "state"), nil)
+	descCertificateFound = prometheus.NewDesc("lecaa_certificate_affected_since_timestamp_seconds",
+		"When the Certificate was first found to be affected, as a unix timestamp.",
+		certificateLabels, nil)
+)
+
+// certificateCollector exports the findings of the 'serve' command as a
+// series for each Certificate. The series are built from the findings on
+// each scrape, so that they always match those served by --api-addr.
+type certificateCollector struct {
+	findings *findings
+}
+
+// serveMetricsAddrOrDisabled returns the address the controller manager
+// serves metrics on, which is disabled unless --metrics-addr is set.
+func serveMetricsAddrOrDisabled() string {
+	if serveMetricsAddr == "" {
+		return "0"
+	}
+	return serveMetricsAddr
+}
+
+// registerCertificateMetrics registers the metrics served by the controller
+// manager at --metrics-addr.
+func registerCertificateMetrics(f *findings) error {
+	if serveMetricsAddr == "" {
+		return nil
+	}
+	if err := ctrlmetrics.Registry.Register(&certificateCollector{findings: f}); err != nil {
+		return err
+	}
+	// Renewals are counted in the same way as by scans.
+	return ctrlmetrics.Registry.Register(metricRenewals)
+}
+
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descCertificateAffected
+	ch <- descCertificateRenewalState
+	ch <- descCertificateFound
+}
+
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	// A standby replica has no findings, and reporting none would look like
+	// every Certificate had been fixed.
+	if !c.findings.isActive() {
+		return
+	}
+	collect := func(f finding, affected float64) {
+		kind := f.IssuerKind
+		if kind == "" {
+			kind = "Issuer"
+		}
+		labels := []string{f.Namespace, f.Name, f.IssuerName, kind}
+		ch <- prometheus.MustNewConstMetric(descCertificateAffected, prometheus.GaugeValue, affected, labels...)
+		ch <- prometheus.MustNewConstMetric(descCertificateFound, prometheus.GaugeValue, float64(f.FoundAt.Unix()), labels...)
+		state := f.renewalState()
+		for _, s := range renewalStates {
+			value := 0.0
+			if s == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(descCertificateRenewalState, prometheus.GaugeValue, value, append(labels, s)...)
+		}
+	}
+	for _, f := range c.findings.list() {
+		collect(f, 1)
+	}
+	for _, f := range c.findings.listResolved() {
+		collect(f, 0)
+	}
+}
+
+// renewalState returns the renewal state of f for
+// lecaa_certificate_renewal_state.
+func (f *finding) renewalState() string {
+	switch {
+	case f.ResolvedAt != nil:
+		return renewalStateResolved
+	case f.RenewalError != "":
+		return renewalStateFailed
+	case f.RenewalTriggered:
+		return renewalStateTriggered
+	case f.ReplicatedFrom != "" || len(f.SharesSecretWith) > 0 || f.PausedBy != "" || f.Warning != "" || f.IssuerProblem != "":
+		return renewalStateBlocked
+	default:
+		return renewalStatePending
+	}
+}
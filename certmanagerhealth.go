@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	core "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	certManagerNamespace     string
+	skipCertManagerPreflight bool
+)
+
+func init() {
+	flag.StringVar(&certManagerNamespace, "cert-manager-namespace", "cert-manager", "The namespace cert-manager is installed in, whose controller and webhook pods are checked before triggering renewals.")
+	flag.BoolVar(&skipCertManagerPreflight, "skip-cert-manager-preflight", false, "If true, do not check that cert-manager is healthy, and serves the API version written by the tool, before triggering renewals.")
+}
+
+// certManagerPreflightRequired returns true if cert-manager must be checked
+// before triggering renewals, which is only the case if it is cert-manager
+// that will renew them.
+func certManagerPreflightRequired() bool {
+	return renew && remediatorName == "cert-manager" && fixturesDir == "" && !skipCertManagerPreflight
+}
+
+// certManagerPreflightRules returns the permissions needed in
+// --cert-manager-namespace to check the cert-manager pods.
+func certManagerPreflightRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}}}
+}
+
+// certManagerHealthCacheDuration is how long serve reuses the result of a
+// cert-manager health check, so that a batch of renewals is checked once
+// rather than for every Certificate.
+const certManagerHealthCacheDuration = time.Minute
+
+// serveHealthCheck, if set, is run by the Remediators returned by
+// newRemediator before each remediation. It is set by serve, which triggers
+// renewals for as long as it runs rather than in a single batch.
+var serveHealthCheck *cachedHealthCheck
+
+// cachedHealthCheck runs check at most once every
+// certManagerHealthCacheDuration, returning the previous result otherwise.
+type cachedHealthCheck struct {
+	check func(ctx context.Context) error
+	now   func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func newCachedHealthCheck(check func(ctx context.Context) error) *cachedHealthCheck {
+	return &cachedHealthCheck{check: check, now: time.Now}
+}
+
+func (c *cachedHealthCheck) run(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := c.now(); c.checked.IsZero() || now.Sub(c.checked) >= certManagerHealthCacheDuration {
+		c.err = c.check(ctx)
+		c.checked = now
+	}
+	return c.err
+}
+
+// healthCheckedRemediator is a Remediator that only calls the next one if
+// cert-manager is healthy.
+type healthCheckedRemediator struct {
+	health *cachedHealthCheck
+	next   renewer.Remediator
+}
+
+// Remediate implements renewer.Remediator.
+func (h *healthCheckedRemediator) Remediate(ctx context.Context, crt capi.Certificate) error {
+	if err := h.health.run(ctx); err != nil {
+		return err
+	}
+	return h.next.Remediate(ctx, crt)
+}
+
+// certManagerComponents are the cert-manager pods that must be Ready for a
+// renewal to complete, by the app.kubernetes.io/component label set by the
+// Helm chart. Older manifests only set the app label, to the value given.
+var certManagerComponents = []struct {
+	component string
+	app       string
+	// impact describes what goes wrong without a Ready pod.
+	impact string
+}{
+	{"controller", "cert-manager", "no certificates can be issued"},
+	{"webhook", "webhook", "changes to cert-manager resources are rejected"},
+}
+
+// checkCertManagerHealth checks that cert-manager can process the renewals
+// about to be triggered: that its controller and webhook pods are Ready, and
+// that the API server still serves the version of its resources used by the
+// tool. Every problem found is returned in a single error.
+func checkCertManagerHealth(ctx context.Context, cfg *rest.Config, cl client.Reader) error {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building discovery client: %w", err)
+	}
+	problems := servedVersionProblems(dc)
+
+	var pods core.PodList
+	if err := cl.List(ctx, &pods, client.InNamespace(certManagerNamespace)); err != nil {
+		return fmt.Errorf("error listing the cert-manager pods in namespace %q (set --skip-cert-manager-preflight to skip this check): %w", certManagerNamespace, err)
+	}
+	for _, c := range certManagerComponents {
+		var found, ready []string
+		var unready []string
+		for _, pod := range pods.Items {
+			component := pod.Labels["app.kubernetes.io/component"]
+			if component == "" && pod.Labels["app"] == c.app {
+				component = c.component
+			}
+			if component != c.component {
+				continue
+			}
+			found = append(found, pod.Name)
+			if problem := podProblem(pod); problem != "" {
+				unready = append(unready, fmt.Sprintf("%s: %s", pod.Name, problem))
+			} else {
+				ready = append(ready, pod.Name)
+			}
+		}
+		switch {
+		case len(found) == 0:
+			problems = append(problems, fmt.Sprintf("no cert-manager %s pods were found in namespace %q, so %s. Set --cert-manager-namespace if cert-manager is installed elsewhere", c.component, certManagerNamespace, c.impact))
+		case len(ready) == 0:
+			sort.Strings(unready)
+			problems = append(problems, fmt.Sprintf("none of the cert-manager %s pods are Ready, so %s:\n    - %s", c.component, c.impact, strings.Join(unready, "\n    - ")))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("cert-manager cannot process renewals, so none were triggered (set --skip-cert-manager-preflight to skip this check):\n  * %s", strings.Join(problems, "\n  * "))
+	}
+	log.Printf("cert-manager preflight checks passed")
+	return nil
+}
+
+// servedVersionProblems describes why the API server does not serve the
+// cert-manager resources written by the tool, at the version it uses.
+func servedVersionProblems(dc discovery.DiscoveryInterface) []string {
+	resources, err := dc.ServerResourcesForGroupVersion(capi.SchemeGroupVersion.String())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return []string{fmt.Sprintf("unable to discover the cert-manager resources served: %v", err)}
+	}
+	served := make(map[string]bool)
+	if err == nil {
+		for _, r := range resources.APIResources {
+			served[r.Name] = true
+		}
+	}
+	var missing []string
+	for _, name := range []string{"certificates", "certificaterequests"} {
+		if !served[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	versions := "none"
+	if groups, err := dc.ServerGroups(); err == nil {
+		for _, g := range groups.Groups {
+			if g.Name != capi.SchemeGroupVersion.Group {
+				continue
+			}
+			var names []string
+			for _, v := range g.Versions {
+				names = append(names, v.Version)
+			}
+			versions = strings.Join(names, ", ")
+		}
+	}
+	return []string{fmt.Sprintf("the API server does not serve %s at %s, which this tool uses (versions served: %s). cert-manager may have been upgraded to a version that no longer serves it, so it would not see the changes made to trigger renewals", strings.Join(missing, " and "), capi.SchemeGroupVersion, versions)}
+}
+
+// podProblem describes why pod is not Ready, or returns "" if it is.
+func podProblem(pod core.Pod) string {
+	if pod.DeletionTimestamp != nil {
+		return "being deleted"
+	}
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.State.Waiting != nil && s.State.Waiting.Reason != "" {
+			return fmt.Sprintf("container %s is %s after %d restarts", s.Name, s.State.Waiting.Reason, s.RestartCount)
+		}
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == core.PodReady {
+			if c.Status == core.ConditionTrue {
+				return ""
+			}
+			if c.Message != "" {
+				return "not Ready: " + c.Message
+			}
+			break
+		}
+	}
+	return fmt.Sprintf("not Ready, in phase %s", pod.Status.Phase)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+)
+
+func TestHealthCheckedRemediator(t *testing.T) {
+	now := time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC)
+	checks := 0
+	var healthErr error
+	health := newCachedHealthCheck(func(context.Context) error {
+		checks++
+		return healthErr
+	})
+	health.now = func() time.Time { return now }
+	remediated := 0
+	r := &healthCheckedRemediator{health: health, next: renewer.RemediatorFunc(func(context.Context, capi.Certificate) error {
+		remediated++
+		return nil
+	})}
+
+	healthErr = errors.New("cert-manager cannot process renewals")
+	for i := 0; i < 3; i++ {
+		if err := r.Remediate(context.Background(), capi.Certificate{}); err != healthErr {
+			t.Errorf("Remediate() with cert-manager unhealthy = %v, want %v", err, healthErr)
+		}
+	}
+	if checks != 1 || remediated != 0 {
+		t.Errorf("checked %d times and remediated %d times, want 1 check and no remediations", checks, remediated)
+	}
+
+	// The failed result is reused until it expires.
+	healthErr = nil
+	now = now.Add(certManagerHealthCacheDuration - time.Second)
+	if err := r.Remediate(context.Background(), capi.Certificate{}); err == nil {
+		t.Errorf("Remediate() before the check expired succeeded")
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		if err := r.Remediate(context.Background(), capi.Certificate{}); err != nil {
+			t.Errorf("Remediate() with cert-manager healthy = %v", err)
+		}
+	}
+	if checks != 2 || remediated != 3 {
+		t.Errorf("checked %d times and remediated %d times, want 2 checks and 3 remediations", checks, remediated)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var (
+	checkpointFile string
+	resumeScan     bool
+)
+
+func init() {
+	flag.StringVar(&checkpointFile, "checkpoint-file", "", "If set, the results of the scan are saved to this file as each namespace is completed, so that an interrupted scan can be continued with --resume-scan instead of starting over. The file is removed once the scan completes.")
+	flag.BoolVar(&resumeScan, "resume-scan", false, "If true, the namespaces already scanned according to --checkpoint-file are not scanned again, and their results are included as they were. If the file does not exist, the whole cluster is scanned.")
+}
+
+func validateCheckpointFlags() error {
+	if resumeScan && checkpointFile == "" {
+		return fmt.Errorf("--resume-scan requires --checkpoint-file")
+	}
+	if checkpointFile != "" && watchMode {
+		return fmt.Errorf("--checkpoint-file cannot be combined with --watch, which does not scan namespace by namespace")
+	}
+	return nil
+}
+
+// checkpointIgnoredParameters are the flags that may differ between a scan
+// and its resumption without affecting the results.
+var checkpointIgnoredParameters = map[string]bool{
+	"checkpoint-file": true,
+	"resume-scan":     true,
+}
+
+// checkpointHeader is the first line of a checkpoint file, describing the
+// scan whose results follow.
+type checkpointHeader struct {
+	StartedAt   time.Time         `json:"startedAt"`
+	RunID       string            `json:"runId"`
+	SecretsOnly bool              `json:"secretsOnly,omitempty"`
+	Parameters  map[string]string `json:"parameters"`
+}
+
+// checkpointNamespace is each following line of a checkpoint file, holding
+// the results of a namespace that was scanned completely.
+type checkpointNamespace struct {
+	Namespace string          `json:"namespace"`
+	Report    *scanner.Report `json:"report"`
+}
+
+// checkpoint implements scanner.Checkpoint by appending the results of each
+// namespace to --checkpoint-file as a line of JSON, so that no more than the
+// namespaces being scanned are lost if the tool is killed.
+type checkpoint struct {
+	lock sync.Mutex
+	file *os.File
+	// failed is set once a write has failed, after which the checkpoint is
+	// no longer written to.
+	failed bool
+	// resumed holds the results of the namespaces scanned before the scan
+	// was interrupted, if --resume-scan is set.
+	resumed map[string]*scanner.Report
+}
+
+// startCheckpoint opens --checkpoint-file, reading the results it holds if
+// --resume-scan is set, and sets up s to save its progress to it. It returns
+// nil if --checkpoint-file is not set.
+func startCheckpoint(s *scanner.Scanner) (*checkpoint, error) {
+	if checkpointFile == "" {
+		return nil, nil
+	}
+	cp := &checkpoint{}
+	_, err := os.Stat(checkpointFile)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading checkpoint file: %w", err)
+	}
+	switch {
+	case exists && !resumeScan:
+		return nil, fmt.Errorf("checkpoint file %q already exists, left by an interrupted scan. Set --resume-scan to resume that scan, or remove the file to start over", checkpointFile)
+	case exists:
+		if cp.resumed, err = readCheckpoint(checkpointFile); err != nil {
+			return nil, err
+		}
+		if cp.file, err = os.OpenFile(checkpointFile, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			return nil, fmt.Errorf("error opening checkpoint file: %w", err)
+		}
+	default:
+		if resumeScan {
+			log.Printf("Checkpoint file %q does not exist, scanning the whole cluster", checkpointFile)
+		}
+		if cp.file, err = os.OpenFile(checkpointFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			return nil, fmt.Errorf("error creating checkpoint file: %w", err)
+		}
+		header := checkpointHeader{StartedAt: time.Now().UTC(), RunID: runID, SecretsOnly: secretsOnly, Parameters: checkpointParameters()}
+		if err := cp.writeLine(header); err != nil {
+			cp.file.Close()
+			return nil, fmt.Errorf("error writing checkpoint file: %w", err)
+		}
+	}
+	s.Checkpoint = cp
+	s.Resume = cp.resumed
+	return cp, nil
+}
+
+// readCheckpoint returns the results of each namespace in the checkpoint file
+// at path, checking that they were produced by a compatible scan.
+func readCheckpoint(path string) (map[string]*scanner.Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint file: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var header checkpointHeader
+	line, err := r.ReadBytes('\n')
+	if err != nil || json.Unmarshal(line, &header) != nil || header.Parameters == nil {
+		return nil, fmt.Errorf("checkpoint file %q is not a checkpoint written by this tool, remove it to start over", path)
+	}
+	if header.SecretsOnly != secretsOnly {
+		return nil, fmt.Errorf("checkpoint file %q was written by a scan with --secrets-only=%t, so it cannot be resumed with --secrets-only=%t", path, header.SecretsOnly, secretsOnly)
+	}
+	if changed := changedParameters(header.Parameters, checkpointParameters()); len(changed) > 0 {
+		log.Printf("WARNING: the scan being resumed was run with different values of %s, so the results of the namespaces it scanned may differ from those of the rest", strings.Join(changed, ", "))
+	}
+	resumed := make(map[string]*scanner.Report)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		var ns checkpointNamespace
+		if err != nil || json.Unmarshal(line, &ns) != nil || ns.Report == nil {
+			// The tool was killed while writing the last namespace, which
+			// is scanned again.
+			log.Printf("WARNING: ignoring an incomplete entry at the end of checkpoint file %q", path)
+			break
+		}
+		resumed[ns.Namespace] = ns.Report
+	}
+	log.Printf("Resuming the scan started at %s (run ID %s), which completed %d namespaces", header.StartedAt.Format(time.RFC3339), header.RunID, len(resumed))
+	return resumed, nil
+}
+
+// checkpointParameters returns the parameters recorded in the checkpoint
+// header, to be compared with those of the scan resuming it.
+func checkpointParameters() map[string]string {
+	params := runParameters()
+	for name := range checkpointIgnoredParameters {
+		delete(params, name)
+	}
+	return params
+}
+
+// changedParameters returns the names of the flags set differently in old
+// and current, sorted.
+func changedParameters(old, current map[string]string) []string {
+	var changed []string
+	for name, value := range old {
+		if v, ok := current[name]; !ok || v != value {
+			changed = append(changed, "--"+name)
+		}
+	}
+	for name := range current {
+		if _, ok := old[name]; !ok {
+			changed = append(changed, "--"+name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// NamespaceScanned implements scanner.Checkpoint. A failure to write the
+// checkpoint is logged rather than failing the scan, which would lose more
+// progress than it saves.
+func (cp *checkpoint) NamespaceScanned(namespace string, r *scanner.Report) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if cp.failed {
+		return
+	}
+	if err := cp.writeLine(checkpointNamespace{Namespace: namespace, Report: r}); err != nil {
+		log.Printf("WARNING: unable to write checkpoint file, an interrupted scan will not be resumable from here: %v", err)
+		cp.failed = true
+	}
+}
+
+func (cp *checkpoint) writeLine(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = cp.file.Write(append(data, '\n'))
+	return err
+}
+
+// finish closes the checkpoint file, removing it if the scan completed with
+// no error, as there is nothing left to resume. It does nothing if cp is nil.
+func (cp *checkpoint) finish(scanErr error) {
+	if cp == nil {
+		return
+	}
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	cp.file.Close()
+	if scanErr != nil {
+		log.Printf("The scan can be resumed from checkpoint file %q with --resume-scan", checkpointFile)
+		return
+	}
+	if err := os.Remove(checkpointFile); err != nil {
+		log.Printf("WARNING: unable to remove checkpoint file %q: %v", checkpointFile, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+// configMapRemediation is given for every affected certificate found in a
+// ConfigMap, as nothing renews them.
+const configMapRemediation = "unmanaged, manual rotation required"
+
+var scanConfigMaps bool
+
+func init() {
+	flag.BoolVar(&scanConfigMaps, "scan-configmaps", false, "If true, the data of every ConfigMap is also checked for PEM encoded certificates, for applications that keep their serving certificate in a ConfigMap rather than a Secret. Affected certificates found are reported as unmanaged, requiring manual rotation, and are never renewed. Requires permission to list ConfigMaps.")
+}
+
+func validateConfigMapFlags() error {
+	if !scanConfigMaps {
+		return nil
+	}
+	if watchMode {
+		return fmt.Errorf("--scan-configmaps cannot be combined with --watch")
+	}
+	if expiringWithin != 0 {
+		return fmt.Errorf("--scan-configmaps cannot be combined with --expiring-within, which describes when cert-manager will renew each certificate")
+	}
+	return nil
+}
+
+// reportConfigMap is an affected certificate found in a ConfigMap.
+type reportConfigMap struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Key is the key of the ConfigMap data holding the certificate.
+	Key         string `json:"key"`
+	Serial      string `json:"serial"`
+	Reason      string `json:"reason,omitempty"`
+	Remediation string `json:"remediation"`
+}
+
+// reportConfigMaps is the result of --scan-configmaps in a report.
+type reportConfigMaps struct {
+	Checked          int                     `json:"checked"`
+	Skipped          int                     `json:"skipped"`
+	Affected         []reportConfigMap       `json:"affected"`
+	FailedNamespaces []reportFailedNamespace `json:"failedNamespaces,omitempty"`
+}
+
+// runConfigMapScan checks the certificates in ConfigMaps with s, if
+// --scan-configmaps is set, logging the results. Namespaces whose ConfigMaps
+// cannot be listed are logged rather than failing the run, as the
+// Certificates have already been checked.
+func runConfigMapScan(ctx context.Context, s *scanner.Scanner) (*scanner.ConfigMapReport, error) {
+	if !scanConfigMaps {
+		return nil, nil
+	}
+	r, err := s.ScanConfigMaps(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning ConfigMaps: %w", err)
+	}
+	log.Printf("  Certificates in ConfigMaps checked: %d, unable to check: %d", r.Checked, r.Skipped)
+	log.Printf("  Affected certificates in ConfigMaps, %s: %d", configMapRemediation, len(r.Affected))
+	for _, a := range r.Affected {
+		log.Printf("    %s/%s key %q, serial %s", a.Namespace, a.Name, a.Key, a.Serial)
+	}
+	for _, f := range r.FailedNamespaces {
+		log.Printf("WARNING: unable to scan ConfigMaps in namespace %q: %v", f.Namespace, f.Err)
+	}
+	return r, nil
+}
+
+// newReportConfigMaps converts the result of --scan-configmaps for a report.
+func newReportConfigMaps(r *scanner.ConfigMapReport) *reportConfigMaps {
+	rc := &reportConfigMaps{Checked: r.Checked, Skipped: r.Skipped, Affected: []reportConfigMap{}}
+	for _, a := range r.Affected {
+		rc.Affected = append(rc.Affected, reportConfigMap{Namespace: a.Namespace, Name: a.Name, Key: a.Key, Serial: a.Serial, Reason: a.Reason, Remediation: configMapRemediation})
+	}
+	for _, f := range r.FailedNamespaces {
+		rc.FailedNamespaces = append(rc.FailedNamespaces, reportFailedNamespace{Namespace: f.Namespace, Error: scrubSecrets(f.Err.Error())})
+	}
+	return rc
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxConversionRetries is the maximum number of times a single read
+	// will be retried while a conversion webhook is unavailable.
+	maxConversionRetries = 6
+	// maxConversionBackoff caps the delay between retries.
+	maxConversionBackoff = 30 * time.Second
+	// maxConversionErrorBody is how much of the body of an error response is
+	// read to tell whether it was caused by a conversion webhook.
+	maxConversionErrorBody = 64 << 10
+)
+
+// conversionRetryTransport retries reads that fail because a conversion
+// webhook, normally cert-manager's, could not be reached. This happens
+// briefly during cert-manager upgrades, while the webhook is restarting, and
+// would otherwise fail the whole run. Only GET requests, which are used for
+// both gets and lists, are retried, with an exponential backoff.
+type conversionRetryTransport struct {
+	next http.RoundTripper
+}
+
+func wrapConversionRetry(rt http.RoundTripper) http.RoundTripper {
+	return &conversionRetryTransport{next: rt}
+}
+
+func (t *conversionRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || req.Method != http.MethodGet || resp.StatusCode < http.StatusInternalServerError {
+			return resp, err
+		}
+		body, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, maxConversionErrorBody))
+		if readErr != nil || !bytes.Contains(body, []byte("conversion webhook")) || attempt > maxConversionRetries {
+			resp.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+			return resp, err
+		}
+		resp.Body.Close()
+
+		delay := time.Second << uint(attempt-1)
+		if delay > maxConversionBackoff {
+			delay = maxConversionBackoff
+		}
+		log.Printf("Conversion webhook is unavailable (%s %s), retrying in %s (retry %d of %d)", req.Method, req.URL.Path, delay, attempt, maxConversionRetries)
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// prefixedBody is a response body part of which has already been read.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
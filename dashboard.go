@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	dashboardAddr      string
+	dashboardUsersFile string
+)
+
+func init() {
+	flag.StringVar(&dashboardAddr, "dashboard-addr", "", "If set, the 'serve' command will serve a web dashboard showing the current findings on this address.")
+	flag.StringVar(&dashboardUsersFile, "dashboard-users-file", "", "The path to a file of dashboard users, one 'username:bcrypt-hash:role' entry per line, where role is 'viewer' or 'renewer'. Required if --dashboard-addr is set.")
+}
+
+// dashboardRole controls what a dashboard user is permitted to do.
+type dashboardRole string
+
+const (
+	// dashboardRoleViewer may view the current findings.
+	dashboardRoleViewer dashboardRole = "viewer"
+	// dashboardRoleRenewer may additionally trigger renewals.
+	dashboardRoleRenewer dashboardRole = "renewer"
+)
+
+type dashboardUser struct {
+	passwordHash []byte
+	role         dashboardRole
+}
+
+// dashboard serves a web UI for the findings of the 'serve' command.
+type dashboard struct {
+	client   client.Client
+	findings *findings
+	users    map[string]dashboardUser
+	// csrfKey is used to derive the per-user token that must be submitted
+	// with each renewal request.
+	csrfKey []byte
+	// renewals serialises renewals triggered from the dashboard.
+	renewals chan finding
+}
+
+// startDashboard starts serving the web dashboard on --dashboard-addr, if
+// set.
+func startDashboard(cl client.Client, f *findings) error {
+	if dashboardAddr == "" {
+		return nil
+	}
+	if dashboardUsersFile == "" {
+		return fmt.Errorf("--dashboard-users-file must be set when --dashboard-addr is set")
+	}
+	users, err := loadDashboardUsers(dashboardUsersFile)
+	if err != nil {
+		return fmt.Errorf("error loading dashboard users file: %w", err)
+	}
+	d := &dashboard{client: cl, findings: f, users: users, csrfKey: make([]byte, 32), renewals: make(chan finding, 100)}
+	if _, err := rand.Read(d.csrfKey); err != nil {
+		return err
+	}
+	go d.processRenewals()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.serveIndex)
+	mux.HandleFunc("/renew", d.serveRenew)
+	go func() {
+		log.Printf("Serving dashboard on %s", dashboardAddr)
+		if err := http.ListenAndServe(dashboardAddr, mux); err != nil {
+			log.Printf("Dashboard server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// loadDashboardUsers reads the users permitted to access the dashboard from
+// path.
+func loadDashboardUsers(path string) (map[string]dashboardUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string]dashboardUser)
+	s := bufio.NewScanner(f)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("line %d: expected 'username:bcrypt-hash:role'", lineNum)
+		}
+		role := dashboardRole(parts[2])
+		if role != dashboardRoleViewer && role != dashboardRoleRenewer {
+			return nil, fmt.Errorf("line %d: unknown role %q", lineNum, role)
+		}
+		users[parts[0]] = dashboardUser{passwordHash: []byte(parts[1]), role: role}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users defined")
+	}
+	return users, nil
+}
+
+// authenticate checks the basic auth credentials of r, returning the name
+// and role of the user. If they are not valid, a 401 response is written.
+func (d *dashboard) authenticate(w http.ResponseWriter, r *http.Request) (string, dashboardRole, bool) {
+	name, password, ok := r.BasicAuth()
+	if ok {
+		if u, found := d.users[name]; found && bcrypt.CompareHashAndPassword(u.passwordHash, []byte(password)) == nil {
+			return name, u.role, true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="letsencrypt-caa-bug-checker"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return "", "", false
+}
+
+func (d *dashboard) csrfToken(user string) string {
+	mac := hmac.New(sha256.New, d.csrfKey)
+	mac.Write([]byte(user))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type namespaceSummary struct {
+	Namespace        string
+	Affected         int
+	RenewalTriggered int
+	Failed           int
+	Resolved         int
+}
+
+type dashboardData struct {
+	Cluster    string
+	Active     bool
+	CanRenew   bool
+	CSRFToken  string
+	Affected   []finding
+	Resolved   []finding
+	Failed     int
+	Triggered  int
+	Namespaces []namespaceSummary
+}
+
+func (d *dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	user, role, ok := d.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := dashboardData{
+		Cluster:   clusterName,
+		Active:    d.findings.isActive(),
+		CanRenew:  role == dashboardRoleRenewer,
+		CSRFToken: d.csrfToken(user),
+		Affected:  d.findings.list(),
+		Resolved:  d.findings.listResolved(),
+	}
+	namespaces := make(map[string]*namespaceSummary)
+	summary := func(ns string) *namespaceSummary {
+		if namespaces[ns] == nil {
+			namespaces[ns] = &namespaceSummary{Namespace: ns}
+		}
+		return namespaces[ns]
+	}
+	for _, c := range data.Affected {
+		s := summary(c.Namespace)
+		s.Affected++
+		if c.RenewalTriggered {
+			s.RenewalTriggered++
+			data.Triggered++
+		}
+		if c.RenewalError != "" {
+			s.Failed++
+			data.Failed++
+		}
+	}
+	for _, c := range data.Resolved {
+		summary(c.Namespace).Resolved++
+	}
+	for _, s := range namespaces {
+		data.Namespaces = append(data.Namespaces, *s)
+	}
+	sort.Slice(data.Namespaces, func(i, j int) bool {
+		if data.Namespaces[i].Affected != data.Namespaces[j].Affected {
+			return data.Namespaces[i].Affected > data.Namespaces[j].Affected
+		}
+		return data.Namespaces[i].Namespace < data.Namespaces[j].Namespace
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Printf("Failed to render dashboard: %v", err)
+	}
+}
+
+// serveRenew queues a renewal of each Certificate selected on the dashboard.
+// Only users with the renewer role may trigger renewals.
+func (d *dashboard) serveRenew(w http.ResponseWriter, r *http.Request) {
+	user, role, ok := d.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if role != dashboardRoleRenewer {
+		http.Error(w, "forbidden: renewing certificates requires the renewer role", http.StatusForbidden)
+		return
+	}
+	if !hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(d.csrfToken(user))) {
+		http.Error(w, "forbidden: invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	if !d.findings.isActive() {
+		http.Error(w, "this replica is not currently checking Certificates", http.StatusServiceUnavailable)
+		return
+	}
+	for _, key := range r.PostForm["certificate"] {
+		c, ok := d.findings.get(key)
+		if !ok {
+			continue
+		}
+		log.Printf("Dashboard user %q requested renewal of Certificate %s", user, key)
+		select {
+		case d.renewals <- c:
+		default:
+			http.Error(w, "too many renewals are already queued, please try again later", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// processRenewals triggers each renewal requested from the dashboard in turn
+// and records the outcome. Renewals are chosen as for any other run, so a
+// Certificate whose Secret is a replica has the Certificate of the source
+// renewed instead, and one that would not be renewed automatically, for
+// example because it is paused or shares its Secret, is refused with the
+// reason recorded as its renewal error.
+func (d *dashboard) processRenewals() {
+	for c := range d.renewals {
+		d.processRenewal(context.Background(), c)
+	}
+}
+
+func (d *dashboard) processRenewal(ctx context.Context, c finding) {
+	key := c.Namespace + "/" + c.Name
+	var crt capi.Certificate
+	if err := d.client.Get(ctx, types.NamespacedName{Namespace: c.Namespace, Name: c.Name}, &crt); err != nil {
+		log.Printf("Failed to get Certificate %s: %v", key, err)
+		return
+	}
+	c.RenewalError, c.RenewalErrorCode = "", ""
+	a := scanner.AffectedCertificate{Certificate: crt, Serial: c.Serial, Reason: c.Reason, ReplicatedFrom: c.ReplicatedFrom, SharesSecretWith: c.SharesSecretWith, Warning: c.Warning}
+	targets, refused := selectRenewalTargets(ctx, d.client, []scanner.AffectedCertificate{a})
+	if reason, ok := refused[key]; ok {
+		log.Printf("NOT renewing Certificate %s as requested from the dashboard, as %s", key, reason)
+		c.RenewalError = "not renewed, as " + scrubSecrets(reason)
+	} else {
+		c.RenewalTriggered = true
+		for _, target := range targets {
+			log.Printf("Triggering renewal of Certificate %s/%s", target.Namespace, target.Name)
+			if err := renewCertificate(ctx, d.client, target); err != nil {
+				log.Printf("Failed to renew certificate %s/%s: %v", target.Namespace, target.Name, err)
+				c.setRenewalError(err)
+				c.RenewalTriggered = false
+				break
+			}
+		}
+	}
+	// The Certificate may have been found to be unaffected while the
+	// renewal was queued.
+	if _, ok := d.findings.get(key); ok {
+		d.findings.record(c)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>Let's Encrypt CAA bug checker{{ if .Cluster }} - {{ .Cluster }}{{ end }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.error { color: #b00; }
+.ok { color: #080; }
+</style>
+</head>
+<body>
+<h1>Let's Encrypt CAA bug checker{{ if .Cluster }} - {{ .Cluster }}{{ end }}</h1>
+{{ if not .Active }}<p class="error">This replica is not currently checking Certificates.</p>{{ end }}
+<p>
+{{ if .Affected }}<strong class="error">{{ len .Affected }} affected</strong>{{ else }}<strong class="ok">No affected certificates</strong>{{ end }},
+{{ .Triggered }} renewals triggered, {{ .Failed }} renewals failed, {{ len .Resolved }} resolved.
+</p>
+
+<h2>Namespaces</h2>
+<table>
+<tr><th>Namespace</th><th>Affected</th><th>Renewal triggered</th><th>Failed</th><th>Resolved</th></tr>
+{{ range .Namespaces }}<tr><td>{{ .Namespace }}</td><td>{{ .Affected }}</td><td>{{ .RenewalTriggered }}</td><td>{{ .Failed }}</td><td>{{ .Resolved }}</td></tr>
+{{ end }}</table>
+
+<h2>Affected certificates</h2>
+<form method="post" action="/renew">
+<input type="hidden" name="csrf" value="{{ .CSRFToken }}">
+<table>
+<tr>{{ if .CanRenew }}<th></th>{{ end }}<th>Certificate</th><th>Secret</th><th>Serial</th><th>Found</th><th>Renewal</th></tr>
+{{ $canRenew := .CanRenew }}{{ range .Affected }}<tr>
+{{ if $canRenew }}<td><input type="checkbox" name="certificate" value="{{ .Namespace }}/{{ .Name }}"></td>{{ end }}
+<td>{{ .Namespace }}/{{ .Name }}</td><td>{{ .SecretName }}</td><td>{{ .Serial }}</td><td>{{ .FoundAt.Format "2006-01-02 15:04:05" }}</td>
+<td>{{ if .RenewalError }}<span class="error">Failed: {{ .RenewalError }}</span>{{ else if .RenewalTriggered }}Triggered{{ else }}Not triggered{{ end }}</td>
+</tr>
+{{ end }}</table>
+{{ if and .CanRenew .Affected }}<button type="submit">Renew selected certificates</button>{{ end }}
+</form>
+
+<h2>Resolved certificates</h2>
+<table>
+<tr><th>Certificate</th><th>Serial</th><th>Resolved</th></tr>
+{{ range .Resolved }}<tr><td>{{ .Namespace }}/{{ .Name }}</td><td>{{ .Serial }}</td><td>{{ .ResolvedAt.Format "2006-01-02 15:04:05" }}</td></tr>
+{{ end }}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jetstack/cert-manager/pkg/api"
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func dashboardTestCertificate(name, secretName string, annotations map[string]string) *capi.Certificate {
+	return &capi.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: name, UID: types.UID("uid-" + name), Annotations: annotations},
+		Spec:       capi.CertificateSpec{SecretName: secretName, DNSNames: []string{name + ".example.com"}, IssuerRef: cmmeta.ObjectReference{Name: "letsencrypt", Kind: "ClusterIssuer"}},
+	}
+}
+
+func TestDashboardRenewalGuards(t *testing.T) {
+	oldUnready, oldRemediator, oldCommand := renewUnreadyIssuers, remediatorName, remediatorCommand
+	defer func() { renewUnreadyIssuers, remediatorName, remediatorCommand = oldUnready, oldRemediator, oldCommand }()
+	// The fixtures do not include the issuer, and cert-manager is not
+	// running to complete a renewal.
+	renewUnreadyIssuers = true
+	remediatorName, remediatorCommand = "exec", "true"
+
+	objs := []runtime.Object{
+		dashboardTestCertificate("paused", "paused-tls", map[string]string{scanner.DefaultPausedAnnotations[0]: "true"}),
+		dashboardTestCertificate("shared", "shared-tls", nil),
+		dashboardTestCertificate("shared-too", "shared-tls", nil),
+		dashboardTestCertificate("plain", "plain-tls", nil),
+	}
+	for _, name := range []string{"paused-tls", "shared-tls", "plain-tls"} {
+		objs = append(objs, &core.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: name}, Data: map[string][]byte{core.TLSCertKey: []byte("x")}})
+	}
+	cl := fake.NewFakeClientWithScheme(api.Scheme, objs...)
+	f := newFindings()
+	f.setActive(true)
+	d := &dashboard{client: cl, findings: f}
+
+	tests := []struct {
+		finding   finding
+		wantError string
+	}{
+		{
+			finding:   finding{Namespace: "web", Name: "paused", SecretName: "paused-tls", Serial: "01"},
+			wantError: "paused by annotation",
+		},
+		{
+			finding:   finding{Namespace: "web", Name: "shared", SecretName: "shared-tls", Serial: "02", SharesSecretWith: []string{"shared-too"}},
+			wantError: `Secret "shared-tls" is also used by Certificate(s) shared-too`,
+		},
+		{
+			finding: finding{Namespace: "web", Name: "plain", SecretName: "plain-tls", Serial: "03"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.finding.Name, func(t *testing.T) {
+			f.record(tt.finding)
+			d.processRenewal(context.Background(), tt.finding)
+			got, _ := f.get("web/" + tt.finding.Name)
+			if tt.wantError == "" {
+				if !got.RenewalTriggered || got.RenewalError != "" {
+					t.Errorf("RenewalTriggered = %t, RenewalError = %q, want a renewal", got.RenewalTriggered, got.RenewalError)
+				}
+				return
+			}
+			if got.RenewalTriggered {
+				t.Errorf("Certificate %s was renewed from the dashboard", tt.finding.Name)
+			}
+			if !strings.Contains(got.RenewalError, tt.wantError) {
+				t.Errorf("RenewalError = %q, want it to contain %q", got.RenewalError, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var (
+	affectedSerialsURL    string
+	affectedSerialsFormat string
+	refreshDataset        bool
+)
+
+func init() {
+	flag.StringVar(&affectedSerialsURL, "affected-serials-url", "", "If set, the affected serials file is downloaded from this URL to --affected-serials-file before it is loaded. s3://bucket/key and gs://bucket/object URLs are fetched from Amazon S3 and Google Cloud Storage with the standard credential chain of each. Files ending in .gz are decompressed.")
+	flag.StringVar(&affectedSerialsFormat, "affected-serials-format", scanner.FormatLECAA, "The format of the affected serials file: 'lecaa' for the file published by Let's Encrypt, or 'hex' for a file with one hexadecimal serial number on each line.")
+	flag.BoolVar(&refreshDataset, "refresh-dataset", false, "If true, the affected serials are re-downloaded (if --affected-serials-url is set) and reloaded before each scheduled scan when running the 'serve' command.")
+}
+
+// dataset holds the affected serials used by long-running modes, allowing
+// them to be replaced while the tool is running. It is safe for concurrent
+// use.
+type dataset struct {
+	lock    sync.RWMutex
+	serials scanner.SerialSource
+	modTime time.Time
+}
+
+// validateDatasetFlags checks that --affected-serials-url has a file to be
+// downloaded to, rather than ignoring it.
+func validateDatasetFlags() error {
+	if affectedSerialsURL != "" && affectedSerialsFile == "" {
+		return fmt.Errorf("--affected-serials-file must be set to the path that --affected-serials-url is downloaded to")
+	}
+	return nil
+}
+
+// loadDataset downloads the affected serials file if --affected-serials-url
+// is set, and then loads it.
+func loadDataset() (*dataset, error) {
+	if err := validateDatasetFlags(); err != nil {
+		return nil, err
+	}
+	if affectedSerialsURL != "" {
+		if err := downloadAffectedSerials(affectedSerialsURL, affectedSerialsFile); err != nil {
+			return nil, err
+		}
+	}
+	if affectedSerialsFile == "" {
+		// Only --detector-command is used to check Certificates.
+		return &dataset{}, nil
+	}
+	info, err := os.Stat(affectedSerialsFile)
+	if err != nil {
+		return nil, err
+	}
+	serials, _, err := loadSerials()
+	if err != nil {
+		return nil, err
+	}
+	return &dataset{serials: serials, modTime: info.ModTime()}, nil
+}
+
+func (d *dataset) get() scanner.SerialSource {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.serials
+}
+
+// refresh re-downloads the affected serials file if --affected-serials-url
+// is set, and reloads it if it has changed since it was last loaded.
+func (d *dataset) refresh() error {
+	if err := validateDatasetFlags(); err != nil {
+		return err
+	}
+	if affectedSerialsURL != "" {
+		if err := downloadAffectedSerials(affectedSerialsURL, affectedSerialsFile); err != nil {
+			return err
+		}
+	}
+	if affectedSerialsFile == "" {
+		return nil
+	}
+	info, err := os.Stat(affectedSerialsFile)
+	if err != nil {
+		return err
+	}
+	d.lock.RLock()
+	unchanged := !info.ModTime().After(d.modTime)
+	d.lock.RUnlock()
+	if unchanged {
+		log.Printf("Affected serials file has not changed since it was last loaded")
+		return nil
+	}
+
+	serials, _, err := loadSerials()
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.serials = serials
+	d.modTime = info.ModTime()
+	return nil
+}
+
+// downloadAffectedSerials downloads the affected serials file from url to
+// path, replacing it atomically once the download has completed. If path
+// already exists, it is only downloaded again if it has been modified since.
+func downloadAffectedSerials(url, path string) error {
+	log.Printf("Downloading affected serials file from %q", url)
+	header := make(http.Header)
+	if info, err := os.Stat(path); err == nil {
+		header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	resp, err := getDataset(context.Background(), url, header)
+	if err != nil {
+		return fmt.Errorf("error downloading affected serials file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		log.Printf("Affected serials file has not been modified since it was last downloaded")
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading affected serials file: unexpected status %s", resp.Status)
+	}
+
+	var r io.Reader = resp.Body
+	if strings.HasSuffix(strings.SplitN(url, "?", 2)[0], ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error decompressing affected serials file: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tmp, err := os.Create(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp"))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error downloading affected serials file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// The file is given the time the dataset was published, so that it can
+	// be compared with --affected-serials-final-date.
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		if err := os.Chtimes(tmp.Name(), lastModified, lastModified); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
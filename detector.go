@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var (
+	detectorCommand string
+	detectorTimeout time.Duration
+
+	checkerAPI          bool
+	checkerAPIURL       string
+	checkerAPIBatchSize int
+	checkerAPIInterval  time.Duration
+)
+
+func init() {
+	flag.StringVar(&detectorCommand, "detector-command", "", "If set, this command (and any space separated arguments) is run for each certificate to decide whether it is affected, in addition to checking the affected serials. It is given a JSON description of the certificate on stdin and must write a JSON verdict such as {\"affected\": true, \"reason\": \"...\"} to stdout. --affected-serials-file is optional if this is set.")
+	flag.DurationVar(&detectorTimeout, "detector-timeout", 10*time.Second, "How long --detector-command may run for each certificate.")
+	flag.BoolVar(&checkerAPI, "checker-api", false, "If true, each certificate's DNS names are checked with the Let's Encrypt checker API instead of the affected serials dataset, which then does not need to be downloaded. The checker connects to each hostname, so certificates that none of their DNS names are serving are counted as skipped.")
+	flag.StringVar(&checkerAPIURL, "checker-api-url", scanner.DefaultCheckerAPIURL, "The URL of the checker used by --checker-api.")
+	flag.IntVar(&checkerAPIBatchSize, "checker-api-batch-size", 20, "The most hostnames sent to the checker in each request by --checker-api.")
+	flag.DurationVar(&checkerAPIInterval, "checker-api-interval", time.Second, "The least time between requests to the checker by --checker-api, to stay within its rate limits.")
+}
+
+// newDetector returns the detector configured by --expiring-within,
+// --detector-command, --checker-api or --incident-file, or nil if none
+// configures one.
+func newDetector() scanner.Detector {
+	if expiringWithin != 0 {
+		return &expiringDetector{within: time.Duration(expiringWithin), now: time.Now}
+	}
+	if checkerAPI {
+		return &scanner.CheckerAPIDetector{URL: checkerAPIURL, BatchSize: checkerAPIBatchSize, Interval: checkerAPIInterval}
+	}
+	if args := strings.Fields(detectorCommand); len(args) > 0 {
+		return &scanner.ExecDetector{Command: args[0], Args: args[1:], Timeout: detectorTimeout}
+	}
+	if currentIncident != nil && currentIncident.Detection.Method == detectionWindow {
+		return incidentDetector(currentIncident)
+	}
+	return nil
+}
+
+// validateDetectorFlags rejects combinations of detectors, as only one is
+// used.
+func validateDetectorFlags() error {
+	if checkerAPI && detectorCommand != "" {
+		return fmt.Errorf("--checker-api cannot be combined with --detector-command")
+	}
+	if checkerAPI && checkerAPIBatchSize <= 0 {
+		return fmt.Errorf("--checker-api-batch-size must be greater than 0")
+	}
+	return nil
+}
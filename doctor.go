@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+)
+
+// runDoctor implements the 'doctor' command, which explains why the issuance
+// of the Certificate given as namespace/name is not completing, by walking
+// from the Certificate to its CertificateRequest, ACME Order and Challenges,
+// so that they do not have to be described one at a time.
+func runDoctor(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected the namespace/name of a Certificate, got %d arguments", len(args))
+	}
+	parts := strings.Split(args[0], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid Certificate %q, must be namespace/name", args[0])
+	}
+	key := client.ObjectKey{Namespace: parts[0], Name: parts[1]}
+
+	var cl client.Client
+	if fixturesDir != "" {
+		sim, err := loadFixtures(fixturesDir)
+		if err != nil {
+			return err
+		}
+		if !sim.hasIssuers {
+			log.Printf("The fixtures contain no Issuers or ClusterIssuers, so the issuer of the Certificate will not be checked")
+			fixturesHaveIssuers = false
+		}
+		cl = sim
+	} else {
+		cfg := restConfig()
+		var err error
+		if cl, err = newClient(cfg); err != nil {
+			return err
+		}
+		if err := checkCertificateCRD(cfg); err != nil {
+			return err
+		}
+		if err := checkPermissions(cfg, "doctor"); err != nil {
+			return err
+		}
+	}
+
+	d := &renewer.Doctor{Client: cl, CheckIssuer: fixturesHaveIssuers, ACMEServers: acmeServers()}
+	diag, err := d.Diagnose(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	for _, step := range diag.Steps {
+		log.Printf("%s %s/%s:", step.Kind, key.Namespace, step.Name)
+		for _, s := range step.Status {
+			log.Printf("  * %s", s)
+		}
+	}
+	log.Println()
+	if diag.Cause == "" {
+		log.Printf("No problem was found with the issuance of Certificate %s", key)
+		return nil
+	}
+	log.Printf("Most likely cause: %s", diag.Cause)
+	return nil
+}
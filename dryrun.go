@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var dryRunRenewals bool
+
+func init() {
+	flag.BoolVar(&dryRunRenewals, "dry-run-renewals", false, "If true, before any renewal is triggered, the Secret updates and CertificateRequest deletions each renewal would make are submitted with server-side dry-run, and Certificates whose changes would be rejected, for example by an admission webhook, are reported and not renewed. Without --renew, only the dry-run is carried out.")
+}
+
+func validateDryRunFlags() error {
+	if !dryRunRenewals {
+		return nil
+	}
+	if remediatorName != "cert-manager" {
+		return fmt.Errorf("--dry-run-renewals can only be used with --remediator=cert-manager, as other remediators make changes outside of the API server")
+	}
+	if secretsOnly {
+		return fmt.Errorf("--dry-run-renewals cannot be combined with --secrets-only, as Secrets not managed by cert-manager cannot be renewed")
+	}
+	if readOnly {
+		return fmt.Errorf("--dry-run-renewals cannot be combined with --read-only, as dry-run requests are updates and deletions, which need the same permissions")
+	}
+	return nil
+}
+
+// dryRunTargets submits the changes that renewing each of targets would make
+// with server-side dry-run, recording any that would fail in results, and
+// returns the targets that would succeed.
+func dryRunTargets(ctx context.Context, cl client.Client, results *scanResults, targets []capi.Certificate) []capi.Certificate {
+	log.Printf("Submitting the changes for %d renewals with server-side dry-run...", len(targets))
+	r := newRenewer(cl)
+	var passed []capi.Certificate
+	for _, crt := range targets {
+		if err := r.DryRun(ctx, crt); err != nil {
+			log.Printf("WARNING: NOT renewing Certificate %s/%s, as the server-side dry-run of its renewal failed: %v", crt.Namespace, crt.Name, err)
+			results.recordDryRun(crt, err)
+			continue
+		}
+		passed = append(passed, crt)
+	}
+	if failed := len(targets) - len(passed); failed > 0 {
+		log.Printf("Server-side dry-run: %d of %d renewals would fail, fix the cause and run again to renew them", failed, len(targets))
+	} else {
+		log.Printf("Server-side dry-run: all %d renewals would be accepted", len(targets))
+	}
+	return passed
+}
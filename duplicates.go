@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ignoreDuplicateLimit bool
+
+func init() {
+	flag.BoolVar(&ignoreDuplicateLimit, "ignore-duplicate-certificate-limit", false, "If true, Certificates are renewed even if enough certificates have been issued for the same names in the past week that Let's Encrypt's duplicate certificate rate limit will probably reject the renewal.")
+}
+
+// duplicateChecker finds Certificates whose renewal would probably be
+// rejected by the Let's Encrypt duplicate certificate limit, as enough
+// certificates for the same names have been issued in the past week. The
+// certificates issued in each namespace are listed once.
+type duplicateChecker struct {
+	cl     client.Reader
+	now    func() time.Time
+	issued map[string][]*x509.Certificate
+	// failed is the namespaces whose certificates could not be listed, which
+	// are not checked.
+	failed map[string]bool
+}
+
+func newDuplicateChecker(cl client.Reader) *duplicateChecker {
+	return &duplicateChecker{cl: cl, now: time.Now, issued: make(map[string][]*x509.Certificate), failed: make(map[string]bool)}
+}
+
+// blocks returns why, and logs it, if crt should not be renewed because the
+// renewal would exceed the duplicate certificate limit, or "" otherwise.
+// Nothing is blocked if --ignore-duplicate-certificate-limit is set, or if
+// the issued certificates cannot be listed.
+func (d *duplicateChecker) blocks(ctx context.Context, crt capi.Certificate) string {
+	if ignoreDuplicateLimit || d.failed[crt.Namespace] {
+		return ""
+	}
+	issued, ok := d.issued[crt.Namespace]
+	if !ok {
+		var err error
+		if issued, err = scanner.IssuedCertificates(ctx, d.cl, crt.Namespace); err != nil {
+			log.Printf("WARNING: unable to check the duplicate certificate limit for Certificates in namespace %q: %v", crt.Namespace, err)
+			d.failed[crt.Namespace] = true
+			return ""
+		}
+		d.issued[crt.Namespace] = issued
+	}
+	// The requests for the certificate in the Secret may have been deleted.
+	var secret core.Secret
+	if err := d.cl.Get(ctx, client.ObjectKey{Namespace: crt.Namespace, Name: crt.Spec.SecretName}, &secret); err == nil {
+		if cert, err := scanner.DecodeCertificate(&secret); err == nil {
+			issued = append(issued[:len(issued):len(issued)], cert)
+		}
+	}
+	count := scanner.CountDuplicates(&crt, issued, d.now().Add(-scanner.DuplicateCertificateWindow))
+	switch {
+	case count >= scanner.DuplicateCertificateLimit:
+		log.Printf("WARNING: NOT renewing Certificate %s/%s, as %d certificates have been issued for its names (%s) in the past week, so Let's Encrypt would probably reject the renewal under its limit of %d duplicate certificates per week. "+
+			"Run again once an older certificate is more than a week old, or set --ignore-duplicate-certificate-limit to renew it anyway.", crt.Namespace, crt.Name, count, scanner.NameSet(&crt), scanner.DuplicateCertificateLimit)
+		return fmt.Sprintf("%d certificates have been issued for its names in the past week, so Let's Encrypt would probably reject the renewal under its duplicate certificate limit", count)
+	case count == scanner.DuplicateCertificateLimit-1:
+		log.Printf("WARNING: %d certificates have been issued for the names of Certificate %s/%s (%s) in the past week, so its renewal will use up the last of Let's Encrypt's limit of %d duplicate certificates per week, and any further renewal will be rejected",
+			count, crt.Namespace, crt.Name, scanner.NameSet(&crt), scanner.DuplicateCertificateLimit)
+	}
+	return ""
+}
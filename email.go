@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	smtpAddr            string
+	smtpUsername        string
+	smtpPasswordFile    string
+	emailFrom           string
+	emailTo             string
+	emailRecipientsFile string
+)
+
+func init() {
+	flag.StringVar(&smtpAddr, "smtp-addr", "", "If set, a report of each scan is emailed using the SMTP server at this host:port.")
+	flag.StringVar(&smtpUsername, "smtp-username", "", "The username to authenticate to the SMTP server with, if required.")
+	flag.StringVar(&smtpPasswordFile, "smtp-password-file", "", "The path to a file containing the password to authenticate to the SMTP server with.")
+	flag.StringVar(&emailFrom, "email-from", "", "The address emails are sent from. Required if --smtp-addr is set.")
+	flag.StringVar(&emailTo, "email-to", "", "Comma separated addresses that receive the full report of each scan.")
+	flag.StringVar(&emailRecipientsFile, "email-recipients-file", "", "Optional path to a YAML file mapping the values of a namespace label to the addresses that receive the section of the report covering those namespaces.")
+}
+
+// emailRecipients is the format of --email-recipients-file. For example:
+//
+//	label: team
+//	recipients:
+//	  payments: [payments-oncall@example.com]
+//
+// sends the affected Certificates in namespaces labelled team=payments to
+// payments-oncall@example.com.
+type emailRecipients struct {
+	Label      string              `json:"label"`
+	Recipients map[string][]string `json:"recipients"`
+}
+
+// emailNotifier emails a report of each scan over SMTP.
+type emailNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+
+	recipients *emailRecipients
+	// client is used to look up namespace labels if recipients is set.
+	client client.Reader
+}
+
+func newEmailNotifier() (*emailNotifier, error) {
+	if emailFrom == "" {
+		return nil, fmt.Errorf("--email-from must be set when --smtp-addr is set")
+	}
+	n := &emailNotifier{addr: smtpAddr, from: emailFrom}
+	for _, addr := range strings.Split(emailTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			n.to = append(n.to, addr)
+		}
+	}
+	if smtpUsername != "" {
+		password, err := ioutil.ReadFile(smtpPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SMTP password file: %w", err)
+		}
+		host, _, err := net.SplitHostPort(smtpAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --smtp-addr: %w", err)
+		}
+		n.auth = smtp.PlainAuth("", smtpUsername, strings.TrimSpace(string(password)), host)
+	}
+	if emailRecipientsFile != "" {
+		data, err := ioutil.ReadFile(emailRecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading email recipients file: %w", err)
+		}
+		n.recipients = &emailRecipients{}
+		if err := yaml.Unmarshal(data, n.recipients); err != nil {
+			return nil, fmt.Errorf("error reading email recipients file: %w", err)
+		}
+		if n.recipients.Label == "" {
+			return nil, fmt.Errorf("email recipients file must set 'label'")
+		}
+		if n.client, err = newClient(restConfig()); err != nil {
+			return nil, err
+		}
+	}
+	if len(n.to) == 0 && n.recipients == nil {
+		return nil, fmt.Errorf("--email-to or --email-recipients-file must be set when --smtp-addr is set")
+	}
+	return n, nil
+}
+
+func (e *emailNotifier) name() string { return "email" }
+
+func (e *emailNotifier) internal() bool { return true }
+
+func (e *emailNotifier) scanComplete(ctx context.Context, n *scanNotification) error {
+	if len(e.to) > 0 {
+		if err := e.send(e.to, n.title(), n); err != nil {
+			return err
+		}
+	}
+	if e.recipients == nil || len(n.Certificates) == 0 {
+		return nil
+	}
+
+	// Send each group of recipients the section of the report covering the
+	// namespaces with their label value.
+	sections := make(map[string]*scanNotification)
+	labels := make(map[string]string)
+	for _, c := range n.Certificates {
+		value, ok := labels[c.Namespace]
+		if !ok {
+			var ns core.Namespace
+			if err := e.client.Get(ctx, client.ObjectKey{Name: c.Namespace}, &ns); err != nil {
+				return fmt.Errorf("error getting Namespace %q: %w", c.Namespace, err)
+			}
+			value = ns.Labels[e.recipients.Label]
+			labels[c.Namespace] = value
+		}
+		if len(e.recipients.Recipients[value]) == 0 {
+			continue
+		}
+		section := sections[value]
+		if section == nil {
+			section = &scanNotification{Cluster: n.Cluster, Summary: n.Summary}
+			section.Summary.Affected, section.Summary.RenewalTriggered, section.Summary.RenewalFailed = 0, 0, 0
+			sections[value] = section
+		}
+		section.Certificates = append(section.Certificates, c)
+		section.Summary.Affected++
+		if c.RenewalTriggered {
+			section.Summary.RenewalTriggered++
+		}
+		if c.RenewalError != "" {
+			section.Summary.RenewalFailed++
+		}
+	}
+	for value, section := range sections {
+		subject := fmt.Sprintf("%s (%s=%s)", section.title(), e.recipients.Label, value)
+		if err := e.send(e.recipients.Recipients[value], subject, section); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// certificateAffected does nothing, as only reports are emailed.
+func (e *emailNotifier) certificateAffected(context.Context, finding) error {
+	return nil
+}
+
+// send emails n to the given recipients, with an HTML summary as the body
+// and the report attached as Markdown, formatted by --email-template and
+// --report-template if they are set.
+func (e *emailNotifier) send(to []string, subject string, n *scanNotification) error {
+	tmpl := emailTemplate
+	if customEmailTemplate != nil {
+		tmpl = customEmailTemplate
+	}
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, n); err != nil {
+		return fmt.Errorf("error executing template %q: %w", tmpl.Name(), err)
+	}
+	report, err := humanReport(n)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fmt.Fprintf(&body, "From: %s\r\n", e.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64(part, html.Bytes())
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/markdown; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`attachment; filename="report.md"`},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64(part, []byte(report))
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(e.addr, e.auth, e.from, to, body.Bytes()); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	return nil
+}
+
+// writeBase64 writes data to w base64 encoded, wrapped at 76 characters as
+// required by RFC 2045.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// markdownReport formats n as a Markdown document, grouped by namespace.
+func markdownReport(n *scanNotification) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", n.title())
+	fmt.Fprintf(&b, "Scan started at %s and took %s.\n\n", n.Summary.StartedAt.Format(time.RFC3339), time.Duration(n.Summary.DurationSeconds*float64(time.Second)).Round(time.Second))
+	if n.Summary.Error != "" {
+		fmt.Fprintf(&b, "**The scan failed:** `%s`\n", n.Summary.Error)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "| Checked | Skipped | Affected | Renewals triggered | Renewals failed |\n")
+	fmt.Fprintf(&b, "|---|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d | %d |\n", n.Summary.Checked, n.Summary.Skipped, n.Summary.Affected, n.Summary.RenewalTriggered, n.Summary.RenewalFailed)
+
+	for _, group := range n.byNamespace() {
+		fmt.Fprintf(&b, "\n## Namespace %s\n\n", group.Namespace)
+		fmt.Fprintf(&b, "| Certificate | Secret | Serial | Renewal |\n")
+		fmt.Fprintf(&b, "|---|---|---|---|\n")
+		for _, c := range group.Certificates {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", c.Name, c.SecretName, c.Serial, renewalStatus(c))
+		}
+		for _, c := range group.Certificates {
+			if len(c.Suggestions) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "\nSuggested next steps for %s:\n\n", c.Name)
+			for _, s := range c.Suggestions {
+				fmt.Fprintf(&b, "- %s\n", s)
+			}
+		}
+	}
+	writeMarkdownRotations(&b, n.Certificates)
+	return b.String()
+}
+
+// writeMarkdownRotations writes a table comparing the certificate in the
+// Secret of each renewed Certificate in certificates before and after its
+// renewal, if any was checked.
+func writeMarkdownRotations(b *strings.Builder, certificates []finding) {
+	header := false
+	for _, c := range certificates {
+		d := c.Rotation
+		if d == nil {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(b, "\n## Rotations\n\n")
+			fmt.Fprintf(b, "| Certificate | Old serial | New serial | Old notAfter | New notAfter | Rotated |\n")
+			fmt.Fprintf(b, "|---|---|---|---|---|---|\n")
+			header = true
+		}
+		rotated := "Yes"
+		if !d.Rotated {
+			rotated = "No"
+			if d.Problem != "" {
+				rotated += ": " + d.Problem
+			}
+		}
+		fmt.Fprintf(b, "| %s/%s | %s | %s | %s | %s | %s |\n", c.Namespace, c.Name, d.OldSerial, d.NewSerial, markdownTime(d.OldNotAfter), markdownTime(d.NewNotAfter), rotated)
+	}
+}
+
+func markdownTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// renewalStatus describes the outcome of any renewal of c.
+func renewalStatus(c finding) string {
+	switch {
+	case c.RenewalError != "":
+		return "Failed: " + c.RenewalError
+	case c.RenewalTriggered:
+		return "Triggered"
+	case c.ReplicatedFrom != "":
+		return "Will be fixed by replication from " + c.ReplicatedFrom
+	case len(c.SharesSecretWith) > 0:
+		return "Not triggered: Secret is also used by " + strings.Join(c.SharesSecretWith, ", ")
+	case c.Warning != "":
+		return "Not triggered: " + c.Warning
+	case c.PausedBy != "":
+		return "Not triggered: requires unpausing (" + c.PausedBy + ")"
+	case c.IssuerProblem != "":
+		return "Not triggered: unrenewable, " + c.IssuerProblem
+	default:
+		return "Not triggered"
+	}
+}
+
+var emailTemplate = template.Must(template.New("email").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h1>{{ title . }}</h1>
+{{ if .Summary.Error }}<p><strong>The scan failed:</strong> <code>{{ .Summary.Error }}</code></p>{{ else }}
+<table border="1" cellpadding="4" style="border-collapse: collapse">
+<tr><th>Checked</th><th>Skipped</th><th>Affected</th><th>Renewals triggered</th><th>Renewals failed</th></tr>
+<tr><td>{{ .Summary.Checked }}</td><td>{{ .Summary.Skipped }}</td><td>{{ .Summary.Affected }}</td><td>{{ .Summary.RenewalTriggered }}</td><td>{{ .Summary.RenewalFailed }}</td></tr>
+</table>
+{{ if .Certificates }}<h2>Affected certificates</h2>
+<table border="1" cellpadding="4" style="border-collapse: collapse">
+<tr><th>Certificate</th><th>Secret</th><th>Serial</th><th>Renewal</th></tr>
+{{ range .Certificates }}<tr><td>{{ .Namespace }}/{{ .Name }}</td><td>{{ .SecretName }}</td><td>{{ .Serial }}</td><td>{{ renewalStatus . }}</td></tr>
+{{ end }}</table>{{ end }}
+<p>The full report is attached.</p>{{ end }}
+</body>
+</html>
+`))
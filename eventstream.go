@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var eventsFile string
+
+func init() {
+	flag.StringVar(&eventsFile, "events-file", "", "Optional path to a file that a JSON event is appended to as each Certificate is checked, each affected Certificate is found and each renewal is triggered and completes, so that progress can be followed while the tool runs. Set to '-' to write the events to stdout.")
+}
+
+// Values of streamEvent.Event.
+const (
+	eventCertificateChecked = "certificateChecked"
+	eventAffectedFound      = "affectedFound"
+	eventRenewalTriggered   = "renewalTriggered"
+	eventRenewalComplete    = "renewalComplete"
+)
+
+// streamEvent is a line of --events-file.
+type streamEvent struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Time          time.Time `json:"time"`
+	RunID         string    `json:"runId"`
+	Event         string    `json:"event"`
+	Namespace     string    `json:"namespace"`
+	Name          string    `json:"name"`
+	SecretName    string    `json:"secretName,omitempty"`
+	Serial        string    `json:"serial,omitempty"`
+	// Affected is only set for certificateChecked events.
+	Affected *bool  `json:"affected,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Warning  string `json:"warning,omitempty"`
+	// Result, Error and ErrorCode are only set for renewalComplete events.
+	Result    string `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+// eventWriter implements scanner.Events by writing each event to
+// --events-file as a line of JSON. A failure to write is logged rather than
+// failing the run, and no more events are written after it. A nil
+// *eventWriter discards events.
+type eventWriter struct {
+	lock   sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+// eventStream is set by openEventStream if --events-file is set.
+var eventStream *eventWriter
+
+// openEventStream opens --events-file for appending, or uses stdout if it is
+// "-", returning a function that closes it.
+func openEventStream() (func(), error) {
+	switch eventsFile {
+	case "":
+		return func() {}, nil
+	case "-":
+		eventStream = &eventWriter{w: os.Stdout}
+		return func() {}, nil
+	}
+	f, err := os.OpenFile(eventsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening events file: %w", err)
+	}
+	eventStream = &eventWriter{w: f}
+	log.Printf("Writing events to %q", eventsFile)
+	return func() {
+		eventStream.lock.Lock()
+		defer eventStream.lock.Unlock()
+		if err := f.Close(); err != nil {
+			log.Printf("Failed to close events file: %v", err)
+		}
+	}, nil
+}
+
+// scannerEvents returns the Events that Scanners and Renewers are given, or
+// nil if --events-file is not set.
+func scannerEvents() scanner.Events {
+	if eventStream == nil {
+		return nil
+	}
+	return eventStream
+}
+
+// OnCertificateChecked implements scanner.Events.
+func (e *eventWriter) OnCertificateChecked(crt capi.Certificate, serial *big.Int, v scanner.Verdict) {
+	ev := newStreamEvent(eventCertificateChecked, crt)
+	if serial != nil {
+		ev.Serial = fmt.Sprintf("%x", serial)
+	}
+	affected := v.Affected
+	ev.Affected = &affected
+	ev.Reason, ev.Warning = v.Reason, v.Warning
+	e.write(ev)
+}
+
+// OnAffectedFound implements scanner.Events.
+func (e *eventWriter) OnAffectedFound(a scanner.AffectedCertificate) {
+	ev := newStreamEvent(eventAffectedFound, a.Certificate)
+	ev.Serial, ev.Reason, ev.Warning = a.Serial, a.Reason, a.Warning
+	e.write(ev)
+}
+
+// OnRenewalTriggered implements scanner.Events.
+func (e *eventWriter) OnRenewalTriggered(crt capi.Certificate) {
+	e.write(newStreamEvent(eventRenewalTriggered, crt))
+}
+
+// OnRenewalComplete implements scanner.Events.
+func (e *eventWriter) OnRenewalComplete(crt capi.Certificate, err error) {
+	ev := newStreamEvent(eventRenewalComplete, crt)
+	ev.Result = "success"
+	if err != nil {
+		ev.Result = "error"
+		ev.Error = scrubSecrets(err.Error())
+		ev.ErrorCode = string(scanner.ErrorCode(err))
+	}
+	e.write(ev)
+}
+
+func newStreamEvent(event string, crt capi.Certificate) streamEvent {
+	return streamEvent{
+		SchemaVersion: eventSchemaVersion,
+		Time:          time.Now().UTC(),
+		RunID:         runID,
+		Event:         event,
+		Namespace:     crt.Namespace,
+		Name:          crt.Name,
+		SecretName:    crt.Spec.SecretName,
+	}
+}
+
+func (e *eventWriter) write(ev streamEvent) {
+	if e == nil {
+		return
+	}
+	if r := outputRedactor; r != nil {
+		ev.Namespace, ev.Name, ev.SecretName = r.name(ev.Namespace), r.name(ev.Name), r.name(ev.SecretName)
+		ev.Reason, ev.Warning, ev.Error = r.text(ev.Reason), r.text(ev.Warning), r.text(ev.Error)
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("WARNING: unable to encode event: %v", err)
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.failed {
+		return
+	}
+	if _, err := e.w.Write(append(line, '\n')); err != nil {
+		log.Printf("WARNING: unable to write events file, no more events will be written: %v", err)
+		e.failed = true
+	}
+}
+
+// withRemediationEvents returns r, telling --events-file when each
+// remediation starts and finishes. It is used for remediators other than
+// cert-manager, whose Renewer reports its own events.
+func withRemediationEvents(r renewer.Remediator) renewer.Remediator {
+	if eventStream == nil {
+		return r
+	}
+	return renewer.RemediatorFunc(func(ctx context.Context, crt capi.Certificate) error {
+		eventStream.OnRenewalTriggered(crt)
+		err := r.Remediate(ctx, crt)
+		eventStream.OnRenewalComplete(crt, err)
+		return err
+	})
+}
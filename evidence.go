@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// evidenceManifest describes the contents of an evidence bundle. It is
+// written to manifest.json in the bundle.
+type evidenceManifest struct {
+	ExportedAt  time.Time `json:"exportedAt"`
+	ToolVersion string    `json:"toolVersion"`
+	Cluster     string    `json:"cluster,omitempty"`
+	// Run is how the report was produced, copied from the report.
+	Run *reportRun `json:"run,omitempty"`
+	// Dataset describes the affected serials file, if
+	// --affected-serials-file was given. The file itself is not included,
+	// as it is large and public.
+	Dataset *evidenceDataset `json:"dataset,omitempty"`
+	// Files lists every other file in the bundle.
+	Files []evidenceFile `json:"files"`
+}
+
+type evidenceDataset struct {
+	Path       string    `json:"path"`
+	SHA256     string    `json:"sha256"`
+	Bytes      int64     `json:"bytes"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+type evidenceFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// runExportEvidence implements the 'export-evidence' command, which writes
+// the report given by --report-file, its signatures, the audit log given by
+// --audit-log-file, the API trace and verification report if given, and a
+// manifest describing them and the dataset to the zip file given as an
+// argument.
+func runExportEvidence(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("the path of the zip file to write must be given")
+	}
+	if reportFile == "" {
+		return fmt.Errorf("--report-file must be specified")
+	}
+	r, err := readReport(reportFile)
+	if err != nil {
+		return err
+	}
+	manifest := evidenceManifest{
+		ExportedAt:  time.Now().UTC(),
+		ToolVersion: toolVersion(),
+		Cluster:     clusterName,
+		Run:         r.Run,
+		Files:       []evidenceFile{},
+	}
+	if affectedSerialsFile != "" {
+		info, err := os.Stat(affectedSerialsFile)
+		if err != nil {
+			return err
+		}
+		sum, err := fileSHA256(affectedSerialsFile)
+		if err != nil {
+			return fmt.Errorf("error hashing affected serials file: %w", err)
+		}
+		manifest.Dataset = &evidenceDataset{Path: affectedSerialsFile, SHA256: sum, Bytes: info.Size(), ModifiedAt: info.ModTime().UTC()}
+	}
+
+	files := []string{reportFile}
+	// The signatures written by --report-signing-key are included if they
+	// exist.
+	for _, path := range []string{reportFile + ".sig", reportFile + ".intoto.json"} {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	if auditLogFile != "" {
+		files = append(files, auditLogFile)
+	} else {
+		log.Printf("WARNING: --audit-log-file is not set, so the evidence will not include a record of the changes made to the cluster")
+	}
+	if apiTraceFile != "" {
+		files = append(files, apiTraceFile)
+	}
+	if verificationReportFile != "" {
+		files = append(files, verificationReportFile)
+	}
+
+	out, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, path := range files {
+		f, err := addEvidenceFile(zw, path)
+		if err != nil {
+			return fmt.Errorf("error adding %q to evidence: %w", path, err)
+		}
+		manifest.Files = append(manifest.Files, f)
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: manifest.ExportedAt})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	log.Printf("Wrote evidence bundle %q with %d files", args[0], len(manifest.Files)+1)
+	return nil
+}
+
+// addEvidenceFile copies the file at path into zw, named by its base name,
+// and returns its description for the manifest.
+func addEvidenceFile(zw *zip.Writer, path string) (evidenceFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return evidenceFile{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return evidenceFile{}, err
+	}
+	name := filepath.Base(path)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: info.ModTime()})
+	if err != nil {
+		return evidenceFile{}, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), f)
+	if err != nil {
+		return evidenceFile{}, err
+	}
+	return evidenceFile{Name: name, SHA256: hex.EncodeToString(h.Sum(nil)), Bytes: n}, nil
+}
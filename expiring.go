@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+// daysFlag is a duration that may also be given in days, such as "30d".
+type daysFlag time.Duration
+
+func (f *daysFlag) String() string {
+	if *f == 0 {
+		return ""
+	}
+	return formatDays(time.Duration(*f))
+}
+
+func (f *daysFlag) Set(value string) error {
+	var d time.Duration
+	var err error
+	if strings.HasSuffix(value, "d") {
+		var days float64
+		days, err = strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		d = time.Duration(days * float64(24*time.Hour))
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a number of days such as 30d, or a duration such as 12h", value)
+	}
+	if d <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	*f = daysFlag(d)
+	return nil
+}
+
+// formatDays formats d as a whole number of days if it is one, and
+// otherwise as a duration.
+func formatDays(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+var expiringWithin daysFlag
+
+func init() {
+	flag.Var(&expiringWithin, "expiring-within", "If set (e.g. '30d'), certificates are reported as affected if they expire within this long, instead of being checked for the incident, along with whether cert-manager will renew them in time. Cannot be combined with --renew or with other ways of detecting affected certificates.")
+}
+
+func validateExpiringFlags() error {
+	if expiringWithin == 0 {
+		return nil
+	}
+	if renew {
+		return fmt.Errorf("--expiring-within cannot be combined with --renew, as it only reports on certificates that cert-manager will normally renew itself")
+	}
+	if affectedSerialsFile != "" || detectorCommand != "" || checkerAPI {
+		return fmt.Errorf("--expiring-within cannot be combined with --affected-serials-file, --detector-command or --checker-api, as expiring certificates would be reported along with affected ones")
+	}
+	log.Printf("Reporting certificates expiring within %s as affected, rather than checking for an incident", formatDays(time.Duration(expiringWithin)))
+	return nil
+}
+
+// expiringDetector reports certificates expiring within --expiring-within
+// as affected.
+type expiringDetector struct {
+	within time.Duration
+	now    func() time.Time
+}
+
+// Detect implements scanner.Detector.
+func (d *expiringDetector) Detect(_ context.Context, crt capi.Certificate, cert *x509.Certificate) (scanner.Verdict, error) {
+	now := d.now()
+	left := cert.NotAfter.Sub(now)
+	if left > d.within {
+		return scanner.Verdict{}, nil
+	}
+	var reason string
+	if left <= 0 {
+		reason = fmt.Sprintf("expired on %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	} else {
+		reason = fmt.Sprintf("expires on %s, in %s", cert.NotAfter.UTC().Format(time.RFC3339), formatDays(left.Round(24*time.Hour)))
+	}
+	return scanner.Verdict{Affected: true, Reason: reason + ", " + renewalOutlook(crt, cert, now)}, nil
+}
+
+// renewalOutlook describes whether cert-manager will renew cert, which is
+// stored in the Secret of crt, before it expires.
+func renewalOutlook(crt capi.Certificate, cert *x509.Certificate, now time.Time) string {
+	if secretsOnly {
+		return "and it is not managed by cert-manager, so it must be renewed by hand"
+	}
+	renewBefore := capi.DefaultRenewBefore
+	if crt.Spec.RenewBefore != nil {
+		renewBefore = crt.Spec.RenewBefore.Duration
+	}
+	renewAt := cert.NotAfter.Add(-renewBefore)
+	if renewAt.After(now) {
+		return fmt.Sprintf("and cert-manager will renew it from %s", renewAt.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("but cert-manager should have renewed it from %s, so renewal is failing or the Certificate is not being reconciled", renewAt.UTC().Format(time.RFC3339))
+}
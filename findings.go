@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+// finding is a Certificate currently known to be affected in serve mode.
+type finding struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	SecretName string `json:"secretName"`
+	IssuerName string `json:"issuerName,omitempty"`
+	IssuerKind string `json:"issuerKind,omitempty"`
+	Serial     string `json:"serial"`
+	// Reason explains why the Certificate is affected.
+	Reason string `json:"reason,omitempty"`
+	// ReplicatedFrom is the namespace/name of the Secret that the Secret of
+	// the Certificate was replicated from, if it is a replica. It will be
+	// fixed by replication once the source has been renewed.
+	ReplicatedFrom string `json:"replicatedFrom,omitempty"`
+	// SharesSecretWith lists the other Certificates using the same Secret,
+	// which prevents the Certificate from being renewed automatically.
+	SharesSecretWith []string `json:"sharesSecretWith,omitempty"`
+	// PausedBy is the annotation pausing the Certificate, if any, which
+	// must be removed before it can be renewed.
+	PausedBy string `json:"pausedBy,omitempty"`
+	// Warning, if set, explains why the Certificate may not really be
+	// affected, which prevents it from being renewed automatically.
+	Warning string `json:"warning,omitempty"`
+	// IssuerProblem, if set, explains why the issuer of the Certificate
+	// cannot issue a new certificate, which prevents it from being renewed
+	// automatically.
+	IssuerProblem string `json:"issuerProblem,omitempty"`
+	// Suggestions lists the steps that could be taken to fix the
+	// Certificate, if it cannot be renewed automatically.
+	Suggestions []string `json:"suggestions,omitempty"`
+	// CertificateRequest is set if the affected certificate was found by
+	// --watch-issuance in the status of this CertificateRequest, as soon as
+	// it was issued.
+	CertificateRequest string `json:"certificateRequest,omitempty"`
+	// Reissued is true if the Certificate was already affected, and has
+	// since been issued a new certificate that is also affected.
+	Reissued bool      `json:"reissued,omitempty"`
+	FoundAt  time.Time `json:"foundAt"`
+	// RenewalTriggered is true if a renewal has been triggered since the
+	// Certificate was found to be affected.
+	RenewalTriggered bool `json:"renewalTriggered,omitempty"`
+	// RenewalError is the error returned by the most recent renewal attempt,
+	// and RenewalErrorCode its machine-readable code.
+	RenewalError     string `json:"renewalError,omitempty"`
+	RenewalErrorCode string `json:"renewalErrorCode,omitempty"`
+	// Rotation compares the certificate in the Secret before and after the
+	// renewal, once the renewal has been checked.
+	Rotation *rotationDiff `json:"rotation,omitempty"`
+	// ResolvedAt is set once the Certificate is no longer affected.
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// setRenewalError records that the most recent renewal attempt failed with
+// err.
+func (f *finding) setRenewalError(err error) {
+	f.RenewalError = scrubSecrets(err.Error())
+	f.RenewalErrorCode = string(scanner.ErrorCode(err))
+}
+
+// findings holds the current state of every affected Certificate found by
+// the Certificate controller. It is safe for concurrent use.
+type findings struct {
+	lock         sync.RWMutex
+	certificates map[string]finding
+	// resolved holds Certificates that were affected but no longer are, for
+	// example because they have since been renewed.
+	resolved map[string]finding
+	// active is true once the controllers have started, which is only the
+	// case on the leader if leader election is enabled.
+	active bool
+}
+
+func newFindings() *findings {
+	return &findings{certificates: make(map[string]finding), resolved: make(map[string]finding)}
+}
+
+func (f *findings) setActive(active bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.active = active
+}
+
+func (f *findings) isActive() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.active
+}
+
+// record stores the latest state of an affected Certificate, returning true
+// if it was not already known to be affected with the same serial number.
+// FoundAt is preserved if it was.
+func (f *findings) record(c finding) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	key := c.Namespace + "/" + c.Name
+	existing, known := f.certificates[key]
+	known = known && existing.Serial == c.Serial
+	if known {
+		c.FoundAt = existing.FoundAt
+	}
+	if c.FoundAt.IsZero() {
+		c.FoundAt = time.Now().UTC()
+	}
+	f.certificates[key] = c
+	delete(f.resolved, key)
+	return !known
+}
+
+// remove records that the Certificate with the given namespace/name key is
+// no longer affected.
+func (f *findings) remove(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	c, ok := f.certificates[key]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	c.ResolvedAt = &now
+	f.resolved[key] = c
+	delete(f.certificates, key)
+}
+
+// get returns the affected Certificate with the given namespace/name key.
+func (f *findings) get(key string) (finding, bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	c, ok := f.certificates[key]
+	return c, ok
+}
+
+// list returns every affected Certificate, sorted by namespace and name.
+func (f *findings) list() []finding {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return sortFindings(f.certificates)
+}
+
+// listResolved returns every Certificate that is no longer affected, sorted
+// by namespace and name.
+func (f *findings) listResolved() []finding {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return sortFindings(f.resolved)
+}
+
+func sortFindings(m map[string]finding) []finding {
+	list := make([]finding, 0, len(m))
+	for _, c := range m {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Namespace != list[j].Namespace {
+			return list[i].Namespace < list[j].Namespace
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	stuckAfter time.Duration
+	resetStuck bool
+)
+
+func init() {
+	flag.DurationVar(&stuckAfter, "stuck-after", 6*time.Hour, "How long an issuance may make no progress before the 'fix-stuck-renewals' command considers it stuck.")
+	flag.BoolVar(&resetStuck, "reset", false, "If true, the 'fix-stuck-renewals' command deletes the CertificateRequests blocking each stuck issuance, so that cert-manager starts it again. Otherwise, stuck issuances are only listed.")
+}
+
+// runFixStuckRenewals implements the 'fix-stuck-renewals' command, which
+// finds Certificates whose issuance has made no progress for --stuck-after,
+// including renewals triggered by this tool that cert-manager never acted
+// on, along with CertificateRequests whose Certificate has been deleted, and
+// resets them if --reset is set.
+func runFixStuckRenewals(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	if stuckAfter <= 0 {
+		return fmt.Errorf("--stuck-after must be positive")
+	}
+	if resetStuck && readOnly {
+		return fmt.Errorf("--read-only cannot be combined with --reset")
+	}
+	var cl client.Client
+	if fixturesDir != "" {
+		sim, err := loadFixtures(fixturesDir)
+		if err != nil {
+			return err
+		}
+		defer sim.logSummary()
+		cl = sim
+	} else {
+		cfg := restConfig()
+		var err error
+		if cl, err = newClient(cfg); err != nil {
+			return err
+		}
+		if err := checkCertificateCRD(cfg); err != nil {
+			return err
+		}
+		if err := checkPermissions(cfg, "fix-stuck"); err != nil {
+			return err
+		}
+		if resetStuck {
+			closeAuditLog, err := openAuditLog()
+			if err != nil {
+				return err
+			}
+			defer closeAuditLog()
+			releaseRunLock, err := acquireRunLock(cfg)
+			if err != nil {
+				return err
+			}
+			defer releaseRunLock()
+		}
+	}
+
+	ctx := context.Background()
+	namespaces, err := newScanner(cl, nil).ListNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	r := newRenewer(cl)
+	now := time.Now()
+	found, failed := 0, 0
+	var resets []outcome
+	for _, ns := range namespaces {
+		stuck, err := renewer.FindStuck(ctx, cl, ns, now, stuckAfter)
+		if err != nil {
+			log.Printf("WARNING: unable to check for stuck issuances in namespace %q: %v", ns, err)
+			failed++
+			continue
+		}
+		for _, s := range stuck {
+			found++
+			logStuckIssuance(s)
+			if !resetStuck || len(s.Requests) == 0 {
+				continue
+			}
+			o := resetOutcome(s)
+			err := r.Reset(ctx, s)
+			resets = append(resets, finishResetOutcome(o, err))
+			if err != nil {
+				log.Printf("Failed to reset: %v", err)
+				failed++
+				continue
+			}
+			if s.Certificate != "" {
+				log.Printf("    Reset, cert-manager will start the issuance again")
+			}
+		}
+	}
+	log.Println()
+	log.Printf("Found %d stuck issuances in %d namespaces", found, len(namespaces))
+	if found > 0 && !resetStuck {
+		log.Printf("Run again with --reset to delete the CertificateRequests blocking them")
+	}
+	if resetStuck {
+		if err := writeOutcomes("fix-stuck-renewals", now, resets); err != nil {
+			return fmt.Errorf("error writing outcomes: %w", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d namespace(s) or issuances could not be checked or reset", failed)
+	}
+	return nil
+}
+
+// logStuckIssuance logs why s is stuck, and what resetting it would delete.
+func logStuckIssuance(s renewer.StuckIssuance) {
+	log.Println()
+	if s.Certificate == "" {
+		log.Printf("Orphaned CertificateRequests in namespace %s:", s.Namespace)
+	} else {
+		log.Printf("Certificate %s/%s is stuck:", s.Namespace, s.Certificate)
+	}
+	for _, reason := range s.Reasons {
+		log.Printf("  * %s", reason)
+	}
+	if len(s.Requests) == 0 {
+		log.Printf("    It cannot be reset automatically, as there is no CertificateRequest blocking it")
+		return
+	}
+	verb := "Would delete"
+	if resetStuck {
+		verb = "Deleting"
+	}
+	for _, req := range s.Requests {
+		log.Printf("    %s CertificateRequest %s/%s", verb, req.Namespace, req.Name)
+	}
+}
+
+// resetOutcome returns the outcome of resetting s, before it is reset.
+func resetOutcome(s renewer.StuckIssuance) outcome {
+	o := outcome{Namespace: s.Namespace, Name: s.Certificate, Action: outcomeReset, TriggeredAt: time.Now().UTC()}
+	for _, req := range s.Requests {
+		o.DeletedCertificateRequests = append(o.DeletedCertificateRequests, req.Name)
+	}
+	return o
+}
+
+// finishResetOutcome records in o that the reset has finished with err.
+func finishResetOutcome(o outcome, err error) outcome {
+	at := time.Now().UTC()
+	o.CheckedAt = &at
+	o.Status = outcomeResetDone
+	if err != nil {
+		o.Status = outcomeFailed
+		o.Error = scrubSecrets(err.Error())
+		o.ErrorCode = string(scanner.ErrorCode(err))
+	}
+	return o
+}
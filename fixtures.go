@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jetstack/cert-manager/pkg/api"
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	kscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var fixturesDir string
+
+func init() {
+	flag.StringVar(&fixturesDir, "fixtures", "", "If set, Namespaces, Certificates, Secrets, CertificateRequests, Issuers and ClusterIssuers are loaded from the YAML or JSON files in this directory instead of a cluster, and the scan (and renewals, if --renew is set) are simulated against them. Changes that would be made are logged, and notifications, metrics and the audit log are disabled.")
+}
+
+func validateFixturesFlags() error {
+	if fixturesDir == "" {
+		return nil
+	}
+	if watchMode {
+		return fmt.Errorf("--fixtures cannot be combined with --watch")
+	}
+	if cacheFile != "" {
+		return fmt.Errorf("--fixtures cannot be combined with --cache-file")
+	}
+	return nil
+}
+
+// simulationClient is an in-memory API client loaded from fixtures. Changes
+// are logged and applied in memory, and cert-manager is simulated by creating
+// a CertificateRequest whenever a renewal is triggered.
+type simulationClient struct {
+	client.Client
+
+	lock    sync.Mutex
+	changes []string
+	// hasIssuers is true if the fixtures include any Issuers or
+	// ClusterIssuers.
+	hasIssuers bool
+}
+
+// loadFixtures builds a simulationClient containing the objects in every
+// .yaml, .yml and .json file in dir.
+func loadFixtures(dir string) (*simulationClient, error) {
+	// The fake client decodes objects using the client-go scheme, so it must
+	// know about cert-manager's types.
+	utilruntime.Must(capi.AddToScheme(kscheme.Scheme))
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixtures: %w", err)
+	}
+	var objs []runtime.Object
+	for _, f := range files {
+		switch filepath.Ext(f.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		fileObjs, err := readFixtureFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading fixtures from %q: %w", path, err)
+		}
+		objs = append(objs, fileObjs...)
+	}
+	objs = completeFixtures(objs)
+	log.Printf("Loaded %d objects from fixtures in %q", len(objs), dir)
+	c := &simulationClient{Client: fake.NewFakeClientWithScheme(api.Scheme, objs...)}
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *capi.Issuer, *capi.ClusterIssuer:
+			c.hasIssuers = true
+		}
+	}
+	return c, nil
+}
+
+func readFixtureFile(path string) ([]runtime.Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := serializer.NewCodecFactory(api.Scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+	var objs []runtime.Object
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// completeFixtures fills in the details that the API server and cert-manager
+// would normally set, so that fixtures can be kept short: a UID for every
+// object, the UID of the Certificate each CertificateRequest is controlled
+// by and a Namespace for every namespace used.
+func completeFixtures(objs []runtime.Object) []runtime.Object {
+	certUIDs := make(map[string]types.UID)
+	// namespaces maps each namespace used to whether a Namespace exists
+	// for it.
+	namespaces := make(map[string]bool)
+	for _, obj := range objs {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		if m.GetUID() == "" {
+			kind, _ := apiutil.GVKForObject(obj, api.Scheme)
+			m.SetUID(types.UID(fmt.Sprintf("fixture-%s-%s-%s", strings.ToLower(kind.Kind), m.GetNamespace(), m.GetName())))
+		}
+		if crt, ok := obj.(*capi.Certificate); ok {
+			certUIDs[crt.Namespace+"/"+crt.Name] = crt.UID
+		}
+		if ns, ok := obj.(*core.Namespace); ok {
+			namespaces[ns.Name] = true
+		} else if _, ok := namespaces[m.GetNamespace()]; !ok && m.GetNamespace() != "" {
+			namespaces[m.GetNamespace()] = false
+		}
+	}
+	for _, obj := range objs {
+		req, ok := obj.(*capi.CertificateRequest)
+		if !ok {
+			continue
+		}
+		for i, ref := range req.OwnerReferences {
+			if ref.Kind == capi.CertificateKind && ref.UID == "" {
+				req.OwnerReferences[i].UID = certUIDs[req.Namespace+"/"+ref.Name]
+			}
+		}
+	}
+	var missing []string
+	for ns, exists := range namespaces {
+		if !exists {
+			missing = append(missing, ns)
+		}
+	}
+	sort.Strings(missing)
+	for _, ns := range missing {
+		objs = append(objs, &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns, UID: types.UID("fixture-namespace-" + ns)}})
+	}
+	return objs
+}
+
+func (c *simulationClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	c.recordChange("create", obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *simulationClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if len((&client.UpdateOptions{}).ApplyOptions(opts).DryRun) > 0 {
+		return c.dryRun(ctx, obj)
+	}
+	c.recordChange("update", obj)
+	if err := c.Client.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	if secret, ok := obj.(*core.Secret); ok && secret.Annotations[capi.IssuerNameAnnotationKey] == renewer.RenewalAnnotationValue {
+		return c.issue(ctx, secret)
+	}
+	return nil
+}
+
+func (c *simulationClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.recordChange("patch", obj)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *simulationClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	if len((&client.DeleteOptions{}).ApplyOptions(opts).DryRun) > 0 {
+		return c.dryRun(ctx, obj)
+	}
+	c.recordChange("delete", obj)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// dryRun simulates a server-side dry-run of a change to obj, which the fake
+// client does not support, by checking that obj exists. There is no
+// admission webhook to reject it.
+func (c *simulationClient) dryRun(ctx context.Context, obj runtime.Object) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+	existing := obj.DeepCopyObject()
+	return c.Client.Get(ctx, key, existing)
+}
+
+// issue simulates cert-manager reacting to a renewal being triggered, by
+// creating a pending CertificateRequest for each Certificate using secret.
+func (c *simulationClient) issue(ctx context.Context, secret *core.Secret) error {
+	var certs capi.CertificateList
+	if err := c.Client.List(ctx, &certs, client.InNamespace(secret.Namespace)); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	n := len(c.changes)
+	c.lock.Unlock()
+	for i := range certs.Items {
+		crt := &certs.Items[i]
+		if crt.Spec.SecretName != secret.Name {
+			continue
+		}
+		req := &capi.CertificateRequest{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       crt.Namespace,
+				Name:            fmt.Sprintf("%s-simulated-%d", crt.Name, n),
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(crt, capi.SchemeGroupVersion.WithKind(capi.CertificateKind))},
+				// Set by the API server, so that the request is
+				// recognised as new.
+				CreationTimestamp: metav1.Now(),
+			},
+			Spec: capi.CertificateRequestSpec{IssuerRef: crt.Spec.IssuerRef},
+		}
+		log.Printf("[simulation] cert-manager would create CertificateRequest %s/%s for Certificate %s", req.Namespace, req.Name, crt.Name)
+		if err := c.Client.Create(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *simulationClient) recordChange(action string, obj runtime.Object) {
+	kind, _ := apiutil.GVKForObject(obj, api.Scheme)
+	name := "unknown"
+	if m, err := meta.Accessor(obj); err == nil {
+		name = m.GetNamespace() + "/" + m.GetName()
+	}
+	change := fmt.Sprintf("%s %s %s", action, kind.Kind, name)
+	log.Printf("[simulation] Would %s", change)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.changes = append(c.changes, change)
+}
+
+// logSummary logs every change that would have been made to the cluster.
+func (c *simulationClient) logSummary() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	log.Printf("Simulation complete, %d changes would have been made to the cluster:", len(c.changes))
+	for _, change := range c.changes {
+		log.Printf("  %s", change)
+	}
+}
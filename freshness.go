@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	finalSerialsSHA256 string
+	finalSerialsCount  int
+	finalSerialsDate   string
+)
+
+func init() {
+	flag.StringVar(&finalSerialsSHA256, "affected-serials-final-sha256", "", "Optional SHA-256 of the final published version of the affected serials file, after decompression. A prominent warning is logged if the file loaded does not match.")
+	flag.IntVar(&finalSerialsCount, "affected-serials-final-count", 0, "Optional number of serials in the final published version of the affected serials file. A prominent warning is logged if the file loaded has fewer, as it is probably a partial early dump.")
+	flag.StringVar(&finalSerialsDate, "affected-serials-final-date", "", "Optional date (YYYY-MM-DD or RFC 3339) on which the final version of the affected serials file was published. A prominent warning is logged if the file loaded was last modified before then.")
+}
+
+// incidentDatasetFinal describes the final published version of a dataset,
+// so that scans with a partial early dump, which misses affected
+// certificates, can be detected.
+type incidentDatasetFinal struct {
+	// SHA256 is the hash of the dataset after decompression.
+	SHA256 string `json:"sha256,omitempty"`
+	// Serials is the number of distinct serial numbers in the dataset.
+	Serials int `json:"serials,omitempty"`
+	// PublishedAt is when the final version was published.
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+}
+
+// parseFinalSerialsDate parses --affected-serials-final-date.
+func parseFinalSerialsDate() (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, finalSerialsDate); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", finalSerialsDate)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --affected-serials-final-date %q: must be YYYY-MM-DD or RFC 3339", finalSerialsDate)
+	}
+	return t, nil
+}
+
+// checkDatasetFreshness compares the affected serials file at path, from
+// which count serials were loaded, with the final version of the dataset
+// given by flags, and logs a prominent warning if it appears to be older or
+// smaller.
+func checkDatasetFreshness(path string, count int) error {
+	if finalSerialsSHA256 == "" && finalSerialsCount == 0 && finalSerialsDate == "" {
+		return nil
+	}
+	stale := false
+	warn := func(format string, args ...interface{}) {
+		stale = true
+		log.Printf("!!!!! WARNING: "+format+". Scanning with a partial or early version of the dataset will MISS affected certificates. Download the final version and run again !!!!!", args...)
+	}
+	if finalSerialsCount > 0 && count < finalSerialsCount {
+		warn("the affected serials file %q contains %d serials, fewer than the %d in the final version of the dataset", path, count, finalSerialsCount)
+	}
+	if finalSerialsDate != "" {
+		published, err := parseFinalSerialsDate()
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(published) {
+			warn("the affected serials file %q was last modified at %s, before the final version of the dataset was published at %s", path, info.ModTime().UTC().Format(time.RFC3339), published.UTC().Format(time.RFC3339))
+		}
+	}
+	if finalSerialsSHA256 != "" {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return fmt.Errorf("error hashing affected serials file: %w", err)
+		}
+		switch {
+		case strings.EqualFold(sum, finalSerialsSHA256):
+			log.Printf("Affected serials file matches the final version of the dataset (SHA-256 %s)", sum)
+		case stale:
+			// The file has already been reported as stale.
+		case finalSerialsCount > 0:
+			// The file is at least as large as the final version, so it may
+			// have been reformatted rather than truncated.
+			log.Printf("WARNING: the SHA-256 of the affected serials file %q is %s, not %s as for the final version of the dataset. Check that it has not been modified.", path, sum, strings.ToLower(finalSerialsSHA256))
+		default:
+			warn("the SHA-256 of the affected serials file %q is %s, not %s as for the final version of the dataset, so it may be an earlier version", path, sum, strings.ToLower(finalSerialsSHA256))
+		}
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -3,9 +3,16 @@ module github.com/jetstack/letsencrypt-caa-bug-checker
 go 1.13
 
 require (
+	github.com/aws/aws-sdk-go v1.24.1
 	github.com/jetstack/cert-manager v0.13.1
+	github.com/prometheus/client_golang v1.0.0
+	github.com/prometheus/common v0.4.1
+	golang.org/x/crypto v0.0.0-20191202143827-86a70503ff7e
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	k8s.io/api v0.17.0
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.17.0
+	k8s.io/klog v1.0.0
 	sigs.k8s.io/controller-runtime v0.3.1-0.20191022174215-ad57a976ffa1
+	sigs.k8s.io/yaml v1.1.0
 )
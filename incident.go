@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"sigs.k8s.io/yaml"
+)
+
+var incidentFile string
+
+func init() {
+	flag.StringVar(&incidentFile, "incident-file", "", "Optional path to a YAML or JSON incident descriptor, describing which certificates are affected by a mass revocation event and how to detect them. Flags given explicitly take precedence over the descriptor.")
+}
+
+// Detection methods supported by incident descriptors.
+const (
+	// detectionSerials checks certificates against the dataset of affected
+	// serial numbers.
+	detectionSerials = "serials"
+	// detectionWindow treats every certificate issued by the CA within the
+	// affected window as affected.
+	detectionWindow = "window"
+	// detectionCommand runs an external detector command.
+	detectionCommand = "command"
+)
+
+// incident describes a mass revocation event, so that future incidents can be
+// handled by publishing a descriptor rather than changing the tool.
+type incident struct {
+	// Name identifies the incident, and is used in alert deduplication keys.
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// CA is the organization name of the CA whose certificates are affected.
+	CA string `json:"ca"`
+	// AffectedWindow is the period in which affected certificates were
+	// issued.
+	AffectedWindow *incidentWindow   `json:"affectedWindow,omitempty"`
+	Dataset        *incidentDataset  `json:"dataset,omitempty"`
+	Detection      incidentDetection `json:"detection"`
+}
+
+type incidentWindow struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// contains returns true if t falls within the window.
+func (w *incidentWindow) contains(t time.Time) bool {
+	return !t.Before(w.From) && !t.After(w.To)
+}
+
+type incidentDataset struct {
+	// URL is where the affected serials are published. Files ending in .gz
+	// are decompressed.
+	URL string `json:"url"`
+	// Format is either "lecaa" (the default) or "hex".
+	Format string `json:"format,omitempty"`
+	// Final, if set, describes the final version of the dataset, so that
+	// older or partial copies can be detected.
+	Final *incidentDatasetFinal `json:"final,omitempty"`
+}
+
+type incidentDetection struct {
+	// Method is one of "serials" (the default), "window" or "command".
+	Method string `json:"method,omitempty"`
+	// Command is run for each certificate if Method is "command", in the
+	// same way as --detector-command.
+	Command string `json:"command,omitempty"`
+	// Timeout limits how long Command may run for each certificate.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// currentIncident is the descriptor loaded from --incident-file, if any.
+var currentIncident *incident
+
+// letsEncryptCAAWindow is the period in which certificates affected by the
+// Let's Encrypt CAA rechecking bug were issued, from the deployment of the bug
+// until it was fixed.
+var letsEncryptCAAWindow = scanner.Window{
+	From: time.Date(2019, time.July, 25, 0, 0, 0, 0, time.UTC),
+	To:   time.Date(2020, time.February, 29, 3, 8, 0, 0, time.UTC),
+}
+
+// issuanceWindow returns the window in which certificates in the affected
+// serials dataset should have been issued: the affectedWindow of the
+// incident descriptor if one was loaded, or otherwise that of the Let's
+// Encrypt CAA rechecking bug.
+func issuanceWindow() *scanner.Window {
+	if currentIncident == nil {
+		w := letsEncryptCAAWindow
+		return &w
+	}
+	if currentIncident.AffectedWindow == nil {
+		return nil
+	}
+	return &scanner.Window{From: currentIncident.AffectedWindow.From, To: currentIncident.AffectedWindow.To}
+}
+
+// loadIncident reads and validates the descriptor at path.
+func loadIncident(path string) (*incident, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading incident file: %w", err)
+	}
+	inc := &incident{}
+	if err := yaml.UnmarshalStrict(data, inc); err != nil {
+		return nil, fmt.Errorf("error decoding incident file %q: %w", path, err)
+	}
+	if inc.Name == "" {
+		return nil, fmt.Errorf("incident file %q must set name", path)
+	}
+	if inc.Detection.Method == "" {
+		inc.Detection.Method = detectionSerials
+	}
+	if inc.AffectedWindow != nil && inc.AffectedWindow.To.Before(inc.AffectedWindow.From) {
+		return nil, fmt.Errorf("incident file %q: affectedWindow.to is before affectedWindow.from", path)
+	}
+	switch inc.Detection.Method {
+	case detectionSerials:
+		if inc.Dataset == nil || inc.Dataset.URL == "" {
+			return nil, fmt.Errorf("incident file %q must set dataset.url to detect affected certificates by serial number", path)
+		}
+	case detectionWindow:
+		if inc.CA == "" || inc.AffectedWindow == nil {
+			return nil, fmt.Errorf("incident file %q must set ca and affectedWindow to detect affected certificates by issuance window", path)
+		}
+	case detectionCommand:
+		if inc.Detection.Command == "" {
+			return nil, fmt.Errorf("incident file %q must set detection.command", path)
+		}
+	default:
+		return nil, fmt.Errorf("incident file %q has unknown detection method %q", path, inc.Detection.Method)
+	}
+	return inc, nil
+}
+
+// applyIncidentFile loads --incident-file, if set, and uses it to set any of
+// the flags it covers that were not given explicitly.
+func applyIncidentFile() error {
+	if incidentFile == "" {
+		return nil
+	}
+	inc, err := loadIncident(incidentFile)
+	if err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	setDefault := func(name, value string) error {
+		if set[name] || value == "" {
+			return nil
+		}
+		return flag.Set(name, value)
+	}
+
+	if err := setDefault("alert-incident", inc.Name); err != nil {
+		return err
+	}
+	if inc.Dataset != nil && inc.Detection.Method == detectionSerials {
+		if offline {
+			log.Printf("Not downloading the dataset of incident %q, as --offline is set", inc.Name)
+		} else if checkerAPI {
+			log.Printf("Not downloading the dataset of incident %q, as --checker-api is set", inc.Name)
+		} else if err := setDefault("affected-serials-url", inc.Dataset.URL); err != nil {
+			return err
+		}
+		if err := setDefault("affected-serials-format", inc.Dataset.Format); err != nil {
+			return err
+		}
+		if final := inc.Dataset.Final; final != nil {
+			if err := setDefault("affected-serials-final-sha256", final.SHA256); err != nil {
+				return err
+			}
+			if final.Serials > 0 {
+				if err := setDefault("affected-serials-final-count", strconv.Itoa(final.Serials)); err != nil {
+					return err
+				}
+			}
+			if final.PublishedAt != nil {
+				if err := setDefault("affected-serials-final-date", final.PublishedAt.Format(time.RFC3339)); err != nil {
+					return err
+				}
+			}
+		}
+		// The dataset is downloaded to a file named after the incident,
+		// unless a path to download it to is given.
+		if err := setDefault("affected-serials-file", filepath.Join(os.TempDir(), inc.Name+"-affected-serials.txt")); err != nil {
+			return err
+		}
+	}
+	if inc.Detection.Method == detectionCommand {
+		if err := setDefault("detector-command", inc.Detection.Command); err != nil {
+			return err
+		}
+		if err := setDefault("detector-timeout", inc.Detection.Timeout); err != nil {
+			return err
+		}
+	}
+	currentIncident = inc
+	log.Printf("Loaded incident %q from %q (detection method: %s)", inc.Name, incidentFile, inc.Detection.Method)
+	return nil
+}
+
+// incidentDetector returns a detector that treats every certificate issued
+// by the CA of inc within its affected window as affected.
+func incidentDetector(inc *incident) scanner.Detector {
+	reason := fmt.Sprintf("issued by %s between %s and %s", inc.CA, inc.AffectedWindow.From.Format(time.RFC3339), inc.AffectedWindow.To.Format(time.RFC3339))
+	return scanner.DetectorFunc(func(_ context.Context, _ capi.Certificate, cert *x509.Certificate) (scanner.Verdict, error) {
+		if issuedBy(cert, inc.CA) && inc.AffectedWindow.contains(cert.NotBefore) {
+			return scanner.Verdict{Affected: true, Reason: reason}, nil
+		}
+		return scanner.Verdict{}, nil
+	})
+}
+
+// issuedBy returns true if the issuer of cert belongs to the organization ca.
+func issuedBy(cert *x509.Certificate, ca string) bool {
+	for _, o := range cert.Issuer.Organization {
+		if strings.EqualFold(o, ca) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var inventoryFile string
+
+func init() {
+	flag.StringVar(&inventoryFile, "inventory-file", "", "If set, the 'inventory' command writes a JSON description of every certificate found, and the totals, to this file.")
+}
+
+// inventory is the summary of the certificates in a cluster written by
+// --inventory-file. Unlike a report, it does not depend on any incident.
+type inventory struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+	RunID         string    `json:"runId"`
+	Total         int       `json:"total"`
+	// ByIssuer counts certificates by the common name, or organization, of
+	// the CA that issued them.
+	ByIssuer map[string]int `json:"byIssuer"`
+	// ByIssuerRef counts cert-manager managed certificates by the kind and
+	// name of their Issuer or ClusterIssuer.
+	ByIssuerRef    map[string]int `json:"byIssuerRef"`
+	ByKeyAlgorithm map[string]int `json:"byKeyAlgorithm"`
+	ByManagedBy    map[string]int `json:"byManagedBy"`
+	// Expiry counts certificates by how soon they expire.
+	Expiry []inventoryExpiryBucket `json:"expiry"`
+	// Problems is the number of Secrets that are missing or could not be
+	// decoded.
+	Problems         int                      `json:"problems"`
+	FailedNamespaces []reportFailedNamespace  `json:"failedNamespaces,omitempty"`
+	Certificates     []scanner.InventoryEntry `json:"certificates"`
+	// Redacted is true if names have been replaced with salted hashes by
+	// --redact.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+type inventoryExpiryBucket struct {
+	// Bucket is "expired", "7d", "30d", "60d", "90d" or "later". Each
+	// counts the certificates expiring within that long, and after the
+	// previous bucket.
+	Bucket string `json:"bucket"`
+	Count  int    `json:"count"`
+}
+
+// inventoryExpiryBuckets are the buckets of the expiry histogram, other than
+// "expired" and "later".
+var inventoryExpiryBuckets = []struct {
+	name   string
+	within time.Duration
+}{
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"60d", 60 * 24 * time.Hour},
+	{"90d", 90 * 24 * time.Hour},
+}
+
+// runInventory implements the 'inventory' command, which lists every
+// certificate in the cluster, held in Secrets of type kubernetes.io/tls or
+// managed by cert-manager Certificates, and summarises them by issuer,
+// expiry, key algorithm and whether they are managed by cert-manager.
+func runInventory(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	if err := setupRedaction(); err != nil {
+		return err
+	}
+	var cl client.Client
+	certificates := true
+	if fixturesDir != "" {
+		sim, err := loadFixtures(fixturesDir)
+		if err != nil {
+			return err
+		}
+		cl = sim
+	} else {
+		cfg := restConfig()
+		var err error
+		if cl, err = newClient(cfg); err != nil {
+			return err
+		}
+		if certificates, err = certificateCRDInstalled(cfg); err != nil {
+			return err
+		}
+		if !certificates {
+			log.Printf("The cert-manager Certificate CRD is not installed, only TLS Secrets will be listed")
+		}
+		if err := checkPermissions(cfg, "inventory"); err != nil {
+			return err
+		}
+	}
+
+	s := newScanner(cl, nil)
+	entries, failed, err := s.Inventory(context.Background(), certificates)
+	if err != nil {
+		return err
+	}
+	inv := newInventory(entries, time.Now())
+	for _, f := range failed {
+		log.Printf("WARNING: unable to list certificates in namespace %q: %v", f.Namespace, f.Err)
+		inv.FailedNamespaces = append(inv.FailedNamespaces, reportFailedNamespace{Namespace: f.Namespace, Error: scrubSecrets(f.Err.Error())})
+	}
+	logInventory(inv)
+	if inventoryFile == "" {
+		return nil
+	}
+	if outputRedactor != nil {
+		outputRedactor.inventory(inv)
+	}
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(inventoryFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing inventory: %w", err)
+	}
+	log.Printf("Wrote inventory of %d certificates to %q", inv.Total, inventoryFile)
+	return nil
+}
+
+// newInventory summarises entries, with expiry relative to now.
+func newInventory(entries []scanner.InventoryEntry, now time.Time) *inventory {
+	inv := &inventory{
+		SchemaVersion:  inventorySchemaVersion,
+		GeneratedAt:    now.UTC(),
+		RunID:          runID,
+		Total:          len(entries),
+		ByIssuer:       make(map[string]int),
+		ByIssuerRef:    make(map[string]int),
+		ByKeyAlgorithm: make(map[string]int),
+		ByManagedBy:    make(map[string]int),
+		Certificates:   entries,
+	}
+	if inv.Certificates == nil {
+		inv.Certificates = []scanner.InventoryEntry{}
+	}
+	expiry := make(map[string]int)
+	for _, e := range entries {
+		inv.ByManagedBy[e.ManagedBy]++
+		if e.IssuerRef != "" {
+			inv.ByIssuerRef[e.IssuerRef]++
+		}
+		if e.Problem != "" {
+			inv.Problems++
+			continue
+		}
+		inv.ByIssuer[e.CA]++
+		inv.ByKeyAlgorithm[e.KeyAlgorithm]++
+		expiry[inventoryExpiryBucketFor(*e.NotAfter, now)]++
+	}
+	inv.Expiry = append(inv.Expiry, inventoryExpiryBucket{Bucket: "expired", Count: expiry["expired"]})
+	for _, b := range inventoryExpiryBuckets {
+		inv.Expiry = append(inv.Expiry, inventoryExpiryBucket{Bucket: b.name, Count: expiry[b.name]})
+	}
+	inv.Expiry = append(inv.Expiry, inventoryExpiryBucket{Bucket: "later", Count: expiry["later"]})
+	return inv
+}
+
+func inventoryExpiryBucketFor(notAfter, now time.Time) string {
+	if !notAfter.After(now) {
+		return "expired"
+	}
+	for _, b := range inventoryExpiryBuckets {
+		if notAfter.Sub(now) <= b.within {
+			return b.name
+		}
+	}
+	return "later"
+}
+
+// logInventory logs the totals of inv.
+func logInventory(inv *inventory) {
+	log.Println()
+	log.Printf("Found %d certificates, %d of which could not be read", inv.Total, inv.Problems)
+	logCounts := func(title string, counts map[string]int) {
+		if len(counts) == 0 {
+			return
+		}
+		log.Printf("%s:", title)
+		keys := make([]string, 0, len(counts))
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		// Largest first, then by name.
+		sort.Slice(keys, func(i, j int) bool {
+			if counts[keys[i]] != counts[keys[j]] {
+				return counts[keys[i]] > counts[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			log.Printf("  %s: %d", k, counts[k])
+		}
+	}
+	logCounts("Managed by", inv.ByManagedBy)
+	logCounts("Issued by", inv.ByIssuer)
+	logCounts("cert-manager issuers", inv.ByIssuerRef)
+	logCounts("Key algorithms", inv.ByKeyAlgorithm)
+	log.Printf("Expiring:")
+	for _, b := range inv.Expiry {
+		label := "within " + b.Bucket
+		switch b.Bucket {
+		case "expired":
+			label = "already expired"
+		case "later":
+			label = "after 90d"
+		}
+		log.Printf("  %s: %d", label, b.Count)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmlisters "github.com/jetstack/cert-manager/pkg/client/listers/certmanager/v1alpha2"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var watchIssuance bool
+
+func init() {
+	flag.BoolVar(&watchIssuance, "watch-issuance", false, "If true, --watch also watches CertificateRequests, and checks each certificate as soon as it is issued, before cert-manager stores it in the Secret. An affected certificate is logged and notified, as it means that the CA issued an affected certificate after the incident, or that the affected serials dataset has changed.")
+}
+
+func validateIssuanceWatchFlags() error {
+	if watchIssuance && !watchMode {
+		return fmt.Errorf("--watch-issuance can only be used with --watch")
+	}
+	return nil
+}
+
+// issuanceWatcher checks the certificate in the status of each
+// CertificateRequest as soon as it is issued.
+type issuanceWatcher struct {
+	watcher       *watcher
+	requestLister cmlisters.CertificateRequestLister
+	queue         workqueue.RateLimitingInterface
+	// startedAt is when the watch started. CertificateRequests issued
+	// before then are not checked, as affected certificates issued during
+	// the incident are found in their Secrets instead.
+	startedAt time.Time
+	// checked holds the serial number last checked for each
+	// CertificateRequest, keyed by namespace/name, so that resyncs do not
+	// notify again. It is only accessed by the worker goroutine.
+	checked map[string]string
+}
+
+// watchIssuedCertificates starts checking the certificates issued for
+// CertificateRequests, using the informer from the same factory as the
+// watcher's other informers. It returns the informer's HasSynced, to be
+// waited for along with the others.
+func (w *watcher) watchIssuedCertificates(ctx context.Context, informer cache.SharedIndexInformer, lister cmlisters.CertificateRequestLister) cache.InformerSynced {
+	iw := &issuanceWatcher{
+		watcher:       w,
+		requestLister: lister,
+		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "certificaterequests"),
+		startedAt:     time.Now(),
+		checked:       make(map[string]string),
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cr := obj.(*capi.CertificateRequest)
+			if len(cr.Status.Certificate) > 0 && cr.CreationTimestamp.Time.Before(iw.startedAt) {
+				return
+			}
+			iw.enqueue(obj)
+		},
+		UpdateFunc: func(old, obj interface{}) {
+			if string(old.(*capi.CertificateRequest).Status.Certificate) != string(obj.(*capi.CertificateRequest).Status.Certificate) {
+				iw.enqueue(obj)
+			}
+		},
+	})
+	go func() {
+		<-ctx.Done()
+		iw.queue.ShutDown()
+	}()
+	go wait.Until(func() {
+		for iw.processNextItem(ctx) {
+		}
+	}, time.Second, ctx.Done())
+	return informer.HasSynced
+}
+
+func (iw *issuanceWatcher) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	iw.queue.Add(key)
+}
+
+func (iw *issuanceWatcher) processNextItem(ctx context.Context) bool {
+	key, shutdown := iw.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer iw.queue.Done(key)
+	if err := iw.check(ctx, key.(string)); err != nil {
+		log.Printf("Failed to check the certificate issued for CertificateRequest %s: %v", key, err)
+		iw.queue.AddRateLimited(key)
+		return true
+	}
+	iw.queue.Forget(key)
+	return true
+}
+
+// check checks the certificate issued for the CertificateRequest with the
+// given namespace/name key, if it has been issued, and notifies if it is
+// affected.
+func (iw *issuanceWatcher) check(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	cr, err := iw.requestLister.CertificateRequests(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		delete(iw.checked, key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(cr.Status.Certificate) == 0 {
+		return nil
+	}
+	cert, err := pki.DecodeX509CertificateBytes(cr.Status.Certificate)
+	if err != nil {
+		log.Printf("Unable to check the certificate issued for CertificateRequest %s: %v", key, err)
+		return nil
+	}
+	serial := fmt.Sprintf("%x", cert.SerialNumber)
+	if iw.checked[key] == serial {
+		return nil
+	}
+
+	// The Certificate is only used for its name, issuer and keystore
+	// passwords by detectors, so a CertificateRequest whose Certificate has
+	// since been deleted is still checked.
+	crt := capi.Certificate{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: cr.Annotations[capi.CertificateNameKey]}}
+	if crt.Name != "" {
+		if c, err := iw.watcher.certLister.Certificates(namespace).Get(crt.Name); err == nil {
+			crt = *c
+		}
+	} else {
+		crt.Name = name
+	}
+	verdict, err := newScanner(iw.watcher.client, iw.watcher.serials).Check(ctx, crt, cert)
+	if err != nil {
+		return err
+	}
+	iw.checked[key] = serial
+	if !verdict.Affected {
+		log.Printf("Certificate issued for CertificateRequest %s (serial number: %s) is not affected", key, serial)
+		return nil
+	}
+	log.Printf("!!!!! The certificate just issued for CertificateRequest %s (serial number: %s) is AFFECTED (reason: %s) !!!!!", key, serial, verdict.Reason)
+	notifyCertificateAffected(ctx, finding{
+		Namespace:          crt.Namespace,
+		Name:               crt.Name,
+		SecretName:         crt.Spec.SecretName,
+		IssuerName:         cr.Spec.IssuerRef.Name,
+		IssuerKind:         cr.Spec.IssuerRef.Kind,
+		Serial:             serial,
+		Reason:             verdict.Reason,
+		Warning:            verdict.Warning,
+		CertificateRequest: name,
+		FoundAt:            time.Now().UTC(),
+	})
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	issuerConcurrencyRaw string
+	inFlightTimeout      time.Duration
+
+	// issuerConcurrency is the most renewals that may be in flight for each
+	// issuer, by issuerLimitKey, parsed from --issuer-concurrency.
+	issuerConcurrency map[string]int
+)
+
+func init() {
+	flag.StringVar(&issuerConcurrencyRaw, "issuer-concurrency", "", "Comma-separated limits on the number of renewals in flight for each issuer, such as 'ClusterIssuer/letsencrypt-dns=2,Issuer/team-a/letsencrypt=5'. Renewals of Certificates using a listed issuer are only triggered while fewer than its limit are still waiting for cert-manager to issue a new certificate. Other issuers are not limited.")
+	flag.DurationVar(&inFlightTimeout, "in-flight-renewal-timeout", 15*time.Minute, "How long a renewal counts towards the --issuer-concurrency limit of its issuer if cert-manager has not issued a new certificate by then.")
+}
+
+func validateIssuerConcurrencyFlags() error {
+	if issuerConcurrencyRaw == "" {
+		return nil
+	}
+	if !renew {
+		return fmt.Errorf("--issuer-concurrency requires --renew")
+	}
+	if inFlightTimeout <= 0 {
+		return fmt.Errorf("--in-flight-renewal-timeout must be positive")
+	}
+	issuerConcurrency = make(map[string]int)
+	for _, entry := range strings.Split(issuerConcurrencyRaw, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid --issuer-concurrency entry %q, expected ClusterIssuer/<name>=<limit> or Issuer/<namespace>/<name>=<limit>", entry)
+		}
+		parts := strings.Split(kv[0], "/")
+		valid := (parts[0] == capi.ClusterIssuerKind && len(parts) == 2) || (parts[0] == capi.IssuerKind && len(parts) == 3)
+		for _, p := range parts {
+			valid = valid && p != ""
+		}
+		if !valid {
+			return fmt.Errorf("invalid issuer %q in --issuer-concurrency, expected ClusterIssuer/<name> or Issuer/<namespace>/<name>", kv[0])
+		}
+		limit, err := strconv.Atoi(kv[1])
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid limit %q for %s in --issuer-concurrency, expected a positive number", kv[1], kv[0])
+		}
+		issuerConcurrency[kv[0]] = limit
+	}
+	return nil
+}
+
+// issuerLimitKey identifies the issuer of crt as in --issuer-concurrency.
+func issuerLimitKey(crt *capi.Certificate) string {
+	ref := crt.Spec.IssuerRef
+	if ref.Kind == capi.ClusterIssuerKind {
+		return capi.ClusterIssuerKind + "/" + ref.Name
+	}
+	return capi.IssuerKind + "/" + crt.Namespace + "/" + ref.Name
+}
+
+// issuerLimit returns the --issuer-concurrency limit of the issuer of crt, or
+// 0 if it is not limited.
+func issuerLimit(crt *capi.Certificate) int {
+	return issuerConcurrency[issuerLimitKey(crt)]
+}
+
+// inFlightRenewal is a renewal that has been triggered, and that cert-manager
+// has not yet issued a new certificate for.
+type inFlightRenewal struct {
+	crt capi.Certificate
+	// previous is the serial number of the certificate in the Secret when the
+	// renewal was triggered.
+	previous string
+	since    time.Time
+}
+
+// renewalLimiter keeps track of the renewals in flight for each issuer with
+// an --issuer-concurrency limit.
+type renewalLimiter struct {
+	cl       client.Client
+	results  *scanResults
+	inFlight map[string][]inFlightRenewal
+}
+
+func newRenewalLimiter(cl client.Client, results *scanResults) *renewalLimiter {
+	return &renewalLimiter{cl: cl, results: results, inFlight: make(map[string][]inFlightRenewal)}
+}
+
+// admits returns whether a renewal of crt may be triggered now.
+func (l *renewalLimiter) admits(crt *capi.Certificate) bool {
+	limit := issuerLimit(crt)
+	return limit == 0 || len(l.inFlight[issuerLimitKey(crt)]) < limit
+}
+
+// track returns a function to be called once the renewal of crt has been
+// triggered, counting it towards the limit of its issuer until it completes.
+// It must be called before the renewal is triggered, to read the serial
+// number of the certificate being replaced.
+func (l *renewalLimiter) track(ctx context.Context, crt capi.Certificate) func() {
+	if issuerLimit(&crt) == 0 {
+		return func() {}
+	}
+	r := inFlightRenewal{crt: crt, previous: secretSerial(ctx, l.cl, crt), since: time.Now()}
+	return func() {
+		if fixturesDir != "" {
+			log.Printf("[simulation] Not counting the renewal towards the limit of %s, as cert-manager is not running to complete it", issuerLimitKey(&crt))
+			return
+		}
+		key := issuerLimitKey(&crt)
+		l.inFlight[key] = append(l.inFlight[key], r)
+	}
+}
+
+// wait blocks until at least one renewal in flight has completed, failed or
+// timed out.
+func (l *renewalLimiter) wait(ctx context.Context) error {
+	log.Printf("Waiting for renewals in flight to complete, as the --issuer-concurrency limit of the issuers of the remaining Certificates has been reached")
+	for {
+		if l.release(ctx) > 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// release stops counting the renewals that have completed, failed or timed
+// out, and returns how many there were.
+func (l *renewalLimiter) release(ctx context.Context) int {
+	r := newRenewer(l.cl)
+	released := 0
+	for key, renewals := range l.inFlight {
+		var still []inFlightRenewal
+		for _, f := range renewals {
+			name := f.crt.Namespace + "/" + f.crt.Name
+			secret, err := r.Issued(ctx, f.crt, f.previous, f.since)
+			switch {
+			case err != nil:
+				log.Printf("Renewal of Certificate %s FAILED: %v", name, err)
+				l.results.recordRenewal(f.crt, err)
+			case secret != nil:
+				log.Printf("Certificate %s has been issued a new certificate", name)
+			case time.Since(f.since) > inFlightTimeout:
+				log.Printf("WARNING: Certificate %s has not been issued a new certificate after %s, no longer counting its renewal towards the limit of %s", name, inFlightTimeout, key)
+				l.results.recordRenewal(f.crt, &scanner.Error{Code: renewer.CodeRenewalTimeout, Err: fmt.Errorf("timed out after %s waiting for cert-manager to issue a new certificate", inFlightTimeout)})
+			default:
+				still = append(still, f)
+				continue
+			}
+			released++
+		}
+		l.inFlight[key] = still
+	}
+	return released
+}
+
+// renewTargets triggers the renewal of each of targets, in order, except that
+// Certificates whose issuer has reached its --issuer-concurrency limit are
+// renewed once renewals it has in flight complete. It stops at the first
+// renewal that cannot be triggered.
+func renewTargets(ctx context.Context, cl client.Client, results *scanResults, targets []capi.Certificate) error {
+	limiter := newRenewalLimiter(cl, results)
+	for pending := targets; len(pending) > 0; {
+		var blocked []capi.Certificate
+		for _, cert := range pending {
+			if !limiter.admits(&cert) {
+				blocked = append(blocked, cert)
+				continue
+			}
+			started := limiter.track(ctx, cert)
+			results.startRotation(ctx, cl, cert, outcomeRenewal)
+			log.Printf("Triggering renewal of Certificate %s/%s", cert.Namespace, cert.Name)
+			err := renewCertificate(ctx, cl, cert)
+			results.recordRenewal(cert, err)
+			if err != nil {
+				metricRenewals.WithLabelValues("failed").Inc()
+				log.Printf("Failed to renew certificate %s/%s: %v", cert.Namespace, cert.Name, err)
+				return err
+			}
+			metricRenewals.WithLabelValues("triggered").Inc()
+			started()
+		}
+		if len(blocked) > 0 {
+			if err := limiter.wait(ctx); err != nil {
+				return err
+			}
+		}
+		pending = blocked
+	}
+	return nil
+}
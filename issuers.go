@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sort"
+	"strings"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	renewUnreadyIssuers bool
+	checkACMEServers    bool
+)
+
+// fixturesHaveIssuers is false when simulating a run against fixtures that
+// contain no issuers, which would otherwise all appear to be missing.
+var fixturesHaveIssuers = true
+
+// acmeServerChecker remembers which ACME servers are reachable for the whole
+// run, shared by the scan and the renewals that follow it.
+var acmeServerChecker = &scanner.ACMEServerChecker{}
+
+func init() {
+	flag.BoolVar(&renewUnreadyIssuers, "renew-unready-issuers", false, "If true, renewals are triggered even for affected Certificates whose Issuer or ClusterIssuer does not exist, is not Ready, has no registered ACME account or whose ACME server is unreachable, and issuers are not looked up at all. By default such Certificates are reported as unrenewable instead, as their renewals never complete.")
+	flag.BoolVar(&checkACMEServers, "check-acme-servers", true, "If true, the directory of the ACME server of each ACME Issuer or ClusterIssuer of an affected Certificate is fetched, and Certificates whose ACME server is unreachable are reported as unrenewable rather than renewed. It is not fetched with --offline or --fixtures.")
+}
+
+// checkIssuers returns true if the issuers of affected Certificates should
+// be looked up.
+func checkIssuers() bool {
+	return fixturesHaveIssuers && !renewUnreadyIssuers
+}
+
+// acmeServers returns the checker of the ACME servers of issuers, or nil if
+// they should not be contacted.
+func acmeServers() *scanner.ACMEServerChecker {
+	if !checkACMEServers || offline || fixturesDir != "" {
+		return nil
+	}
+	return acmeServerChecker
+}
+
+// issuerBlockers checks the issuers of the Certificates about to be renewed,
+// looking up each issuer once, and collects the Certificates that are not
+// renewed because of their issuer, so that they are reported by issuer
+// rather than one by one.
+type issuerBlockers struct {
+	cl       client.Reader
+	problems map[string]string
+	blocked  []reportIssuerBlocker
+}
+
+func newIssuerBlockers(cl client.Reader) *issuerBlockers {
+	return &issuerBlockers{cl: cl, problems: make(map[string]string)}
+}
+
+// blocks returns the problem with the issuer of crt if it should not be
+// renewed because its issuer is missing, not Ready or unreachable, or ""
+// otherwise. A failure to look up the issuer does not block the renewal.
+func (b *issuerBlockers) blocks(ctx context.Context, crt capi.Certificate) string {
+	if !checkIssuers() {
+		return ""
+	}
+	key := scanner.IssuerKey(&crt)
+	problem, ok := b.problems[key]
+	if !ok {
+		var err error
+		problem, err = scanner.IssuerProblem(ctx, b.cl, acmeServers(), &crt)
+		if err != nil {
+			log.Printf("WARNING: unable to check the issuer of Certificate %s/%s, renewing it anyway: %v", crt.Namespace, crt.Name, err)
+			return ""
+		}
+		b.problems[key] = problem
+	}
+	if problem != "" {
+		b.blocked = append(b.blocked, newIssuerBlocker(crt, problem))
+	}
+	return problem
+}
+
+// log explains which issuers block renewals, and of which Certificates.
+func (b *issuerBlockers) log() {
+	for _, blocker := range mergeIssuerBlockers(b.blocked) {
+		log.Printf("WARNING: NOT renewing %d Certificate(s) using %s, as it is unrenewable: %s, so the renewals would never complete. "+
+			"Fix the issuer and run again, or set --renew-unready-issuers to renew them anyway.", len(blocker.Certificates), blocker.issuer(), blocker.Problem)
+		for _, crt := range blocker.Certificates {
+			log.Printf("  * %s", crt)
+		}
+	}
+}
+
+// logIssuerProblem explains why crt, whose issuer has the given problem, will
+// not be renewed.
+func logIssuerProblem(crt capi.Certificate, problem string) {
+	log.Printf("WARNING: NOT renewing Certificate %s/%s, as it is unrenewable: %s, so the renewal would never complete. "+
+		"Fix the issuer and run again, or set --renew-unready-issuers to renew it anyway.", crt.Namespace, crt.Name, problem)
+}
+
+// countIssuerProblems returns the number of affected Certificates whose
+// issuer is missing, not Ready or unreachable.
+func countIssuerProblems(affected []scanner.AffectedCertificate) int {
+	n := 0
+	for _, a := range affected {
+		if a.IssuerProblem != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// reportIssuerBlocker is an issuer that cannot issue certificates, blocking
+// the renewal of the affected Certificates using it.
+type reportIssuerBlocker struct {
+	Kind string `json:"kind"`
+	// Namespace is not set for a ClusterIssuer.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Problem   string `json:"problem"`
+	// Certificates lists the namespace/name of each affected Certificate
+	// using the issuer.
+	Certificates []string `json:"certificates"`
+}
+
+func newIssuerBlocker(crt capi.Certificate, problem string) reportIssuerBlocker {
+	b := reportIssuerBlocker{Kind: crt.Spec.IssuerRef.Kind, Name: crt.Spec.IssuerRef.Name, Problem: problem, Certificates: []string{crt.Namespace + "/" + crt.Name}}
+	if b.Kind == "" {
+		b.Kind = capi.IssuerKind
+	}
+	if b.Kind != capi.ClusterIssuerKind {
+		b.Namespace = crt.Namespace
+	}
+	return b
+}
+
+// issuer names the issuer, such as ClusterIssuer "letsencrypt" or Issuer
+// "default/letsencrypt".
+func (b reportIssuerBlocker) issuer() string {
+	if b.Namespace == "" {
+		return b.Kind + ` "` + b.Name + `"`
+	}
+	return b.Kind + ` "` + b.Namespace + "/" + b.Name + `"`
+}
+
+// issuerBlockersOf returns the issuers blocking the renewal of affected
+// Certificates.
+func issuerBlockersOf(affected []scanner.AffectedCertificate) []reportIssuerBlocker {
+	var blockers []reportIssuerBlocker
+	for _, a := range affected {
+		if a.IssuerProblem != "" {
+			blockers = append(blockers, newIssuerBlocker(a.Certificate, a.IssuerProblem))
+		}
+	}
+	return mergeIssuerBlockers(blockers)
+}
+
+// mergeIssuerBlockers combines the entries for the same issuer and problem,
+// such as those of several shards, sorted by the number of Certificates
+// blocked, largest first.
+func mergeIssuerBlockers(blockers []reportIssuerBlocker) []reportIssuerBlocker {
+	var merged []reportIssuerBlocker
+	index := make(map[string]int)
+	for _, b := range blockers {
+		key := strings.Join([]string{b.Kind, b.Namespace, b.Name, b.Problem}, "\x00")
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			b.Certificates = append([]string(nil), b.Certificates...)
+			merged = append(merged, b)
+			continue
+		}
+		merged[i].Certificates = append(merged[i].Certificates, b.Certificates...)
+	}
+	for i := range merged {
+		sort.Strings(merged[i].Certificates)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		if len(a.Certificates) != len(b.Certificates) {
+			return len(a.Certificates) > len(b.Certificates)
+		}
+		return a.issuer() < b.issuer()
+	})
+	return merged
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// keystoreFields maps each keystore Secret key to its field in
+// spec.keystores. The Certificate types this tool is built against predate
+// keystores, so the Certificate is read as unstructured to find them.
+var keystoreFields = map[string]string{
+	scanner.PKCS12SecretKey: "pkcs12",
+	scanner.JKSSecretKey:    "jks",
+}
+
+// keystorePassword returns a function that resolves the password of a
+// keystore from the Secret referenced by the passwordSecretRef of its
+// Certificate, using cl.
+func keystorePassword(cl client.Reader) scanner.KeystorePasswordFunc {
+	return func(ctx context.Context, crt capi.Certificate, key string) (string, error) {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(capi.SchemeGroupVersion.WithKind("Certificate"))
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: crt.Namespace, Name: crt.Name}, u); err != nil {
+			return "", fmt.Errorf("error getting Certificate: %w", err)
+		}
+		ref, found, err := unstructured.NestedStringMap(u.Object, "spec", "keystores", keystoreFields[key], "passwordSecretRef")
+		if err != nil {
+			return "", fmt.Errorf("error reading passwordSecretRef: %w", err)
+		}
+		if !found || ref["name"] == "" {
+			return "", nil
+		}
+		secretKey := ref["key"]
+		if secretKey == "" {
+			secretKey = "password"
+		}
+		var secret core.Secret
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: crt.Namespace, Name: ref["name"]}, &secret); err != nil {
+			return "", fmt.Errorf("error getting password Secret %q: %w", ref["name"], err)
+		}
+		password, ok := secret.Data[secretKey]
+		if !ok {
+			return "", fmt.Errorf("password Secret %q has no key %q", ref["name"], secretKey)
+		}
+		return string(password), nil
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var (
+	leaderElect                 bool
+	leaderElectionNamespace     string
+	leaderElectionID            string
+	leaderElectionLeaseDuration time.Duration
+	leaderElectionRenewDeadline time.Duration
+	leaderElectionRetryPeriod   time.Duration
+)
+
+func init() {
+	flag.BoolVar(&leaderElect, "leader-elect", false, "If true, the 'serve' command will use a Lease to elect a leader, and only the leader will check and renew Certificates. This allows more than one replica to be run for availability.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "The namespace to create the leader election Lease in. Defaults to the namespace the tool is running in.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "letsencrypt-caa-bug-checker", "The name of the leader election Lease.")
+	flag.DurationVar(&leaderElectionLeaseDuration, "leader-election-lease-duration", 15*time.Second, "How long standby replicas will wait before attempting to acquire leadership after the leader stops renewing the Lease.")
+	flag.DurationVar(&leaderElectionRenewDeadline, "leader-election-renew-deadline", 10*time.Second, "How long the leader will keep retrying to renew the Lease before giving up leadership.")
+	flag.DurationVar(&leaderElectionRetryPeriod, "leader-election-retry-period", 2*time.Second, "How often replicas will attempt to acquire or renew the Lease.")
+}
+
+// inClusterNamespaceFile contains the namespace of the pod when running
+// inside a cluster.
+const inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElectionLeaseNamespace returns the namespace of the leader election
+// Lease, defaulting to the namespace the tool is running in.
+func leaderElectionLeaseNamespace() (string, error) {
+	if leaderElectionNamespace != "" {
+		return leaderElectionNamespace, nil
+	}
+	data, err := ioutil.ReadFile(inClusterNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("--leader-election-namespace must be set when not running inside a cluster: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// runWithLeaderElection blocks until this replica has been elected leader
+// and then calls run, which should block until stopCh is closed. If
+// leadership is lost, the process exits so that a standby replica can take
+// over without both replicas acting on Certificates at the same time.
+func runWithLeaderElection(cfg *rest.Config, stopCh <-chan struct{}, run func(stopCh <-chan struct{}) error) error {
+	namespace, err := leaderElectionLeaseNamespace()
+	if err != nil {
+		return err
+	}
+	id, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error getting hostname: %w", err)
+	}
+	id = id + "_" + string(uuid.NewUUID())
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building Kubernetes client: %w", err)
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, namespace, leaderElectionID, kubeClient.CoreV1(), kubeClient.CoordinationV1(), resourcelock.ResourceLockConfig{
+		Identity: id,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating leader election lock: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	// errCh receives the result of run, and is only written to once this
+	// replica has become leader.
+	errCh := make(chan error, 1)
+	var leading int32
+	log.Printf("Waiting to acquire leader election Lease %s/%s as %s", namespace, leaderElectionID, id)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaderElectionLeaseDuration,
+		RenewDeadline:   leaderElectionRenewDeadline,
+		RetryPeriod:     leaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				atomic.StoreInt32(&leading, 1)
+				log.Printf("Acquired leader election Lease %s/%s", namespace, leaderElectionID)
+				err := run(ctx.Done())
+				// Release the Lease if run returned before being stopped.
+				cancel()
+				errCh <- err
+			},
+			OnStoppedLeading: func() {
+				// ctx is only cancelled when stopping or once run has
+				// returned, in which case leadership was given up rather
+				// than lost.
+				if ctx.Err() != nil {
+					return
+				}
+				log.Fatalf("Lost leader election Lease %s/%s, exiting", namespace, leaderElectionID)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					log.Printf("Replica %s is the current leader", identity)
+				}
+			},
+		},
+	})
+
+	if atomic.LoadInt32(&leading) == 0 {
+		return nil
+	}
+	return <-errCh
+}
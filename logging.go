@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+var (
+	// pemPattern matches PEM blocks, including those whose line endings
+	// have been escaped by %q, and those cut short.
+	pemPattern = regexp.MustCompile(`(?s)-----BEGIN ([A-Z0-9 ]+)-----.*?(?:-----END [A-Z0-9 ]+-----|$)`)
+	// base64Pattern matches long runs of base64, such as the values of
+	// Secret data or DER printed as base64.
+	base64Pattern = regexp.MustCompile(`[A-Za-z0-9+/]{64,}={0,2}`)
+	// hexPattern matches strings that are only hexadecimal, such as hashes
+	// and serial numbers, which are not secret.
+	hexPattern = regexp.MustCompile(`^[A-Fa-f0-9]+$`)
+	// bytesPattern matches byte slices formatted with %v or %#v, as the data
+	// of a Secret printed with %v, %+v or %#v would be.
+	bytesPattern = regexp.MustCompile(`\[(?:\d{1,3} ){31,}\d{1,3}\]|\{(?:0x[0-9a-f]{1,2}, ){31,}0x[0-9a-f]{1,2}\}`)
+	// pemRestPattern matches the rest of a PEM block that was cut short at
+	// the end of a previous write: its remaining base64 lines, escaped or
+	// not, and its END line.
+	pemRestPattern = regexp.MustCompile(`^(?:[A-Za-z0-9+/=\s]|\\[rn])*(?:-----END ([A-Z0-9 ]+)-----)?`)
+)
+
+// scrubSecrets removes anything that could be Secret data, such as private
+// keys and the PEM bodies of certificates, from s. It is applied to every
+// line logged and to error messages included in reports and notifications,
+// so that a Secret being formatted by mistake, for example in an error
+// returned by a library, cannot leak its data.
+func scrubSecrets(s string) string {
+	if strings.Contains(s, "-----BEGIN ") {
+		s = pemPattern.ReplaceAllString(s, "[REDACTED ${1}]")
+	}
+	s = base64Pattern.ReplaceAllStringFunc(s, func(b string) string {
+		if hexPattern.MatchString(b) {
+			return b
+		}
+		return "[REDACTED DATA]"
+	})
+	return bytesPattern.ReplaceAllString(s, "[REDACTED BYTES]")
+}
+
+// scrubbingWriter scrubs Secret data from everything written to it. The log
+// package writes each message with a single call to Write, so a PEM block
+// spanning several lines of a message is scrubbed whole. A PEM block that is
+// still open at the end of a write, as it may be if another writer splits a
+// message, also has its remaining lines scrubbed from the start of the next.
+type scrubbingWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+	// inPEM is true if the last write ended inside a PEM block.
+	inPEM bool
+}
+
+func (s *scrubbingWriter) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	text := string(p)
+	prefix := ""
+	if s.inPEM {
+		loc := pemRestPattern.FindStringSubmatchIndex(text)
+		if loc[1] > 0 {
+			prefix = "[REDACTED]"
+			if loc[2] >= 0 {
+				prefix = "[REDACTED " + text[loc[2]:loc[3]] + "]"
+			}
+		}
+		// The block is closed by its END line, or by anything that cannot
+		// be part of it.
+		s.inPEM = loc[1] == len(text) && loc[2] < 0
+		text = text[loc[1]:]
+	}
+	if begin := strings.LastIndex(text, "-----BEGIN "); begin >= 0 && !strings.Contains(text[begin:], "-----END ") {
+		s.inPEM = true
+	}
+	if _, err := io.WriteString(s.w, prefix+scrubSecrets(text)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// installLogScrubbing makes the standard logger, which the tool and its
+// packages log with, and klog, which client-go logs with, scrub Secret data
+// from every message.
+func installLogScrubbing() {
+	w := &scrubbingWriter{w: os.Stderr}
+	log.SetOutput(w)
+
+	// klog writes straight to stderr unless it is logging to files. With a
+	// log file set, it writes each message once to the output given to
+	// SetOutput, which replaces the file, rather than once for each severity
+	// at or below its own. A stderrthreshold above FATAL stops it also
+	// writing errors straight to stderr.
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	for name, value := range map[string]string{"logtostderr": "false", "alsologtostderr": "false", "log_file": "stderr", "stderrthreshold": "4"} {
+		if err := flags.Set(name, value); err != nil {
+			log.Fatalf("error configuring klog: %v", err)
+		}
+	}
+	klog.SetOutput(w)
+}
@@ -1,44 +1,166 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"log"
-	"math/big"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/jetstack/cert-manager/pkg/api"
-	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
-	"github.com/jetstack/cert-manager/pkg/util/pki"
-	core "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"k8s.io/client-go/metadata"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
 var (
-	affectedSerialsFile string
-	renew               bool
+	affectedSerialsFile   string
+	renew                 bool
+	cleanupFailedRequests bool
+	pageSize              int64
+	kubeAPIQPS            float64
+	kubeAPIBurst          int
+	scanConcurrency       int
+	cacheFile             string
+	watchMode             bool
 )
 
 func init() {
 	flag.StringVar(&affectedSerialsFile, "affected-serials-file", "", "The path to the extracted 'affected serials' file.")
 	flag.BoolVar(&renew, "renew", false, "If true, any affected certificates will be renewed. This may take a few minutes per Certificate.")
+	flag.Int64Var(&pageSize, "page-size", 500, "The maximum number of resources to request from the API server in a single list call. Set to 0 to disable pagination.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 20, "The maximum number of queries per second the tool will send to the Kubernetes API server.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 30, "The maximum burst of queries the tool will send to the Kubernetes API server.")
+	flag.IntVar(&scanConcurrency, "scan-concurrency", 4, "The number of namespaces to scan concurrently.")
+	flag.StringVar(&cacheFile, "cache-file", "", "Optional path to a file used to cache the serial numbers of Secrets between runs. Secrets that have not changed since the previous run will not be fetched or decoded again.")
+	flag.BoolVar(&watchMode, "watch", false, "If true, the tool will keep running and use informers to re-check Certificates and Secrets as they change, instead of performing a one-off scan.")
+	flag.BoolVar(&cleanupFailedRequests, "cleanup-failed-requests", false, "If true, failed or denied CertificateRequests owned by affected Certificates will be deleted before triggering a renewal. If --renew is not set, the CertificateRequests that would be deleted are listed instead.")
+}
+
+// commands are the subcommands supported in addition to the default scan.
+// Flags may be given after the name of the command.
+var commands = map[string]func(args []string) error{
+	"merge-reports":      runMergeReports,
+	"generate-manifests": runGenerateManifests,
+	"serve":              runServe,
+	"export-evidence":    runExportEvidence,
+	"bundle-dataset":     runBundleDataset,
+	"verify":             runVerify,
+	"inventory":          runInventory,
+	"fix-stuck-renewals": runFixStuckRenewals,
+	"renew":              runRenewFromReport,
+	"doctor":             runDoctor,
 }
 
 func main() {
+	installLogScrubbing()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			flag.CommandLine.Parse(os.Args[2:])
+			setupRunID()
+			if err := applyDatasetBundle(); err != nil {
+				log.Fatal(err)
+			}
+			if err := applyIncidentFile(); err != nil {
+				log.Fatal(err)
+			}
+			if err := validateOfflineFlags(); err != nil {
+				log.Fatal(err)
+			}
+			if err := validateReadOnlyFlags(); err != nil {
+				log.Fatal(err)
+			}
+			if err := cmd(flag.Args()); err != nil {
+				log.Printf("%v", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
-	if affectedSerialsFile == "" {
-		log.Fatal("--affected-serials-file must be specified! Please download and extract the file by running the 'prepare-lecaa' script here: https://github.com/hannob/lecaa")
+	setupRunID()
+	if err := applyDatasetBundle(); err != nil {
+		log.Fatal(err)
 	}
-	if renew {
+	if err := applyIncidentFile(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateOfflineFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateDatasetFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateDetectorFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateExpiringFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateShardFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateCheckpointFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateFixturesFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateRemediatorFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateDryRunFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateCanaryFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateIssuerConcurrencyFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateRenewHookFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadTemplates(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateIssuanceWatchFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSecretsOnlyFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateRotationFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateConfigMapFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateAdoptFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateReadOnlyFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateReportSigningFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupRedaction(); err != nil {
+		log.Fatal(err)
+	}
+	if sampleRate != 0 && renew {
+		log.Fatal("--sample cannot be combined with --renew, as only a sample of affected certificates would be renewed")
+	}
+	requireAffectedSerialsFile()
+	if renew && fixturesDir == "" {
 		log.Printf("!!!!! --renew has been set to TRUE. Any affected certificates will have a renewal automatically triggered if found !!!!!")
 		log.Printf("!!!!! Waiting 5s before proceeding, if you DO NOT renewals to be triggered, hit ctrl+c NOW !!!!!")
 		time.Sleep(time.Second * 5)
@@ -49,211 +171,414 @@ func main() {
 		"It is safe to run multiple times, and will take no action if " +
 		"certificates do not need to be re-issued.")
 
-	if err := run(); err != nil {
+	stopProfiling, err := startProfiling()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stopTracing, err := startTracing()
+	if err != nil {
+		log.Fatal(err)
+	}
+	closeAuditLog := func() {}
+	closeAPITrace := func() {}
+	closeEventStream, err := openEventStream()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fixturesDir != "" {
+		// Nothing outside of the simulation should be told about its results.
+		log.Printf("Simulating a run against the fixtures in %q, notifications, metrics and the audit log are disabled", fixturesDir)
+	} else {
+		// Notifiers may talk to the API server, so the trace is opened first.
+		if closeAPITrace, err = openAPITrace(); err != nil {
+			log.Fatal(err)
+		}
+		if err := setupNotifiers(); err != nil {
+			log.Fatal(err)
+		}
+		if closeAuditLog, err = openAuditLog(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	start := time.Now()
+	err = run()
+	if fixturesDir == "" {
+		pushMetrics(start, err)
+	}
+	closeAuditLog()
+	closeAPITrace()
+	closeEventStream()
+	stopTracing()
+	stopProfiling()
+	if err != nil {
 		log.Printf("%v", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	ctx := context.Background()
-
-	// Build an API client
-	cfg := ctrl.GetConfigOrDie()
-	mapper, err := apiutil.NewDynamicRESTMapper(cfg)
-	if err != nil {
-		return err
+func requireAffectedSerialsFile() {
+	if affectedSerialsFile == "" && newDetector() == nil {
+		log.Fatal("--affected-serials-file must be specified! Please download and extract the file by running the 'prepare-lecaa' script here: https://github.com/hannob/lecaa")
 	}
-	cl, err := client.New(cfg, client.Options{
-		Scheme: api.Scheme,
-		Mapper: mapper,
-	})
-	if err != nil {
-		return fmt.Errorf("error building API client: %w", err)
+}
+
+func run() (err error) {
+	ctx, sp := startSpan(context.Background(), "run")
+	defer func() { sp.finish(err) }()
+
+	var cfg *rest.Config
+	var cl client.Client
+	if fixturesDir != "" {
+		sim, err := loadFixtures(fixturesDir)
+		if err != nil {
+			return err
+		}
+		defer sim.logSummary()
+		if !sim.hasIssuers {
+			log.Printf("The fixtures contain no Issuers or ClusterIssuers, so the issuers of affected Certificates will not be checked")
+			fixturesHaveIssuers = false
+		}
+		cl = sim
+	} else {
+		cfg = restConfig()
+		if cl, err = newClient(cfg); err != nil {
+			return err
+		}
+		mode := "scan"
+		if watchMode {
+			mode = "watch"
+		}
+		if secretsOnly {
+			mode = "secrets"
+			if adoptOrphanedSecrets {
+				installed, err := certificateCRDInstalled(cfg)
+				if err != nil {
+					return err
+				}
+				if !installed {
+					return fmt.Errorf("--adopt-orphaned-secrets requires cert-manager to be installed, but the Certificate CRD is not")
+				}
+			}
+		} else if err := checkCertificateCRD(cfg); err != nil {
+			return err
+		}
+		if err := checkPermissions(cfg, mode); err != nil {
+			return err
+		}
+		releaseRunLock, err := acquireRunLock(cfg)
+		if err != nil {
+			return err
+		}
+		defer releaseRunLock()
 	}
 
-	var certs capi.CertificateList
-	if err := cl.List(ctx, &certs); err != nil {
-		return fmt.Errorf("error listing Certificate resources: %w", err)
+	if affectedSerialsURL != "" {
+		if err := downloadAffectedSerials(affectedSerialsURL, affectedSerialsFile); err != nil {
+			return err
+		}
 	}
-	log.Printf("Found %d Certificate resources to check", len(certs.Items))
-	var secrets core.SecretList
-	if err := cl.List(ctx, &secrets); err != nil {
-		return fmt.Errorf("error listing Secret resources: %w", err)
+	serials, loadDuration, err := loadSerials()
+	if err != nil {
+		return err
 	}
-	secretsMap := makeSecretsMap(secrets.Items)
 
-	serialsToCertificates := make(map[string]capi.Certificate)
-	skipped := 0
-	for _, crt := range certs.Items {
-		log.Printf("+++ Checking Secret resource for Certificate %s/%s", crt.Namespace, crt.Name)
-		secret, ok := secretsMap[crt.Namespace+"/"+crt.Spec.SecretName]
-		if !ok {
-			log.Printf("Unable to find Secret resource %q, skipping...", crt.Spec.SecretName)
-			skipped++
-			continue
+	if watchMode {
+		stopCh := ctrl.SetupSignalHandler()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			<-stopCh
+			cancel()
+		}()
+		if certManagerPreflightRequired() {
+			if err := checkCertManagerHealth(ctx, cfg, cl); err != nil {
+				return err
+			}
 		}
-		if secret.Data == nil || secret.Data[core.TLSCertKey] == nil {
-			log.Printf("Secret %q does not contain any data for key %q, skipping...", crt.Spec.SecretName, core.TLSCertKey)
-			skipped++
-			continue
+		return runWatch(ctx, cfg, cl, serials)
+	}
+
+	s := newScanner(cl, serials)
+	var cache *secretCache
+	if cacheFile != "" {
+		if cache, err = loadSecretCache(cacheFile); err != nil {
+			return fmt.Errorf("error loading cache file: %w", err)
 		}
-		certPEM := secret.Data[core.TLSCertKey]
-		cert, err := pki.DecodeX509CertificateBytes(certPEM)
+		md, err := metadata.NewForConfig(cfg)
 		if err != nil {
-			log.Printf("Failed to decode x509 certificate data in Secret %q: %v, skipping...", crt.Spec.SecretName, err)
-			skipped++
-			continue
+			return fmt.Errorf("error building metadata API client: %w", err)
 		}
-		serialsToCertificates[fmt.Sprintf("%x", cert.SerialNumber)] = crt
+		s.Cache = &metadataSerialCache{cache: cache, metadata: md}
 	}
-	affected, err := affectedCertificates(serialsToCertificates)
+	cp, err := startCheckpoint(s)
 	if err != nil {
-		log.Printf("Failed to check if certificates are affected: %v", err)
 		return err
 	}
+	scanStart := time.Now()
+	results, err := runScan(ctx, s)
+	cp.finish(err)
+	if err != nil {
+		notifyScanComplete(ctx, newScanNotification(scanStart, nil, err))
+		return err
+	}
+	results.timings.AddPhase(phaseLoadSerials, loadDuration)
+	if cache != nil {
+		if err := cache.save(cacheFile); err != nil {
+			log.Printf("Failed to save cache file: %v", err)
+		}
+	}
+	affected := results.AffectedCertificates()
 	log.Println("Finished analyzing certificates, results:")
-	log.Printf("  Skipped/unable to check: %d", skipped)
-	log.Printf("  Unaffected certificates: %d", len(serialsToCertificates)-len(affected))
+	log.Printf("  Skipped/unable to check: %d", results.Skipped)
+	for _, code := range sortedCodes(results.SkippedByCode) {
+		log.Printf("    %s: %d", code, results.SkippedByCode[code])
+	}
+	if results.Temporary > 0 {
+		log.Printf("  Temporary certificates, still being issued: %d", results.Temporary)
+	}
+	if results.Staging > 0 {
+		log.Printf("  Let's Encrypt staging certificates, not checked: %d", results.Staging)
+	}
+	if results.Terminating > 0 {
+		log.Printf("  Being deleted, not checked: %d", results.Terminating)
+	}
+	if len(results.TerminatingNamespaces) > 0 {
+		log.Printf("  Namespaces being deleted, not scanned: %s", strings.Join(results.TerminatingNamespaces, ", "))
+	}
+	if len(results.InProgress) > 0 {
+		log.Printf("  Issuance in progress, re-check later: %d", len(results.InProgress))
+		for _, crt := range results.InProgress {
+			log.Printf("    %s/%s", crt.Namespace, crt.Name)
+		}
+	}
+	log.Printf("  Unaffected certificates: %d", results.Checked-len(affected))
 	log.Printf("  Affected certificates: %d", len(affected))
-	if len(affected) == 0 {
-		return nil
+	if n := countReplicas(results.Affected); n > 0 {
+		log.Printf("    of which using replicated Secrets: %d", n)
 	}
-	if !renew {
-		log.Println()
-		log.Printf("Will NOT trigger a renewal as --renew set to false")
-		return nil
+	if n := countPaused(results.Affected); n > 0 {
+		log.Printf("    of which paused, requiring unpausing before renewal: %d", n)
 	}
-
-	log.Println()
-	log.Printf("Will now attempting to renew the following certificates:")
-	for sn, cert := range affected {
-		log.Printf("  * %s/%s (serial number: %s)", cert.Namespace, cert.Name, sn)
+	if n := countIssuerProblems(results.Affected); n > 0 {
+		log.Printf("    of which unrenewable, issuer missing, not ready or unreachable: %d", n)
 	}
-	log.Println()
-	log.Printf("!!!!! Will now attempt to renew %d certificates, waiting 2s... !!!!!", len(affected))
-	time.Sleep(time.Second * 2)
-	log.Println()
-
-	for _, cert := range affected {
-		log.Printf("Triggering renewal of Certificate %s/%s", cert.Namespace, cert.Name)
-		if err := renewCertificate(ctx, cl, cert); err != nil {
-			log.Printf("Failed to renew certificate %s/%s: %v", cert.Namespace, cert.Name, err)
-			return err
+	if n := countUntrusted(results.Affected); n > 0 {
+		log.Printf("    of which issued outside the incident window, NOT trusted: %d", n)
+		log.Printf("WARNING: %d certificate(s) matched the affected serials but were not issued within the incident window. The dataset may be corrupt or for a different incident, so these will not be renewed automatically.", n)
+	}
+	if results.Partial() {
+		log.Printf("  Namespaces that could not be scanned, results are PARTIAL: %d", len(results.FailedNamespaces))
+		for _, f := range results.FailedNamespaces {
+			log.Printf("    %s: %v", f.Namespace, f.Err)
 		}
 	}
-	return nil
-}
-
-func renewCertificate(ctx context.Context, cl client.Client, cert capi.Certificate) error {
-	var requests capi.CertificateRequestList
-	if err := cl.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
-		return err
+	if len(results.Conflicts) > 0 {
+		log.Printf("  Secrets used by more than one Certificate: %d", len(results.Conflicts))
+		for _, c := range results.Conflicts {
+			log.Printf("    %s/%s: %s", c.Namespace, c.SecretName, strings.Join(c.Certificates, ", "))
+		}
+	}
+	if sampleRate != 0 {
+		log.Printf("  Not checked due to sampling: %d", results.NotSampled)
+		logEstimate(estimateAffected(results.Total(), results.Checked, len(affected)))
 	}
-	for _, req := range requests.Items {
-		// If any existing CertificateRequest resources exist and are complete,
-		// we delete them to avoid a re-issuance of the same certificate.
-		if !metav1.IsControlledBy(&req, &cert) {
-			continue
+	if affectedAccountsFile != "" && !secretsOnly {
+		// The accounts are only reported, so a failure does not prevent
+		// affected Certificates from being renewed.
+		if results.affectedAccounts, err = findAffectedAccounts(ctx, cl); err != nil {
+			log.Printf("WARNING: unable to find affected ACME accounts: %v", err)
+		} else {
+			logAffectedAccounts(results.affectedAccounts)
 		}
+	}
+	if results.configMaps, err = runConfigMapScan(ctx, s); err != nil {
+		return err
+	}
+	if delay := totalThrottleDelay(); delay > 0 {
+		log.Printf("  Time spent backing off from API server throttling: %s", delay)
+	}
+	logTimings(results.timings)
+	recordScanMetrics(results)
 
-		// This indicates an issuance is currently in progress
-		if len(req.Status.Certificate) == 0 {
-			log.Printf("Found existing CertificateRequest %s/%s for Certificate - skipping triggering a renewal...", req.Namespace, req.Name)
-			return nil
+	renewStart := time.Now()
+	renewCtx, renewSpan := startSpan(ctx, "renew")
+	err = renewAffected(renewCtx, cl, results)
+	checkRotations(renewCtx, cl, results)
+	renewSpan.finish(err)
+	results.timings.AddPhase(phaseRenew, time.Since(renewStart))
+	if renew {
+		if err := writeOutcomes("scan", scanStart, renewalOutcomes(ctx, cl, results)); err != nil {
+			return fmt.Errorf("error writing outcomes: %w", err)
 		}
+	}
+
+	n := newScanNotification(scanStart, results, err)
+	for _, f := range n.Certificates {
+		notifyCertificateAffected(ctx, f)
+	}
+	notifyScanComplete(ctx, n)
 
-		if err := cl.Delete(ctx, &req); err != nil {
-			log.Printf("Failed to delete old CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
+	if namespaceReports {
+		if err := writeNamespaceReports(ctx, cl, results); err != nil {
 			return err
 		}
-
-		log.Printf("Deleted old CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
 	}
-
-	// Fetch an up to date copy of the Secret resource for this Certificate
-	var secret core.Secret
-	if err := cl.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.Spec.SecretName}, &secret); err != nil {
-		log.Printf("Failed to retrieve up-to-date copy of existing Secret resource for Certificate: %v", err)
-		return err
+	if reportFile != "" {
+		if err := writeReport(reportFile, newReport(results)); err != nil {
+			return fmt.Errorf("error writing report: %w", err)
+		}
+		log.Printf("Wrote report to %q", reportFile)
+	}
+	if markdownReportFile != "" {
+		if err := writeMarkdownReport(markdownReportFile, n); err != nil {
+			return fmt.Errorf("error writing Markdown report: %w", err)
+		}
+		log.Printf("Wrote Markdown report to %q", markdownReportFile)
+	}
+	if err == nil && results.Partial() {
+		// The run still fails, so that partial results are not mistaken
+		// for a clean bill of health.
+		return fmt.Errorf("%d namespace(s) could not be scanned, so the results are partial", len(results.FailedNamespaces))
 	}
+	return err
+}
 
-	// Manually override/set the IssuerNameAnnotationKey - this will cause cert-manager
-	// to assume that we have changed the 'issuerRef' specified on the Certificate and
-	// trigger a one-time renewal.
-	if secret.Annotations == nil {
-		secret.Annotations = make(map[string]string)
-	}
-	secret.Annotations[capi.IssuerNameAnnotationKey] = "force-renewal-triggered"
-	if err := cl.Update(ctx, &secret); err != nil {
-		log.Printf("Failed to update Secret resource for Certificate: %v", err)
-		return err
+// restConfig returns the configuration used to connect to the API server,
+// with rate limits and throttling retries configured from flags, and retries
+// of reads while a conversion webhook is unavailable.
+func restConfig() *rest.Config {
+	cfg := ctrl.GetConfigOrDie()
+	cfg.QPS = float32(kubeAPIQPS)
+	cfg.Burst = kubeAPIBurst
+	if apiTrace != nil {
+		cfg.Wrap(wrapAPITrace)
+	}
+	cfg.Wrap(wrapThrottleRetry)
+	cfg.Wrap(wrapConversionRetry)
+	if readOnly {
+		// This is the outermost wrapper, so that writes are refused before
+		// they could be retried.
+		cfg.Wrap(wrapReadOnly)
 	}
+	return cfg
+}
 
-	log.Printf("Triggered renewal of Certificate - waiting for new CertificateRequest resource to be created...")
-	// Wait for a CertificateRequest resource to be created
-	err := wait.Poll(time.Second, time.Minute, func() (bool, error) {
-		var requests capi.CertificateRequestList
-		if err := cl.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
-			return false, err
-		}
-		// Wait for a CertificateRequest owned by this Certificate to exist
-		for _, req := range requests.Items {
-			if metav1.IsControlledBy(&req, &cert) {
-				log.Printf("CertificateRequest %s/%s found, renewal in progress!", req.Namespace, req.Name)
-				return true, nil
-			}
-		}
-		return false, nil
+// newClient builds an API client that reads directly from the API server.
+func newClient(cfg *rest.Config) (client.Client, error) {
+	mapper, err := apiutil.NewDynamicRESTMapper(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := client.New(cfg, client.Options{
+		Scheme: api.Scheme,
+		Mapper: mapper,
 	})
 	if err != nil {
-		log.Printf("Failed to wait for new CertificateRequest to be created: %v", err)
-		return err
+		return nil, fmt.Errorf("error building API client: %w", err)
 	}
-	return nil
+	return cl, nil
 }
 
-func affectedCertificates(certsBySerial map[string]capi.Certificate) (map[string]capi.Certificate, error) {
-	f, err := os.Open(affectedSerialsFile)
+// loadSerials loads the file given by --affected-serials-file, returning
+// the affected serials and how long they took to load.
+func loadSerials() (scanner.SerialSet, time.Duration, error) {
+	// Load the affected serials into memory up front so that each page of
+	// Certificates can be checked as soon as it has been listed, rather than
+	// building an index of every Certificate in the cluster first.
+	if affectedSerialsFile == "" {
+		// Only --detector-command is used to check certificates.
+		return nil, 0, nil
+	}
+	if err := verifyDatasetSignature(affectedSerialsFile); err != nil {
+		return nil, 0, err
+	}
+	log.Printf("Loading affected serial numbers from %q", affectedSerialsFile)
+	start := time.Now()
+	serials, err := scanner.LoadFileFormat(affectedSerialsFile, affectedSerialsFormat)
 	if err != nil {
-		return nil, err
+		log.Printf("Failed to load affected serials file: %v", err)
+		return nil, 0, err
+	}
+	d := time.Since(start)
+	log.Printf("Loaded %d affected serial numbers in %s", len(serials), d.Round(time.Millisecond))
+	if err := checkDatasetFreshness(affectedSerialsFile, len(serials)); err != nil {
+		return nil, 0, err
 	}
-	defer f.Close()
+	return serials, d, nil
+}
 
-	affectedMap := make(map[string]capi.Certificate)
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "serial ") {
-			log.Printf("Failed to parse line in affected serials file, does not start with 'serial ': %v", line)
-			continue
+// renewAffected triggers a renewal of each affected Certificate found by a
+// scan, if --renew is set.
+func renewAffected(ctx context.Context, cl client.Client, results *scanResults) error {
+	affected := results.AffectedCertificates()
+	if len(affected) == 0 {
+		return nil
+	}
+	if secretsOnly {
+		if adoptOrphanedSecrets {
+			// cert-manager issues the Certificates created for adopted Secrets.
+			if certManagerPreflightRequired() {
+				if err := checkCertManagerHealth(ctx, restConfig(), cl); err != nil {
+					return err
+				}
+			}
+			return adoptSecrets(ctx, cl, results)
 		}
-
-		// extract the serial number from the serials.txt file and convert it
-		// to a big.Int to avoid trailing zeroes in serial numbers causing problems.
-		serial := strings.Split(line, " ")[1]
-		serialInt := big.NewInt(0)
-		_, ok := serialInt.SetString(serial, 16)
-		if !ok {
-			log.Printf("Failed to parse int64 from serial number in serials.txt: %v (line: %s)", err, line)
-			continue
+		logSecretsOnly(len(affected))
+		return nil
+	}
+	if !renew {
+		log.Println()
+		log.Printf("Will NOT trigger a renewal as --renew set to false")
+		if dryRunRenewals {
+			if targets := renewalTargets(ctx, cl, results.Affected); len(targets) > 0 {
+				dryRunTargets(ctx, cl, results, targets)
+			}
 		}
-		cert, affected := certsBySerial[fmt.Sprintf("%x", serialInt)]
-		if !affected {
-			continue
+		if cleanupFailedRequests {
+			return listFailedCertificateRequests(ctx, cl, affected)
 		}
-		affectedMap[serial] = cert
+		return nil
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+
+	log.Println()
+	targets := renewalTargets(ctx, cl, results.Affected)
+	if dryRunRenewals && len(targets) > 0 {
+		targets = dryRunTargets(ctx, cl, results, targets)
+	}
+	if len(targets) == 0 {
+		log.Printf("No certificates can be renewed automatically")
+		return nil
+	}
+	if certManagerPreflightRequired() {
+		if err := checkCertManagerHealth(ctx, restConfig(), cl); err != nil {
+			return err
+		}
+	}
+	log.Printf("Will now attempting to renew the following certificates:")
+	for _, crt := range targets {
+		log.Printf("  * %s/%s", crt.Namespace, crt.Name)
+	}
+	log.Println()
+	log.Printf("!!!!! Will now attempt to renew %d certificates, waiting 2s... !!!!!", len(targets))
+	time.Sleep(time.Second * 2)
+	log.Println()
+
+	if canaryCount > 0 {
+		var err error
+		if targets, err = renewCanaries(ctx, cl, results, targets); err != nil {
+			return err
+		}
 	}
-	return affectedMap, nil
+	return renewTargets(ctx, cl, results, targets)
 }
 
-func makeSecretsMap(secrets []core.Secret) map[string]core.Secret {
-	m := make(map[string]core.Secret)
-	for _, s := range secrets {
-		m[s.Namespace+"/"+s.Name] = s
+// sortedCodes returns the codes in counts, sorted alphabetically.
+func sortedCodes(counts map[scanner.Code]int) []scanner.Code {
+	var codes []scanner.Code
+	for code := range counts {
+		codes = append(codes, code)
 	}
-	return m
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
 }
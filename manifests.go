@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha2"
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	lecaa "github.com/jetstack/letsencrypt-caa-bug-checker/pkg/apis/lecaa/v1alpha1"
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	coordination "k8s.io/api/coordination/v1"
+	core "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	manifestsMode       string
+	manifestsNamespace  string
+	manifestsImage      string
+	manifestsSchedule   string
+	manifestsSerialsURL string
+)
+
+func init() {
+	flag.StringVar(&manifestsMode, "manifests-mode", "scan", "The mode to generate manifests for with the 'generate-manifests' command. Either 'scan', to run a one-off scan as a Job (or CronJob if --manifests-schedule is set), or 'serve', to run the controller as a Deployment.")
+	flag.StringVar(&manifestsNamespace, "manifests-namespace", "letsencrypt-caa-bug-checker", "The namespace to run the tool in when generating manifests.")
+	flag.StringVar(&manifestsImage, "manifests-image", "", "The container image containing the tool to use when generating manifests.")
+	flag.StringVar(&manifestsSchedule, "manifests-schedule", "", "If set, a CronJob running on this schedule is generated instead of a Job in 'scan' mode.")
+	flag.StringVar(&manifestsSerialsURL, "manifests-serials-url", "https://d4twhgtvn0ff5.cloudfront.net/caa-rechecking-incident-affected-serials.txt.gz", "The URL of the gzipped affected serials file, downloaded by the generated manifests before the tool starts. s3:// and gs:// URLs are downloaded by the tool itself, using the credentials of its ServiceAccount through IAM roles for service accounts or Workload Identity.")
+}
+
+const (
+	manifestsName = "letsencrypt-caa-bug-checker"
+	// serialsVolumeSize is the size limit of the volume the affected serials
+	// file is downloaded into. Decompressed, the file is approximately 1.2GB.
+	serialsVolumeSize = "2Gi"
+	serialsMountPath  = "/data"
+	fetchSerialsImage = "curlimages/curl:7.69.0"
+)
+
+// runGenerateManifests implements the 'generate-manifests' command, which
+// writes the YAML needed to run the tool inside a cluster to stdout. The
+// RBAC rules only grant the permissions needed by the selected mode and the
+// --renew, --cleanup-failed-requests and --leader-elect flags.
+func runGenerateManifests(args []string) error {
+	if manifestsImage == "" {
+		return fmt.Errorf("--manifests-image must be specified")
+	}
+	if manifestsMode != "scan" && manifestsMode != "serve" {
+		return fmt.Errorf("--manifests-mode must be either 'scan' or 'serve', got %q", manifestsMode)
+	}
+	if manifestsMode == "serve" && manifestsSchedule != "" {
+		return fmt.Errorf("--manifests-schedule cannot be used with --manifests-mode=serve")
+	}
+	return writeManifests(os.Stdout, generateManifests())
+}
+
+// generateManifests returns the objects needed to run the tool in the mode
+// given by --manifests-mode.
+func generateManifests() []runtime.Object {
+	objs := []runtime.Object{
+		&core.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: manifestsNamespace},
+		},
+		&core.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: manifestsObjectMeta(),
+		},
+		&core.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: manifestsObjectMeta(),
+			Data: map[string]string{
+				"AFFECTED_SERIALS_URL":    manifestsSerialsURL,
+				"RENEW":                   strconv.FormatBool(renew),
+				"CLEANUP_FAILED_REQUESTS": strconv.FormatBool(cleanupFailedRequests),
+			},
+		},
+		&rbac.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: manifestsName},
+			Rules:      clusterRoleRules(manifestsMode),
+		},
+		&rbac.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: manifestsName},
+			RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "ClusterRole", Name: manifestsName},
+			Subjects:   []rbac.Subject{{Kind: "ServiceAccount", Name: manifestsName, Namespace: manifestsNamespace}},
+		},
+	}
+	if manifestsMode == "serve" && leaderElect {
+		objs = append(objs,
+			&rbac.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: manifestsObjectMeta(),
+				Rules:      leaderElectionRules(),
+			},
+			&rbac.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: manifestsObjectMeta(),
+				RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "Role", Name: manifestsName},
+				Subjects:   []rbac.Subject{{Kind: "ServiceAccount", Name: manifestsName, Namespace: manifestsNamespace}},
+			},
+		)
+	}
+
+	if runLockRequired() {
+		// The run lock is shared with runs from outside the cluster, so it
+		// is in --run-lock-namespace rather than the namespace of the tool.
+		meta := metav1.ObjectMeta{Name: manifestsName + "-run-lock", Namespace: runLockNamespace, Labels: manifestsLabels()}
+		objs = append(objs,
+			&rbac.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: meta,
+				Rules:      leaderElectionRules(),
+			},
+			&rbac.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: meta,
+				RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "Role", Name: meta.Name},
+				Subjects:   []rbac.Subject{{Kind: "ServiceAccount", Name: manifestsName, Namespace: manifestsNamespace}},
+			},
+		)
+	}
+	if certManagerPreflightRequired() {
+		// The cert-manager pods are checked before renewals are triggered.
+		meta := metav1.ObjectMeta{Name: manifestsName + "-cert-manager-preflight", Namespace: certManagerNamespace, Labels: manifestsLabels()}
+		objs = append(objs,
+			&rbac.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: meta,
+				Rules:      certManagerPreflightRules(),
+			},
+			&rbac.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: meta,
+				RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "Role", Name: meta.Name},
+				Subjects:   []rbac.Subject{{Kind: "ServiceAccount", Name: manifestsName, Namespace: manifestsNamespace}},
+			},
+		)
+	}
+
+	switch {
+	case manifestsMode == "serve":
+		replicas := int32(1)
+		if leaderElect {
+			replicas = 2
+		}
+		objs = append(objs, &apps.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: manifestsObjectMeta(),
+			Spec: apps.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: manifestsLabels()},
+				Template: podTemplate(core.RestartPolicyAlways),
+			},
+		})
+	case manifestsSchedule != "":
+		objs = append(objs, &batchv1beta1.CronJob{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1beta1", Kind: "CronJob"},
+			ObjectMeta: manifestsObjectMeta(),
+			Spec: batchv1beta1.CronJobSpec{
+				Schedule:          manifestsSchedule,
+				ConcurrencyPolicy: batchv1beta1.ForbidConcurrent,
+				JobTemplate: batchv1beta1.JobTemplateSpec{
+					Spec: jobSpec(),
+				},
+			},
+		})
+	default:
+		objs = append(objs, &batch.Job{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+			ObjectMeta: manifestsObjectMeta(),
+			Spec:       jobSpec(),
+		})
+	}
+	return objs
+}
+
+// clusterRoleRules returns the cluster-wide permissions needed in the given
+// mode, which is one of "scan", "secrets", "watch", "serve", "inventory",
+// "fix-stuck" or "doctor".
+func clusterRoleRules(mode string) []rbac.PolicyRule {
+	if mode == "inventory" {
+		return []rbac.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+			{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificates"}, Verbs: []string{"list"}},
+			{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"list"}},
+		}
+	}
+	if mode == "fix-stuck" {
+		requestVerbs := []string{"list"}
+		if resetStuck {
+			requestVerbs = append(requestVerbs, "delete")
+		}
+		return []rbac.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+			{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+			{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificates"}, Verbs: []string{"list"}},
+			{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificaterequests"}, Verbs: requestVerbs},
+			{APIGroups: []string{cmacme.SchemeGroupVersion.Group}, Resources: []string{"orders"}, Verbs: []string{"list"}},
+		}
+	}
+	if mode == "doctor" {
+		return []rbac.PolicyRule{
+			{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificates"}, Verbs: []string{"get"}},
+			{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificaterequests"}, Verbs: []string{"list"}},
+			{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"issuers", "clusterissuers"}, Verbs: []string{"get"}},
+			{APIGroups: []string{cmacme.SchemeGroupVersion.Group}, Resources: []string{"orders", "challenges"}, Verbs: []string{"list"}},
+		}
+	}
+	if mode == "secrets" {
+		// Secrets are listed instead of the Certificates using them.
+		rules := []rbac.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+			{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"list"}},
+		}
+		if namespaceReports {
+			rules = append(rules, rbac.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "update"}})
+		}
+		if scanConfigMaps {
+			rules = append(rules, rbac.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"list"}})
+		}
+		if adoptOrphanedSecrets {
+			rules = append(rules,
+				rbac.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+				rbac.PolicyRule{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificates"}, Verbs: []string{"list"}},
+			)
+			if renew {
+				// Adopted Certificates are created, then renewed as usual.
+				rules = append(rules,
+					rbac.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"update"}},
+					rbac.PolicyRule{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificates"}, Verbs: []string{"create"}},
+					rbac.PolicyRule{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificaterequests"}, Verbs: []string{"list", "delete"}},
+				)
+			}
+		}
+		return rules
+	}
+	readVerbs := []string{"list"}
+	secretVerbs := []string{"get"}
+	if mode != "scan" {
+		// The controller caches and watches the resources it reconciles.
+		readVerbs = []string{"get", "list", "watch"}
+		secretVerbs = readVerbs
+	}
+	if renew {
+		secretVerbs = append(secretVerbs, "update")
+	}
+	rules := []rbac.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+		{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificates"}, Verbs: readVerbs},
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: secretVerbs},
+	}
+	if !renewUnreadyIssuers {
+		issuerVerbs := []string{"get"}
+		if mode != "scan" {
+			issuerVerbs = readVerbs
+		}
+		rules = append(rules, rbac.PolicyRule{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"issuers", "clusterissuers"}, Verbs: issuerVerbs})
+	}
+	if renew || cleanupFailedRequests || (mode == "watch" && watchIssuance) {
+		requestVerbs := readVerbs
+		if renew {
+			requestVerbs = append(requestVerbs, "delete")
+		}
+		rules = append(rules, rbac.PolicyRule{APIGroups: []string{capi.SchemeGroupVersion.Group}, Resources: []string{"certificaterequests"}, Verbs: requestVerbs})
+	}
+	if renew && !ignoreDuplicateLimit {
+		// Orders hold the certificates issued recently, which count towards
+		// the duplicate certificate limit.
+		rules = append(rules, rbac.PolicyRule{APIGroups: []string{cmacme.SchemeGroupVersion.Group}, Resources: []string{"orders"}, Verbs: readVerbs})
+	}
+	if namespaceReports {
+		rules = append(rules, rbac.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "update"}})
+	}
+	if scanConfigMaps && mode == "scan" {
+		rules = append(rules, rbac.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"list"}})
+	}
+	if mode == "serve" {
+		rules = append(rules,
+			rbac.PolicyRule{APIGroups: []string{lecaa.GroupName}, Resources: []string{"caabugscans"}, Verbs: []string{"get", "list", "watch"}},
+			rbac.PolicyRule{APIGroups: []string{lecaa.GroupName}, Resources: []string{"caabugscans/status"}, Verbs: []string{"update"}},
+		)
+	}
+	return rules
+}
+
+// leaderElectionRules returns the permissions needed in the leader election
+// namespace when --leader-elect is set, and in the run lock namespace when
+// the run lock is required.
+func leaderElectionRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{{
+		APIGroups: []string{coordination.GroupName},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "create", "update"},
+	}}
+}
+
+func jobSpec() batch.JobSpec {
+	backoffLimit := int32(0)
+	return batch.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template:     podTemplate(core.RestartPolicyNever),
+	}
+}
+
+// podTemplate returns a pod that downloads the affected serials file into
+// an emptyDir volume before running the tool. Files in S3 or Cloud Storage
+// are downloaded by the tool itself, with the credentials of its
+// ServiceAccount, rather than by curl.
+func podTemplate(restartPolicy core.RestartPolicy) core.PodTemplateSpec {
+	serialsFile := serialsMountPath + "/serials.txt"
+	args := []string{
+		"--affected-serials-file=" + serialsFile,
+		"--renew=$(RENEW)",
+		"--cleanup-failed-requests=$(CLEANUP_FAILED_REQUESTS)",
+	}
+	if namespaceReports {
+		args = append(args, "--namespace-reports", "--namespace-report-name="+namespaceReportName)
+	}
+	if runLockRequired() {
+		args = append(args, "--run-lock-namespace="+runLockNamespace, "--run-lock-name="+runLockName)
+	}
+	if certManagerNamespace != "cert-manager" {
+		args = append(args, "--cert-manager-namespace="+certManagerNamespace)
+	}
+	if scanConfigMaps && manifestsMode == "scan" {
+		args = append(args, "--scan-configmaps")
+	}
+	if isObjectStorageURL(manifestsSerialsURL) {
+		args = append(args, "--affected-serials-url=$(AFFECTED_SERIALS_URL)")
+	}
+	if manifestsMode == "scan" {
+		// The working directory of the image may not be writable.
+		args = append(args, "--outcomes-file="+serialsMountPath+"/outcomes.json")
+	}
+	var ports []core.ContainerPort
+	var liveness, readiness *core.Probe
+	if manifestsMode == "serve" {
+		args = append([]string{"serve"}, args...)
+		probePort := intstr.FromString("probes")
+		ports = []core.ContainerPort{{Name: "probes", ContainerPort: 8081}}
+		args = append(args, "--health-probe-addr=:8081")
+		if leaderElect {
+			args = append(args, "--leader-elect")
+		}
+		liveness = &core.Probe{Handler: core.Handler{HTTPGet: &core.HTTPGetAction{Path: "/healthz", Port: probePort}}}
+		readiness = &core.Probe{Handler: core.Handler{HTTPGet: &core.HTTPGetAction{Path: "/readyz", Port: probePort}}}
+	}
+
+	sizeLimit := resource.MustParse(serialsVolumeSize)
+	envFrom := []core.EnvFromSource{{ConfigMapRef: &core.ConfigMapEnvSource{LocalObjectReference: core.LocalObjectReference{Name: manifestsName}}}}
+	mounts := []core.VolumeMount{{Name: "serials", MountPath: serialsMountPath}}
+	var initContainers []core.Container
+	if !isObjectStorageURL(manifestsSerialsURL) {
+		initContainers = []core.Container{{
+			Name:         "fetch-serials",
+			Image:        fetchSerialsImage,
+			Command:      []string{"sh", "-c", "curl -sSfL \"$AFFECTED_SERIALS_URL\" | gunzip > " + serialsFile},
+			EnvFrom:      envFrom,
+			VolumeMounts: mounts,
+		}}
+	}
+	return core.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: manifestsLabels()},
+		Spec: core.PodSpec{
+			ServiceAccountName: manifestsName,
+			RestartPolicy:      restartPolicy,
+			InitContainers:     initContainers,
+			Containers: []core.Container{{
+				Name:           manifestsName,
+				Image:          manifestsImage,
+				Args:           args,
+				EnvFrom:        envFrom,
+				Ports:          ports,
+				LivenessProbe:  liveness,
+				ReadinessProbe: readiness,
+				VolumeMounts:   mounts,
+			}},
+			Volumes: []core.Volume{{
+				Name:         "serials",
+				VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{SizeLimit: &sizeLimit}},
+			}},
+		},
+	}
+}
+
+func manifestsObjectMeta() metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: manifestsName, Namespace: manifestsNamespace, Labels: manifestsLabels()}
+}
+
+func manifestsLabels() map[string]string {
+	return map[string]string{"app.kubernetes.io/name": manifestsName}
+}
+
+// writeManifests writes objs to w as a multi-document YAML stream.
+func writeManifests(w io.Writer, objs []runtime.Object) error {
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("error encoding manifest: %w", err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
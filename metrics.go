@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	pushgatewayURL string
+	pushgatewayJob string
+	clusterName    string
+	metricsFile    string
+)
+
+func init() {
+	flag.StringVar(&pushgatewayURL, "pushgateway-url", "", "If set, push the final counters of the run to the Prometheus Pushgateway at this URL.")
+	flag.StringVar(&pushgatewayJob, "pushgateway-job", "letsencrypt-caa-bug-checker", "The job name to push metrics to the Pushgateway under.")
+	flag.StringVar(&metricsFile, "metrics-file", "", "If set, write the final counters of the run to this file in the Prometheus text format, for example for the node exporter's textfile collector.")
+	flag.StringVar(&clusterName, "cluster-name", "", "The name of the cluster being checked, added as the 'cluster' label to metrics pushed to the Pushgateway.")
+}
+
+var (
+	metricCertificates = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lecaa_certificates",
+		Help: "The number of Certificates found by the most recent scan, by result.",
+	}, []string{"result"})
+	metricRenewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lecaa_renewals_total",
+		Help: "The number of renewals triggered, by result.",
+	}, []string{"result"})
+	metricScanDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lecaa_scan_duration_seconds",
+		Help: "How long the most recent run took.",
+	})
+	metricLastCompletion = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lecaa_last_completion_timestamp_seconds",
+		Help: "The time the most recent run completed, as a unix timestamp.",
+	})
+	metricLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lecaa_last_success",
+		Help: "1 if the most recent run completed without error, 0 otherwise.",
+	})
+)
+
+// metricsRegistry holds the metrics reported by the tool.
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(metricCertificates, metricRenewals, metricScanDuration, metricLastCompletion, metricLastSuccess)
+}
+
+// recordScanMetrics sets the certificate gauges from the results of a scan.
+func recordScanMetrics(results *scanResults) {
+	affected := len(results.AffectedCertificates())
+	metricCertificates.WithLabelValues("affected").Set(float64(affected))
+	metricCertificates.WithLabelValues("unaffected").Set(float64(results.Checked - affected))
+	metricCertificates.WithLabelValues("skipped").Set(float64(results.Skipped))
+	metricCertificates.WithLabelValues("temporary").Set(float64(results.Temporary))
+	metricCertificates.WithLabelValues("staging").Set(float64(results.Staging))
+	metricCertificates.WithLabelValues("terminating").Set(float64(results.Terminating))
+	metricCertificates.WithLabelValues("in_progress").Set(float64(len(results.InProgress)))
+}
+
+// pushMetrics pushes the metrics of a completed run to the Pushgateway, if
+// --pushgateway-url is set, and writes them to --metrics-file, if set.
+func pushMetrics(start time.Time, runErr error) {
+	if pushgatewayURL == "" && metricsFile == "" {
+		return
+	}
+	metricScanDuration.Set(time.Since(start).Seconds())
+	metricLastCompletion.SetToCurrentTime()
+	if runErr == nil {
+		metricLastSuccess.Set(1)
+	} else {
+		metricLastSuccess.Set(0)
+	}
+	if metricsFile != "" {
+		if err := prometheus.WriteToTextfile(metricsFile, metricsRegistry); err != nil {
+			log.Printf("Failed to write metrics file: %v", err)
+		} else {
+			log.Printf("Wrote metrics to %q", metricsFile)
+		}
+	}
+	if pushgatewayURL == "" {
+		return
+	}
+	pusher := push.New(pushgatewayURL, pushgatewayJob).Gatherer(metricsRegistry)
+	if clusterName != "" {
+		pusher = pusher.Grouping("cluster", clusterName)
+	}
+	if err := pusher.Push(); err != nil {
+		log.Printf("Failed to push metrics to Pushgateway: %v", err)
+		return
+	}
+	log.Printf("Pushed metrics to Pushgateway at %q", pushgatewayURL)
+}
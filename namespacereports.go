@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	namespaceReports    bool
+	namespaceReportName string
+)
+
+func init() {
+	flag.BoolVar(&namespaceReports, "namespace-reports", false, "If true, after each scan the findings for each namespace are written to a ConfigMap in that namespace, so that the teams using it can see their own affected Certificates.")
+	flag.StringVar(&namespaceReportName, "namespace-report-name", "letsencrypt-caa-bug-checker-report", "The name of the ConfigMap written to each namespace by --namespace-reports.")
+}
+
+const (
+	// namespaceReportKey and namespaceReportSummaryKey are the keys of the
+	// JSON report and a plain text summary in each ConfigMap.
+	namespaceReportKey        = "report.json"
+	namespaceReportSummaryKey = "summary.txt"
+)
+
+// namespaceReport is the report written to each namespace.
+type namespaceReport struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+	Cluster       string    `json:"cluster,omitempty"`
+	RunID         string    `json:"runId,omitempty"`
+	Namespace     string    `json:"namespace"`
+	Certificates  int       `json:"certificates"`
+	Affected      []finding `json:"affected"`
+}
+
+// summary returns a plain text summary of r for people reading the ConfigMap
+// with kubectl.
+func (r *namespaceReport) summary() string {
+	var b strings.Builder
+	if len(r.Affected) == 0 {
+		fmt.Fprintf(&b, "None of the %d Certificates in namespace %s are affected by the Let's Encrypt CAA bug.\n", r.Certificates, r.Namespace)
+	} else {
+		fmt.Fprintf(&b, "%d of the %d Certificates in namespace %s are affected by the Let's Encrypt CAA bug:\n", len(r.Affected), r.Certificates, r.Namespace)
+		for _, f := range r.Affected {
+			fmt.Fprintf(&b, "  * %s (Secret %s, serial %s): renewal %s\n", f.Name, f.SecretName, f.Serial, strings.ToLower(renewalStatus(f)))
+		}
+	}
+	fmt.Fprintf(&b, "Last checked at %s.\n", r.GeneratedAt.Format(time.RFC3339))
+	return b.String()
+}
+
+// writeNamespaceReports writes a ConfigMap to each namespace scanned that
+// contains Certificates. Namespaces with no Certificates only have an
+// existing ConfigMap updated, so that a report of Certificates that have
+// since been deleted does not linger, without creating a ConfigMap in every
+// namespace in the cluster.
+func writeNamespaceReports(ctx context.Context, cl client.Client, results *scanResults) error {
+	reports := make(map[string]*namespaceReport, len(results.Namespaces))
+	now := time.Now().UTC()
+	for ns, n := range results.Namespaces {
+		reports[ns] = &namespaceReport{SchemaVersion: namespaceReportSchemaVersion, GeneratedAt: now, Cluster: clusterName, RunID: runID, Namespace: ns, Certificates: n, Affected: []finding{}}
+	}
+	for _, f := range results.findings() {
+		if r, ok := reports[f.Namespace]; ok {
+			r.Affected = append(r.Affected, f)
+		}
+	}
+
+	var namespaces []string
+	for ns := range reports {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	failed := 0
+	for _, ns := range namespaces {
+		if err := writeNamespaceReport(ctx, cl, reports[ns]); err != nil {
+			log.Printf("Failed to write report to namespace %q: %v", ns, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to write reports to %d namespaces", failed)
+	}
+	log.Printf("Wrote reports to ConfigMap %q in each namespace", namespaceReportName)
+	return nil
+}
+
+func writeNamespaceReport(ctx context.Context, cl client.Client, r *namespaceReport) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	reportData := map[string]string{
+		namespaceReportKey:        string(data),
+		namespaceReportSummaryKey: r.summary(),
+	}
+
+	var cm core.ConfigMap
+	err = cl.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: namespaceReportName}, &cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		if r.Certificates == 0 {
+			return nil
+		}
+		cm = core.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   r.Namespace,
+				Name:        namespaceReportName,
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": manifestsName},
+				Annotations: map[string]string{runIDAnnotation: runID},
+			},
+			Data: reportData,
+		}
+		err = cl.Create(ctx, &cm)
+		if auditErr := auditLog.record("create", "ConfigMap", cm.Namespace, cm.Name, map[string]interface{}{"data": reportData}, "write namespace report", err); auditErr != nil {
+			return auditErr
+		}
+		return err
+	case err != nil:
+		return err
+	}
+	cm.Data = reportData
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	cm.Annotations[runIDAnnotation] = runID
+	err = cl.Update(ctx, &cm)
+	if auditErr := auditLog.record("update", "ConfigMap", cm.Namespace, cm.Name, map[string]interface{}{"data": reportData}, "write namespace report", err); auditErr != nil {
+		return auditErr
+	}
+	return err
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	notifyWebhookURL            string
+	notifyWebhookPerCertificate bool
+	notifyFile                  string
+)
+
+func init() {
+	flag.StringVar(&notifyWebhookURL, "notify-webhook-url", "", "If set, a JSON summary is POSTed to this URL each time a scan completes.")
+	flag.BoolVar(&notifyWebhookPerCertificate, "notify-webhook-per-certificate", false, "If true, a JSON notification is also POSTed to --notify-webhook-url for each affected Certificate found.")
+	flag.StringVar(&notifyFile, "notify-file", "", "If set, the notifications that would be POSTed to --notify-webhook-url, including one for each affected Certificate, are appended to this file instead, one per line. For clusters without access to a notification service.")
+}
+
+// scanNotification describes a completed scan to notifiers.
+type scanNotification struct {
+	Cluster string     `json:"cluster,omitempty"`
+	Summary runSummary `json:"summary"`
+	// Certificates lists every affected Certificate, along with the outcome
+	// of any renewal attempted.
+	Certificates []finding `json:"certificates"`
+}
+
+// newScanNotification builds a notification for a scan that started at
+// start. results may be nil if the scan failed.
+func newScanNotification(start time.Time, results *scanResults, err error) *scanNotification {
+	n := &scanNotification{
+		Cluster: clusterName,
+		Summary: runSummary{
+			RunID:           runID,
+			StartedAt:       start.UTC(),
+			DurationSeconds: time.Since(start).Seconds(),
+		},
+		Certificates: []finding{},
+	}
+	if err != nil {
+		n.Summary.Error = scrubSecrets(err.Error())
+	}
+	if results == nil {
+		return n
+	}
+	n.Summary.Checked = results.Checked
+	n.Summary.Skipped = results.Skipped
+	for _, f := range results.FailedNamespaces {
+		n.Summary.FailedNamespaces = append(n.Summary.FailedNamespaces, f.Namespace)
+	}
+	n.Certificates = results.findings()
+	n.Summary.Affected = len(n.Certificates)
+	for _, c := range n.Certificates {
+		if c.RenewalTriggered {
+			n.Summary.RenewalTriggered++
+		}
+		if c.RenewalError != "" {
+			n.Summary.RenewalFailed++
+		}
+	}
+	return n
+}
+
+// notifier sends notifications about scans to an external system.
+type notifier interface {
+	// name identifies the notifier in log messages.
+	name() string
+	// scanComplete is called each time a scan completes, whether or not it
+	// was successful.
+	scanComplete(ctx context.Context, n *scanNotification) error
+	// certificateAffected is called each time an affected Certificate is
+	// found.
+	certificateAffected(ctx context.Context, f finding) error
+}
+
+// internalNotifier is implemented by notifiers whose recipients own the
+// Certificates they are told about, such as the teams emailed about their
+// namespaces, and so are sent real names even if --redact is set.
+type internalNotifier interface {
+	internal() bool
+}
+
+func isInternalNotifier(nt notifier) bool {
+	i, ok := nt.(internalNotifier)
+	return ok && i.internal()
+}
+
+// notifiers are the notifiers configured by flags. They are set up by
+// setupNotifiers.
+var notifiers []notifier
+
+// setupNotifiers configures the notifiers enabled by flags.
+func setupNotifiers() error {
+	notifiers = nil
+	if notifyWebhookURL != "" {
+		notifiers = append(notifiers, &webhookNotifier{url: notifyWebhookURL, perCertificate: notifyWebhookPerCertificate})
+	}
+	if notifyFile != "" {
+		notifiers = append(notifiers, &fileNotifier{path: notifyFile})
+	}
+	if slackWebhookURL != "" || slackTokenFile != "" {
+		n, err := newSlackNotifier()
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if teamsWebhookURL != "" {
+		notifiers = append(notifiers, &teamsNotifier{webhookURL: teamsWebhookURL})
+	}
+	if pagerDutyRoutingKeyFile != "" {
+		n, err := newPagerDutyNotifier()
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if opsgenieAPIKeyFile != "" {
+		n, err := newOpsgenieNotifier()
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if smtpAddr != "" {
+		n, err := newEmailNotifier()
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return nil
+}
+
+// notifyScanComplete sends n to every configured notifier. Failures are
+// logged rather than returned, so that an unavailable notification service
+// does not cause a scan to fail.
+func notifyScanComplete(ctx context.Context, n *scanNotification) {
+	var redacted *scanNotification
+	if outputRedactor != nil {
+		redacted = outputRedactor.notification(n)
+	}
+	for _, nt := range notifiers {
+		n := n
+		if redacted != nil && !isInternalNotifier(nt) {
+			n = redacted
+		}
+		if err := nt.scanComplete(ctx, n); err != nil {
+			log.Printf("Failed to send %s notification: %v", nt.name(), err)
+		}
+	}
+}
+
+// notifyCertificateAffected notifies every configured notifier that f has
+// been found to be affected.
+func notifyCertificateAffected(ctx context.Context, f finding) {
+	for _, nt := range notifiers {
+		f := f
+		if outputRedactor != nil && !isInternalNotifier(nt) {
+			f = outputRedactor.finding(f)
+		}
+		if err := nt.certificateAffected(ctx, f); err != nil {
+			log.Printf("Failed to send %s notification for Certificate %s/%s: %v", nt.name(), f.Namespace, f.Name, err)
+		}
+	}
+}
+
+// notificationClient is the HTTP client used by notifiers.
+var notificationClient = &http.Client{Timeout: 30 * time.Second}
+
+// postJSON POSTs body, encoded as JSON, to url and checks that a successful
+// response was returned. If out is not nil, the response is decoded into it.
+func postJSON(ctx context.Context, url string, body interface{}, headers map[string]string, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("error decoding response: %w", err)
+		}
+	}
+	return nil
+}
+
+// webhookNotifier POSTs notifications as JSON to a generic webhook.
+type webhookNotifier struct {
+	url            string
+	perCertificate bool
+}
+
+// webhookPayload is the body of each webhook request. Event is either
+// "scan-complete" or "certificate-affected".
+type webhookPayload struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Event         string            `json:"event"`
+	Cluster       string            `json:"cluster,omitempty"`
+	Time          time.Time         `json:"time"`
+	Scan          *scanNotification `json:"scan,omitempty"`
+	Certificate   *finding          `json:"certificate,omitempty"`
+}
+
+func (w *webhookNotifier) name() string { return "webhook" }
+
+func (w *webhookNotifier) scanComplete(ctx context.Context, n *scanNotification) error {
+	return postJSON(ctx, w.url, webhookPayload{SchemaVersion: webhookSchemaVersion, Event: "scan-complete", Cluster: clusterName, Time: time.Now().UTC(), Scan: n}, nil, nil)
+}
+
+func (w *webhookNotifier) certificateAffected(ctx context.Context, f finding) error {
+	if !w.perCertificate {
+		return nil
+	}
+	return postJSON(ctx, w.url, webhookPayload{SchemaVersion: webhookSchemaVersion, Event: "certificate-affected", Cluster: clusterName, Time: time.Now().UTC(), Certificate: &f}, nil, nil)
+}
+
+// fileNotifier appends the payloads of webhookNotifier to a file, one per
+// line, so that a collector can pick them up from clusters that cannot reach
+// a webhook.
+type fileNotifier struct {
+	path string
+	lock sync.Mutex
+}
+
+func (f *fileNotifier) name() string { return "file" }
+
+func (f *fileNotifier) scanComplete(_ context.Context, n *scanNotification) error {
+	return f.append(webhookPayload{SchemaVersion: webhookSchemaVersion, Event: "scan-complete", Cluster: clusterName, Time: time.Now().UTC(), Scan: n})
+}
+
+func (f *fileNotifier) certificateAffected(_ context.Context, c finding) error {
+	return f.append(webhookPayload{SchemaVersion: webhookSchemaVersion, Event: "certificate-affected", Cluster: clusterName, Time: time.Now().UTC(), Certificate: &c})
+}
+
+func (f *fileNotifier) append(p webhookPayload) error {
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	out, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// namespaceCount is the number of affected Certificates in a namespace.
+type namespaceCount struct {
+	Namespace string
+	Affected  int
+}
+
+// topNamespaces returns up to max namespaces with the most affected
+// Certificates, in descending order.
+func (n *scanNotification) topNamespaces(max int) []namespaceCount {
+	counts := make(map[string]int)
+	for _, c := range n.Certificates {
+		counts[c.Namespace]++
+	}
+	var top []namespaceCount
+	for ns, count := range counts {
+		top = append(top, namespaceCount{Namespace: ns, Affected: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Affected != top[j].Affected {
+			return top[i].Affected > top[j].Affected
+		}
+		return top[i].Namespace < top[j].Namespace
+	})
+	if len(top) > max {
+		top = top[:max]
+	}
+	return top
+}
+
+// title returns a one line summary of the scan.
+func (n *scanNotification) title() string {
+	cluster := ""
+	if n.Cluster != "" {
+		cluster = " in cluster " + n.Cluster
+	}
+	switch {
+	case n.Summary.Error != "":
+		return "Let's Encrypt CAA bug scan" + cluster + " failed"
+	case n.Summary.Affected == 0:
+		return "No certificates affected by the Let's Encrypt CAA bug" + cluster
+	default:
+		return fmt.Sprintf("%d certificates affected by the Let's Encrypt CAA bug%s", n.Summary.Affected, cluster)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"golang.org/x/oauth2/google"
+)
+
+// getDataset GETs the affected serials file from rawURL with the given
+// headers. As well as http:// and https:// URLs, s3://bucket/key and
+// gs://bucket/object URLs are fetched from Amazon S3 and Google Cloud Storage
+// using the standard credential chain of each, for datasets distributed
+// through private buckets rather than public HTTP.
+func getDataset(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3":
+		return getS3Object(ctx, u.Host, strings.TrimPrefix(u.Path, "/"), header)
+	case "gs":
+		return getGCSObject(ctx, u.Host, strings.TrimPrefix(u.Path, "/"), header)
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
+// isObjectStorageURL returns true if rawURL is an s3:// or gs:// URL.
+func isObjectStorageURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "s3://") || strings.HasPrefix(rawURL, "gs://")
+}
+
+// getGCSObject GETs object from bucket with the XML API, which honours
+// If-Modified-Since and returns Last-Modified like a web server. Credentials
+// are found by Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS,
+// the gcloud CLI, or the metadata server, which serves those of Workload
+// Identity in GKE.
+func getGCSObject(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error) {
+	cl, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_only")
+	if err != nil {
+		return nil, fmt.Errorf("error finding Google Cloud credentials: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://storage.googleapis.com/"+bucket+"/"+escapePath(object), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	return cl.Do(req.WithContext(ctx))
+}
+
+// getS3Object GETs key from bucket with the AWS SDK, which finds credentials
+// with its default chain: the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables, a web identity token such as that of IAM roles for
+// service accounts in EKS, the shared credentials and config files, and the
+// credential endpoints of ECS and EC2. The bucket's region is looked up, so
+// AWS_REGION need not match it. AWS_ENDPOINT_URL_S3, or AWS_ENDPOINT_URL,
+// replaces the S3 endpoint, for S3 compatible stores. The object is returned
+// as an HTTP response, so that it is handled like any other download.
+func getS3Object(ctx context.Context, bucket, key string, header http.Header) (*http.Response, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %w", err)
+	}
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	cfg := aws.NewConfig().WithRegion(region)
+	if endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	} else {
+		bucketRegion, err := s3manager.GetBucketRegion(ctx, sess, bucket, region)
+		if err != nil {
+			return nil, fmt.Errorf("error finding the region of S3 bucket %q: %w", bucket, err)
+		}
+		cfg = cfg.WithRegion(bucketRegion)
+	}
+
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if since, err := http.ParseTime(header.Get("If-Modified-Since")); err == nil {
+		input.IfModifiedSince = aws.Time(since)
+	}
+	out, err := s3.New(sess, cfg).GetObjectWithContext(ctx, input)
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotModified {
+			return &http.Response{StatusCode: http.StatusNotModified, Status: "304 Not Modified", Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}
+		return nil, err
+	}
+	resp := &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: make(http.Header), Body: out.Body}
+	if out.LastModified != nil {
+		resp.Header.Set("Last-Modified", out.LastModified.UTC().Format(http.TimeFormat))
+	}
+	return resp, nil
+}
+
+// escapePath escapes the name of an object for use in a URL path, encoding
+// every byte other than unreserved characters and "/".
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv sets the given environment variables, returning a function that
+// restores them.
+func setEnv(vars map[string]string) func() {
+	old := make(map[string]*string)
+	for name, value := range vars {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = &v
+		} else {
+			old[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, v := range old {
+			if v == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *v)
+			}
+		}
+	}
+}
+
+func TestGetDatasetS3(t *testing.T) {
+	modified := time.Date(2020, 3, 3, 12, 0, 0, 0, time.UTC)
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.URL.Path != "/security-datasets/caa/serials.txt.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Write([]byte("serials"))
+	}))
+	defer srv.Close()
+	defer setEnv(map[string]string{
+		"AWS_ENDPOINT_URL_S3":         srv.URL,
+		"AWS_ACCESS_KEY_ID":           "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY":       "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		"AWS_SESSION_TOKEN":           "token",
+		"AWS_REGION":                  "eu-west-1",
+		"AWS_SHARED_CREDENTIALS_FILE": os.DevNull,
+		"AWS_CONFIG_FILE":             os.DevNull,
+	})()
+
+	resp, err := getDataset(context.Background(), "s3://security-datasets/caa/serials.txt.gz", make(http.Header))
+	if err != nil {
+		t.Fatalf("getDataset() error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "serials" {
+		t.Errorf("getDataset() = %d %q, want 200 %q", resp.StatusCode, body, "serials")
+	}
+	if got := resp.Header.Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", got, modified.Format(http.TimeFormat))
+	}
+	auth := requests[0].Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") {
+		t.Errorf("request not signed with the credentials from the environment: Authorization = %q", auth)
+	}
+	if got := requests[0].Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("X-Amz-Security-Token = %q, want %q", got, "token")
+	}
+
+	header := make(http.Header)
+	header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+	resp, err = getDataset(context.Background(), "s3://security-datasets/caa/serials.txt.gz", header)
+	if err != nil {
+		t.Fatalf("getDataset() with If-Modified-Since error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("getDataset() with If-Modified-Since = %d, want 304", resp.StatusCode)
+	}
+
+	if _, err := getDataset(context.Background(), "s3://security-datasets/missing", make(http.Header)); err == nil {
+		t.Errorf("getDataset() of a missing object returned no error")
+	}
+}
+
+func TestIsObjectStorageURL(t *testing.T) {
+	for url, want := range map[string]bool{
+		"s3://bucket/key":             true,
+		"gs://bucket/object":          true,
+		"https://example.com/serials": false,
+		"http://s3.amazonaws.com/b/k": false,
+		"/data/serials.txt.gz":        false,
+	} {
+		if got := isObjectStorageURL(url); got != want {
+			t.Errorf("isObjectStorageURL(%q) = %t, want %t", url, got, want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	offline       bool
+	datasetBundle string
+)
+
+func init() {
+	flag.BoolVar(&offline, "offline", false, "If true, the tool makes no network connections other than to the Kubernetes API server, for clusters with no egress. Flags that need the network, such as --affected-serials-url and the notifiers, are rejected, and the affected serials must be a local file or --dataset-bundle.")
+	flag.StringVar(&datasetBundle, "dataset-bundle", "", "Path to a directory written by the 'bundle-dataset' command. Sets --affected-serials-file, --dataset-signature and --incident-file to the files in it, unless they are given explicitly.")
+}
+
+// Names of the files in a dataset bundle.
+const (
+	bundleSerialsFile   = "affected-serials.txt"
+	bundleSignatureFile = "affected-serials.txt.sig"
+	bundleIncidentFile  = "incident.yaml"
+)
+
+// applyDatasetBundle sets flags to the files in --dataset-bundle, if set. It
+// must be called before applyIncidentFile, so that a bundled incident
+// descriptor is applied.
+func applyDatasetBundle() error {
+	if datasetBundle == "" {
+		return nil
+	}
+	serials := filepath.Join(datasetBundle, bundleSerialsFile)
+	if _, err := os.Stat(serials); err != nil {
+		return fmt.Errorf("--dataset-bundle %q does not contain an affected serials file: %w", datasetBundle, err)
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	files := []struct{ flag, path string }{
+		{"affected-serials-file", serials},
+		{"dataset-signature", filepath.Join(datasetBundle, bundleSignatureFile)},
+		{"incident-file", filepath.Join(datasetBundle, bundleIncidentFile)},
+	}
+	for _, f := range files {
+		if set[f.flag] {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			// The signature and incident descriptor are optional.
+			continue
+		}
+		if err := flag.Set(f.flag, f.path); err != nil {
+			return err
+		}
+	}
+	log.Printf("Using the dataset bundle in %q", datasetBundle)
+	return nil
+}
+
+// validateOfflineFlags rejects flags that need the network if --offline is
+// set, and then stops anything else from using it.
+func validateOfflineFlags() error {
+	if !offline {
+		return nil
+	}
+	checks := []struct {
+		flag string
+		set  bool
+	}{
+		{"affected-serials-url", affectedSerialsURL != ""},
+		{"dataset-signature", isHTTPURL(datasetSignature)},
+		{"notify-webhook-url", notifyWebhookURL != ""},
+		{"slack-webhook-url", slackWebhookURL != ""},
+		{"slack-token-file", slackTokenFile != ""},
+		{"teams-webhook-url", teamsWebhookURL != ""},
+		{"pagerduty-routing-key-file", pagerDutyRoutingKeyFile != ""},
+		{"opsgenie-api-key-file", opsgenieAPIKeyFile != ""},
+		{"smtp-addr", smtpAddr != ""},
+		{"pushgateway-url", pushgatewayURL != ""},
+		{"otlp-endpoint", otlpEndpoint != ""},
+		{"checker-api", checkerAPI},
+		{"remediator=webhook", remediatorName == "webhook"},
+	}
+	var needNetwork []string
+	for _, c := range checks {
+		if c.set {
+			needNetwork = append(needNetwork, "--"+c.flag)
+		}
+	}
+	if len(needNetwork) > 0 {
+		return fmt.Errorf("--offline cannot be combined with flags that need the network: %s. Use --dataset-bundle, --notify-file and --metrics-file instead", strings.Join(needNetwork, ", "))
+	}
+	// Clients of the Kubernetes API server build their own transports, so
+	// this only affects everything else.
+	http.DefaultTransport = offlineTransport{}
+	log.Printf("Running offline, no connections will be made other than to the Kubernetes API server")
+	return nil
+}
+
+// offlineTransport refuses every request, so that anything missed by
+// validateOfflineFlags fails rather than trying to connect.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("refusing to connect to %s, as --offline is set", req.URL.Host)
+}
+
+// runBundleDataset implements the 'bundle-dataset' command, which writes the
+// affected serials file, downloading it from --affected-serials-url if set,
+// along with --dataset-signature and --incident-file, to the directory given
+// as an argument. The directory can then be carried into an air-gapped
+// environment and given to --dataset-bundle.
+func runBundleDataset(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("the path of the directory to write the bundle to must be given")
+	}
+	dir := args[0]
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	serials := filepath.Join(dir, bundleSerialsFile)
+	switch {
+	case affectedSerialsURL != "":
+		if err := downloadAffectedSerials(affectedSerialsURL, serials); err != nil {
+			return err
+		}
+	case affectedSerialsFile != "":
+		if err := copyFile(affectedSerialsFile, serials); err != nil {
+			return fmt.Errorf("error copying affected serials file: %w", err)
+		}
+	default:
+		return fmt.Errorf("--affected-serials-url or --affected-serials-file must be specified")
+	}
+	if datasetSignature != "" {
+		sig, err := readSignature(datasetSignature)
+		if err != nil {
+			return fmt.Errorf("error reading --dataset-signature: %w", err)
+		}
+		path := filepath.Join(dir, bundleSignatureFile)
+		if err := ioutil.WriteFile(path, sig, 0644); err != nil {
+			return err
+		}
+		// The bundled copy is verified, rather than the original, so that
+		// a bundle that would be refused is found before it is carried
+		// across.
+		datasetSignature = path
+		if err := verifyDatasetSignature(serials); err != nil {
+			return err
+		}
+	}
+	if incidentFile != "" {
+		if err := copyFile(incidentFile, filepath.Join(dir, bundleIncidentFile)); err != nil {
+			return fmt.Errorf("error copying incident file: %w", err)
+		}
+	}
+	log.Printf("Wrote dataset bundle to %q", dir)
+	return nil
+}
+
+// copyFile copies the file at src to dst, replacing it if it exists.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
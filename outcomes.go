@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var outcomesFile string
+
+func init() {
+	flag.StringVar(&outcomesFile, "outcomes-file", "outcomes.json", "Path that a JSON record of what the tool did to each Certificate is written to at the end of every run that changes the cluster: a scan or 'renew' with --renew, or 'fix-stuck-renewals' with --reset. Set to '' to not write one.")
+}
+
+// Values of outcome.Action.
+const (
+	outcomeRenewal       = "renewal"
+	outcomeCanaryRenewal = "canaryRenewal"
+	outcomeAdoption      = "adoption"
+	outcomeReset         = "reset"
+)
+
+// Values of outcome.Status.
+const (
+	// outcomeRotated means the Secret holds a new certificate.
+	outcomeRotated = "Rotated"
+	// outcomePending means the renewal was triggered, but the Secret has not
+	// been checked for a new certificate since, as --rotation-wait is 0 or
+	// cert-manager is not running.
+	outcomePending = "Pending"
+	// outcomeNotRotated means the Secret still held the old certificate once
+	// --rotation-wait had passed.
+	outcomeNotRotated = "NotRotated"
+	outcomeFailed     = "Failed"
+	// outcomeResetDone means the CertificateRequests blocking a stuck
+	// issuance were deleted.
+	outcomeResetDone = "Reset"
+)
+
+// outcomes is written to --outcomes-file. Unlike a report, which describes
+// what a scan found, it only lists what the run changed.
+type outcomes struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	RunID         string `json:"runId"`
+	// Command is "scan", "renew" or "fix-stuck-renewals".
+	Command string `json:"command"`
+	// Simulated is true if the run was against --fixtures, so nothing was
+	// really changed.
+	Simulated  bool      `json:"simulated,omitempty"`
+	Redacted   bool      `json:"redacted,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Outcomes   []outcome `json:"outcomes"`
+}
+
+// outcome is what a run did to a Certificate.
+type outcome struct {
+	Namespace   string    `json:"namespace"`
+	Name        string    `json:"name"`
+	SecretName  string    `json:"secretName,omitempty"`
+	Action      string    `json:"action"`
+	TriggeredAt time.Time `json:"triggeredAt"`
+	// CheckedAt is when Status was last checked, if it was after the
+	// action.
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	// CertificateRequest is the CertificateRequest created by cert-manager
+	// for the renewal, if it had been by the end of the run.
+	CertificateRequest string `json:"certificateRequest,omitempty"`
+	// DeletedCertificateRequests lists the CertificateRequests deleted by a
+	// reset.
+	DeletedCertificateRequests []string `json:"deletedCertificateRequests,omitempty"`
+	Status                     string   `json:"status"`
+	Error                      string   `json:"error,omitempty"`
+	ErrorCode                  string   `json:"errorCode,omitempty"`
+}
+
+// renewalOutcomes returns the outcome of each renewal triggered after the
+// scan that produced results, once checkRotations has run.
+func renewalOutcomes(ctx context.Context, cl client.Reader, results *scanResults) []outcome {
+	results.lock.Lock()
+	defer results.lock.Unlock()
+	var list []outcome
+	for key, err := range results.renewals {
+		rot, ok := results.rotations[key]
+		if !ok {
+			continue
+		}
+		o := outcome{Namespace: rot.crt.Namespace, Name: rot.crt.Name, SecretName: rot.crt.Spec.SecretName, Action: rot.action, TriggeredAt: rot.triggeredAt.UTC()}
+		if rot.checked {
+			at := rot.checkedAt.UTC()
+			o.CheckedAt = &at
+		}
+		switch d := rot.diff(); {
+		case err != nil:
+			o.Status = outcomeFailed
+			o.Error = scrubSecrets(err.Error())
+			o.ErrorCode = string(scanner.ErrorCode(err))
+		case d.Rotated:
+			o.Status = outcomeRotated
+		case rot.checked && d.Problem != "":
+			o.Status = outcomeNotRotated
+			o.Error = d.Problem
+		default:
+			o.Status = outcomePending
+		}
+		if err == nil {
+			o.CertificateRequest = newestRequest(ctx, cl, rot.crt, rot.triggeredAt)
+		}
+		list = append(list, o)
+	}
+	return list
+}
+
+// newestRequest returns the name of the most recent CertificateRequest of crt
+// created since the given time, or "" if there is none. Requests are matched
+// to crt by name, as the Certificates of adopted Secrets were created during
+// the run.
+func newestRequest(ctx context.Context, cl client.Reader, crt capi.Certificate, since time.Time) string {
+	// CreationTimestamp only has a precision of a second.
+	since = since.Truncate(time.Second)
+	var requests capi.CertificateRequestList
+	if err := cl.List(ctx, &requests, client.InNamespace(crt.Namespace)); err != nil {
+		log.Printf("WARNING: unable to list CertificateRequests of Certificate %s/%s for --outcomes-file: %v", crt.Namespace, crt.Name, err)
+		return ""
+	}
+	var newest *capi.CertificateRequest
+	for i := range requests.Items {
+		req := &requests.Items[i]
+		owner := metav1.GetControllerOf(req)
+		if owner == nil || owner.Kind != capi.CertificateKind || owner.Name != crt.Name || req.CreationTimestamp.Time.Before(since) {
+			continue
+		}
+		if newest == nil || newest.CreationTimestamp.Before(&req.CreationTimestamp) {
+			newest = req
+		}
+	}
+	if newest == nil {
+		return ""
+	}
+	return newest.Name
+}
+
+// writeOutcomes writes list, the outcomes of a run of command that started
+// at start, to --outcomes-file, if it is set.
+func writeOutcomes(command string, start time.Time, list []outcome) error {
+	if outcomesFile == "" {
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	o := &outcomes{
+		SchemaVersion: outcomesSchemaVersion,
+		RunID:         runID,
+		Command:       command,
+		Simulated:     fixturesDir != "",
+		StartedAt:     start.UTC(),
+		FinishedAt:    time.Now().UTC(),
+		Outcomes:      list,
+	}
+	if o.Outcomes == nil {
+		o.Outcomes = []outcome{}
+	}
+	if outputRedactor != nil {
+		outputRedactor.outcomes(o)
+	}
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(outcomesFile, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	log.Printf("Wrote the outcomes of %d Certificate(s) to %q", len(list), outcomesFile)
+	return nil
+}
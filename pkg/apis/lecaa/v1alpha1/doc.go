@@ -0,0 +1,6 @@
+// Package v1alpha1 contains the CAABugScan API, used to declaratively run
+// scans for certificates affected by the Let's Encrypt CAA rechecking bug and
+// record their results.
+// +k8s:deepcopy-gen=package
+// +groupName=lecaa.jetstack.io
+package v1alpha1
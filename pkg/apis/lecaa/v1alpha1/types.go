@@ -0,0 +1,103 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:scope=Cluster
+
+// A CAABugScan describes a scan of the cluster for Certificates affected by
+// the Let's Encrypt CAA rechecking bug. The scan is run by the controller
+// started with the 'serve' command, and its results are recorded in the
+// status.
+type CAABugScan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CAABugScanSpec   `json:"spec,omitempty"`
+	Status CAABugScanStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CAABugScanList is a list of CAABugScans.
+type CAABugScanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []CAABugScan `json:"items"`
+}
+
+// RenewalPolicy controls what happens to affected Certificates found by a
+// scan.
+type RenewalPolicy string
+
+const (
+	// RenewalPolicyNone only reports affected Certificates.
+	RenewalPolicyNone RenewalPolicy = "None"
+	// RenewalPolicyRenew triggers a renewal of each affected Certificate.
+	RenewalPolicyRenew RenewalPolicy = "Renew"
+)
+
+// CAABugScanSpec selects what a scan covers and what it does with the
+// affected Certificates it finds.
+type CAABugScanSpec struct {
+	// Namespaces limits the scan to the given namespaces. If empty, every
+	// namespace in the cluster is scanned.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// RenewalPolicy controls whether affected Certificates are renewed.
+	// Defaults to None.
+	// +optional
+	RenewalPolicy RenewalPolicy `json:"renewalPolicy,omitempty"`
+
+	// RescanInterval, if set, causes the scan to be repeated at this interval.
+	// If not set, the scan is only run again when the spec changes.
+	// +optional
+	RescanInterval *metav1.Duration `json:"rescanInterval,omitempty"`
+}
+
+// CAABugScanStatus records the results of the most recent scan.
+type CAABugScanStatus struct {
+	// ObservedGeneration is the generation of the spec the most recent scan
+	// was run against.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastScanTime is the time the most recent scan completed.
+	// +optional
+	LastScanTime *metav1.Time `json:"lastScanTime,omitempty"`
+
+	// Checked is the number of Certificates whose Secret was checked.
+	Checked int `json:"checked"`
+
+	// Skipped is the number of Certificates that could not be checked.
+	Skipped int `json:"skipped"`
+
+	// Affected lists the affected Certificates found.
+	// +optional
+	Affected []AffectedCertificate `json:"affected,omitempty"`
+
+	// Error is set if the most recent scan failed.
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// AffectedCertificate is an affected Certificate found by a scan.
+type AffectedCertificate struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Serial    string `json:"serial"`
+
+	// RenewalTriggered is true if a renewal of the Certificate was triggered.
+	// +optional
+	RenewalTriggered bool `json:"renewalTriggered,omitempty"`
+
+	// RenewalError is set if triggering a renewal of the Certificate failed.
+	// +optional
+	RenewalError string `json:"renewalError,omitempty"`
+}
@@ -0,0 +1,297 @@
+// Package reconciler continuously checks cert-manager Certificates as a
+// controller-runtime controller, so that it can be added to the manager of
+// another operator to share its caches and leader election.
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// SecretNameField is the field index added to the manager by
+// SetupWithManager to look up the Certificates that use a given Secret.
+// Managers can only have one index with a given name, so operators that
+// already index this field should not add more than one Reconciler.
+const SecretNameField = "spec.secretName"
+
+// issuerRecheckInterval is how often an affected Certificate whose issuer is
+// missing or not Ready is checked again.
+const issuerRecheckInterval = 5 * time.Minute
+
+// duplicateRecheckInterval is how often an affected Certificate whose
+// remediation is refused by CheckDuplicates is checked again.
+const duplicateRecheckInterval = time.Hour
+
+// Reconciler checks whether a Certificate is affected each time it or its
+// Secret changes. Only Serials or Detector is required. The scheme of the
+// manager it is added to must include cert-manager's v1alpha2 types.
+type Reconciler struct {
+	// Client is used to read Certificates and Secrets. It defaults to the
+	// manager's client, which reads from its shared caches.
+	Client client.Client
+	// Serials returns the current affected serials. It is called for each
+	// reconcile, so that the dataset can be replaced while running.
+	Serials func() scanner.SerialSource
+	// Detector, if set, is also used to check each certificate.
+	Detector scanner.Detector
+	// KeystorePassword, if set, is used to decrypt PKCS#12 keystores so that
+	// they can be checked too.
+	KeystorePassword scanner.KeystorePasswordFunc
+	// IssuanceWindow, if set, is the period in which the certificates in the
+	// affected serials were issued (see scanner.Scanner). Certificates
+	// matching the serials outside of it are not remediated.
+	IssuanceWindow *scanner.Window
+	// PausedAnnotations are the annotations that mark a Certificate as
+	// paused (see scanner.PausedBy). It defaults to
+	// scanner.DefaultPausedAnnotations.
+	PausedAnnotations []string
+	// CheckIssuers causes the Issuer or ClusterIssuer of each affected
+	// Certificate to be looked up, and Certificates whose issuer is missing
+	// or not Ready to be reported with an IssuerProblem and not remediated,
+	// as their renewal would never complete.
+	CheckIssuers bool
+	// ACMEServers, if set along with CheckIssuers, is used to check that
+	// the ACME servers of the issuers of affected Certificates are
+	// reachable.
+	ACMEServers *scanner.ACMEServerChecker
+	// CheckDuplicates, if set, is called before remediating each affected
+	// Certificate, and returns why its renewal would probably be rejected by
+	// the Let's Encrypt duplicate certificate limit, having logged it, or ""
+	// if it would not. Refused Certificates are checked again later, as
+	// older certificates leave the limit's window.
+	CheckDuplicates func(ctx context.Context, crt capi.Certificate) string
+	// Remediator, if set, is used to fix affected Certificates, normally by
+	// a *renewer.Renewer triggering their renewal. Remediation is retried
+	// until it succeeds.
+	Remediator renewer.Remediator
+	// MaxConcurrentReconciles is the number of Certificates checked
+	// concurrently. It defaults to 1.
+	MaxConcurrentReconciles int
+	// Events, if set, is told about each Certificate checked. Renewals are
+	// reported by the Remediator, if it is a *renewer.Renewer with Events.
+	Events scanner.Events
+
+	// OnAffected, if set, is called each time a Certificate is found to be
+	// affected, with whether it was remediated and the error remediation
+	// failed with, if any. Certificates using a replicated Secret, or a
+	// Secret shared with another Certificate, paused Certificates, those
+	// with a warning and those whose issuer is missing or not Ready are not
+	// remediated.
+	OnAffected func(ctx context.Context, a scanner.AffectedCertificate, renewalTriggered bool, renewalErr error)
+	// OnUnaffected, if set, is called each time a Certificate is found not
+	// to be affected, including once it has been deleted.
+	OnUnaffected func(ctx context.Context, key types.NamespacedName)
+}
+
+// SetupWithManager adds r to mgr as a controller watching Certificates and
+// Secrets.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Client == nil {
+		r.Client = mgr.GetClient()
+	}
+	if err := mgr.GetFieldIndexer().IndexField(&capi.Certificate{}, SecretNameField, func(obj runtime.Object) []string {
+		return []string{obj.(*capi.Certificate).Spec.SecretName}
+	}); err != nil {
+		return fmt.Errorf("error indexing Certificates: %w", err)
+	}
+	workers := r.MaxConcurrentReconciles
+	if workers < 1 {
+		workers = 1
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&capi.Certificate{}).
+		Watches(&source.Kind{Type: &core.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.certificatesForSecret),
+		}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: workers}).
+		Complete(r)
+}
+
+// Reconcile implements reconcile.Reconciler.
+func (r *Reconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+	var crt capi.Certificate
+	if err := r.Client.Get(ctx, req.NamespacedName, &crt); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.unaffected(ctx, req.NamespacedName)
+		}
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if crt.DeletionTimestamp != nil {
+		r.unaffected(ctx, req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+	var secret core.Secret
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: crt.Namespace, Name: crt.Spec.SecretName}, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			// The Certificate will be reconciled again once its Secret exists.
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	cert, err := scanner.DecodeCertificate(&secret)
+	if err != nil {
+		log.Printf("Unable to check Secret %q for Certificate %s: %v", secret.Name, req, err)
+		return reconcile.Result{}, nil
+	}
+	s := &scanner.Scanner{Detector: r.Detector, KeystorePassword: r.KeystorePassword, IssuanceWindow: r.IssuanceWindow}
+	if r.Serials != nil {
+		s.Serials = r.Serials()
+	}
+	serial := cert.SerialNumber
+	verdict, err := s.Check(ctx, crt, cert)
+	if err == nil && !verdict.Affected {
+		var ks *big.Int
+		if ks, verdict, err = s.CheckKeystores(ctx, crt, &secret); verdict.Affected {
+			serial = ks
+		}
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if r.Events != nil {
+		r.Events.OnCertificateChecked(crt, serial, verdict)
+	}
+	if !verdict.Affected {
+		if err := scanner.VerifyKeyPair(&secret, cert); err != nil {
+			log.Printf("Secret %q for Certificate %s needs manual intervention: %v", secret.Name, req, err)
+		}
+		r.unaffected(ctx, req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
+	log.Printf("Certificate %s is AFFECTED (serial number: %x)", req, serial)
+	a := scanner.AffectedCertificate{Certificate: crt, Serial: fmt.Sprintf("%x", serial), Reason: verdict.Reason, Warning: verdict.Warning}
+	if r.Events != nil {
+		r.Events.OnAffectedFound(a)
+	}
+	if source, ok := scanner.ReplicationSource(&secret); ok {
+		// The Certificate of the source Secret is remediated instead, if it
+		// is also reconciled.
+		log.Printf("Secret %q of Certificate %s is a replica of Secret %s, and will be fixed by replication", secret.Name, req, source)
+		a.ReplicatedFrom = source.String()
+		r.affected(ctx, a, false, nil)
+		return reconcile.Result{}, nil
+	}
+	others, err := r.sharingSecret(ctx, crt)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(others) > 0 {
+		// Remediating one of several Certificates sharing a Secret would
+		// make them fight over it.
+		log.Printf("NOT remediating Certificate %s, as its Secret %q is also used by Certificate(s) %s", req, crt.Spec.SecretName, strings.Join(others, ", "))
+		a.SharesSecretWith = others
+		r.affected(ctx, a, false, nil)
+		return reconcile.Result{}, nil
+	}
+	if verdict.Warning != "" {
+		log.Printf("WARNING: NOT remediating Certificate %s, as it matched the affected serials, but %s", req, verdict.Warning)
+		r.affected(ctx, a, false, nil)
+		return reconcile.Result{}, nil
+	}
+	if annotation, ok := scanner.PausedBy(&crt, r.PausedAnnotations); ok {
+		// cert-manager ignores renewals of paused Certificates.
+		log.Printf("NOT remediating Certificate %s, as it is paused by annotation %q", req, annotation)
+		a.PausedBy = annotation
+		r.affected(ctx, a, false, nil)
+		return reconcile.Result{}, nil
+	}
+	if r.CheckIssuers {
+		problem, err := scanner.IssuerProblem(ctx, r.Client, r.ACMEServers, &crt)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if problem != "" {
+			// Issuers are not watched, so the Certificate is checked again
+			// later in case its issuer has been fixed.
+			log.Printf("NOT remediating Certificate %s, as it is unrenewable: %s", req, problem)
+			a.IssuerProblem = problem
+			r.affected(ctx, a, false, nil)
+			return reconcile.Result{RequeueAfter: issuerRecheckInterval}, nil
+		}
+	}
+	if r.Remediator == nil {
+		r.affected(ctx, a, false, nil)
+		return reconcile.Result{}, nil
+	}
+	if r.CheckDuplicates != nil {
+		if reason := r.CheckDuplicates(ctx, crt); reason != "" {
+			r.affected(ctx, a, false, nil)
+			return reconcile.Result{RequeueAfter: duplicateRecheckInterval}, nil
+		}
+	}
+	// The Certificate is remediated each time it is reconciled while it is
+	// affected, which Renewer handles by not triggering a renewal while one
+	// is already in progress.
+	log.Printf("Remediating Certificate %s", req)
+	if err := r.Remediator.Remediate(ctx, crt); err != nil {
+		log.Printf("Failed to remediate Certificate %s: %v", req, err)
+		r.affected(ctx, a, false, err)
+		return reconcile.Result{}, err
+	}
+	r.affected(ctx, a, true, nil)
+	return reconcile.Result{}, nil
+}
+
+func (r *Reconciler) affected(ctx context.Context, a scanner.AffectedCertificate, renewalTriggered bool, renewalErr error) {
+	if r.OnAffected != nil {
+		r.OnAffected(ctx, a, renewalTriggered, renewalErr)
+	}
+}
+
+func (r *Reconciler) unaffected(ctx context.Context, key types.NamespacedName) {
+	if r.OnUnaffected != nil {
+		r.OnUnaffected(ctx, key)
+	}
+}
+
+// sharingSecret returns the names of the other Certificates using the same
+// Secret as crt.
+func (r *Reconciler) sharingSecret(ctx context.Context, crt capi.Certificate) ([]string, error) {
+	var certs capi.CertificateList
+	if err := r.Client.List(ctx, &certs, client.InNamespace(crt.Namespace), client.MatchingField(SecretNameField, crt.Spec.SecretName)); err != nil {
+		return nil, fmt.Errorf("error listing Certificates using Secret %q: %w", crt.Spec.SecretName, err)
+	}
+	var names []string
+	for _, other := range certs.Items {
+		if other.Name != crt.Name {
+			names = append(names, other.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// certificatesForSecret maps a Secret to the Certificates that use it.
+func (r *Reconciler) certificatesForSecret(obj handler.MapObject) []reconcile.Request {
+	var certs capi.CertificateList
+	if err := r.Client.List(context.Background(), &certs, client.InNamespace(obj.Meta.GetNamespace()), client.MatchingField(SecretNameField, obj.Meta.GetName())); err != nil {
+		log.Printf("Failed to list Certificates for Secret %s/%s: %v", obj.Meta.GetNamespace(), obj.Meta.GetName(), err)
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, crt := range certs.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: crt.Namespace, Name: crt.Name}})
+	}
+	return reqs
+}
@@ -0,0 +1,276 @@
+package renewer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha2"
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Doctor diagnoses why the issuance of a Certificate is not completing.
+type Doctor struct {
+	Client client.Reader
+	// CheckIssuer, if true, also checks the Issuer or ClusterIssuer of the
+	// Certificate with scanner.IssuerProblem, fetching the directory of an
+	// ACME issuer's server with ACMEServers if it is not nil.
+	CheckIssuer bool
+	ACMEServers *scanner.ACMEServerChecker
+}
+
+// Diagnosis describes the issuance of a Certificate, found by Diagnose.
+type Diagnosis struct {
+	// Steps summarise the Certificate, its most recent CertificateRequest,
+	// the ACME Order of that request and the Challenges of the Order, in
+	// that order, as far as they exist.
+	Steps []DiagnosisStep
+	// Cause is the most likely reason the issuance is blocked, or "" if
+	// none was found.
+	Cause string
+}
+
+// DiagnosisStep summarises an object involved in the issuance of a
+// Certificate.
+type DiagnosisStep struct {
+	Kind string
+	Name string
+	// Status lists the conditions or state of the object.
+	Status []string
+}
+
+// Diagnose walks the chain of objects cert-manager creates to issue the
+// Certificate with the given key, from the Certificate to its most recent
+// CertificateRequest, the ACME Order of that request and the Challenges of
+// the Order, summarising each and working out the most likely cause of a
+// stalled issuance, such as a CAA record forbidding issuance, a DNS-01 record
+// that has not propagated or an HTTP-01 challenge that cannot be reached. As
+// with FindStuck, the ACME CRDs may not be installed if no ACME issuer is
+// used, in which case Orders and Challenges are not shown.
+func (d *Doctor) Diagnose(ctx context.Context, key client.ObjectKey) (*Diagnosis, error) {
+	var crt capi.Certificate
+	if err := d.Client.Get(ctx, key, &crt); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("Certificate %s not found", key)
+		}
+		return nil, fmt.Errorf("error getting Certificate %s: %w", key, err)
+	}
+	diag := &Diagnosis{}
+	diag.Steps = append(diag.Steps, DiagnosisStep{Kind: capi.CertificateKind, Name: crt.Name, Status: certificateStatus(&crt)})
+	issuerProblem := ""
+	if d.CheckIssuer {
+		var err error
+		if issuerProblem, err = scanner.IssuerProblem(ctx, d.Client, d.ACMEServers, &crt); err != nil {
+			issuerProblem = fmt.Sprintf("unable to check the issuer: %v", err)
+		}
+	}
+
+	var requests capi.CertificateRequestList
+	if err := d.Client.List(ctx, &requests, client.InNamespace(crt.Namespace)); err != nil {
+		return nil, &scanner.Error{Code: CodeListRequestsFailed, Err: fmt.Errorf("error listing CertificateRequest resources: %w", err)}
+	}
+	var req *capi.CertificateRequest
+	for i := range requests.Items {
+		r := &requests.Items[i]
+		if metav1.IsControlledBy(r, &crt) && (req == nil || req.CreationTimestamp.Before(&r.CreationTimestamp)) {
+			req = r
+		}
+	}
+	if req == nil {
+		switch {
+		case issuerProblem != "":
+			diag.Cause = issuerProblem
+		case !certificateReady(&crt):
+			diag.Cause = "cert-manager has not created a CertificateRequest for the Certificate. Check that cert-manager is running and that the Certificate is not paused"
+		}
+		return diag, nil
+	}
+	diag.Steps = append(diag.Steps, DiagnosisStep{Kind: capi.CertificateRequestKind, Name: req.Name, Status: requestStatus(req)})
+
+	var order *cmacme.Order
+	var orders cmacme.OrderList
+	if err := d.Client.List(ctx, &orders, client.InNamespace(crt.Namespace)); err == nil {
+		for i := range orders.Items {
+			if controlledBy(&orders.Items[i], "CertificateRequest", req.Name) {
+				order = &orders.Items[i]
+				break
+			}
+		}
+	}
+	var challenges []cmacme.Challenge
+	if order != nil {
+		diag.Steps = append(diag.Steps, DiagnosisStep{Kind: "Order", Name: order.Name, Status: orderStatus(order)})
+		var list cmacme.ChallengeList
+		if err := d.Client.List(ctx, &list, client.InNamespace(crt.Namespace)); err == nil {
+			for _, ch := range list.Items {
+				if controlledBy(&ch, "Order", order.Name) {
+					challenges = append(challenges, ch)
+				}
+			}
+		}
+		sort.Slice(challenges, func(i, j int) bool { return challenges[i].Name < challenges[j].Name })
+		for i := range challenges {
+			ch := &challenges[i]
+			diag.Steps = append(diag.Steps, DiagnosisStep{Kind: "Challenge", Name: ch.Name, Status: challengeStatus(ch)})
+		}
+	}
+
+	// The deepest object that explains the failure is the most specific.
+	for i := range challenges {
+		if cause := challengeCause(&challenges[i]); cause != "" {
+			diag.Cause = cause
+			return diag, nil
+		}
+	}
+	switch {
+	case order != nil && orderFailed(order):
+		diag.Cause = classifyCause(fmt.Sprintf("Order %s is %s: %s", order.Name, order.Status.State, order.Status.Reason), "", strings.Join(order.Spec.DNSNames, ", "))
+	case scanner.IsFailedCertificateRequest(req):
+		diag.Cause = classifyCause(fmt.Sprintf("CertificateRequest %s failed: %s", req.Name, requestMessage(req)), "", strings.Join(crt.Spec.DNSNames, ", "))
+	case issuerProblem != "":
+		diag.Cause = issuerProblem
+	}
+	return diag, nil
+}
+
+// controlledBy returns true if obj is controlled by an object of the given
+// kind and name. Owners are matched by name rather than UID, as in
+// FindStuck.
+func controlledBy(obj metav1.Object, kind, name string) bool {
+	owner := metav1.GetControllerOf(obj)
+	return owner != nil && owner.Kind == kind && owner.Name == name
+}
+
+func certificateReady(crt *capi.Certificate) bool {
+	for _, c := range crt.Status.Conditions {
+		if c.Type == capi.CertificateConditionReady {
+			return c.Status == cmmeta.ConditionTrue
+		}
+	}
+	return false
+}
+
+func certificateStatus(crt *capi.Certificate) []string {
+	var status []string
+	for _, c := range crt.Status.Conditions {
+		status = append(status, formatCondition(string(c.Type), c.Status, c.Reason, c.Message, c.LastTransitionTime))
+	}
+	if crt.Status.NotAfter != nil {
+		status = append(status, "current certificate expires at "+formatTime(crt.Status.NotAfter.Time))
+	}
+	if len(status) == 0 {
+		status = append(status, "no conditions")
+	}
+	return status
+}
+
+func requestStatus(req *capi.CertificateRequest) []string {
+	status := []string{"created at " + formatTime(req.CreationTimestamp.Time)}
+	for _, c := range req.Status.Conditions {
+		status = append(status, formatCondition(string(c.Type), c.Status, c.Reason, c.Message, c.LastTransitionTime))
+	}
+	if len(req.Status.Certificate) > 0 {
+		status = append(status, "a certificate has been issued")
+	}
+	return status
+}
+
+func requestMessage(req *capi.CertificateRequest) string {
+	message := "no reason given"
+	for _, c := range req.Status.Conditions {
+		if c.Message != "" {
+			message = c.Message
+		}
+	}
+	return message
+}
+
+func formatCondition(kind string, status cmmeta.ConditionStatus, reason, message string, since *metav1.Time) string {
+	s := fmt.Sprintf("%s=%s", kind, status)
+	if reason != "" {
+		s += " (" + reason + ")"
+	}
+	if since != nil {
+		s += " since " + formatTime(since.Time)
+	}
+	if message != "" {
+		s += ": " + message
+	}
+	return s
+}
+
+func orderFailed(order *cmacme.Order) bool {
+	switch order.Status.State {
+	case cmacme.Invalid, cmacme.Errored, cmacme.Expired:
+		return true
+	}
+	return false
+}
+
+func orderStatus(order *cmacme.Order) []string {
+	state := string(order.Status.State)
+	if state == "" {
+		state = "unknown"
+	}
+	status := []string{"state " + state}
+	if order.Status.Reason != "" {
+		status[0] += ": " + order.Status.Reason
+	}
+	if len(order.Spec.DNSNames) > 0 {
+		status = append(status, "for "+strings.Join(order.Spec.DNSNames, ", "))
+	}
+	if order.Status.URL != "" {
+		status = append(status, "ACME order "+order.Status.URL)
+	}
+	return status
+}
+
+func challengeStatus(ch *cmacme.Challenge) []string {
+	state := string(ch.Status.State)
+	if state == "" {
+		state = "unknown"
+	}
+	status := []string{fmt.Sprintf("%s for %s, state %s, presented %t, processing %t", ch.Spec.Type, ch.Spec.DNSName, state, ch.Status.Presented, ch.Status.Processing)}
+	if ch.Status.Reason != "" {
+		status = append(status, ch.Status.Reason)
+	}
+	return status
+}
+
+// challengeCause returns why ch is blocking its Order, or "" if it is not.
+func challengeCause(ch *cmacme.Challenge) string {
+	if ch.Status.State == cmacme.Valid || ch.Status.Reason == "" {
+		return ""
+	}
+	return classifyCause(fmt.Sprintf("Challenge %s for %s: %s", ch.Name, ch.Spec.DNSName, ch.Status.Reason), ch.Spec.Type, ch.Spec.DNSName)
+}
+
+// classifyCause explains a failure described by message, recognising the
+// common causes of stalled ACME issuances. challengeType is the type of the
+// Challenge that failed, if any, and dnsNames the names being validated.
+func classifyCause(message string, challengeType cmacme.ACMEChallengeType, dnsNames string) string {
+	lower := strings.ToLower(message)
+	containsAny := func(substrings ...string) bool {
+		for _, s := range substrings {
+			if strings.Contains(lower, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case containsAny("caa"):
+		return fmt.Sprintf("bad CAA record: the CAA records of %s do not allow the ACME server to issue certificates. Add a CAA record allowing it, such as 0 issue \"letsencrypt.org\", or remove the records forbidding it (%s)", dnsNames, message)
+	case challengeType == cmacme.ACMEChallengeTypeDNS01 && containsAny("propagat", "dns record", "txt record", "nxdomain", "no txt", "waiting for"):
+		return fmt.Sprintf("DNS propagation: the DNS-01 TXT record for %s is not yet visible. Check the credentials of the solver's DNS provider, and that _acme-challenge.%s resolves from the nameservers cert-manager uses (%s)", dnsNames, dnsNames, message)
+	case challengeType == cmacme.ACMEChallengeTypeHTTP01 && containsAny("self check", "wrong status code", "connection", "timeout", "no such host", "404"):
+		return fmt.Sprintf("HTTP-01 routing: http://%s/.well-known/acme-challenge/ does not reach the solver. Check that the Ingress created by the solver uses the right ingress class, and that port 80 is routed to it from the internet (%s)", dnsNames, message)
+	}
+	return message
+}
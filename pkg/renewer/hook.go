@@ -0,0 +1,77 @@
+package renewer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+// CodeHookFailed is the code of errors returned by Hook.
+const CodeHookFailed scanner.Code = "HookFailed"
+
+// ErrHookFailed is a sentinel error for use with errors.Is.
+var ErrHookFailed = &scanner.Error{Code: CodeHookFailed}
+
+// Hook is a command run before or after a Certificate is remediated, for
+// example to take the servers using it out of a load balancer pool while it
+// is replaced. Like ExecRemediator, it is given a RemediationRequest as JSON
+// on stdin, and the same details are set in its environment:
+//
+//	LECAA_NAMESPACE, LECAA_CERTIFICATE, LECAA_SECRET_NAME,
+//	LECAA_ISSUER_NAME, LECAA_ISSUER_KIND, LECAA_COMMON_NAME,
+//	LECAA_DNS_NAMES (comma separated) and LECAA_RUN_ID
+//
+// along with any variables passed to Run.
+type Hook struct {
+	Command string
+	Args    []string
+	// Timeout is how long the command may run for each Certificate. It
+	// defaults to one minute.
+	Timeout time.Duration
+	// RunID, if set, is included in each RemediationRequest.
+	RunID string
+}
+
+// Run runs the hook for crt with the environment variables in env, given as
+// KEY=value, in addition to those describing crt. It returns an error with
+// CodeHookFailed if the command does not exit successfully.
+func (h *Hook) Run(ctx context.Context, crt capi.Certificate, env ...string) error {
+	req := newRemediationRequest(crt, h.RunID)
+	input, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"LECAA_NAMESPACE="+req.Namespace,
+		"LECAA_CERTIFICATE="+req.Name,
+		"LECAA_SECRET_NAME="+req.SecretName,
+		"LECAA_ISSUER_NAME="+req.IssuerName,
+		"LECAA_ISSUER_KIND="+req.IssuerKind,
+		"LECAA_COMMON_NAME="+req.CommonName,
+		"LECAA_DNS_NAMES="+strings.Join(req.DNSNames, ","),
+		"LECAA_RUN_ID="+req.RunID,
+	)
+	cmd.Env = append(cmd.Env, env...)
+	if err := cmd.Run(); err != nil {
+		return &scanner.Error{Code: CodeHookFailed, Err: fmt.Errorf("error running hook %q: %w: %s", h.Command, err, strings.TrimSpace(stderr.String()))}
+	}
+	return nil
+}
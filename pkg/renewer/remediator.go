@@ -0,0 +1,148 @@
+package renewer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+// Remediator fixes an affected Certificate. Renewer, which triggers a renewal
+// by cert-manager, is the default, but other implementations can be used for
+// Certificates that cert-manager cannot renew, for example by calling an
+// internal certificate service or opening a ticket. Remediate may be called
+// more than once for the same Certificate, so it should be idempotent.
+type Remediator interface {
+	Remediate(ctx context.Context, crt capi.Certificate) error
+}
+
+// CodeRemediationFailed is the code of errors returned by ExecRemediator and
+// WebhookRemediator.
+const CodeRemediationFailed scanner.Code = "RemediationFailed"
+
+// ErrRemediationFailed is a sentinel error for use with errors.Is.
+var ErrRemediationFailed = &scanner.Error{Code: CodeRemediationFailed}
+
+// RemediatorFunc adapts a function to a Remediator.
+type RemediatorFunc func(ctx context.Context, crt capi.Certificate) error
+
+// Remediate implements Remediator.
+func (f RemediatorFunc) Remediate(ctx context.Context, crt capi.Certificate) error {
+	return f(ctx, crt)
+}
+
+// Remediate implements Remediator by calling Renew.
+func (r *Renewer) Remediate(ctx context.Context, crt capi.Certificate) error {
+	return r.Renew(ctx, crt)
+}
+
+// RemediationRequest describes an affected Certificate to an ExecRemediator
+// command or a WebhookRemediator.
+type RemediationRequest struct {
+	Namespace  string   `json:"namespace"`
+	Name       string   `json:"name"`
+	SecretName string   `json:"secretName"`
+	IssuerName string   `json:"issuerName"`
+	IssuerKind string   `json:"issuerKind,omitempty"`
+	CommonName string   `json:"commonName,omitempty"`
+	DNSNames   []string `json:"dnsNames,omitempty"`
+	// RunID identifies the run of the tool making the request, if set.
+	RunID string `json:"runId,omitempty"`
+}
+
+func newRemediationRequest(crt capi.Certificate, runID string) RemediationRequest {
+	return RemediationRequest{
+		Namespace:  crt.Namespace,
+		Name:       crt.Name,
+		SecretName: crt.Spec.SecretName,
+		IssuerName: crt.Spec.IssuerRef.Name,
+		IssuerKind: crt.Spec.IssuerRef.Kind,
+		CommonName: crt.Spec.CommonName,
+		DNSNames:   crt.Spec.DNSNames,
+		RunID:      runID,
+	}
+}
+
+// ExecRemediator is a Remediator that runs an external command for each
+// Certificate. The command is given a RemediationRequest as JSON on stdin,
+// and must exit successfully once the Certificate has been remediated.
+type ExecRemediator struct {
+	Command string
+	Args    []string
+	// Timeout is how long the command may run for each Certificate. It
+	// defaults to one minute.
+	Timeout time.Duration
+	// RunID, if set, is included in each RemediationRequest.
+	RunID string
+}
+
+// Remediate implements Remediator.
+func (e *ExecRemediator) Remediate(ctx context.Context, crt capi.Certificate) error {
+	input, err := json.Marshal(newRemediationRequest(crt, e.RunID))
+	if err != nil {
+		return err
+	}
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return &scanner.Error{Code: CodeRemediationFailed, Err: fmt.Errorf("error running remediation command %q: %w: %s", e.Command, err, strings.TrimSpace(stderr.String()))}
+	}
+	return nil
+}
+
+// WebhookRemediator is a Remediator that POSTs a RemediationRequest as JSON
+// to URL for each Certificate, treating any 2xx response as success.
+type WebhookRemediator struct {
+	URL string
+	// Headers are added to each request, for example to authenticate it.
+	Headers map[string]string
+	// Client is used to send requests. If nil, a client with a 30 second
+	// timeout is used.
+	Client *http.Client
+	// RunID, if set, is included in each RemediationRequest.
+	RunID string
+}
+
+// Remediate implements Remediator.
+func (w *WebhookRemediator) Remediate(ctx context.Context, crt capi.Certificate) error {
+	body, err := json.Marshal(newRemediationRequest(crt, w.RunID))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	cl := w.Client
+	if cl == nil {
+		cl = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := cl.Do(req.WithContext(ctx))
+	if err != nil {
+		return &scanner.Error{Code: CodeRemediationFailed, Err: fmt.Errorf("error calling remediation webhook: %w", err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &scanner.Error{Code: CodeRemediationFailed, Err: fmt.Errorf("remediation webhook returned unexpected status %s", resp.Status)}
+	}
+	return nil
+}
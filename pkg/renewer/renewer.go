@@ -0,0 +1,507 @@
+// Package renewer triggers the renewal of cert-manager Certificates.
+package renewer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RenewalAnnotationValue is the value the issuer name annotation of a Secret
+// is set to in order to trigger a renewal.
+const RenewalAnnotationValue = "force-renewal-triggered"
+
+// RevisionAnnotationKey is set by cert-manager v0.15 and later on each
+// CertificateRequest, to the revision of the Certificate it was created for.
+const RevisionAnnotationKey = "cert-manager.io/certificate-revision"
+
+// TriggeredAtAnnotationKey is the annotation recording when a renewal was
+// last triggered on a Secret, in RFC 3339 format, so that renewals that
+// cert-manager never completes can be found (see FindStuck).
+const TriggeredAtAnnotationKey = "lecaa.jetstack.io/renewal-triggered-at"
+
+// Codes of the failures that can cause a renewal to fail, in addition to
+// scanner.CodeSecretMissing and scanner.CodeSecretFetchFailed.
+const (
+	CodeListRequestsFailed  scanner.Code = "ListCertificateRequestsFailed"
+	CodeDeleteRequestFailed scanner.Code = "DeleteCertificateRequestFailed"
+	CodeSecretUpdateFailed  scanner.Code = "SecretUpdateFailed"
+	CodeAuditFailed         scanner.Code = "AuditFailed"
+	CodeRenewalTimeout      scanner.Code = "RenewalTimeout"
+	CodeMutationReverted    scanner.Code = "MutationReverted"
+	CodeIssuanceFailed      scanner.Code = "IssuanceFailed"
+)
+
+// Sentinel errors for use with errors.Is.
+var (
+	ErrListRequestsFailed  = &scanner.Error{Code: CodeListRequestsFailed}
+	ErrDeleteRequestFailed = &scanner.Error{Code: CodeDeleteRequestFailed}
+	ErrSecretUpdateFailed  = &scanner.Error{Code: CodeSecretUpdateFailed}
+	ErrAuditFailed         = &scanner.Error{Code: CodeAuditFailed}
+	ErrRenewalTimeout      = &scanner.Error{Code: CodeRenewalTimeout}
+	ErrMutationReverted    = &scanner.Error{Code: CodeMutationReverted}
+	ErrIssuanceFailed      = &scanner.Error{Code: CodeIssuanceFailed}
+)
+
+// AuditFunc is called after each change made to the cluster, with the action
+// taken, the kind, namespace and name of the object changed, the change made
+// as a JSON merge patch (nil for deletions), why it was made and the result.
+// If it returns an error, the renewal making the change fails.
+type AuditFunc func(action, kind, namespace, name string, patch interface{}, reason string, err error) error
+
+// Renewer triggers renewals of Certificates. Only Client is required.
+type Renewer struct {
+	Client client.Client
+
+	// CleanupFailedRequests causes failed or denied CertificateRequests owned
+	// by a Certificate to be deleted before its renewal is triggered.
+	CleanupFailedRequests bool
+	// WaitTimeout is how long to wait for cert-manager to create a new
+	// CertificateRequest after a renewal has been triggered. It defaults to
+	// one minute.
+	WaitTimeout time.Duration
+
+	// Tracer, if set, is used to trace renewals.
+	Tracer scanner.Tracer
+	// Audit, if set, is called after each change made to the cluster.
+	Audit AuditFunc
+	// Events, if set, is told when renewals are triggered and complete.
+	Events scanner.Events
+	// Annotations, if set, are added to each Secret along with the
+	// annotation triggering its renewal, for example to record which run
+	// triggered it.
+	Annotations map[string]string
+}
+
+// Renew triggers a renewal of cert by annotating its Secret, and waits for
+// cert-manager to create a CertificateRequest for it. While waiting, the
+// Secret is read back to check that the annotation has not been reverted by
+// another controller, such as a Secret sync tool. It is safe to call
+// repeatedly, as a renewal is not triggered while one is already in progress.
+// Nothing is done for Certificates or Secrets that are being deleted.
+// Errors returned are *scanner.Errors, whose Code describes what failed.
+func (r *Renewer) Renew(ctx context.Context, cert capi.Certificate) (err error) {
+	ctx, sp := scanner.StartSpan(ctx, r.Tracer, "renew-certificate")
+	sp.SetAttribute("namespace", cert.Namespace)
+	sp.SetAttribute("certificate", cert.Name)
+	defer func() { sp.Finish(err) }()
+	if r.Events != nil {
+		defer func() { r.Events.OnRenewalComplete(cert, err) }()
+	}
+	// Updating the Secret of a Certificate that is being deleted, for example
+	// during namespace teardown, would fail or recreate resources that are
+	// being garbage collected.
+	if cert.DeletionTimestamp != nil {
+		log.Printf("Certificate %s/%s is being deleted - skipping triggering a renewal...", cert.Namespace, cert.Name)
+		return nil
+	}
+
+	var requests capi.CertificateRequestList
+	_, listSpan := scanner.StartSpan(ctx, r.Tracer, "api.list-certificate-requests")
+	err = r.Client.List(ctx, &requests, client.InNamespace(cert.Namespace))
+	listSpan.Finish(err)
+	if err != nil {
+		return &scanner.Error{Code: CodeListRequestsFailed, Err: err}
+	}
+	latest := latestRevision(requests.Items, &cert)
+	// kept are the requests for the current revision, which are not deleted
+	// and so must not be mistaken for the request for the renewal.
+	kept := make(map[string]bool)
+	for _, req := range requests.Items {
+		// If any existing CertificateRequest resources exist and are complete,
+		// we delete them to avoid a re-issuance of the same certificate.
+		if !metav1.IsControlledBy(&req, &cert) {
+			continue
+		}
+
+		// Failed or denied requests from earlier attempts may block a new
+		// issuance on some versions of cert-manager, so remove them first.
+		if r.CleanupFailedRequests && IsFailedCertificateRequest(&req) {
+			if err := r.deleteCertificateRequest(ctx, &req); err != nil {
+				log.Printf("Failed to delete failed CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
+				return err
+			}
+			log.Printf("Deleted failed CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
+			continue
+		}
+
+		// This indicates an issuance is currently in progress
+		if len(req.Status.Certificate) == 0 {
+			log.Printf("Found existing CertificateRequest %s/%s for Certificate - skipping triggering a renewal...", req.Namespace, req.Name)
+			return nil
+		}
+
+		if isCurrentRevision(&req, latest) {
+			log.Printf("Keeping CertificateRequest %s/%s for the current revision (%d) of Certificate", req.Namespace, req.Name, latest)
+			kept[req.Name] = true
+			continue
+		}
+		if err := r.deleteCertificateRequest(ctx, &req); err != nil {
+			log.Printf("Failed to delete old CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
+			return err
+		}
+
+		log.Printf("Deleted old CertificateRequest %s/%s for Certificate", req.Namespace, req.Name)
+	}
+
+	// Fetch an up to date copy of the Secret resource for this Certificate
+	var secret core.Secret
+	_, getSpan := scanner.StartSpan(ctx, r.Tracer, "api.get-secret")
+	err = r.Client.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.Spec.SecretName}, &secret)
+	getSpan.Finish(err)
+	if err != nil {
+		log.Printf("Failed to retrieve up-to-date copy of existing Secret resource for Certificate: %v", err)
+		if apierrors.IsNotFound(err) {
+			return &scanner.Error{Code: scanner.CodeSecretMissing, Err: err}
+		}
+		return &scanner.Error{Code: scanner.CodeSecretFetchFailed, Err: err}
+	}
+	if secret.DeletionTimestamp != nil {
+		log.Printf("Secret %s/%s is being deleted - skipping triggering a renewal...", secret.Namespace, secret.Name)
+		return nil
+	}
+
+	// Manually override/set the IssuerNameAnnotationKey - this will cause cert-manager
+	// to assume that we have changed the 'issuerRef' specified on the Certificate and
+	// trigger a one-time renewal.
+	annotations := r.annotate(&secret)
+	_, updateSpan := scanner.StartSpan(ctx, r.Tracer, "api.update-secret")
+	err = r.Client.Update(ctx, &secret)
+	updateSpan.Finish(err)
+	patch := map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}}
+	if auditErr := r.audit("update", "Secret", secret.Namespace, secret.Name, patch, "trigger renewal of Certificate "+cert.Name, err); auditErr != nil {
+		return auditErr
+	}
+	if err != nil {
+		log.Printf("Failed to update Secret resource for Certificate: %v", err)
+		return &scanner.Error{Code: CodeSecretUpdateFailed, Err: err}
+	}
+	// A mutating admission webhook may have dropped the annotation from the
+	// update, which the Secret returned by the API server reflects.
+	if secret.Annotations[capi.IssuerNameAnnotationKey] != RenewalAnnotationValue {
+		err := &scanner.Error{Code: CodeMutationReverted, Err: fmt.Errorf("mutation reverted by another controller: the %s annotation of Secret %s/%s was not persisted by the update",
+			capi.IssuerNameAnnotationKey, secret.Namespace, secret.Name)}
+		log.Printf("Renewal of Certificate was undone: %v", err)
+		return err
+	}
+
+	if r.Events != nil {
+		r.Events.OnRenewalTriggered(cert)
+	}
+	log.Printf("Triggered renewal of Certificate - waiting for new CertificateRequest resource to be created...")
+	// Wait for a CertificateRequest resource to be created
+	timeout := r.WaitTimeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	_, waitSpan := scanner.StartSpan(ctx, r.Tracer, "wait-for-certificate-request")
+	err = wait.Poll(time.Second, timeout, func() (bool, error) {
+		var requests capi.CertificateRequestList
+		if err := r.Client.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
+			return false, err
+		}
+		// Wait for a new CertificateRequest owned by this Certificate to exist
+		for _, req := range requests.Items {
+			if metav1.IsControlledBy(&req, &cert) && !kept[req.Name] {
+				log.Printf("CertificateRequest %s/%s found, renewal in progress!", req.Namespace, req.Name)
+				return true, nil
+			}
+		}
+		// cert-manager only changes the annotation back once it has created
+		// a CertificateRequest, so anything else changing it will have
+		// undone the renewal.
+		return false, r.checkAnnotation(ctx, &secret)
+	})
+	waitSpan.Finish(err)
+	if err == wait.ErrWaitTimeout {
+		log.Printf("Timed out waiting for new CertificateRequest to be created")
+		return &scanner.Error{Code: CodeRenewalTimeout, Err: fmt.Errorf("timed out after %s waiting for cert-manager to create a CertificateRequest", timeout)}
+	}
+	if scanner.ErrorCode(err) == CodeMutationReverted {
+		log.Printf("Renewal of Certificate was undone: %v", err)
+		return err
+	}
+	if err != nil {
+		log.Printf("Failed to wait for new CertificateRequest to be created: %v", err)
+		return &scanner.Error{Code: CodeListRequestsFailed, Err: err}
+	}
+	return nil
+}
+
+// annotate sets the annotations triggering a renewal on secret, returning
+// them.
+func (r *Renewer) annotate(secret *core.Secret) map[string]string {
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	annotations := map[string]string{
+		capi.IssuerNameAnnotationKey: RenewalAnnotationValue,
+		TriggeredAtAnnotationKey:     time.Now().UTC().Format(time.RFC3339),
+	}
+	for k, v := range r.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range annotations {
+		secret.Annotations[k] = v
+	}
+	return annotations
+}
+
+// DryRun submits the changes Renew would make to trigger a renewal of cert,
+// deleting its old CertificateRequests and updating its Secret, with
+// server-side dry-run, so that changes the API server or an admission
+// webhook would reject are found without changing anything. Nothing is
+// submitted if a renewal would not be triggered, because cert or its Secret
+// is being deleted, or an issuance is already in progress. Errors returned
+// are *scanner.Errors with the same Codes as those of Renew.
+func (r *Renewer) DryRun(ctx context.Context, cert capi.Certificate) (err error) {
+	ctx, sp := scanner.StartSpan(ctx, r.Tracer, "dry-run-renewal")
+	sp.SetAttribute("namespace", cert.Namespace)
+	sp.SetAttribute("certificate", cert.Name)
+	defer func() { sp.Finish(err) }()
+	if cert.DeletionTimestamp != nil {
+		return nil
+	}
+	var requests capi.CertificateRequestList
+	if err := r.Client.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
+		return &scanner.Error{Code: CodeListRequestsFailed, Err: err}
+	}
+	var deletions []*capi.CertificateRequest
+	latest := latestRevision(requests.Items, &cert)
+	for i := range requests.Items {
+		req := &requests.Items[i]
+		if !metav1.IsControlledBy(req, &cert) {
+			continue
+		}
+		if r.CleanupFailedRequests && IsFailedCertificateRequest(req) {
+			deletions = append(deletions, req)
+			continue
+		}
+		if len(req.Status.Certificate) == 0 {
+			return nil
+		}
+		if !isCurrentRevision(req, latest) {
+			deletions = append(deletions, req)
+		}
+	}
+	for _, req := range deletions {
+		if err := r.Client.Delete(ctx, req, client.DryRunAll); err != nil {
+			return &scanner.Error{Code: CodeDeleteRequestFailed, Err: fmt.Errorf("deleting CertificateRequest %s/%s would fail: %w", req.Namespace, req.Name, err)}
+		}
+	}
+
+	var secret core.Secret
+	err = r.Client.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.Spec.SecretName}, &secret)
+	if apierrors.IsNotFound(err) {
+		return &scanner.Error{Code: scanner.CodeSecretMissing, Err: err}
+	}
+	if err != nil {
+		return &scanner.Error{Code: scanner.CodeSecretFetchFailed, Err: err}
+	}
+	if secret.DeletionTimestamp != nil {
+		return nil
+	}
+	r.annotate(&secret)
+	if err := r.Client.Update(ctx, &secret, client.DryRunAll); err != nil {
+		return &scanner.Error{Code: CodeSecretUpdateFailed, Err: fmt.Errorf("updating Secret %s/%s would fail: %w", secret.Namespace, secret.Name, err)}
+	}
+	// The Secret returned by a dry-run has been through the mutating
+	// admission webhooks, just as a real update would.
+	if secret.Annotations[capi.IssuerNameAnnotationKey] != RenewalAnnotationValue {
+		return &scanner.Error{Code: CodeMutationReverted, Err: fmt.Errorf("mutation would be reverted: the %s annotation of Secret %s/%s would not be persisted by the update",
+			capi.IssuerNameAnnotationKey, secret.Namespace, secret.Name)}
+	}
+	return nil
+}
+
+// WaitForIssuance waits for cert-manager to store a new certificate in the
+// Secret of cert, other than the one with the hexadecimal serial number
+// previous, and returns the Secret. A CertificateRequest owned by cert that
+// was created since the given time and fails returns an error with
+// CodeIssuanceFailed, and giving up after timeout one with
+// CodeRenewalTimeout.
+func (r *Renewer) WaitForIssuance(ctx context.Context, cert capi.Certificate, previous string, since time.Time, timeout time.Duration) (*core.Secret, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var secret *core.Secret
+	err := wait.PollImmediateUntil(5*time.Second, func() (bool, error) {
+		var err error
+		secret, err = r.Issued(ctx, cert, previous, since)
+		return secret != nil, err
+	}, waitCtx.Done())
+	if err == wait.ErrWaitTimeout {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &scanner.Error{Code: CodeRenewalTimeout, Err: fmt.Errorf("timed out after %s waiting for cert-manager to issue a new certificate", timeout)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Issued returns the Secret of cert if cert-manager has stored a certificate
+// in it other than the one with the hexadecimal serial number previous, or
+// nil if it has not yet. A CertificateRequest owned by cert that was created
+// since the given time and failed returns an error with CodeIssuanceFailed.
+// Errors reading the cluster are logged and returned as nil, so that the
+// caller checks again.
+func (r *Renewer) Issued(ctx context.Context, cert capi.Certificate, previous string, since time.Time) (*core.Secret, error) {
+	// CreationTimestamp only has a precision of a second.
+	since = since.Truncate(time.Second)
+	var requests capi.CertificateRequestList
+	if err := r.Client.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
+		log.Printf("Unable to list CertificateRequests for Certificate %s/%s, retrying: %v", cert.Namespace, cert.Name, err)
+		return nil, nil
+	}
+	for i := range requests.Items {
+		req := &requests.Items[i]
+		if !metav1.IsControlledBy(req, &cert) || req.CreationTimestamp.Time.Before(since) || !IsFailedCertificateRequest(req) {
+			continue
+		}
+		reason := "it failed"
+		for _, c := range req.Status.Conditions {
+			if c.Message != "" {
+				reason = c.Message
+				break
+			}
+		}
+		return nil, &scanner.Error{Code: CodeIssuanceFailed, Err: fmt.Errorf("CertificateRequest %s/%s failed: %s", req.Namespace, req.Name, reason)}
+	}
+	var secret core.Secret
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: cert.Namespace, Name: cert.Spec.SecretName}, &secret); err != nil {
+		return nil, nil
+	}
+	issued, err := scanner.DecodeCertificate(&secret)
+	if err != nil || scanner.IsTemporaryCertificate(issued) || fmt.Sprintf("%x", issued.SerialNumber) == previous {
+		return nil, nil
+	}
+	return &secret, nil
+}
+
+// checkAnnotation reads secret back from the API server and returns an error
+// with CodeMutationReverted if the annotation triggering a renewal is no
+// longer set. Errors reading the Secret are ignored, as the renewal may still
+// go ahead.
+func (r *Renewer) checkAnnotation(ctx context.Context, secret *core.Secret) error {
+	var latest core.Secret
+	_, sp := scanner.StartSpan(ctx, r.Tracer, "api.get-secret")
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: secret.Namespace, Name: secret.Name}, &latest)
+	sp.Finish(err)
+	if err != nil {
+		return nil
+	}
+	if value, ok := latest.Annotations[capi.IssuerNameAnnotationKey]; !ok || value != RenewalAnnotationValue {
+		if !ok {
+			value = "(removed)"
+		}
+		return &scanner.Error{Code: CodeMutationReverted, Err: fmt.Errorf("mutation reverted by another controller: the %s annotation of Secret %s/%s was changed from %q to %q before cert-manager acted on it",
+			capi.IssuerNameAnnotationKey, secret.Namespace, secret.Name, RenewalAnnotationValue, value)}
+	}
+	return nil
+}
+
+func (r *Renewer) deleteCertificateRequest(ctx context.Context, req *capi.CertificateRequest) error {
+	_, sp := scanner.StartSpan(ctx, r.Tracer, "api.delete-certificate-request")
+	sp.SetAttribute("certificaterequest", req.Name)
+	err := r.Client.Delete(ctx, req)
+	sp.Finish(err)
+	reason := "renewal"
+	if owner := metav1.GetControllerOf(req); owner != nil {
+		reason = "renewal of Certificate " + owner.Name
+	}
+	if IsFailedCertificateRequest(req) {
+		reason = "clean up failed request before " + reason
+	} else {
+		reason = "clean up old request before " + reason
+	}
+	if auditErr := r.audit("delete", "CertificateRequest", req.Namespace, req.Name, nil, reason, err); auditErr != nil {
+		return auditErr
+	}
+	if err != nil {
+		return &scanner.Error{Code: CodeDeleteRequestFailed, Err: err}
+	}
+	return nil
+}
+
+func (r *Renewer) audit(action, kind, namespace, name string, patch interface{}, reason string, err error) error {
+	if r.Audit == nil {
+		return nil
+	}
+	if auditErr := r.Audit(action, kind, namespace, name, patch, reason, err); auditErr != nil {
+		return &scanner.Error{Code: CodeAuditFailed, Err: auditErr}
+	}
+	return nil
+}
+
+// FailedCertificateRequests returns the failed or denied CertificateRequests
+// owned by cert, which would be deleted before a renewal if
+// CleanupFailedRequests is set.
+func (r *Renewer) FailedCertificateRequests(ctx context.Context, cert capi.Certificate) ([]capi.CertificateRequest, error) {
+	var requests capi.CertificateRequestList
+	if err := r.Client.List(ctx, &requests, client.InNamespace(cert.Namespace)); err != nil {
+		return nil, err
+	}
+	var failed []capi.CertificateRequest
+	for _, req := range requests.Items {
+		if metav1.IsControlledBy(&req, &cert) && IsFailedCertificateRequest(&req) {
+			failed = append(failed, req)
+		}
+	}
+	return failed, nil
+}
+
+// requestRevision returns the revision of the Certificate that req was
+// created for, from RevisionAnnotationKey, or 0 if it is not annotated, as
+// with versions of cert-manager before v0.15.
+func requestRevision(req *capi.CertificateRequest) int {
+	revision, err := strconv.Atoi(req.Annotations[RevisionAnnotationKey])
+	if err != nil || revision < 1 {
+		return 0
+	}
+	return revision
+}
+
+// latestRevision returns the highest revision of the CertificateRequests in
+// requests owned by cert, or 0 if none is annotated with one. The
+// status.revision of the Certificate is not part of the API this is built
+// against, so the latest request stands in for it: once complete, it is for
+// the current revision, and while in progress, for the next one.
+func latestRevision(requests []capi.CertificateRequest, cert *capi.Certificate) int {
+	latest := 0
+	for i := range requests {
+		if revision := requestRevision(&requests[i]); revision > latest && metav1.IsControlledBy(&requests[i], cert) {
+			latest = revision
+		}
+	}
+	return latest
+}
+
+// isCurrentRevision returns true if req is for the latest revision of its
+// Certificate. Like cert-manager's own garbage collection, only requests for
+// older revisions are deleted, as versions of cert-manager that annotate
+// requests with a revision never reuse one for an older revision, which is
+// what deleting complete requests guards against on older versions.
+// Unannotated requests are always older than annotated ones, as they were
+// created before cert-manager was upgraded.
+func isCurrentRevision(req *capi.CertificateRequest, latest int) bool {
+	return latest > 0 && requestRevision(req) == latest
+}
+
+// IsFailedCertificateRequest returns true if the given CertificateRequest has
+// failed, been marked as invalid or been denied. It is the same as
+// scanner.IsFailedCertificateRequest.
+func IsFailedCertificateRequest(req *capi.CertificateRequest) bool {
+	return scanner.IsFailedCertificateRequest(req)
+}
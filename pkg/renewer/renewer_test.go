@@ -0,0 +1,222 @@
+package renewer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jetstack/cert-manager/pkg/api"
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testCertificate = &capi.Certificate{
+	ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "example", UID: types.UID("uid-example")},
+	Spec:       capi.CertificateSpec{SecretName: "example-tls"},
+}
+
+// testRequest returns a complete CertificateRequest for the given revision
+// of testCertificate, or an unannotated one if revision is empty. It is
+// owned by owner, unless that is nil.
+func testRequest(name, revision string, owner *capi.Certificate) *capi.CertificateRequest {
+	req := &capi.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: name},
+		Status:     capi.CertificateRequestStatus{Certificate: []byte("issued")},
+	}
+	if revision != "" {
+		req.Annotations = map[string]string{RevisionAnnotationKey: revision}
+	}
+	if owner != nil {
+		req.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, capi.SchemeGroupVersion.WithKind("Certificate"))}
+	}
+	return req
+}
+
+func TestLatestRevision(t *testing.T) {
+	other := testCertificate.DeepCopy()
+	other.UID = "uid-other"
+	tests := []struct {
+		name     string
+		requests []*capi.CertificateRequest
+		want     int
+	}{
+		{
+			name: "no requests",
+		},
+		{
+			name:     "unannotated requests",
+			requests: []*capi.CertificateRequest{testRequest("example-1", "", testCertificate)},
+		},
+		{
+			name: "highest revision",
+			requests: []*capi.CertificateRequest{
+				testRequest("example-2", "2", testCertificate),
+				testRequest("example-3", "3", testCertificate),
+				testRequest("example-1", "1", testCertificate),
+			},
+			want: 3,
+		},
+		{
+			name: "invalid revisions ignored",
+			requests: []*capi.CertificateRequest{
+				testRequest("example-1", "1", testCertificate),
+				testRequest("example-x", "x", testCertificate),
+				testRequest("example-0", "-4", testCertificate),
+			},
+			want: 1,
+		},
+		{
+			name: "requests of other Certificates ignored",
+			requests: []*capi.CertificateRequest{
+				testRequest("example-1", "1", testCertificate),
+				testRequest("other-5", "5", other),
+				testRequest("orphan-6", "6", nil),
+			},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []capi.CertificateRequest
+			for _, req := range tt.requests {
+				requests = append(requests, *req)
+			}
+			if got := latestRevision(requests, testCertificate); got != tt.want {
+				t.Errorf("latestRevision() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCurrentRevision(t *testing.T) {
+	tests := []struct {
+		revision string
+		latest   int
+		want     bool
+	}{
+		{revision: "3", latest: 3, want: true},
+		{revision: "2", latest: 3},
+		{revision: "", latest: 3},
+		// Without annotated requests, there is no current revision to keep.
+		{revision: "", latest: 0},
+		{revision: "x", latest: 0},
+	}
+	for _, tt := range tests {
+		if got := isCurrentRevision(testRequest("example", tt.revision, testCertificate), tt.latest); got != tt.want {
+			t.Errorf("isCurrentRevision(revision %q, latest %d) = %t, want %t", tt.revision, tt.latest, got, tt.want)
+		}
+	}
+}
+
+// revertingClient is a Client whose updates of Secrets have their renewal
+// annotation dropped, as by a mutating admission webhook, or reverted just
+// afterwards, as by a Secret sync tool.
+type revertingClient struct {
+	client.Client
+	// afterUpdate reverts the annotation once the update has been made,
+	// rather than dropping it from the update.
+	afterUpdate bool
+}
+
+func (c *revertingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	secret, ok := obj.(*core.Secret)
+	if !ok {
+		return c.Client.Update(ctx, obj, opts...)
+	}
+	if !c.afterUpdate {
+		delete(secret.Annotations, capi.IssuerNameAnnotationKey)
+		return c.Client.Update(ctx, secret, opts...)
+	}
+	if err := c.Client.Update(ctx, secret, opts...); err != nil {
+		return err
+	}
+	reverted := secret.DeepCopy()
+	reverted.Annotations[capi.IssuerNameAnnotationKey] = "letsencrypt"
+	return c.Client.Update(ctx, reverted, opts...)
+}
+
+func TestRenew(t *testing.T) {
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "example-tls", Annotations: map[string]string{capi.IssuerNameAnnotationKey: "letsencrypt"}},
+	}
+	tests := []struct {
+		name string
+		// client wraps the fake client, if set.
+		client   func(client.Client) client.Client
+		wantCode scanner.Code
+		// wantRequests are the CertificateRequests left after the renewal.
+		wantRequests []string
+		// wantTriggered is whether the renewal annotation should be left on
+		// the Secret.
+		wantTriggered bool
+	}{
+		{
+			// No new request is created, as cert-manager is not running, so
+			// the renewal times out rather than mistaking the kept request
+			// for the current revision for a new one.
+			name:          "request for the current revision kept",
+			wantCode:      CodeRenewalTimeout,
+			wantRequests:  []string{"example-2"},
+			wantTriggered: true,
+		},
+		{
+			name:         "annotation dropped from the update",
+			client:       func(cl client.Client) client.Client { return &revertingClient{Client: cl} },
+			wantCode:     CodeMutationReverted,
+			wantRequests: []string{"example-2"},
+		},
+		{
+			name:         "annotation reverted after the update",
+			client:       func(cl client.Client) client.Client { return &revertingClient{Client: cl, afterUpdate: true} },
+			wantCode:     CodeMutationReverted,
+			wantRequests: []string{"example-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewFakeClientWithScheme(api.Scheme,
+				testCertificate.DeepCopy(),
+				secret.DeepCopy(),
+				testRequest("example-1", "1", testCertificate),
+				testRequest("example-2", "2", testCertificate),
+			)
+			r := &Renewer{Client: cl, WaitTimeout: 1500 * time.Millisecond}
+			if tt.client != nil {
+				r.Client = tt.client(cl)
+			}
+			err := r.Renew(context.Background(), *testCertificate)
+			if code := scanner.ErrorCode(err); code != tt.wantCode {
+				t.Errorf("Renew() error = %v, want code %s", err, tt.wantCode)
+			}
+
+			for _, name := range []string{"example-1", "example-2"} {
+				var req capi.CertificateRequest
+				err := cl.Get(context.Background(), client.ObjectKey{Namespace: "web", Name: name}, &req)
+				want := false
+				for _, w := range tt.wantRequests {
+					want = want || w == name
+				}
+				switch {
+				case want && err != nil:
+					t.Errorf("CertificateRequest %s was not kept: %v", name, err)
+				case !want && !apierrors.IsNotFound(err):
+					t.Errorf("CertificateRequest %s was not deleted: %v", name, err)
+				}
+			}
+			var got core.Secret
+			if err := cl.Get(context.Background(), client.ObjectKey{Namespace: "web", Name: "example-tls"}, &got); err != nil {
+				t.Fatal(err)
+			}
+			if triggered := got.Annotations[capi.IssuerNameAnnotationKey] == RenewalAnnotationValue; triggered != tt.wantTriggered {
+				t.Errorf("Secret annotated with %s=%q, want triggered = %t", capi.IssuerNameAnnotationKey, got.Annotations[capi.IssuerNameAnnotationKey], tt.wantTriggered)
+			}
+		})
+	}
+}
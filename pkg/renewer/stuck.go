@@ -0,0 +1,196 @@
+package renewer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha2"
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StuckIssuance is an issuance that has made no progress for longer than
+// expected, found by FindStuck.
+type StuckIssuance struct {
+	Namespace string
+	// Certificate is the name of the Certificate being issued, or "" if
+	// Requests are orphaned, their Certificate having been deleted.
+	Certificate string
+	// Reasons explain why the issuance is stuck.
+	Reasons []string
+	// Requests are the CertificateRequests to delete so that cert-manager
+	// starts the issuance again. If there are none, the issuance cannot be
+	// reset, and Reasons must be investigated instead.
+	Requests []capi.CertificateRequest
+}
+
+// FindStuck returns the issuances in namespace that have been stuck for
+// longer than after, as of now: Certificates whose Issuing condition has
+// been True, or Ready condition False, since before then, CertificateRequests
+// pending since before then or whose ACME Order has failed, renewals
+// triggered by Renew that cert-manager has not completed, and
+// CertificateRequests whose Certificate has been deleted. The Order CRD may
+// not be installed if no ACME issuer is used, in which case Orders are not
+// checked.
+func FindStuck(ctx context.Context, cl client.Reader, namespace string, now time.Time, after time.Duration) ([]StuckIssuance, error) {
+	cutoff := now.Add(-after)
+	var certs capi.CertificateList
+	if err := cl.List(ctx, &certs, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing Certificate resources: %w", err)
+	}
+	var requests capi.CertificateRequestList
+	if err := cl.List(ctx, &requests, client.InNamespace(namespace)); err != nil {
+		return nil, &scanner.Error{Code: CodeListRequestsFailed, Err: fmt.Errorf("error listing CertificateRequest resources: %w", err)}
+	}
+	failedOrders := make(map[string]cmacme.Order)
+	var orders cmacme.OrderList
+	if err := cl.List(ctx, &orders, client.InNamespace(namespace)); err == nil {
+		for _, order := range orders.Items {
+			owner := metav1.GetControllerOf(&order)
+			if owner == nil || owner.Kind != "CertificateRequest" {
+				continue
+			}
+			switch order.Status.State {
+			case cmacme.Invalid, cmacme.Errored, cmacme.Expired:
+				failedOrders[owner.Name] = order
+			}
+		}
+	}
+
+	var stuck []StuckIssuance
+	exists := make(map[string]bool)
+	for i := range certs.Items {
+		crt := &certs.Items[i]
+		exists[string(crt.UID)] = true
+		if crt.DeletionTimestamp != nil {
+			continue
+		}
+		s := StuckIssuance{Namespace: namespace, Certificate: crt.Name}
+		for _, c := range crt.Status.Conditions {
+			if c.LastTransitionTime == nil || !c.LastTransitionTime.Time.Before(cutoff) {
+				continue
+			}
+			switch {
+			case c.Type == scanner.CertificateConditionIssuing && c.Status == cmmeta.ConditionTrue:
+				s.Reasons = append(s.Reasons, fmt.Sprintf("its Issuing condition has been True since %s", formatTime(c.LastTransitionTime.Time)))
+			case c.Type == capi.CertificateConditionReady && c.Status == cmmeta.ConditionFalse:
+				s.Reasons = append(s.Reasons, fmt.Sprintf("its Ready condition has been False since %s: %s", formatTime(c.LastTransitionTime.Time), c.Message))
+			}
+		}
+		var pending bool
+		for j := range requests.Items {
+			req := &requests.Items[j]
+			if !metav1.IsControlledBy(req, crt) {
+				continue
+			}
+			if order, ok := failedOrders[req.Name]; ok && !scanner.IsFailedCertificateRequest(req) {
+				s.Reasons = append(s.Reasons, fmt.Sprintf("Order %s of CertificateRequest %s is %s: %s", order.Name, req.Name, order.Status.State, order.Status.Reason))
+				s.Requests = append(s.Requests, *req)
+				continue
+			}
+			if len(req.Status.Certificate) > 0 || scanner.IsFailedCertificateRequest(req) {
+				continue
+			}
+			pending = true
+			if req.CreationTimestamp.Time.Before(cutoff) {
+				s.Reasons = append(s.Reasons, fmt.Sprintf("CertificateRequest %s has been pending since %s", req.Name, formatTime(req.CreationTimestamp.Time)))
+				s.Requests = append(s.Requests, *req)
+			}
+		}
+		if reason, err := stuckTrigger(ctx, cl, crt, cutoff, pending); err != nil {
+			return nil, err
+		} else if reason != "" {
+			s.Reasons = append(s.Reasons, reason)
+		}
+		if len(s.Reasons) > 0 {
+			// A failed request blocks nothing once the issuance is stuck
+			// for another reason, but is deleted along with the rest so
+			// that cert-manager does not back off before retrying.
+			for j := range requests.Items {
+				req := &requests.Items[j]
+				if metav1.IsControlledBy(req, crt) && scanner.IsFailedCertificateRequest(req) && req.CreationTimestamp.Time.Before(cutoff) {
+					s.Requests = append(s.Requests, *req)
+				}
+			}
+			sort.Slice(s.Requests, func(i, j int) bool { return s.Requests[i].Name < s.Requests[j].Name })
+			stuck = append(stuck, s)
+		}
+	}
+
+	orphans := StuckIssuance{Namespace: namespace}
+	for j := range requests.Items {
+		req := &requests.Items[j]
+		owner := metav1.GetControllerOf(req)
+		if owner == nil || owner.Kind != capi.CertificateKind || exists[string(owner.UID)] {
+			continue
+		}
+		orphans.Reasons = append(orphans.Reasons, fmt.Sprintf("CertificateRequest %s belongs to Certificate %s, which no longer exists", req.Name, owner.Name))
+		orphans.Requests = append(orphans.Requests, *req)
+	}
+	if len(orphans.Requests) > 0 {
+		stuck = append(stuck, orphans)
+	}
+	return stuck, nil
+}
+
+// stuckTrigger describes why a renewal of crt triggered by Renew before
+// cutoff is stuck, or returns "" if there is none. pending is true if crt has
+// a CertificateRequest in progress, in which case cert-manager has acted on
+// the trigger, and whether that request is stuck is checked separately.
+func stuckTrigger(ctx context.Context, cl client.Reader, crt *capi.Certificate, cutoff time.Time, pending bool) (string, error) {
+	var secret core.Secret
+	err := cl.Get(ctx, client.ObjectKey{Namespace: crt.Namespace, Name: crt.Spec.SecretName}, &secret)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", &scanner.Error{Code: scanner.CodeSecretFetchFailed, Err: err}
+	}
+	if secret.Annotations[capi.IssuerNameAnnotationKey] != RenewalAnnotationValue || pending {
+		return "", nil
+	}
+	at, err := time.Parse(time.RFC3339, secret.Annotations[TriggeredAtAnnotationKey])
+	if err == nil && !at.Before(cutoff) {
+		return "", nil
+	}
+	when := "by an earlier version of this tool"
+	if err == nil {
+		when = "at " + formatTime(at)
+	}
+	return fmt.Sprintf("a renewal was triggered %s, but cert-manager has not created a CertificateRequest for it. Check that cert-manager is running and that the Certificate is not paused", when), nil
+}
+
+// Reset deletes the CertificateRequests of s, so that cert-manager starts
+// the issuance again. The Orders of any ACME requests are deleted with them
+// by garbage collection.
+func (r *Renewer) Reset(ctx context.Context, s StuckIssuance) error {
+	for i := range s.Requests {
+		req := &s.Requests[i]
+		err := r.Client.Delete(ctx, req)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		reason := "reset stuck issuance of Certificate " + s.Certificate
+		if s.Certificate == "" {
+			reason = "clean up orphaned request"
+		}
+		if auditErr := r.audit("delete", "CertificateRequest", req.Namespace, req.Name, nil, reason, err); auditErr != nil {
+			return auditErr
+		}
+		if err != nil {
+			return &scanner.Error{Code: CodeDeleteRequestFailed, Err: err}
+		}
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
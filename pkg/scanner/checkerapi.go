@@ -0,0 +1,282 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+)
+
+// DefaultCheckerAPIURL is the endpoint Let's Encrypt published for checking
+// whether the certificates served by a list of hostnames are affected by the
+// CAA rechecking bug.
+const DefaultCheckerAPIURL = "https://unboundtest.com/caaproblem/checkhosts"
+
+// CheckerAPIDetector is a Detector that asks a checker API, such as the one
+// published by Let's Encrypt, about each of a certificate's DNS names, rather
+// than using the affected serials dataset. The checker connects to each
+// hostname and reports on the certificate it serves, so a certificate is
+// only affected if the checker reports that a hostname serving it, with the
+// same serial number, needs renewal. A certificate that none of its
+// hostnames are serving cannot be checked, and an error is returned for it.
+//
+// The names of each certificate are posted as a whitespace separated fqdns
+// form field, in batches of up to BatchSize, and the checker must respond
+// with a line for each of them. Results are cached by hostname, so that
+// names shared by several certificates are only checked once.
+type CheckerAPIDetector struct {
+	URL string
+	// BatchSize is the most hostnames sent in a single request. It defaults
+	// to 20.
+	BatchSize int
+	// Interval is the least time between requests, to stay within the
+	// checker's rate limits. It defaults to one second.
+	Interval time.Duration
+	// Timeout is how long each request may take. It defaults to 30 seconds.
+	Timeout time.Duration
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+
+	lock  sync.Mutex
+	next  time.Time
+	cache map[string]checkerAPIResult
+}
+
+// checkerAPIResult is the checker's report on the certificate served by a
+// hostname.
+type checkerAPIResult struct {
+	// Serial is the serial number of the certificate served, in lower case
+	// hex without leading zeros, or empty if it was not reported.
+	Serial   string
+	Affected bool
+	// Problem is set if the checker could not check the hostname.
+	Problem string
+}
+
+var (
+	checkerAPISerial = regexp.MustCompile(`(?i)serial number is ([0-9a-f]+)`)
+	checkerAPIHost   = regexp.MustCompile(`(?i)available on ([^\s]+?)\.?\s`)
+)
+
+// Detect implements Detector.
+func (d *CheckerAPIDetector) Detect(ctx context.Context, crt capi.Certificate, cert *x509.Certificate) (Verdict, error) {
+	hosts := checkerAPIHosts(cert)
+	if len(hosts) == 0 {
+		return Verdict{}, &Error{Code: CodeDetectorFailed, Err: fmt.Errorf("the certificate has no DNS names to check")}
+	}
+	results, err := d.lookup(ctx, hosts)
+	if err != nil {
+		return Verdict{}, &Error{Code: CodeDetectorFailed, Err: err}
+	}
+	serial := fmt.Sprintf("%x", cert.SerialNumber)
+	var problems []string
+	checked := false
+	for _, host := range hosts {
+		r := results[host]
+		switch {
+		case r.Problem != "":
+			problems = append(problems, fmt.Sprintf("%s: %s", host, r.Problem))
+		case r.Serial != serial:
+			// The hostname serves a different certificate, for example an
+			// older one, or one from another cluster.
+		case r.Affected:
+			return Verdict{Affected: true, Reason: fmt.Sprintf("the Let's Encrypt checker reports that the certificate served by %s needs renewal", host)}, nil
+		default:
+			checked = true
+		}
+	}
+	if !checked {
+		msg := "none of its DNS names are serving it, so the checker cannot check it"
+		if len(problems) > 0 {
+			msg += ": " + strings.Join(problems, "; ")
+		}
+		return Verdict{}, &Error{Code: CodeDetectorFailed, Err: fmt.Errorf("%s", msg)}
+	}
+	return Verdict{}, nil
+}
+
+// checkerAPIHosts returns the hostnames of cert that the checker can
+// connect to, in lower case and without duplicates. Wildcard names are
+// skipped, as there is no single host to connect to.
+func checkerAPIHosts(cert *x509.Certificate) []string {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSuffix(n, "."))
+		if n == "" || strings.HasPrefix(n, "*.") || seen[n] {
+			continue
+		}
+		seen[n] = true
+		hosts = append(hosts, n)
+	}
+	return hosts
+}
+
+// lookup returns the result for each of hosts, querying the checker for any
+// that are not cached.
+func (d *CheckerAPIDetector) lookup(ctx context.Context, hosts []string) (map[string]checkerAPIResult, error) {
+	results := make(map[string]checkerAPIResult, len(hosts))
+	var missing []string
+	d.lock.Lock()
+	for _, h := range hosts {
+		if r, ok := d.cache[h]; ok {
+			results[h] = r
+		} else {
+			missing = append(missing, h)
+		}
+	}
+	d.lock.Unlock()
+
+	batchSize := d.BatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+	for len(missing) > 0 {
+		batch := missing
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		missing = missing[len(batch):]
+		got, err := d.query(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		d.lock.Lock()
+		if d.cache == nil {
+			d.cache = make(map[string]checkerAPIResult)
+		}
+		for _, h := range batch {
+			r, ok := got[h]
+			if !ok {
+				r = checkerAPIResult{Problem: "the checker did not report on it"}
+			}
+			// Hostnames that could not be checked, for example because they
+			// did not resolve, are not cached, so that they are retried.
+			if r.Problem == "" {
+				d.cache[h] = r
+			}
+			results[h] = r
+		}
+		d.lock.Unlock()
+	}
+	return results, nil
+}
+
+// wait blocks until a request may be made without exceeding Interval.
+func (d *CheckerAPIDetector) wait(ctx context.Context) error {
+	interval := d.Interval
+	if interval == 0 {
+		interval = time.Second
+	}
+	d.lock.Lock()
+	now := time.Now()
+	at := d.next
+	if at.Before(now) {
+		at = now
+	}
+	d.next = at.Add(interval)
+	d.lock.Unlock()
+
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// query asks the checker about hosts.
+func (d *CheckerAPIDetector) query(ctx context.Context, hosts []string) (map[string]checkerAPIResult, error) {
+	if err := d.wait(ctx); err != nil {
+		return nil, err
+	}
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	form := url.Values{"fqdns": {strings.Join(hosts, "\n")}}
+	req, err := http.NewRequest(http.MethodPost, d.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying checker API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("checker API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return parseCheckerAPIResponse(resp.Body, hosts)
+}
+
+// parseCheckerAPIResponse parses a response from the checker, which has a
+// line for each hostname, such as:
+//
+//	The certificate currently available on example.com needs renewal because it is affected by the Let's Encrypt CAA rechecking problem. Its serial number is 03e9...
+//	The certificate currently available on example.org is OK. It is not one of the certificates affected by the Let's Encrypt CAA rechecking problem. Its serial number is 04a1...
+//	example.net: dial tcp: lookup example.net: no such host
+//
+// Only results for hosts are returned.
+func parseCheckerAPIResponse(r io.Reader, hosts []string) (map[string]checkerAPIResult, error) {
+	want := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		want[h] = true
+	}
+	results := make(map[string]checkerAPIResult)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if m := checkerAPIHost.FindStringSubmatch(line + " "); m != nil && want[strings.ToLower(m[1])] {
+			var res checkerAPIResult
+			if m := checkerAPISerial.FindStringSubmatch(line); m != nil {
+				res.Serial = strings.TrimLeft(strings.ToLower(m[1]), "0")
+			}
+			lower := strings.ToLower(line)
+			switch {
+			case strings.Contains(lower, "needs renewal"):
+				res.Affected = true
+			case strings.Contains(lower, " is ok"):
+			default:
+				res.Problem = line
+			}
+			if res.Problem == "" && res.Serial == "" {
+				res.Problem = "the checker did not report the serial number of the certificate it served"
+			}
+			results[strings.ToLower(m[1])] = res
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 && want[strings.ToLower(line[:i])] {
+			results[strings.ToLower(line[:i])] = checkerAPIResult{Problem: strings.TrimSpace(line[i+1:])}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading checker API response: %w", err)
+	}
+	return results, nil
+}
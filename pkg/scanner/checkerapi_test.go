@@ -0,0 +1,109 @@
+package scanner
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+)
+
+// checkerAPIResponse is a response from the Let's Encrypt checker API, with
+// the hostnames and serial numbers replaced.
+const checkerAPIResponse = `The certificate currently available on affected.example.com needs renewal because it is affected by the Let's Encrypt CAA rechecking problem. Its serial number is 03e9a1b2c3d4e5f60718293a4b5c6d7e8f90. See your ACME client documentation for instructions on how to renew a certificate.
+The certificate currently available on ok.example.com is OK. It is not one of the certificates affected by the Let's Encrypt CAA rechecking problem. Its serial number is 04a1b2c3d4e5f60718293a4b5c6d7e8f9012
+The certificate currently available on moved.example.com needs renewal because it is affected by the Let's Encrypt CAA rechecking problem. Its serial number is 03ffeeddccbbaa99887766554433221100ff. See your ACME client documentation for instructions on how to renew a certificate.
+unresolvable.example.com: dial tcp: lookup unresolvable.example.com on 127.0.0.11:53: no such host
+`
+
+func checkerAPITestCertificate(serial, dnsName string) *x509.Certificate {
+	n, _ := new(big.Int).SetString(serial, 16)
+	return &x509.Certificate{SerialNumber: n, Subject: pkix.Name{CommonName: dnsName}, DNSNames: []string{dnsName}}
+}
+
+func TestCheckerAPIDetector(t *testing.T) {
+	var lock sync.Mutex
+	var queried []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("checker API called with method %s, want POST", r.Method)
+		}
+		lock.Lock()
+		queried = append(queried, strings.Fields(r.FormValue("fqdns"))...)
+		lock.Unlock()
+		w.Write([]byte(checkerAPIResponse))
+	}))
+	defer srv.Close()
+	d := &CheckerAPIDetector{URL: srv.URL, Interval: time.Nanosecond}
+
+	tests := []struct {
+		name         string
+		cert         *x509.Certificate
+		wantAffected bool
+		wantError    string
+	}{
+		{
+			name:         "affected",
+			cert:         checkerAPITestCertificate("03e9a1b2c3d4e5f60718293a4b5c6d7e8f90", "affected.example.com"),
+			wantAffected: true,
+		},
+		{
+			name: "not affected",
+			cert: checkerAPITestCertificate("04a1b2c3d4e5f60718293a4b5c6d7e8f9012", "ok.example.com"),
+		},
+		{
+			// The hostname now serves another certificate, which says
+			// nothing about this one.
+			name:      "different serial",
+			cert:      checkerAPITestCertificate("03e9a1b2c3d4e5f60718293a4b5c6d7e8f90", "moved.example.com"),
+			wantError: "none of its DNS names are serving it",
+		},
+		{
+			name:      "no report",
+			cert:      checkerAPITestCertificate("03e9a1b2c3d4e5f60718293a4b5c6d7e8f90", "missing.example.com"),
+			wantError: "missing.example.com: the checker did not report on it",
+		},
+		{
+			name:      "checker error",
+			cert:      checkerAPITestCertificate("03e9a1b2c3d4e5f60718293a4b5c6d7e8f90", "unresolvable.example.com"),
+			wantError: "unresolvable.example.com: dial tcp: lookup unresolvable.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := d.Detect(context.Background(), capi.Certificate{}, tt.cert)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("Detect() error = %v, want it to contain %q", err, tt.wantError)
+				}
+				if code := ErrorCode(err); code != CodeDetectorFailed {
+					t.Errorf("Detect() error code = %s, want %s", code, CodeDetectorFailed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			if verdict.Affected != tt.wantAffected {
+				t.Errorf("Detect() Affected = %t, want %t", verdict.Affected, tt.wantAffected)
+			}
+		})
+	}
+
+	// Results are cached, apart from hostnames that could not be checked.
+	queried = nil
+	if _, err := d.Detect(context.Background(), capi.Certificate{}, checkerAPITestCertificate("03e9a1b2c3d4e5f60718293a4b5c6d7e8f90", "affected.example.com")); err != nil {
+		t.Fatalf("Detect() of a cached hostname error = %v", err)
+	}
+	d.Detect(context.Background(), capi.Certificate{}, checkerAPITestCertificate("03e9a1b2c3d4e5f60718293a4b5c6d7e8f90", "unresolvable.example.com"))
+	if len(queried) != 1 || queried[0] != "unresolvable.example.com" {
+		t.Errorf("queried %q, want only the hostname that could not be checked", queried)
+	}
+}
@@ -0,0 +1,167 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"sort"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigMapReport is the result of ScanConfigMaps.
+type ConfigMapReport struct {
+	// Checked is the number of certificates found in ConfigMaps whose
+	// serial number was checked.
+	Checked int
+	// Skipped is the number of certificates found in ConfigMaps that could
+	// not be checked.
+	Skipped int
+	// Affected lists every affected certificate found in a ConfigMap,
+	// sorted by namespace, name and key.
+	Affected []ConfigMapCertificate
+	// FailedNamespaces lists the namespaces whose ConfigMaps could not be
+	// listed, sorted by namespace.
+	FailedNamespaces []NamespaceFailure
+}
+
+// ConfigMapCertificate is an affected certificate stored under Key in the
+// data of a ConfigMap. Nothing manages such certificates, so they must be
+// rotated by hand.
+type ConfigMapCertificate struct {
+	Namespace string
+	Name      string
+	Key       string
+	// Serial is the serial number, in hexadecimal.
+	Serial string
+	// Reason explains why the certificate is affected.
+	Reason string
+}
+
+// ScanConfigMaps checks the certificates that applications keep in the data
+// of ConfigMaps rather than in Secrets, for example legacy applications that
+// mount their serving certificate from one. Each value holding a PEM encoded
+// certificate is checked, using the first certificate in it that is not a CA,
+// as CA bundles such as kube-root-ca.crt hold no serving certificate. As with
+// Inventory, namespaces that cannot be listed are returned in the report
+// rather than failing the scan.
+func (s *Scanner) ScanConfigMaps(ctx context.Context) (report *ConfigMapReport, err error) {
+	ctx, sp := StartSpan(ctx, s.Tracer, "scan-configmaps")
+	defer func() { sp.Finish(err) }()
+	namespaces, _, err := s.listNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Found %d namespaces to scan for certificates in ConfigMaps", len(namespaces))
+	report = &ConfigMapReport{}
+	for _, ns := range namespaces {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err := s.scanConfigMapsNamespace(ctx, ns, report); err != nil {
+			report.FailedNamespaces = append(report.FailedNamespaces, NamespaceFailure{Namespace: ns, Err: err})
+		}
+	}
+	sort.Slice(report.Affected, func(i, j int) bool {
+		a, b := report.Affected[i], report.Affected[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Key < b.Key
+	})
+	sp.SetAttribute("configmaps.checked", report.Checked)
+	sp.SetAttribute("configmaps.affected", len(report.Affected))
+	return report, nil
+}
+
+// scanConfigMapsNamespace checks the certificates in the ConfigMaps in
+// namespace, recording the outcome in report.
+func (s *Scanner) scanConfigMapsNamespace(ctx context.Context, namespace string, report *ConfigMapReport) error {
+	var list core.ConfigMapList
+	err := s.listPages(ctx, &list, func() error {
+		for i := range list.Items {
+			cm := &list.Items[i]
+			keys := make([]string, 0, len(cm.Data))
+			for key := range cm.Data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				cert := configMapCertificate([]byte(cm.Data[key]))
+				if cert == nil {
+					continue
+				}
+				s.checkConfigMapCertificate(ctx, cm, key, cert, report)
+			}
+		}
+		return nil
+	}, client.InNamespace(namespace))
+	if err != nil {
+		return fmt.Errorf("error listing ConfigMap resources: %w", err)
+	}
+	return nil
+}
+
+// checkConfigMapCertificate checks cert, stored under key in cm, and records
+// the outcome in report.
+func (s *Scanner) checkConfigMapCertificate(ctx context.Context, cm *core.ConfigMap, key string, cert *x509.Certificate, report *ConfigMapReport) {
+	if IsTemporaryCertificate(cert) || s.excludesStaging(cert) {
+		return
+	}
+	// Detectors are given a Certificate, so one is made up for the
+	// ConfigMap as for TLS Secrets, which does not exist.
+	crt := capi.Certificate{ObjectMeta: metav1.ObjectMeta{Namespace: cm.Namespace, Name: cm.Name}}
+	v, err := s.Check(ctx, crt, cert)
+	if err != nil {
+		log.Printf("Unable to check certificate in ConfigMap %s/%s key %q: %v, skipping...", cm.Namespace, cm.Name, key, err)
+		report.Skipped++
+		return
+	}
+	report.Checked++
+	if !v.Affected {
+		return
+	}
+	log.Printf("ConfigMap %s/%s key %q holds an affected certificate", cm.Namespace, cm.Name, key)
+	if w := v.Warning; w != "" {
+		log.Printf("WARNING: ConfigMap %s/%s key %q matched the affected serials, but %s", cm.Namespace, cm.Name, key, w)
+	}
+	report.Affected = append(report.Affected, ConfigMapCertificate{
+		Namespace: cm.Namespace,
+		Name:      cm.Name,
+		Key:       key,
+		Serial:    fmt.Sprintf("%x", cert.SerialNumber),
+		Reason:    v.Reason,
+	})
+}
+
+// configMapCertificate returns the first certificate in data that is not a
+// CA, or nil if data holds no PEM encoded certificate or only CAs.
+func configMapCertificate(data []byte) *x509.Certificate {
+	if !bytes.Contains(data, []byte("-----BEGIN CERTIFICATE-----")) {
+		return nil
+	}
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || cert.IsCA {
+			continue
+		}
+		return cert
+	}
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+)
+
+// Verdict is the outcome of checking a certificate.
+type Verdict struct {
+	Affected bool `json:"affected"`
+	// Reason explains why the certificate is affected.
+	Reason string `json:"reason,omitempty"`
+	// Warning, if set, explains why the verdict should not be trusted, for
+	// example because the certificate was issued outside the Scanner's
+	// IssuanceWindow. It is set by the Scanner rather than by detectors.
+	Warning string `json:"-"`
+}
+
+// SerialReason is the reason given for certificates whose serial number is
+// in a Scanner's Serials.
+const SerialReason = "serial number is in the affected serials dataset"
+
+// Detector decides whether a certificate is affected, allowing incidents
+// that cannot be described by a list of serial numbers to be detected.
+type Detector interface {
+	// Detect checks cert, which is stored in the Secret of crt.
+	Detect(ctx context.Context, crt capi.Certificate, cert *x509.Certificate) (Verdict, error)
+}
+
+// DetectorFunc adapts a function to a Detector.
+type DetectorFunc func(ctx context.Context, crt capi.Certificate, cert *x509.Certificate) (Verdict, error)
+
+// Detect implements Detector.
+func (f DetectorFunc) Detect(ctx context.Context, crt capi.Certificate, cert *x509.Certificate) (Verdict, error) {
+	return f(ctx, crt, cert)
+}
+
+// ExecDetector is a Detector that runs an external command for each
+// certificate. The command is given an ExecDetectorRequest as JSON on stdin,
+// and must write a Verdict as JSON to stdout and exit successfully.
+type ExecDetector struct {
+	Command string
+	Args    []string
+	// Timeout is how long the command may run for each certificate. It
+	// defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// ExecDetectorRequest describes a certificate to an ExecDetector command.
+type ExecDetectorRequest struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	SecretName string    `json:"secretName"`
+	IssuerName string    `json:"issuerName"`
+	IssuerKind string    `json:"issuerKind,omitempty"`
+	Serial     string    `json:"serial"`
+	Subject    string    `json:"subject"`
+	Issuer     string    `json:"issuer"`
+	DNSNames   []string  `json:"dnsNames,omitempty"`
+	NotBefore  time.Time `json:"notBefore"`
+	NotAfter   time.Time `json:"notAfter"`
+	// PEM is the PEM encoded certificate.
+	PEM string `json:"pem"`
+}
+
+// Detect implements Detector.
+func (d *ExecDetector) Detect(ctx context.Context, crt capi.Certificate, cert *x509.Certificate) (Verdict, error) {
+	req := ExecDetectorRequest{
+		Namespace:  crt.Namespace,
+		Name:       crt.Name,
+		SecretName: crt.Spec.SecretName,
+		IssuerName: crt.Spec.IssuerRef.Name,
+		IssuerKind: crt.Spec.IssuerRef.Kind,
+		Serial:     fmt.Sprintf("%x", cert.SerialNumber),
+		Subject:    cert.Subject.String(),
+		Issuer:     cert.Issuer.String(),
+		DNSNames:   cert.DNSNames,
+		NotBefore:  cert.NotBefore.UTC(),
+		NotAfter:   cert.NotAfter.UTC(),
+		PEM:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, d.Command, d.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Verdict{}, &Error{Code: CodeDetectorFailed, Err: fmt.Errorf("error running detector %q: %w: %s", d.Command, err, strings.TrimSpace(stderr.String()))}
+	}
+	var v Verdict
+	if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+		return Verdict{}, &Error{Code: CodeDetectorFailed, Err: fmt.Errorf("error decoding verdict from detector %q: %w", d.Command, err)}
+	}
+	return v, nil
+}
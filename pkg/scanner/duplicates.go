@@ -0,0 +1,99 @@
+package scanner
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	cmacme "github.com/jetstack/cert-manager/pkg/apis/acme/v1alpha2"
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DuplicateCertificateLimit is the number of certificates for exactly
+	// the same set of names that Let's Encrypt issues in each
+	// DuplicateCertificateWindow, across all accounts.
+	DuplicateCertificateLimit = 5
+	// DuplicateCertificateWindow is the sliding window that
+	// DuplicateCertificateLimit applies to.
+	DuplicateCertificateWindow = 7 * 24 * time.Hour
+)
+
+// NameSet returns the set of names requested by crt, as counted by rate
+// limits: lower-cased, deduplicated, sorted and comma separated.
+func NameSet(crt *capi.Certificate) string {
+	return nameSet(crt.Spec.CommonName, crt.Spec.DNSNames)
+}
+
+// certificateNameSet returns the set of names cert was issued for, as
+// NameSet.
+func certificateNameSet(cert *x509.Certificate) string {
+	return nameSet(cert.Subject.CommonName, cert.DNSNames)
+}
+
+func nameSet(commonName string, dnsNames []string) string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append([]string{commonName}, dnsNames...) {
+		name = strings.ToLower(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// IssuedCertificates returns the certificates found in the status of the
+// CertificateRequests and ACME Orders in namespace, which cert-manager keeps
+// for a while after they are issued. The Order CRD may not be installed if
+// no ACME issuer is used, in which case only CertificateRequests are read.
+func IssuedCertificates(ctx context.Context, cl client.Reader, namespace string) ([]*x509.Certificate, error) {
+	var issued []*x509.Certificate
+	add := func(data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		if cert, err := pki.DecodeX509CertificateBytes(data); err == nil {
+			issued = append(issued, cert)
+		}
+	}
+	var requests capi.CertificateRequestList
+	if err := cl.List(ctx, &requests, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing CertificateRequest resources: %w", err)
+	}
+	for _, req := range requests.Items {
+		add(req.Status.Certificate)
+	}
+	var orders cmacme.OrderList
+	if err := cl.List(ctx, &orders, client.InNamespace(namespace)); err == nil {
+		for _, order := range orders.Items {
+			add(order.Status.Certificate)
+		}
+	}
+	return issued, nil
+}
+
+// CountDuplicates returns the number of distinct certificates in issued, by
+// serial number, that were issued since the given time for exactly the
+// names requested by crt, which count towards the
+// DuplicateCertificateLimit of its renewal.
+func CountDuplicates(crt *capi.Certificate, issued []*x509.Certificate, since time.Time) int {
+	names := NameSet(crt)
+	seen := make(map[string]bool)
+	for _, cert := range issued {
+		serial := cert.SerialNumber.String()
+		if seen[serial] || cert.NotBefore.Before(since) || certificateNameSet(cert) != names {
+			continue
+		}
+		seen[serial] = true
+	}
+	return len(seen)
+}
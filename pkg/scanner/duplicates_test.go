@@ -0,0 +1,68 @@
+package scanner
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+)
+
+func TestCountDuplicates(t *testing.T) {
+	now := time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-DuplicateCertificateWindow)
+	issued := func(serial int64, notBefore time.Time, commonName string, dnsNames ...string) *x509.Certificate {
+		return &x509.Certificate{SerialNumber: big.NewInt(serial), NotBefore: notBefore, Subject: pkix.Name{CommonName: commonName}, DNSNames: dnsNames}
+	}
+	crt := &capi.Certificate{Spec: capi.CertificateSpec{CommonName: "example.com", DNSNames: []string{"www.example.com", "Example.com"}}}
+
+	tests := []struct {
+		name   string
+		issued []*x509.Certificate
+		want   int
+	}{
+		{
+			name: "none issued",
+		},
+		{
+			name: "same names in any order and case",
+			issued: []*x509.Certificate{
+				issued(1, now.Add(-time.Hour), "example.com", "example.com", "www.example.com"),
+				issued(2, now.Add(-24*time.Hour), "", "WWW.example.com", "example.com"),
+			},
+			want: 2,
+		},
+		{
+			name: "same serial counted once",
+			issued: []*x509.Certificate{
+				issued(1, now.Add(-time.Hour), "example.com", "www.example.com"),
+				issued(1, now.Add(-time.Hour), "example.com", "www.example.com"),
+			},
+			want: 1,
+		},
+		{
+			name: "issued before the window",
+			issued: []*x509.Certificate{
+				issued(1, since.Add(-time.Second), "example.com", "www.example.com"),
+				issued(2, since, "example.com", "www.example.com"),
+			},
+			want: 1,
+		},
+		{
+			name: "different names",
+			issued: []*x509.Certificate{
+				issued(1, now.Add(-time.Hour), "example.com"),
+				issued(2, now.Add(-time.Hour), "example.com", "www.example.com", "api.example.com"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CountDuplicates(crt, tt.issued, since); got != tt.want {
+				t.Errorf("CountDuplicates() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
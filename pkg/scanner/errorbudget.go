@@ -0,0 +1,112 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CodeErrorBudgetExceeded is the code of the error returned by a scan that
+// was aborted because too many Certificates could not be checked.
+const CodeErrorBudgetExceeded Code = "ErrorBudgetExceeded"
+
+// ErrErrorBudgetExceeded is a sentinel error for use with errors.Is.
+var ErrErrorBudgetExceeded = &Error{Code: CodeErrorBudgetExceeded}
+
+// DefaultErrorBudgetMinimum is the number of Certificates that must have been
+// attempted before ErrorBudget is applied, if ErrorBudgetMinimum is not set.
+const DefaultErrorBudgetMinimum = 20
+
+// errorBudget aborts a scan once too many of the Certificates attempted so
+// far could not be checked, which usually means that something is wrong with
+// the whole cluster rather than with individual Secrets.
+type errorBudget struct {
+	max      float64
+	minimum  int
+	failFast bool
+	cancel   context.CancelFunc
+	// err is set once the budget has been exceeded.
+	err error
+}
+
+// newErrorBudget returns the error budget configured on s, which calls cancel
+// once it has been exceeded, or nil if s has none.
+func (s *Scanner) newErrorBudget(cancel context.CancelFunc) *errorBudget {
+	if !s.FailFast && (s.ErrorBudget <= 0 || s.ErrorBudget >= 1) {
+		return nil
+	}
+	minimum := s.ErrorBudgetMinimum
+	if minimum == 0 {
+		minimum = DefaultErrorBudgetMinimum
+	}
+	return &errorBudget{max: s.ErrorBudget, minimum: minimum, failFast: s.FailFast, cancel: cancel}
+}
+
+// check aborts the scan if r, which must not be modified concurrently,
+// exceeds the budget.
+func (b *errorBudget) check(r *Report) {
+	if b == nil || b.err != nil || r.Skipped == 0 {
+		return
+	}
+	attempted := r.Checked + r.Skipped + r.Temporary + r.Staging
+	ratio := float64(r.Skipped) / float64(attempted)
+	var what string
+	switch {
+	case b.failFast:
+		what = ", and fail-fast is set"
+	case attempted >= b.minimum && ratio > b.max:
+		what = fmt.Sprintf(", exceeding the error budget of %s", percent(b.max))
+	default:
+		return
+	}
+	b.err = &Error{Code: CodeErrorBudgetExceeded, Err: fmt.Errorf("aborting the scan, as %d of the %d Certificates attempted (%s) could not be checked%s (%s). %s",
+		r.Skipped, attempted, percent(ratio), what, describeCodes(r.SkippedByCode), diagnose(r.SkippedByCode))}
+	b.cancel()
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%.0f%%", f*100)
+}
+
+// describeCodes formats counts as, for example,
+// "SecretFetchFailed: 40, DecodeFailed: 2", most common first.
+func describeCodes(counts map[Code]int) string {
+	codes := make([]Code, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if counts[codes[i]] != counts[codes[j]] {
+			return counts[codes[i]] > counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%s: %d", code, counts[code])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// diagnose suggests the likely cause of a mass failure, going by its most
+// common code.
+func diagnose(counts map[Code]int) string {
+	var top Code
+	for code, n := range counts {
+		if n > counts[top] || (n == counts[top] && code < top) {
+			top = code
+		}
+	}
+	switch top {
+	case CodeSecretFetchFailed:
+		return "Secrets could not be read, which usually means the credentials or RBAC permissions in use do not allow it, or the API server cannot decrypt Secrets because encryption at rest is misconfigured."
+	case CodeSecretMissing:
+		return "Certificates refer to Secrets that do not exist, which usually means this is the wrong cluster, or Secrets have been restored without their Certificates being updated."
+	case CodeCertificateDataMissing, CodeDecodeFailed, CodeKeyDecodeFailed:
+		return "Secrets do not contain valid PEM data, which usually means they are encrypted by another tool, such as sealed-secrets or SOPS, or have been corrupted."
+	case CodeDetectorFailed:
+		return "The Detector failed, so check that it works and returns valid verdicts."
+	}
+	return "Check the errors logged for the skipped Certificates."
+}
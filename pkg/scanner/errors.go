@@ -0,0 +1,67 @@
+package scanner
+
+import "errors"
+
+// Code is a stable, machine-readable identifier for a class of failure,
+// which automation can branch on instead of parsing error messages.
+type Code string
+
+// Codes of the failures that cause a Certificate to be skipped by a scan.
+const (
+	CodeUnknown                Code = "Unknown"
+	CodeSecretMissing          Code = "SecretMissing"
+	CodeSecretFetchFailed      Code = "SecretFetchFailed"
+	CodeCertificateDataMissing Code = "CertificateDataMissing"
+	CodeDecodeFailed           Code = "DecodeFailed"
+	CodeKeyDecodeFailed        Code = "KeyDecodeFailed"
+	CodeKeyMismatch            Code = "KeyMismatch"
+	CodeDetectorFailed         Code = "DetectorFailed"
+)
+
+// Error is an error with a Code.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+// Sentinel errors for use with errors.Is, which matches any *Error with the
+// same Code.
+var (
+	ErrSecretMissing          = &Error{Code: CodeSecretMissing}
+	ErrSecretFetchFailed      = &Error{Code: CodeSecretFetchFailed}
+	ErrCertificateDataMissing = &Error{Code: CodeCertificateDataMissing}
+	ErrDecodeFailed           = &Error{Code: CodeDecodeFailed}
+	ErrKeyDecodeFailed        = &Error{Code: CodeKeyDecodeFailed}
+	ErrKeyMismatch            = &Error{Code: CodeKeyMismatch}
+	ErrDetectorFailed         = &Error{Code: CodeDetectorFailed}
+)
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return string(e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is an *Error with the same Code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// ErrorCode returns the Code of the first *Error in the chain of err, or
+// CodeUnknown if there is none. It returns "" if err is nil.
+func ErrorCode(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeUnknown
+}
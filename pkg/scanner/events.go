@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"fmt"
+	"math/big"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+)
+
+// Events is told about the progress of scans and renewals, so that programs
+// embedding this package can report progress without parsing its logs.
+// Namespaces are scanned concurrently, so it must be safe for concurrent use.
+type Events interface {
+	// OnCertificateChecked is called after each Certificate has been checked,
+	// with the serial number of its certificate and whether it is affected.
+	OnCertificateChecked(crt capi.Certificate, serial *big.Int, v Verdict)
+	// OnAffectedFound is called after OnCertificateChecked for each affected
+	// Certificate.
+	OnAffectedFound(a AffectedCertificate)
+	// OnRenewalTriggered is called once the Secret of a Certificate has been
+	// updated to trigger its renewal.
+	OnRenewalTriggered(crt capi.Certificate)
+	// OnRenewalComplete is called when a renewal finishes, with the error it
+	// failed with, if any. It is also called if no renewal was triggered
+	// because one was already in progress.
+	OnRenewalComplete(crt capi.Certificate, err error)
+}
+
+// EventFuncs is an Events that calls whichever of its functions are set.
+type EventFuncs struct {
+	CertificateChecked func(crt capi.Certificate, serial *big.Int, v Verdict)
+	AffectedFound      func(a AffectedCertificate)
+	RenewalTriggered   func(crt capi.Certificate)
+	RenewalComplete    func(crt capi.Certificate, err error)
+}
+
+// OnCertificateChecked implements Events.
+func (e EventFuncs) OnCertificateChecked(crt capi.Certificate, serial *big.Int, v Verdict) {
+	if e.CertificateChecked != nil {
+		e.CertificateChecked(crt, serial, v)
+	}
+}
+
+// OnAffectedFound implements Events.
+func (e EventFuncs) OnAffectedFound(a AffectedCertificate) {
+	if e.AffectedFound != nil {
+		e.AffectedFound(a)
+	}
+}
+
+// OnRenewalTriggered implements Events.
+func (e EventFuncs) OnRenewalTriggered(crt capi.Certificate) {
+	if e.RenewalTriggered != nil {
+		e.RenewalTriggered(crt)
+	}
+}
+
+// OnRenewalComplete implements Events.
+func (e EventFuncs) OnRenewalComplete(crt capi.Certificate, err error) {
+	if e.RenewalComplete != nil {
+		e.RenewalComplete(crt, err)
+	}
+}
+
+// certificateChecked tells s.Events that crt has been checked.
+func (s *Scanner) certificateChecked(crt capi.Certificate, serial *big.Int, v Verdict) {
+	if s.Events == nil {
+		return
+	}
+	s.Events.OnCertificateChecked(crt, serial, v)
+	if v.Affected {
+		s.Events.OnAffectedFound(AffectedCertificate{Certificate: crt, Serial: fmt.Sprintf("%x", serial), Reason: v.Reason, Warning: v.Warning})
+	}
+}
@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	core "k8s.io/api/core/v1"
+)
+
+// Tracer starts trace spans for the steps of a scan or renewal.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any, returning
+	// a context containing the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	// SetAttribute records a string, integer or boolean attribute.
+	SetAttribute(key string, value interface{})
+	// Finish ends the span, marking it as failed if err is not nil.
+	Finish(err error)
+}
+
+// StartSpan starts a span using t, which may be nil.
+func StartSpan(ctx context.Context, t Tracer, name string) (context.Context, Span) {
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) Finish(error)                     {}
+
+// Phases of a scan reported to a Timer.
+const (
+	PhaseList   = "list"
+	PhaseFetch  = "fetch"
+	PhaseDecode = "decode"
+	PhaseMatch  = "match"
+)
+
+// Timer records how long each phase of a scan took. Because namespaces are
+// scanned concurrently, it must be safe for concurrent use.
+type Timer interface {
+	// AddPhase adds d to the time spent in phase.
+	AddPhase(phase string, d time.Duration)
+	// AddNamespace records that scanning namespace took d.
+	AddNamespace(namespace string, d time.Duration)
+}
+
+// SerialCache caches the serial numbers of Secrets, so that Secrets that have
+// not changed do not need to be fetched and decoded again. It must be safe for
+// concurrent use.
+type SerialCache interface {
+	// Lookup returns the cached serial number of the Secret with the given
+	// namespace and name, if the Secret has not changed since it was cached.
+	Lookup(ctx context.Context, namespace, name string) (*big.Int, bool)
+	// Store caches the serial number of secret.
+	Store(secret *core.Secret, serial *big.Int)
+}
+
+// Checkpoint records the results of a scan as each namespace is completed,
+// so that a scan that is interrupted can be resumed later (see
+// Scanner.Resume). It must be safe for concurrent use.
+type Checkpoint interface {
+	// NamespaceScanned is called once every Certificate in namespace has
+	// been checked, with the results for the namespace alone.
+	NamespaceScanned(namespace string, r *Report)
+}
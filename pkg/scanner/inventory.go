@@ -0,0 +1,181 @@
+package scanner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Values of InventoryEntry.ManagedBy.
+const (
+	ManagedByCertManager = "cert-manager"
+	ManagedByNone        = "unmanaged"
+)
+
+// InventoryEntry describes a certificate found in the cluster, either in a
+// Secret of type kubernetes.io/tls or referenced by a Certificate.
+type InventoryEntry struct {
+	Namespace  string `json:"namespace"`
+	SecretName string `json:"secretName"`
+	// Certificates lists the cert-manager Certificates storing their
+	// certificate in the Secret.
+	Certificates []string `json:"certificates,omitempty"`
+	// IssuerRef is the kind and name of the issuer of the first of
+	// Certificates, such as ClusterIssuer/letsencrypt-prod.
+	IssuerRef string `json:"issuerRef,omitempty"`
+	ManagedBy string `json:"managedBy"`
+	// The remaining fields describe the certificate in the Secret, and are
+	// not set if Problem is.
+	Serial  string `json:"serial,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+	// CA names the CA that issued the certificate, by the common name of
+	// Issuer, or its organization if it has none.
+	CA           string     `json:"ca,omitempty"`
+	DNSNames     []string   `json:"dnsNames,omitempty"`
+	NotBefore    *time.Time `json:"notBefore,omitempty"`
+	NotAfter     *time.Time `json:"notAfter,omitempty"`
+	KeyAlgorithm string     `json:"keyAlgorithm,omitempty"`
+	// Problem is set if the Secret is missing or its certificate could not
+	// be decoded.
+	Problem string `json:"problem,omitempty"`
+}
+
+// Inventory lists every certificate in the cluster, whether or not it is
+// managed by cert-manager, without checking whether any is affected. If
+// certificates is false, the cert-manager Certificate CRD is not installed,
+// and only Secrets are listed. Namespaces that cannot be listed are
+// returned in failed rather than failing the inventory.
+func (s *Scanner) Inventory(ctx context.Context, certificates bool) (entries []InventoryEntry, failed []NamespaceFailure, err error) {
+	ctx, sp := StartSpan(ctx, s.Tracer, "inventory")
+	defer func() { sp.Finish(err) }()
+	namespaces, _, err := s.listNamespaces(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, ns := range namespaces {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		found, err := s.inventoryNamespace(ctx, ns, certificates)
+		if err != nil {
+			failed = append(failed, NamespaceFailure{Namespace: ns, Err: err})
+			continue
+		}
+		entries = append(entries, found...)
+	}
+	sp.SetAttribute("certificates", len(entries))
+	return entries, failed, nil
+}
+
+// inventoryNamespace lists the certificates in namespace.
+func (s *Scanner) inventoryNamespace(ctx context.Context, namespace string, certificates bool) ([]InventoryEntry, error) {
+	bySecret := make(map[string][]capi.Certificate)
+	if certificates {
+		var certList capi.CertificateList
+		if err := s.listPages(ctx, &certList, func() error {
+			for _, crt := range certList.Items {
+				bySecret[crt.Spec.SecretName] = append(bySecret[crt.Spec.SecretName], crt)
+			}
+			return nil
+		}, client.InNamespace(namespace)); err != nil {
+			return nil, fmt.Errorf("error listing Certificate resources: %w", err)
+		}
+	}
+
+	var entries []InventoryEntry
+	seen := make(map[string]bool)
+	var list core.SecretList
+	if err := s.listPages(ctx, &list, func() error {
+		for i := range list.Items {
+			secret := &list.Items[i]
+			crts := bySecret[secret.Name]
+			if secret.Type != core.SecretTypeTLS && len(crts) == 0 {
+				continue
+			}
+			seen[secret.Name] = true
+			e := newInventoryEntry(namespace, secret.Name, crts)
+			cert, err := DecodeCertificate(secret)
+			if err != nil {
+				e.Problem = err.Error()
+			} else {
+				describeInventoryCertificate(&e, cert)
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	}, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("error listing Secret resources: %w", err)
+	}
+	for name, crts := range bySecret {
+		if seen[name] {
+			continue
+		}
+		e := newInventoryEntry(namespace, name, crts)
+		e.Problem = "the Secret does not exist"
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SecretName < entries[j].SecretName })
+	return entries, nil
+}
+
+func newInventoryEntry(namespace, secretName string, crts []capi.Certificate) InventoryEntry {
+	e := InventoryEntry{Namespace: namespace, SecretName: secretName, ManagedBy: ManagedByNone}
+	if len(crts) == 0 {
+		return e
+	}
+	e.ManagedBy = ManagedByCertManager
+	for _, crt := range crts {
+		e.Certificates = append(e.Certificates, crt.Name)
+	}
+	sort.Strings(e.Certificates)
+	ref := crts[0].Spec.IssuerRef
+	kind := ref.Kind
+	if kind == "" {
+		kind = capi.IssuerKind
+	}
+	e.IssuerRef = kind + "/" + ref.Name
+	return e
+}
+
+func describeInventoryCertificate(e *InventoryEntry, cert *x509.Certificate) {
+	e.Serial = fmt.Sprintf("%x", cert.SerialNumber)
+	e.Subject = cert.Subject.String()
+	e.Issuer = cert.Issuer.String()
+	switch {
+	case cert.Issuer.CommonName != "":
+		e.CA = cert.Issuer.CommonName
+	case len(cert.Issuer.Organization) > 0:
+		e.CA = cert.Issuer.Organization[0]
+	default:
+		e.CA = e.Issuer
+	}
+	e.DNSNames = cert.DNSNames
+	notBefore, notAfter := cert.NotBefore.UTC(), cert.NotAfter.UTC()
+	e.NotBefore, e.NotAfter = &notBefore, &notAfter
+	e.KeyAlgorithm = KeyAlgorithm(cert)
+}
+
+// KeyAlgorithm describes the public key of cert, such as RSA-2048 or
+// ECDSA-P-256.
+func KeyAlgorithm(cert *x509.Certificate) string {
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
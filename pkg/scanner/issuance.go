@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CertificateConditionIssuing is set by newer versions of cert-manager while
+// a Certificate is being issued.
+const CertificateConditionIssuing capi.CertificateConditionType = "Issuing"
+
+// certificateRequestConditionDenied is set by newer versions of cert-manager
+// when a CertificateRequest has been denied by an approver.
+const certificateRequestConditionDenied capi.CertificateRequestConditionType = "Denied"
+
+// IsFailedCertificateRequest returns true if the given CertificateRequest has
+// failed, been marked as invalid or been denied.
+func IsFailedCertificateRequest(req *capi.CertificateRequest) bool {
+	if req.Status.FailureTime != nil {
+		return true
+	}
+	for _, c := range req.Status.Conditions {
+		switch c.Type {
+		case capi.CertificateRequestConditionReady:
+			if c.Reason == capi.CertificateRequestReasonFailed {
+				return true
+			}
+		case capi.CertificateRequestConditionInvalidRequest, certificateRequestConditionDenied:
+			if c.Status == cmmeta.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsIssuing returns true if crt is in the middle of an issuance, either
+// because its Issuing condition is true or because one of requests, which
+// should include the CertificateRequests in its namespace, is owned by it
+// and has neither completed nor failed.
+func IsIssuing(crt *capi.Certificate, requests []capi.CertificateRequest) bool {
+	for _, c := range crt.Status.Conditions {
+		if c.Type == CertificateConditionIssuing && c.Status == cmmeta.ConditionTrue {
+			return true
+		}
+	}
+	for i := range requests {
+		req := &requests[i]
+		if metav1.IsControlledBy(req, crt) && len(req.Status.Certificate) == 0 && !IsFailedCertificateRequest(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// issuanceChecker lists the CertificateRequests in a namespace the first
+// time they are needed to check whether a Certificate is being issued.
+type issuanceChecker struct {
+	scanner   *Scanner
+	namespace string
+	requests  []capi.CertificateRequest
+	listed    bool
+}
+
+// issuing returns true if crt is being issued. Errors listing
+// CertificateRequests are returned, in which case the caller should treat the
+// Certificate as skipped.
+func (c *issuanceChecker) issuing(ctx context.Context, crt *capi.Certificate) (bool, error) {
+	if IsIssuing(crt, nil) {
+		return true, nil
+	}
+	if !c.listed {
+		var list capi.CertificateRequestList
+		if err := c.scanner.listPages(ctx, &list, func() error {
+			c.requests = append(c.requests, list.Items...)
+			return nil
+		}, client.InNamespace(c.namespace)); err != nil {
+			return false, fmt.Errorf("error listing CertificateRequest resources in namespace %q: %w", c.namespace, err)
+		}
+		c.listed = true
+	}
+	return IsIssuing(crt, c.requests), nil
+}
+
+// inProgressCode returns true if a Certificate that could not be checked with
+// the given code may be in that state because it is being issued.
+func inProgressCode(code Code) bool {
+	switch code {
+	case CodeSecretMissing, CodeCertificateDataMissing, CodeDecodeFailed, CodeKeyDecodeFailed, CodeKeyMismatch:
+		return true
+	}
+	return false
+}
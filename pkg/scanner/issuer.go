@@ -0,0 +1,211 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IssuerProblem returns why the Issuer or ClusterIssuer referenced by crt
+// cannot issue certificates, because it does not exist, is not Ready or, for
+// an ACME issuer, has no registered account, or "" if it can. If acme is not
+// nil, the directory of an ACME issuer's server is also fetched with it, so
+// that an unreachable server is reported too. A renewal of such a
+// Certificate never completes. Issuers of other API groups, such as external
+// issuers, are not checked. Errors other than the issuer not existing are
+// returned.
+func IssuerProblem(ctx context.Context, cl client.Reader, acme *ACMEServerChecker, crt *capi.Certificate) (string, error) {
+	ref := crt.Spec.IssuerRef
+	if ref.Group != "" && ref.Group != capi.SchemeGroupVersion.Group {
+		return "", nil
+	}
+	var kind string
+	var spec capi.IssuerSpec
+	var status capi.IssuerStatus
+	var err error
+	switch ref.Kind {
+	case "", capi.IssuerKind:
+		kind = capi.IssuerKind
+		var iss capi.Issuer
+		err = cl.Get(ctx, client.ObjectKey{Namespace: crt.Namespace, Name: ref.Name}, &iss)
+		spec, status = iss.Spec, iss.Status
+	case capi.ClusterIssuerKind:
+		kind = capi.ClusterIssuerKind
+		var iss capi.ClusterIssuer
+		err = cl.Get(ctx, client.ObjectKey{Name: ref.Name}, &iss)
+		spec, status = iss.Spec, iss.Status
+	default:
+		return "", nil
+	}
+	if apierrors.IsNotFound(err) {
+		return fmt.Sprintf("issuer missing: %s %q does not exist", kind, ref.Name), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error getting %s %q: %w", kind, ref.Name, err)
+	}
+	if problem := readyProblem(kind, ref.Name, status); problem != "" {
+		return problem, nil
+	}
+	if spec.ACME == nil {
+		return "", nil
+	}
+	// cert-manager records the account URI once the account is registered,
+	// which a Ready condition left over from before the account was deleted
+	// or its private key replaced does not show.
+	if status.ACME == nil || status.ACME.URI == "" {
+		return fmt.Sprintf("issuer not ready: %s %q has no registered ACME account", kind, ref.Name), nil
+	}
+	if acme != nil {
+		if problem := acme.Problem(ctx, spec.ACME.Server); problem != "" {
+			return fmt.Sprintf("issuer unreachable: ACME server %s of %s %q %s", spec.ACME.Server, kind, ref.Name, problem), nil
+		}
+	}
+	return "", nil
+}
+
+// readyProblem returns why an issuer with status is not Ready, or "" if it
+// is.
+func readyProblem(kind, name string, status capi.IssuerStatus) string {
+	for _, c := range status.Conditions {
+		if c.Type != capi.IssuerConditionReady {
+			continue
+		}
+		if c.Status == cmmeta.ConditionTrue {
+			return ""
+		}
+		if c.Message != "" {
+			return fmt.Sprintf("issuer not ready: %s %q is not Ready: %s", kind, name, c.Message)
+		}
+		break
+	}
+	return fmt.Sprintf("issuer not ready: %s %q is not Ready", kind, name)
+}
+
+// IssuerKey identifies the issuer referenced by crt, such as
+// "default/cert-manager.io/Issuer/letsencrypt" or
+// "cert-manager.io/ClusterIssuer/letsencrypt".
+func IssuerKey(crt *capi.Certificate) string {
+	ref := crt.Spec.IssuerRef
+	key := ref.Group + "/" + ref.Kind + "/" + ref.Name
+	if ref.Kind != capi.ClusterIssuerKind {
+		key = crt.Namespace + "/" + key
+	}
+	return key
+}
+
+// issuerCache remembers the outcome of IssuerProblem for each issuer during a
+// scan, as many Certificates usually share a few issuers.
+type issuerCache struct {
+	lock     sync.Mutex
+	problems map[string]string
+}
+
+// problem returns IssuerProblem for crt, looking up its issuer the first
+// time it is needed.
+func (c *issuerCache) problem(ctx context.Context, cl client.Reader, acme *ACMEServerChecker, crt *capi.Certificate) (string, error) {
+	key := IssuerKey(crt)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if problem, ok := c.problems[key]; ok {
+		return problem, nil
+	}
+	problem, err := IssuerProblem(ctx, cl, acme, crt)
+	if err != nil {
+		return "", err
+	}
+	if c.problems == nil {
+		c.problems = make(map[string]string)
+	}
+	c.problems[key] = problem
+	return problem, nil
+}
+
+const (
+	// DefaultACMEServerTimeout is the default timeout of a request for the
+	// directory of an ACME server.
+	DefaultACMEServerTimeout = 10 * time.Second
+	// DefaultACMEServerMaxAge is how long the outcome of checking an ACME
+	// server is remembered by default.
+	DefaultACMEServerMaxAge = 5 * time.Minute
+)
+
+// ACMEServerChecker checks that ACME servers are reachable by fetching their
+// directory, remembering the outcome for each server, as most issuers share
+// one or two.
+type ACMEServerChecker struct {
+	// Client is used to fetch directories. It defaults to a client with a
+	// timeout of DefaultACMEServerTimeout.
+	Client *http.Client
+	// MaxAge is how long the outcome for a server is remembered, so that a
+	// long running watch notices a server recovering or failing. It
+	// defaults to DefaultACMEServerMaxAge.
+	MaxAge time.Duration
+
+	lock    sync.Mutex
+	results map[string]acmeServerResult
+}
+
+type acmeServerResult struct {
+	problem   string
+	checkedAt time.Time
+}
+
+// Problem returns why the ACME server with the given directory URL cannot be
+// used, phrased to follow the URL, such as "is unreachable: ...", or "" if
+// it can.
+func (c *ACMEServerChecker) Problem(ctx context.Context, server string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	maxAge := c.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultACMEServerMaxAge
+	}
+	if r, ok := c.results[server]; ok && time.Since(r.checkedAt) < maxAge {
+		return r.problem
+	}
+	problem := c.fetchDirectory(ctx, server)
+	if c.results == nil {
+		c.results = make(map[string]acmeServerResult)
+	}
+	c.results[server] = acmeServerResult{problem: problem, checkedAt: time.Now()}
+	return problem
+}
+
+func (c *ACMEServerChecker) fetchDirectory(ctx context.Context, server string) string {
+	cl := c.Client
+	if cl == nil {
+		cl = &http.Client{Timeout: DefaultACMEServerTimeout}
+	}
+	req, err := http.NewRequest(http.MethodGet, server, nil)
+	if err != nil {
+		return fmt.Sprintf("is not a valid URL: %v", err)
+	}
+	resp, err := cl.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Sprintf("is unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("returned %s for its directory", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Sprintf("is unreachable: %v", err)
+	}
+	var dir struct {
+		NewOrder string `json:"newOrder"`
+	}
+	if err := json.Unmarshal(data, &dir); err != nil || dir.NewOrder == "" {
+		return "did not return an ACME directory"
+	}
+	return ""
+}
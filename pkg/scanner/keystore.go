@@ -0,0 +1,231 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"golang.org/x/crypto/pkcs12"
+	core "k8s.io/api/core/v1"
+)
+
+// Keys of the keystores that cert-manager adds to the Secret of Certificates
+// using spec.keystores.
+const (
+	PKCS12SecretKey = "keystore.p12"
+	JKSSecretKey    = "keystore.jks"
+)
+
+// KeystoreKeys lists the keys of every keystore format that is checked.
+var KeystoreKeys = []string{PKCS12SecretKey, JKSSecretKey}
+
+// KeystorePasswordFunc returns the password of the keystore stored under key,
+// one of KeystoreKeys, in the Secret of crt. It returns an empty password,
+// and no error, if none is configured.
+type KeystorePasswordFunc func(ctx context.Context, crt capi.Certificate, key string) (string, error)
+
+// HasKeystores returns true if secret contains any keystores.
+func HasKeystores(secret *core.Secret) bool {
+	for _, key := range KeystoreKeys {
+		if len(secret.Data[key]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeKeystore decodes the leaf certificate in the keystore stored under
+// key, one of KeystoreKeys, in secret. The password is required to decrypt
+// PKCS#12 keystores. JKS keystores store certificates unencrypted, so their
+// password is only used, if given, to verify their integrity.
+func DecodeKeystore(secret *core.Secret, key, password string) (*x509.Certificate, error) {
+	data := secret.Data[key]
+	if len(data) == 0 {
+		return nil, &Error{Code: CodeCertificateDataMissing, Err: fmt.Errorf("does not contain any data for key %q", key)}
+	}
+	var der []byte
+	var err error
+	switch key {
+	case PKCS12SecretKey:
+		der, err = pkcs12Leaf(data, password)
+	case JKSSecretKey:
+		der, err = jksLeaf(data, password)
+	default:
+		err = fmt.Errorf("unknown keystore %q", key)
+	}
+	if err != nil {
+		return nil, &Error{Code: CodeDecodeFailed, Err: fmt.Errorf("failed to decode %s: %w", key, err)}
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, &Error{Code: CodeDecodeFailed, Err: fmt.Errorf("failed to decode x509 certificate data in %s: %w", key, err)}
+	}
+	return cert, nil
+}
+
+// pkcs12Leaf returns the certificate matching the private key in a PKCS#12
+// keystore, or the first certificate that is not a CA if there is no key.
+func pkcs12Leaf(data []byte, password string) ([]byte, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, err
+	}
+	var keyID string
+	for _, b := range blocks {
+		if b.Type == "PRIVATE KEY" {
+			keyID = b.Headers["localKeyId"]
+		}
+	}
+	var leaf *pem.Block
+	for _, b := range blocks {
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		if keyID != "" && b.Headers["localKeyId"] == keyID {
+			return b.Bytes, nil
+		}
+		if leaf == nil {
+			if cert, err := x509.ParseCertificate(b.Bytes); err == nil && !cert.IsCA {
+				leaf = b
+			}
+		}
+	}
+	if leaf == nil {
+		return nil, errors.New("no certificate found")
+	}
+	return leaf.Bytes, nil
+}
+
+// JKS entry tags.
+const (
+	jksPrivateKeyEntry  = 1
+	jksTrustedCertEntry = 2
+)
+
+// jksLeaf returns the first certificate in the chain of the first private
+// key entry of a JKS keystore, or the first trusted certificate if there are
+// no private keys.
+func jksLeaf(data []byte, password string) ([]byte, error) {
+	if len(data) < sha1.Size {
+		return nil, errors.New("keystore is truncated")
+	}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if password != "" && !bytes.Equal(jksDigest(body, password), digest) {
+		return nil, errors.New("keystore was tampered with, or password was incorrect")
+	}
+	r := &jksReader{r: bytes.NewReader(body)}
+	if magic := r.uint32(); magic != 0xfeedfeed {
+		return nil, errors.New("not a JKS keystore")
+	}
+	version := r.uint32()
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported JKS version %d", version)
+	}
+	var trusted []byte
+	for i, n := 0, r.uint32(); i < int(n) && r.err == nil; i++ {
+		tag := r.uint32()
+		r.utf()    // alias
+		r.uint64() // creation time
+		switch tag {
+		case jksPrivateKeyEntry:
+			r.bytes(int(r.uint32())) // encrypted key
+			chain := r.uint32()
+			for j := 0; j < int(chain) && r.err == nil; j++ {
+				cert := r.certificate(version)
+				if j == 0 && r.err == nil {
+					return cert, nil
+				}
+			}
+		case jksTrustedCertEntry:
+			cert := r.certificate(version)
+			if trusted == nil {
+				trusted = cert
+			}
+		default:
+			return nil, fmt.Errorf("unknown JKS entry type %d", tag)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if trusted == nil {
+		return nil, errors.New("no certificate found")
+	}
+	return trusted, nil
+}
+
+// jksDigest returns the integrity digest of a JKS keystore, which is
+// computed over the password, a fixed salt and the keystore contents.
+func jksDigest(body []byte, password string) []byte {
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// jksReader reads the big-endian fields of a JKS keystore, recording the
+// first error encountered.
+type jksReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *jksReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > 1<<24 {
+		r.err = fmt.Errorf("invalid JKS field length %d", n)
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = fmt.Errorf("keystore is truncated: %w", err)
+		return nil
+	}
+	return b
+}
+
+func (r *jksReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *jksReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *jksReader) utf() string {
+	b := r.bytes(2)
+	if b == nil {
+		return ""
+	}
+	return string(r.bytes(int(binary.BigEndian.Uint16(b))))
+}
+
+// certificate reads a certificate, which is preceded by its type in version
+// 2 keystores.
+func (r *jksReader) certificate(version uint32) []byte {
+	if version == 2 {
+		r.utf()
+	}
+	return r.bytes(int(r.uint32()))
+}
@@ -0,0 +1,30 @@
+package scanner
+
+import (
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultPausedAnnotations are the annotations that, when set to "true" on a
+// Certificate, are used by default to recognise Certificates whose
+// reconciliation by cert-manager has been paused or disabled.
+var DefaultPausedAnnotations = []string{
+	"cert-manager.io/paused",
+	"cert-manager.io/disable-reconciliation",
+}
+
+// PausedBy returns the first of annotations that is set to "true" on crt, or
+// DefaultPausedAnnotations if annotations is nil. Triggering a renewal of a
+// paused Certificate does nothing until it has been unpaused.
+func PausedBy(crt metav1.Object, annotations []string) (string, bool) {
+	if annotations == nil {
+		annotations = DefaultPausedAnnotations
+	}
+	for _, annotation := range annotations {
+		if paused, err := strconv.ParseBool(crt.GetAnnotations()[annotation]); err == nil && paused {
+			return annotation, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"regexp"
+)
+
+// certificatePEMBlock matches a PEM encoded certificate, however its lines
+// are broken.
+var certificatePEMBlock = regexp.MustCompile(`(?s)-----BEGIN CERTIFICATE-----(.*?)-----END CERTIFICATE-----`)
+
+// notBase64 matches the characters that cannot appear in base64 data.
+var notBase64 = regexp.MustCompile(`[^A-Za-z0-9+/=]`)
+
+// recoverCertificate returns the first certificate that can be recovered from
+// tls.crt data that cert-manager would not have written, and which its
+// parser rejects. This covers data that has been edited by hand or written
+// by other tools, with CRLF or escaped line endings, indentation, text
+// around the PEM blocks, other PEM blocks such as private keys, junk after
+// the certificate, raw DER or PEM that has been base64 encoded twice.
+func recoverCertificate(data []byte) (*x509.Certificate, error) {
+	data = bytes.ReplaceAll(data, []byte(`\r\n`), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte(`\n`), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), nil)
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			return cert, nil
+		}
+	}
+	// PEM blocks whose lines have been joined, indented or interspersed with
+	// junk are not decoded by pem.Decode, so decode their contents directly.
+	for _, m := range certificatePEMBlock.FindAllSubmatch(data, -1) {
+		der, err := base64.StdEncoding.DecodeString(string(notBase64.ReplaceAll(m[1], nil)))
+		if err != nil {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			return cert, nil
+		}
+	}
+	trimmed := bytes.TrimSpace(data)
+	if cert, err := x509.ParseCertificate(trimmed); err == nil {
+		return cert, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(string(notBase64.ReplaceAll(trimmed, nil))); err == nil && bytes.Contains(decoded, []byte("-----BEGIN CERTIFICATE-----")) {
+		return recoverCertificate(decoded)
+	}
+	return nil, errors.New("no certificate could be recovered")
+}
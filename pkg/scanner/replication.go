@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Annotations and labels set on replicas by Secret replication controllers.
+const (
+	// reflectorReflectsAnnotation is set by reflector to the namespace/name
+	// of the source Secret.
+	reflectorReflectsAnnotation = "reflector.v1.k8s.emberstack.com/reflects"
+	// replicatorReplicateFromAnnotation is set by users of
+	// kubernetes-replicator to the namespace/name, or just the name if in
+	// the same namespace, of the source Secret.
+	replicatorReplicateFromAnnotation = "replicator.v1.mittwald.de/replicate-from"
+	// kubedOriginNamespaceLabel and kubedOriginNameLabel are set by kubed to
+	// the namespace and name of the source Secret.
+	kubedOriginNamespaceLabel = "kubed.appscode.com/origin.namespace"
+	kubedOriginNameLabel      = "kubed.appscode.com/origin.name"
+)
+
+// ReplicationSource returns the Secret that secret was replicated from by
+// kubed, reflector or kubernetes-replicator, if any. Renewing a Certificate
+// that uses a replica has no lasting effect, as the replication controller
+// overwrites it with the contents of the source.
+func ReplicationSource(secret metav1.Object) (types.NamespacedName, bool) {
+	if ns, name := secret.GetLabels()[kubedOriginNamespaceLabel], secret.GetLabels()[kubedOriginNameLabel]; ns != "" && name != "" {
+		return replicationSource(secret, ns, name)
+	}
+	for _, annotation := range []string{reflectorReflectsAnnotation, replicatorReplicateFromAnnotation} {
+		value := strings.TrimSpace(secret.GetAnnotations()[annotation])
+		if value == "" {
+			continue
+		}
+		ns, name := secret.GetNamespace(), value
+		if i := strings.Index(value, "/"); i >= 0 {
+			ns, name = value[:i], value[i+1:]
+		}
+		return replicationSource(secret, ns, name)
+	}
+	return types.NamespacedName{}, false
+}
+
+// replicationSource returns the source namespace/name, unless it is secret
+// itself, as is the case for the source Secret when some replication
+// controllers copy their annotations.
+func replicationSource(secret metav1.Object, ns, name string) (types.NamespacedName, bool) {
+	if ns == "" || name == "" || (ns == secret.GetNamespace() && name == secret.GetName()) {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: ns, Name: name}, true
+}
@@ -0,0 +1,948 @@
+// Package scanner checks the cert-manager Certificates in a cluster against a
+// set of affected serial numbers.
+package scanner
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Scanner checks Certificates against a set of affected serials. Only Client,
+// and one of Serials or Detector, are required.
+type Scanner struct {
+	// Client is used to list Namespaces and Certificates and to get Secrets.
+	Client  client.Reader
+	Serials SerialSource
+	// Detector, if set, is also used to check each certificate, which is
+	// affected if either its serial number is in Serials or Detector says so.
+	// Cache is not used if Detector is set, as Detector needs the whole
+	// certificate.
+	Detector Detector
+
+	// Namespaces, if set, limits the scan to the given namespaces instead of
+	// every namespace in the cluster.
+	Namespaces []string
+	// NamespaceFilter, if set, is called for each namespace to be scanned,
+	// and namespaces for which it returns false are skipped.
+	NamespaceFilter func(namespace string) bool
+	// CertificateFilter, if set, is called for each Certificate found, and
+	// Certificates for which it returns false are counted as not sampled
+	// instead of being checked.
+	CertificateFilter func(crt capi.Certificate) bool
+
+	// Concurrency is the number of namespaces scanned concurrently. It
+	// defaults to 1.
+	Concurrency int
+	// PageSize is the maximum number of resources requested in each list
+	// call. If 0, lists are not paginated.
+	PageSize int64
+
+	// KeystorePassword, if set, is used to decrypt PKCS#12 keystores, which
+	// are otherwise not checked.
+	KeystorePassword KeystorePasswordFunc
+	// IssuanceWindow, if set, is the period in which the certificates in
+	// Serials were issued. A certificate whose serial number is in Serials
+	// but whose notBefore is outside the window suggests that the dataset is
+	// corrupt or for a different incident, so the Certificate is still
+	// reported as affected, but with a warning.
+	IssuanceWindow *Window
+	// PausedAnnotations are the annotations that mark a Certificate as
+	// paused (see PausedBy). It defaults to DefaultPausedAnnotations.
+	PausedAnnotations []string
+	// CheckIssuers causes the Issuer or ClusterIssuer of each affected
+	// Certificate to be looked up, so that Certificates whose issuer is
+	// missing or not Ready are reported with an IssuerProblem.
+	CheckIssuers bool
+	// ACMEServers, if set along with CheckIssuers, is used to check that
+	// the ACME servers of the issuers of affected Certificates are
+	// reachable (see IssuerProblem).
+	ACMEServers *ACMEServerChecker
+	// IncludeStaging causes certificates issued by the Let's Encrypt staging
+	// environment (see IsStagingCertificate) to be checked. By default they
+	// are never affected, and are counted in the Staging of the Report.
+	IncludeStaging bool
+	// ErrorBudget, if between 0 and 1, is the largest fraction of the
+	// Certificates attempted that may fail to be checked before the scan is
+	// aborted with an error with CodeErrorBudgetExceeded, explaining the
+	// likely cause. It is only applied once ErrorBudgetMinimum Certificates
+	// have been attempted, which defaults to DefaultErrorBudgetMinimum.
+	ErrorBudget        float64
+	ErrorBudgetMinimum int
+	// FailFast aborts the scan as soon as any Certificate fails to be
+	// checked.
+	FailFast bool
+
+	// Cache, if set, is consulted before fetching each Secret. Secrets that
+	// contain keystores are not cached, as their keystores must be checked
+	// too.
+	Cache SerialCache
+	// Tracer, if set, is used to trace the scan.
+	Tracer Tracer
+	// Timer, if set, is told how long each phase of the scan took.
+	Timer Timer
+	// Events, if set, is told about each Certificate checked.
+	Events Events
+	// Checkpoint, if set, is given the results of each namespace once it
+	// has been scanned completely, so that an interrupted scan can be
+	// resumed.
+	Checkpoint Checkpoint
+	// Resume, if set, holds the results of the namespaces already scanned
+	// by an interrupted scan, keyed by namespace, as given to its
+	// Checkpoint. These namespaces are not scanned again, and their results
+	// are included in the Report as they were. Namespaces that no longer
+	// exist are left out.
+	Resume map[string]*Report
+}
+
+// Report is the result of a scan.
+type Report struct {
+	// Checked is the number of Certificates whose serial number was checked.
+	Checked int
+	// Skipped is the number of Certificates that could not be checked, for
+	// example because their Secret does not exist.
+	Skipped int
+	// SkippedByCode is the number of Certificates skipped with each error
+	// Code.
+	SkippedByCode map[Code]int
+	// NotSampled is the number of Certificates excluded by CertificateFilter.
+	NotSampled int
+	// Temporary is the number of Certificates whose Secret holds a temporary
+	// certificate issued by cert-manager while the real one is being issued
+	// (see IsTemporaryCertificate). These are neither checked nor skipped.
+	Temporary int
+	// Staging is the number of Certificates whose Secret holds a certificate
+	// issued by the Let's Encrypt staging environment (see
+	// IsStagingCertificate), unless the Scanner's IncludeStaging is set.
+	// These are neither checked nor skipped.
+	Staging int
+	// InProgress lists the Certificates that could not be checked because
+	// they are in the middle of an issuance (see IsIssuing), sorted by
+	// namespace and name. They should be checked again later, for example
+	// with Recheck.
+	InProgress []capi.Certificate
+	// Terminating is the number of Certificates that are being deleted,
+	// which are not checked, as renewing them would fail or recreate
+	// resources that are being garbage collected.
+	Terminating int
+	// TerminatingNamespaces lists the namespaces that were not scanned
+	// because they are being deleted.
+	TerminatingNamespaces []string
+	// Affected lists every affected Certificate, sorted by namespace and
+	// name. More than one Certificate may share a serial number, for example
+	// if a Secret has been replicated into several namespaces and adopted by
+	// a Certificate in each one, in which case ReplicatedFrom is set on the
+	// replicas.
+	Affected []AffectedCertificate
+	// Namespaces maps each namespace scanned to the number of Certificates
+	// found in it.
+	Namespaces map[string]int
+	// Conflicts lists every Secret used by more than one Certificate, sorted
+	// by namespace and Secret name.
+	Conflicts []SecretConflict
+	// FailedNamespaces lists the namespaces whose Certificates could not all
+	// be listed, sorted by namespace. If any did, the report is partial.
+	FailedNamespaces []NamespaceFailure
+}
+
+// NamespaceFailure is a namespace that could not be scanned completely, for
+// example because the scanner is not allowed to list Certificates in it.
+// Certificates listed before the failure are still included in the Report.
+type NamespaceFailure struct {
+	Namespace string
+	Err       error
+}
+
+// Partial returns true if some namespaces could not be scanned completely.
+func (r *Report) Partial() bool {
+	return len(r.FailedNamespaces) > 0
+}
+
+// SecretConflict is a Secret that more than one Certificate uses. cert-manager
+// reconciles each of them separately, so they fight over the contents of the
+// Secret, and triggering a renewal of any of them makes that worse.
+type SecretConflict struct {
+	Namespace  string
+	SecretName string
+	// Certificates is the names of the Certificates using the Secret, sorted.
+	Certificates []string
+}
+
+// AffectedCertificate is a Certificate whose serial number is affected.
+type AffectedCertificate struct {
+	Certificate capi.Certificate
+	// Serial is the serial number, in hexadecimal.
+	Serial string
+	// Reason explains why the Certificate is affected.
+	Reason string
+	// ReplicatedFrom is the namespace/name of the Secret that the
+	// Certificate's Secret was replicated from, if it is a replica (see
+	// ReplicationSource). Such Certificates are fixed by renewing the
+	// Certificate of the source Secret rather than their own.
+	ReplicatedFrom string
+	// SharesSecretWith lists the other Certificates in the same namespace
+	// using the same Secret, if any. Such Certificates should not be renewed
+	// until the conflict has been resolved.
+	SharesSecretWith []string
+	// PausedBy is the annotation that pauses reconciliation of the
+	// Certificate, if any (see PausedBy). Such Certificates must be unpaused
+	// before they can be renewed.
+	PausedBy string
+	// Warning, if set, explains why the Certificate may not really be
+	// affected (see Verdict). Such Certificates should be investigated
+	// rather than renewed automatically.
+	Warning string
+	// IssuerProblem, if set, explains why the issuer of the Certificate
+	// cannot issue a new certificate (see IssuerProblem). It is only set if
+	// the Scanner's CheckIssuers is. A renewal of such a Certificate would
+	// never complete.
+	IssuerProblem string
+}
+
+// Total returns the number of Certificates found by the scan.
+func (r *Report) Total() int {
+	return r.Checked + r.Skipped + r.NotSampled + r.Temporary + r.Staging + len(r.InProgress) + r.Terminating
+}
+
+// AffectedCertificates returns every affected Certificate.
+func (r *Report) AffectedCertificates() []capi.Certificate {
+	var certs []capi.Certificate
+	for _, a := range r.Affected {
+		certs = append(certs, a.Certificate)
+	}
+	return certs
+}
+
+// collector accumulates a Report while namespaces are scanned concurrently.
+type collector struct {
+	lock    sync.Mutex
+	report  Report
+	issuers issuerCache
+	// budget, if set, aborts the scan once too many Certificates have been
+	// skipped.
+	budget *errorBudget
+	// namespaces, if set, also accumulates a Report for each namespace
+	// being scanned, to be passed to the Scanner's Checkpoint once the
+	// namespace has been scanned completely.
+	namespaces map[string]*Report
+}
+
+// reports returns the Reports that results in namespace are recorded in.
+func (c *collector) reports(namespace string) []*Report {
+	if c.namespaces == nil {
+		return []*Report{&c.report}
+	}
+	r, ok := c.namespaces[namespace]
+	if !ok {
+		r = newReport(nil)
+		c.namespaces[namespace] = r
+	}
+	return []*Report{&c.report, r}
+}
+
+// takeNamespace returns the Report of the results in namespace, and stops
+// accumulating it.
+func (c *collector) takeNamespace(namespace string) *Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r := c.reports(namespace)[1]
+	delete(c.namespaces, namespace)
+	return r
+}
+
+// merge adds the results of namespaces scanned earlier, such as those of an
+// interrupted scan being resumed, to the Report.
+func (c *collector) merge(r *Report) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.report.Checked += r.Checked
+	c.report.Skipped += r.Skipped
+	for code, n := range r.SkippedByCode {
+		c.report.SkippedByCode[code] += n
+	}
+	c.report.NotSampled += r.NotSampled
+	c.report.Temporary += r.Temporary
+	c.report.Staging += r.Staging
+	c.report.InProgress = append(c.report.InProgress, r.InProgress...)
+	c.report.Terminating += r.Terminating
+	c.report.Affected = append(c.report.Affected, r.Affected...)
+	for ns, n := range r.Namespaces {
+		c.report.Namespaces[ns] = n
+	}
+	c.report.Conflicts = append(c.report.Conflicts, r.Conflicts...)
+	c.budget.check(&c.report)
+}
+
+func (c *collector) recordSkipped(namespace string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.reports(namespace) {
+		r.Skipped++
+		r.SkippedByCode[ErrorCode(err)]++
+	}
+	c.budget.check(&c.report)
+}
+
+// budgetErr returns the error the scan was aborted with if the error budget
+// was exceeded.
+func (c *collector) budgetErr() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.budget == nil {
+		return nil
+	}
+	return c.budget.err
+}
+
+func (c *collector) recordInProgress(crt capi.Certificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.reports(crt.Namespace) {
+		r.InProgress = append(r.InProgress, crt)
+	}
+}
+
+func (c *collector) recordFailedNamespace(namespace string, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.report.FailedNamespaces = append(c.report.FailedNamespaces, NamespaceFailure{Namespace: namespace, Err: err})
+}
+
+func (c *collector) recordTerminating(namespace string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.reports(namespace) {
+		r.Terminating++
+	}
+}
+
+func (c *collector) recordNotSampled(namespace string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.reports(namespace) {
+		r.NotSampled++
+	}
+}
+
+func (c *collector) recordChecked(crt capi.Certificate, result checkResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.reports(crt.Namespace) {
+		switch {
+		case result.temporary:
+			r.Temporary++
+		case result.staging:
+			r.Staging++
+		default:
+			r.Checked++
+			if result.verdict.Affected {
+				r.Affected = append(r.Affected, AffectedCertificate{Certificate: crt, Serial: fmt.Sprintf("%x", result.serial), Reason: result.verdict.Reason, ReplicatedFrom: result.replicatedFrom, PausedBy: result.pausedBy, Warning: result.verdict.Warning, IssuerProblem: result.issuerProblem})
+			}
+		}
+	}
+}
+
+func (c *collector) recordNamespace(namespace string, certificates int, secrets map[string][]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range c.reports(namespace) {
+		r.Namespaces[namespace] = certificates
+		for secret, names := range secrets {
+			if len(names) > 1 {
+				sort.Strings(names)
+				r.Conflicts = append(r.Conflicts, SecretConflict{Namespace: namespace, SecretName: secret, Certificates: names})
+			}
+		}
+	}
+}
+
+// Scan checks every Certificate in the cluster. Namespaces are scanned
+// concurrently by a pool of Concurrency workers. A namespace that cannot be
+// scanned is recorded in the FailedNamespaces of the Report, and the rest of
+// the cluster is still scanned. An error is only returned if the scan could
+// not be carried out at all, the error budget was exceeded, or ctx was
+// cancelled.
+func (s *Scanner) Scan(ctx context.Context) (report *Report, err error) {
+	ctx, sp := StartSpan(ctx, s.Tracer, "scan")
+	defer func() { sp.Finish(err) }()
+	namespaces, terminating, err := s.listNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sp.SetAttribute("namespaces", len(namespaces))
+	log.Printf("Found %d namespaces to scan", len(namespaces))
+	for _, ns := range terminating {
+		log.Printf("Namespace %q is being deleted, not scanning it", ns)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c := newCollector(terminating)
+	c.budget = s.newErrorBudget(cancel)
+	namespaces = s.resume(namespaces, c)
+	err = s.scanNamespaces(ctx, namespaces, c, "Certificate", s.scanNamespace)
+	if budgetErr := c.budgetErr(); budgetErr != nil {
+		return nil, budgetErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	report = &c.report
+	sortReport(report)
+	sort.Slice(report.Conflicts, func(i, j int) bool {
+		a, b := report.Conflicts[i], report.Conflicts[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.SecretName < b.SecretName
+	})
+	sort.Slice(report.FailedNamespaces, func(i, j int) bool {
+		return report.FailedNamespaces[i].Namespace < report.FailedNamespaces[j].Namespace
+	})
+	recordSharedSecrets(report)
+	sp.SetAttribute("namespaces.failed", len(report.FailedNamespaces))
+	sp.SetAttribute("certificates.checked", report.Checked)
+	sp.SetAttribute("certificates.affected", len(report.Affected))
+	return report, nil
+}
+
+func newCollector(terminating []string) *collector {
+	return &collector{report: *newReport(terminating)}
+}
+
+func newReport(terminating []string) *Report {
+	return &Report{Affected: []AffectedCertificate{}, SkippedByCode: make(map[Code]int), Namespaces: make(map[string]int), TerminatingNamespaces: terminating}
+}
+
+// scanNamespaces calls scan for each of namespaces using a pool of
+// Concurrency workers. Namespaces that fail to be scanned are recorded in c,
+// in which kind names the resources that could not be listed. An error is
+// only returned if ctx is cancelled.
+func (s *Scanner) scanNamespaces(ctx context.Context, namespaces []string, c *collector, kind string, scan func(ctx context.Context, namespace string, c *collector) error) error {
+	workers := s.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if s.Checkpoint != nil {
+		c.namespaces = make(map[string]*Report)
+	}
+	namespaceCh := make(chan string)
+	errCh := make(chan error, len(namespaces))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ns := range namespaceCh {
+				err := scan(ctx, ns, c)
+				if err == nil {
+					// A namespace whose scan was cut short by ctx being
+					// cancelled may have Certificates skipped because of
+					// it, so it is scanned again when resuming.
+					if s.Checkpoint != nil && ctx.Err() == nil {
+						s.Checkpoint.NamespaceScanned(ns, c.takeNamespace(ns))
+					}
+					continue
+				}
+				err = fmt.Errorf("error listing %s resources in namespace %q: %w", kind, ns, err)
+				if ctx.Err() != nil {
+					errCh <- err
+					continue
+				}
+				log.Printf("WARNING: %v, continuing with the remaining namespaces", err)
+				c.recordFailedNamespace(ns, err)
+			}
+		}()
+	}
+	for _, ns := range namespaces {
+		namespaceCh <- ns
+	}
+	close(namespaceCh)
+	wg.Wait()
+	close(errCh)
+	return <-errCh
+}
+
+// resume adds the results in Resume of the namespaces being scanned to c,
+// returning the namespaces that still need to be scanned.
+func (s *Scanner) resume(namespaces []string, c *collector) []string {
+	if len(s.Resume) == 0 {
+		return namespaces
+	}
+	var remaining []string
+	for _, ns := range namespaces {
+		r, ok := s.Resume[ns]
+		if !ok {
+			remaining = append(remaining, ns)
+			continue
+		}
+		c.merge(r)
+	}
+	log.Printf("Resuming an interrupted scan, %d of %d namespaces were already scanned", len(namespaces)-len(remaining), len(namespaces))
+	return remaining
+}
+
+// sortReport sorts the Certificates listed in report by namespace and name.
+func sortReport(report *Report) {
+	sort.Slice(report.Affected, func(i, j int) bool {
+		return certificateLess(report.Affected[i].Certificate, report.Affected[j].Certificate)
+	})
+	sort.Slice(report.InProgress, func(i, j int) bool {
+		return certificateLess(report.InProgress[i], report.InProgress[j])
+	})
+}
+
+func certificateLess(a, b capi.Certificate) bool {
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// Recheck checks the Certificates in report.InProgress again, for example
+// once their issuance has had time to complete, updating report with the
+// results. Certificates that are still being issued remain in InProgress.
+func (s *Scanner) Recheck(ctx context.Context, report *Report) error {
+	pending := report.InProgress
+	c := &collector{report: *report}
+	c.report.InProgress = nil
+	if c.report.SkippedByCode == nil {
+		c.report.SkippedByCode = make(map[Code]int)
+	}
+	checkers := make(map[string]*issuanceChecker)
+	for _, crt := range pending {
+		// The Certificate is fetched again, as its status will have changed.
+		var latest capi.Certificate
+		if err := s.Client.Get(ctx, client.ObjectKey{Namespace: crt.Namespace, Name: crt.Name}, &latest); err != nil {
+			if apierrors.IsNotFound(err) {
+				log.Printf("Certificate %s/%s has been deleted", crt.Namespace, crt.Name)
+				continue
+			}
+			return fmt.Errorf("error getting Certificate %s/%s: %w", crt.Namespace, crt.Name, err)
+		}
+		if latest.DeletionTimestamp != nil {
+			c.recordTerminating(latest.Namespace)
+			continue
+		}
+		if checkers[latest.Namespace] == nil {
+			checkers[latest.Namespace] = &issuanceChecker{scanner: s, namespace: latest.Namespace}
+		}
+		s.check(ctx, latest, c, checkers[latest.Namespace])
+	}
+	*report = c.report
+	sortReport(report)
+	recordSharedSecrets(report)
+	return nil
+}
+
+// check checks crt and records the outcome in c. Certificates that cannot be
+// checked because they are being issued are recorded as in progress.
+func (s *Scanner) check(ctx context.Context, crt capi.Certificate, c *collector, issuance *issuanceChecker) {
+	r, err := s.checkCertificate(ctx, crt)
+	if err != nil {
+		if inProgressCode(ErrorCode(err)) {
+			issuing, listErr := issuance.issuing(ctx, &crt)
+			if listErr != nil {
+				log.Printf("Unable to tell whether Certificate %s/%s is being issued: %v", crt.Namespace, crt.Name, listErr)
+			}
+			if issuing {
+				log.Printf("Certificate %s/%s is being issued, re-check it later", crt.Namespace, crt.Name)
+				c.recordInProgress(crt)
+				return
+			}
+		}
+		c.recordSkipped(crt.Namespace, err)
+		return
+	}
+	if r.verdict.Warning != "" {
+		log.Printf("WARNING: Certificate %s/%s matched the affected serials, but %s", crt.Namespace, crt.Name, r.verdict.Warning)
+	}
+	if r.verdict.Affected {
+		if annotation, ok := PausedBy(&crt, s.PausedAnnotations); ok {
+			log.Printf("Certificate %s/%s is paused by annotation %q, and must be unpaused before it can be renewed", crt.Namespace, crt.Name, annotation)
+			r.pausedBy = annotation
+		}
+		if s.CheckIssuers {
+			problem, err := c.issuers.problem(ctx, s.Client, s.ACMEServers, &crt)
+			if err != nil {
+				log.Printf("WARNING: unable to check the issuer of Certificate %s/%s: %v", crt.Namespace, crt.Name, err)
+			} else if problem != "" {
+				log.Printf("Certificate %s/%s cannot be renewed until its issuer is fixed: %s", crt.Namespace, crt.Name, problem)
+				r.issuerProblem = problem
+			}
+		}
+	}
+	c.recordChecked(crt, r)
+	s.certificateChecked(crt, r.serial, r.verdict)
+}
+
+// recordSharedSecrets sets SharesSecretWith on each affected Certificate in
+// report that is part of a conflict.
+func recordSharedSecrets(report *Report) {
+	conflicts := make(map[string][]string)
+	for _, c := range report.Conflicts {
+		conflicts[c.Namespace+"/"+c.SecretName] = c.Certificates
+	}
+	for i, a := range report.Affected {
+		report.Affected[i].SharesSecretWith = nil
+		for _, name := range conflicts[a.Certificate.Namespace+"/"+a.Certificate.Spec.SecretName] {
+			if name != a.Certificate.Name {
+				report.Affected[i].SharesSecretWith = append(report.Affected[i].SharesSecretWith, name)
+			}
+		}
+	}
+}
+
+// ListNamespaces returns the namespaces that would be scanned, other than
+// those being deleted, sorted by name.
+func (s *Scanner) ListNamespaces(ctx context.Context) ([]string, error) {
+	namespaces, _, err := s.listNamespaces(ctx)
+	return namespaces, err
+}
+
+// listNamespaces returns the namespaces to be scanned, and those that were
+// excluded because they are being deleted. Namespaces given in Namespaces
+// are not checked for deletion.
+func (s *Scanner) listNamespaces(ctx context.Context) (namespaces, terminating []string, err error) {
+	include := func(ns string) bool {
+		return s.NamespaceFilter == nil || s.NamespaceFilter(ns)
+	}
+	// Namespaces are scanned in order, so that runs are reproducible.
+	if len(s.Namespaces) > 0 {
+		for _, ns := range s.Namespaces {
+			if include(ns) {
+				namespaces = append(namespaces, ns)
+			}
+		}
+		sort.Strings(namespaces)
+		return namespaces, nil, nil
+	}
+	var nsList core.NamespaceList
+	if err := s.listPages(ctx, &nsList, func() error {
+		for _, ns := range nsList.Items {
+			if !include(ns.Name) {
+				continue
+			}
+			if ns.DeletionTimestamp != nil || ns.Status.Phase == core.NamespaceTerminating {
+				terminating = append(terminating, ns.Name)
+				continue
+			}
+			namespaces = append(namespaces, ns.Name)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, fmt.Errorf("error listing Namespace resources: %w", err)
+	}
+	sort.Strings(namespaces)
+	sort.Strings(terminating)
+	return namespaces, terminating, nil
+}
+
+// scanNamespace checks each page of Certificates in the given namespace as
+// soon as it has been listed, recording the outcome in c.
+func (s *Scanner) scanNamespace(ctx context.Context, namespace string, c *collector) (err error) {
+	ctx, sp := StartSpan(ctx, s.Tracer, "scan-namespace")
+	sp.SetAttribute("namespace", namespace)
+	defer func() { sp.Finish(err) }()
+	start := time.Now()
+	certificates := 0
+	// secrets maps each Secret name to the Certificates using it, to find
+	// conflicts.
+	secrets := make(map[string][]string)
+	issuance := &issuanceChecker{scanner: s, namespace: namespace}
+	var processing time.Duration
+	var certList capi.CertificateList
+	err = s.listPages(ctx, &certList, func() error {
+		pageStart := time.Now()
+		defer func() { processing += time.Since(pageStart) }()
+		certificates += len(certList.Items)
+		// The API server returns resources sorted by name, but other
+		// readers, such as caches, need not.
+		sort.Slice(certList.Items, func(i, j int) bool { return certList.Items[i].Name < certList.Items[j].Name })
+		for _, crt := range certList.Items {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if crt.DeletionTimestamp != nil {
+				log.Printf("Certificate %s/%s is being deleted, not checking it", crt.Namespace, crt.Name)
+				c.recordTerminating(namespace)
+				continue
+			}
+			secrets[crt.Spec.SecretName] = append(secrets[crt.Spec.SecretName], crt.Name)
+			if s.CertificateFilter != nil && !s.CertificateFilter(crt) {
+				c.recordNotSampled(namespace)
+				continue
+			}
+			s.check(ctx, crt, c, issuance)
+		}
+		return nil
+	}, client.InNamespace(namespace))
+	total := time.Since(start)
+	s.addPhase(PhaseList, total-processing)
+	if s.Timer != nil {
+		s.Timer.AddNamespace(namespace, total)
+	}
+	if err == nil {
+		c.recordNamespace(namespace, certificates, secrets)
+	}
+	sp.SetAttribute("certificates", certificates)
+	return err
+}
+
+func (s *Scanner) addPhase(phase string, d time.Duration) {
+	if s.Timer != nil {
+		s.Timer.AddPhase(phase, d)
+	}
+}
+
+// Check returns whether cert, which is stored in the Secret of crt, is
+// affected.
+func (s *Scanner) Check(ctx context.Context, crt capi.Certificate, cert *x509.Certificate) (Verdict, error) {
+	if IsTemporaryCertificate(cert) || s.excludesStaging(cert) {
+		return Verdict{}, nil
+	}
+	if s.Serials != nil && s.Serials.Contains(cert.SerialNumber) {
+		v := Verdict{Affected: true, Reason: SerialReason}
+		if w := s.IssuanceWindow; w != nil && !w.Contains(cert.NotBefore) {
+			v.Warning = fmt.Sprintf("its notBefore of %s is outside the incident's issuance window of %s to %s, so the affected serials dataset may be corrupt or for a different incident",
+				cert.NotBefore.UTC().Format(time.RFC3339), w.From.UTC().Format(time.RFC3339), w.To.UTC().Format(time.RFC3339))
+		}
+		return v, nil
+	}
+	if s.Detector != nil {
+		v, err := s.Detector.Detect(ctx, crt, cert)
+		if err != nil && ErrorCode(err) == CodeUnknown {
+			err = &Error{Code: CodeDetectorFailed, Err: err}
+		}
+		return v, err
+	}
+	return Verdict{}, nil
+}
+
+// excludesStaging returns true if cert was issued by the Let's Encrypt
+// staging environment and IncludeStaging is not set.
+func (s *Scanner) excludesStaging(cert *x509.Certificate) bool {
+	return !s.IncludeStaging && IsStagingCertificate(cert)
+}
+
+// CheckSecret decodes the certificate stored in secret, which belongs to crt,
+// and returns its serial number and whether it is affected, including any
+// certificates in its keystores. An unaffected Secret whose private key does
+// not match its certificate returns an error with CodeKeyMismatch.
+func (s *Scanner) CheckSecret(ctx context.Context, crt capi.Certificate, secret *core.Secret) (*big.Int, Verdict, error) {
+	cert, err := DecodeCertificate(secret)
+	if err != nil {
+		return nil, Verdict{}, err
+	}
+	v, err := s.Check(ctx, crt, cert)
+	if err != nil || v.Affected {
+		return cert.SerialNumber, v, err
+	}
+	if serial, kv, err := s.CheckKeystores(ctx, crt, secret); err != nil || kv.Affected {
+		return serial, kv, err
+	}
+	if err := VerifyKeyPair(secret, cert); err != nil {
+		return nil, Verdict{}, err
+	}
+	return cert.SerialNumber, v, nil
+}
+
+// CheckKeystores checks the certificates in any keystores in secret, which
+// belongs to crt, returning the serial number of the first affected one.
+// These normally hold the same certificate as tls.crt, but may not if they
+// failed to be updated. Keystores that cannot be decoded are logged and
+// otherwise ignored, rather than preventing the rest of the Secret from
+// being checked.
+func (s *Scanner) CheckKeystores(ctx context.Context, crt capi.Certificate, secret *core.Secret) (*big.Int, Verdict, error) {
+	for _, key := range KeystoreKeys {
+		if len(secret.Data[key]) == 0 {
+			continue
+		}
+		password := ""
+		if s.KeystorePassword != nil {
+			p, err := s.KeystorePassword(ctx, crt, key)
+			if err != nil {
+				log.Printf("WARNING: unable to get the password of %s in Secret %s/%s, so it was not checked: %v", key, secret.Namespace, secret.Name, err)
+				continue
+			}
+			password = p
+		}
+		if password == "" && key == PKCS12SecretKey {
+			log.Printf("WARNING: no password found for %s in Secret %s/%s, so it was not checked", key, secret.Namespace, secret.Name)
+			continue
+		}
+		cert, err := DecodeKeystore(secret, key, password)
+		if err != nil {
+			log.Printf("WARNING: unable to check %s in Secret %s/%s: %v", key, secret.Namespace, secret.Name, err)
+			continue
+		}
+		v, err := s.Check(ctx, crt, cert)
+		if err != nil {
+			return nil, Verdict{}, err
+		}
+		if v.Affected {
+			v.Reason = fmt.Sprintf("%s (certificate in %s)", v.Reason, key)
+			return cert.SerialNumber, v, nil
+		}
+	}
+	return nil, Verdict{}, nil
+}
+
+// checkResult is the outcome of checking a single Certificate.
+type checkResult struct {
+	serial  *big.Int
+	verdict Verdict
+	// replicatedFrom is the namespace/name of the source of the Secret, if it
+	// is a replica and affected.
+	replicatedFrom string
+	// temporary is true if the Secret holds a temporary certificate.
+	temporary bool
+	// staging is true if the Secret holds a Let's Encrypt staging
+	// certificate, which is not checked.
+	staging bool
+	// pausedBy is the annotation pausing the Certificate, if it is paused
+	// and affected.
+	pausedBy string
+	// issuerProblem explains why the issuer of the Certificate cannot issue
+	// certificates, if it is affected.
+	issuerProblem string
+}
+
+// checkCertificate fetches the Secret resource for the given Certificate and
+// checks the certificate stored within it. If the certificate cannot be
+// checked, the reason is logged and an *Error is returned.
+func (s *Scanner) checkCertificate(ctx context.Context, crt capi.Certificate) (checkResult, error) {
+	ctx, sp := StartSpan(ctx, s.Tracer, "check-certificate")
+	sp.SetAttribute("namespace", crt.Namespace)
+	sp.SetAttribute("certificate", crt.Name)
+	sp.SetAttribute("secret", crt.Spec.SecretName)
+	var spanErr error
+	defer func() { sp.Finish(spanErr) }()
+	log.Printf("+++ Checking Secret resource for Certificate %s/%s", crt.Namespace, crt.Name)
+	fetchStart := time.Now()
+	if s.Cache != nil && s.Detector == nil {
+		if serial, ok := s.Cache.Lookup(ctx, crt.Namespace, crt.Spec.SecretName); ok {
+			s.addPhase(PhaseFetch, time.Since(fetchStart))
+			matchStart := time.Now()
+			affected := s.Serials != nil && s.Serials.Contains(serial)
+			s.addPhase(PhaseMatch, time.Since(matchStart))
+			// Affected Secrets are fetched anyway, to find out whether they
+			// are replicas.
+			if !affected {
+				sp.SetAttribute("cached", true)
+				log.Printf("Secret %q has not changed since the last run, using cached serial number", crt.Spec.SecretName)
+				return checkResult{serial: serial}, nil
+			}
+			fetchStart = time.Now()
+		}
+	}
+
+	// Secrets are fetched individually rather than listed, so that the
+	// tool can be used without permission to list Secrets cluster-wide.
+	var secret core.Secret
+	_, getSpan := StartSpan(ctx, s.Tracer, "api.get-secret")
+	err := s.Client.Get(ctx, client.ObjectKey{Namespace: crt.Namespace, Name: crt.Spec.SecretName}, &secret)
+	getSpan.Finish(err)
+	s.addPhase(PhaseFetch, time.Since(fetchStart))
+	if err != nil {
+		spanErr = err
+		if apierrors.IsNotFound(err) {
+			log.Printf("Unable to find Secret resource %q, skipping...", crt.Spec.SecretName)
+			return checkResult{}, &Error{Code: CodeSecretMissing, Err: err}
+		}
+		log.Printf("Failed to retrieve Secret resource %q: %v, skipping...", crt.Spec.SecretName, err)
+		return checkResult{}, &Error{Code: CodeSecretFetchFailed, Err: err}
+	}
+	decodeStart := time.Now()
+	cert, err := DecodeCertificate(&secret)
+	s.addPhase(PhaseDecode, time.Since(decodeStart))
+	if err != nil {
+		spanErr = err
+		log.Printf("Unable to check Secret %q: %v, skipping...", crt.Spec.SecretName, err)
+		return checkResult{}, err
+	}
+	if IsTemporaryCertificate(cert) {
+		log.Printf("Secret %q holds a temporary certificate while cert-manager issues the real one, not checking it", crt.Spec.SecretName)
+		return checkResult{serial: cert.SerialNumber, temporary: true}, nil
+	}
+	if s.excludesStaging(cert) {
+		log.Printf("Secret %q holds a Let's Encrypt staging certificate, not checking it", crt.Spec.SecretName)
+		return checkResult{serial: cert.SerialNumber, staging: true}, nil
+	}
+	// Secrets whose key does not match are not cached, so that they are
+	// reported again until they have been fixed.
+	keyErr := VerifyKeyPair(&secret, cert)
+	if s.Cache != nil && keyErr == nil && !HasKeystores(&secret) {
+		s.Cache.Store(&secret, cert.SerialNumber)
+	}
+	matchStart := time.Now()
+	serial := cert.SerialNumber
+	v, err := s.Check(ctx, crt, cert)
+	if err == nil && !v.Affected {
+		if ks, kv, kerr := s.CheckKeystores(ctx, crt, &secret); kerr != nil || kv.Affected {
+			serial, v, err = ks, kv, kerr
+		}
+	}
+	s.addPhase(PhaseMatch, time.Since(matchStart))
+	if err != nil {
+		spanErr = err
+		log.Printf("Unable to check certificate in Secret %q: %v, skipping...", crt.Spec.SecretName, err)
+		return checkResult{}, err
+	}
+	if keyErr != nil {
+		// Affected Certificates are still reported, as renewing them also
+		// replaces the key.
+		if !v.Affected {
+			spanErr = keyErr
+			log.Printf("Secret %q needs manual intervention: %v, skipping...", crt.Spec.SecretName, keyErr)
+			return checkResult{}, keyErr
+		}
+		log.Printf("WARNING: Secret %q is affected and also needs manual intervention: %v", crt.Spec.SecretName, keyErr)
+	}
+	r := checkResult{serial: serial, verdict: v}
+	if source, ok := ReplicationSource(&secret); ok && v.Affected {
+		log.Printf("Secret %q is a replica of Secret %s, which should be renewed instead", crt.Spec.SecretName, source)
+		r.replicatedFrom = source.String()
+	}
+	return r, nil
+}
+
+// pagedList is a list type that can be retrieved from the API server in pages.
+type pagedList interface {
+	runtime.Object
+	GetContinue() string
+}
+
+// listPages lists resources into list one page at a time, calling fn after
+// each page has been retrieved.
+func (s *Scanner) listPages(ctx context.Context, list pagedList, fn func() error, opts ...client.ListOption) error {
+	continueToken := ""
+	for {
+		pageOpts := append([]client.ListOption{client.Limit(s.PageSize), client.Continue(continueToken)}, opts...)
+		_, sp := StartSpan(ctx, s.Tracer, "api.list")
+		sp.SetAttribute("type", fmt.Sprintf("%T", list))
+		err := s.Client.List(ctx, list, pageOpts...)
+		sp.Finish(err)
+		if err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		continueToken = list.GetContinue()
+		if continueToken == "" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ScanSecrets checks every Secret of type kubernetes.io/tls in the cluster,
+// for clusters in which cert-manager is not installed, for example because
+// certificates are obtained by certbot sidecars or managed by hand. Each
+// Secret is reported as if it belonged to a Certificate with the same name,
+// which does not exist, so the Certificates in the Report cannot be renewed.
+// The Secrets are listed rather than fetched individually, so permission to
+// list Secrets is required.
+func (s *Scanner) ScanSecrets(ctx context.Context) (report *Report, err error) {
+	ctx, sp := StartSpan(ctx, s.Tracer, "scan-secrets")
+	defer func() { sp.Finish(err) }()
+	namespaces, terminating, err := s.listNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sp.SetAttribute("namespaces", len(namespaces))
+	log.Printf("Found %d namespaces to scan for TLS Secrets", len(namespaces))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c := newCollector(terminating)
+	c.budget = s.newErrorBudget(cancel)
+	namespaces = s.resume(namespaces, c)
+	err = s.scanNamespaces(ctx, namespaces, c, "Secret", s.scanSecretsNamespace)
+	if budgetErr := c.budgetErr(); budgetErr != nil {
+		return nil, budgetErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	report = &c.report
+	sortReport(report)
+	sort.Slice(report.FailedNamespaces, func(i, j int) bool {
+		return report.FailedNamespaces[i].Namespace < report.FailedNamespaces[j].Namespace
+	})
+	sp.SetAttribute("namespaces.failed", len(report.FailedNamespaces))
+	sp.SetAttribute("secrets.checked", report.Checked)
+	sp.SetAttribute("secrets.affected", len(report.Affected))
+	return report, nil
+}
+
+// scanSecretsNamespace checks the TLS Secrets in namespace.
+func (s *Scanner) scanSecretsNamespace(ctx context.Context, namespace string, c *collector) (err error) {
+	ctx, sp := StartSpan(ctx, s.Tracer, "scan-namespace")
+	sp.SetAttribute("namespace", namespace)
+	defer func() { sp.Finish(err) }()
+	start := time.Now()
+	secrets := 0
+	var processing time.Duration
+	var list core.SecretList
+	err = s.listPages(ctx, &list, func() error {
+		pageStart := time.Now()
+		defer func() { processing += time.Since(pageStart) }()
+		sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+		for i := range list.Items {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			secret := &list.Items[i]
+			if secret.Type != core.SecretTypeTLS {
+				continue
+			}
+			secrets++
+			if secret.DeletionTimestamp != nil {
+				c.recordTerminating(namespace)
+				continue
+			}
+			s.checkTLSSecret(ctx, secret, c)
+		}
+		return nil
+	}, client.InNamespace(namespace))
+	total := time.Since(start)
+	s.addPhase(PhaseList, total-processing)
+	if s.Timer != nil {
+		s.Timer.AddNamespace(namespace, total)
+	}
+	if err == nil {
+		c.recordNamespace(namespace, secrets, nil)
+	}
+	sp.SetAttribute("secrets", secrets)
+	return err
+}
+
+// checkTLSSecret checks secret, which is not managed by a Certificate, and
+// records the outcome in c.
+func (s *Scanner) checkTLSSecret(ctx context.Context, secret *core.Secret, c *collector) {
+	log.Printf("+++ Checking TLS Secret %s/%s", secret.Namespace, secret.Name)
+	crt := capi.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: secret.Namespace, Name: secret.Name},
+		Spec:       capi.CertificateSpec{SecretName: secret.Name},
+	}
+	cert, err := DecodeCertificate(secret)
+	if err != nil {
+		log.Printf("Unable to check Secret %q: %v, skipping...", secret.Name, err)
+		c.recordSkipped(secret.Namespace, err)
+		return
+	}
+	if IsTemporaryCertificate(cert) {
+		c.recordChecked(crt, checkResult{serial: cert.SerialNumber, temporary: true})
+		return
+	}
+	if s.excludesStaging(cert) {
+		log.Printf("Secret %s/%s holds a Let's Encrypt staging certificate, not checking it", secret.Namespace, secret.Name)
+		c.recordChecked(crt, checkResult{serial: cert.SerialNumber, staging: true})
+		return
+	}
+	serial := cert.SerialNumber
+	v, err := s.Check(ctx, crt, cert)
+	if err == nil && !v.Affected {
+		if ks, kv, kerr := s.CheckKeystores(ctx, crt, secret); kerr != nil || kv.Affected {
+			serial, v, err = ks, kv, kerr
+		}
+	}
+	if err == nil && !v.Affected {
+		err = VerifyKeyPair(secret, cert)
+	}
+	if err != nil {
+		log.Printf("Unable to check certificate in Secret %q: %v, skipping...", secret.Name, err)
+		c.recordSkipped(secret.Namespace, err)
+		return
+	}
+	r := checkResult{serial: serial, verdict: v}
+	if v.Affected {
+		log.Printf("Secret %s/%s is affected", secret.Namespace, secret.Name)
+		if w := v.Warning; w != "" {
+			log.Printf("WARNING: Secret %s/%s matched the affected serials, but %s", secret.Namespace, secret.Name, w)
+		}
+		if source, ok := ReplicationSource(secret); ok {
+			r.replicatedFrom = source.String()
+		}
+	}
+	c.recordChecked(crt, r)
+	s.certificateChecked(crt, r.serial, r.verdict)
+}
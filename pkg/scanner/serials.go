@@ -0,0 +1,101 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+	core "k8s.io/api/core/v1"
+)
+
+// SerialSet is an in-memory SerialSource, keyed by the big-endian bytes of
+// each serial number so that leading zeroes are not significant.
+type SerialSet map[string]struct{}
+
+// Contains returns true if serial is in the set.
+func (s SerialSet) Contains(serial *big.Int) bool {
+	_, ok := s[string(serial.Bytes())]
+	return ok
+}
+
+// Add adds serial to the set.
+func (s SerialSet) Add(serial *big.Int) {
+	s[string(serial.Bytes())] = struct{}{}
+}
+
+// DecodeCertificate decodes the certificate stored in the given Secret. If the
+// data is malformed, for example because it has been edited by hand, the
+// certificate is recovered from it where possible, and a warning is logged.
+func DecodeCertificate(secret *core.Secret) (*x509.Certificate, error) {
+	if secret.Data == nil || secret.Data[core.TLSCertKey] == nil {
+		return nil, &Error{Code: CodeCertificateDataMissing, Err: fmt.Errorf("does not contain any data for key %q", core.TLSCertKey)}
+	}
+	cert, err := pki.DecodeX509CertificateBytes(secret.Data[core.TLSCertKey])
+	if err == nil {
+		return cert, nil
+	}
+	cert, recoverErr := recoverCertificate(secret.Data[core.TLSCertKey])
+	if recoverErr != nil {
+		return nil, &Error{Code: CodeDecodeFailed, Err: fmt.Errorf("failed to decode x509 certificate data: %w", err)}
+	}
+	log.Printf("WARNING: Secret %s/%s has malformed %q data (%v), but the certificate could be recovered from it", secret.Namespace, secret.Name, core.TLSCertKey, err)
+	return cert, nil
+}
+
+// temporarySerialNumber is the serial number of the temporary certificates
+// that cert-manager stores in the Secret of Certificates annotated with
+// cert-manager.io/issue-temporary-certificate while they are being issued.
+const temporarySerialNumber = 0x1234567890
+
+// temporaryIssuerCommonName is the common name of the throwaway CA that
+// cert-manager signs temporary certificates with.
+const temporaryIssuerCommonName = "cert-manager.local"
+
+// IsTemporaryCertificate returns true if cert is a placeholder that
+// cert-manager issued itself while the real certificate is being issued.
+// Its serial number is meaningless, so it cannot be affected.
+func IsTemporaryCertificate(cert *x509.Certificate) bool {
+	return cert.Issuer.CommonName == temporaryIssuerCommonName ||
+		(cert.SerialNumber.IsInt64() && cert.SerialNumber.Int64() == temporarySerialNumber && bytes.Equal(cert.RawIssuer, cert.RawSubject))
+}
+
+// VerifyKeyPair checks that the private key stored in the given Secret
+// matches cert, which was decoded from it. A Secret whose key does not match
+// cannot be used to serve TLS, and needs to be fixed by hand whether or not
+// its certificate is affected. Secrets without a private key are not
+// checked.
+func VerifyKeyPair(secret *core.Secret, cert *x509.Certificate) error {
+	keyData := secret.Data[core.TLSPrivateKeyKey]
+	if len(keyData) == 0 {
+		return nil
+	}
+	key, err := pki.DecodePrivateKeyBytes(keyData)
+	if err != nil {
+		return &Error{Code: CodeKeyDecodeFailed, Err: fmt.Errorf("failed to decode private key data: %w", err)}
+	}
+	keyPub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return &Error{Code: CodeKeyDecodeFailed, Err: fmt.Errorf("failed to encode public key: %w", err)}
+	}
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return &Error{Code: CodeDecodeFailed, Err: fmt.Errorf("failed to encode public key of certificate: %w", err)}
+	}
+	if !bytes.Equal(keyPub, certPub) {
+		return &Error{Code: CodeKeyMismatch, Err: fmt.Errorf("private key in %q does not match the certificate in %q", core.TLSPrivateKeyKey, core.TLSCertKey)}
+	}
+	return nil
+}
+
+// SerialFromSecret decodes the certificate stored in the given Secret and
+// returns its serial number.
+func SerialFromSecret(secret *core.Secret) (*big.Int, error) {
+	cert, err := DecodeCertificate(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cert.SerialNumber, nil
+}
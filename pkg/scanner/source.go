@@ -0,0 +1,195 @@
+package scanner
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SerialSource is a source of affected serial numbers. It allows datasets to
+// be backed by something other than an in-memory SerialSet, such as a
+// database or an API.
+type SerialSource interface {
+	// Contains returns true if serial is affected.
+	Contains(serial *big.Int) bool
+	// Stream calls fn with each affected serial number, stopping and
+	// returning the error if fn returns one.
+	Stream(fn func(serial *big.Int) error) error
+}
+
+// Stream implements SerialSource.
+func (s SerialSet) Stream(fn func(serial *big.Int) error) error {
+	for b := range s {
+		if err := fn(new(big.Int).SetBytes([]byte(b))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	// serialsReadBufferSize is the size of the buffer used to read the
+	// affected serials file. Lines longer than this are truncated, as only the
+	// serial number at the start of each line is needed.
+	serialsReadBufferSize = 1 << 20
+	// serialsProgressInterval is how often, in bytes, progress is logged while
+	// reading the affected serials file.
+	serialsProgressInterval = 100 << 20
+)
+
+// Formats of affected serials files.
+const (
+	// FormatLECAA is the format of the file published by Let's Encrypt, in
+	// which each line has the form "serial <hex> ...".
+	FormatLECAA = "lecaa"
+	// FormatHex has a single hexadecimal serial number on each line.
+	FormatHex = "hex"
+)
+
+// LoadFile reads the affected serials file at path, in FormatLECAA, into
+// memory.
+func LoadFile(path string) (SerialSet, error) {
+	return LoadFileFormat(path, FormatLECAA)
+}
+
+// LoadFileFormat reads the affected serials file at path, in the given
+// format, into memory.
+func LoadFileFormat(path, format string) (SerialSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ReadSerialsFormat(f, info.Size(), format)
+}
+
+// LoadURL downloads the affected serials file from url into memory,
+// decompressing it if the URL ends in ".gz". If cl is nil,
+// http.DefaultClient is used.
+func LoadURL(ctx context.Context, cl *http.Client, url string) (SerialSet, error) {
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cl.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading affected serials file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading affected serials file: unexpected status %s", resp.Status)
+	}
+	var r io.Reader = resp.Body
+	if strings.HasSuffix(strings.SplitN(url, "?", 2)[0], ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing affected serials file: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ReadSerials(r, resp.ContentLength)
+}
+
+// ReadSerials reads affected serials, in the format of the file published by
+// Let's Encrypt, from r. size is used to report progress, and may be 0 or -1
+// if it is not known.
+func ReadSerials(in io.Reader, size int64) (SerialSet, error) {
+	return ReadSerialsFormat(in, size, FormatLECAA)
+}
+
+// ReadSerialsFormat reads affected serials in the given format from r, in the
+// same way as ReadSerials.
+func ReadSerialsFormat(in io.Reader, size int64, format string) (SerialSet, error) {
+	var parse func(SerialSet, string)
+	switch format {
+	case FormatLECAA, "":
+		parse = parseSerialsLine
+	case FormatHex:
+		parse = parseHexSerialsLine
+	default:
+		return nil, fmt.Errorf("unknown affected serials file format %q", format)
+	}
+	serials := make(SerialSet)
+	r := bufio.NewReaderSize(in, serialsReadBufferSize)
+	var offset, nextProgress int64 = 0, serialsProgressInterval
+	for lineNum := 1; ; lineNum++ {
+		line, isPrefix, err := r.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading affected serials file at line %d (byte offset %d): %w", lineNum, offset, err)
+		}
+		offset += int64(len(line)) + 1
+		parse(serials, string(line))
+
+		// Discard the remainder of any line too long to fit in the buffer.
+		for isPrefix {
+			line, isPrefix, err = r.ReadLine()
+			if err != nil {
+				return nil, fmt.Errorf("error reading affected serials file at line %d (byte offset %d): %w", lineNum, offset, err)
+			}
+			offset += int64(len(line))
+		}
+
+		if offset >= nextProgress {
+			if size > 0 {
+				log.Printf("Read %d of %d MB from affected serials file (%d%%), %d serial numbers loaded so far", offset>>20, size>>20, offset*100/size, len(serials))
+			} else {
+				log.Printf("Read %d MB from affected serials file, %d serial numbers loaded so far", offset>>20, len(serials))
+			}
+			nextProgress += serialsProgressInterval
+		}
+	}
+	return serials, nil
+}
+
+// parseSerialsLine parses a single line of the affected serials file and
+// adds the serial number it contains to serials.
+func parseSerialsLine(serials SerialSet, line string) {
+	if !strings.HasPrefix(line, "serial ") {
+		log.Printf("Failed to parse line in affected serials file, does not start with 'serial ': %v", line)
+		return
+	}
+
+	// extract the serial number from the serials.txt file and convert it
+	// to a big.Int to avoid trailing zeroes in serial numbers causing problems.
+	serial := strings.Split(line, " ")[1]
+	serialInt := big.NewInt(0)
+	_, ok := serialInt.SetString(serial, 16)
+	if !ok {
+		log.Printf("Failed to parse serial number in serials.txt (line: %s)", line)
+		return
+	}
+	serials.Add(serialInt)
+}
+
+// parseHexSerialsLine parses a line of a FormatHex file. Blank lines are
+// ignored.
+func parseHexSerialsLine(serials SerialSet, line string) {
+	serial := strings.TrimSpace(line)
+	if serial == "" {
+		return
+	}
+	serialInt := big.NewInt(0)
+	if _, ok := serialInt.SetString(strings.Replace(serial, ":", "", -1), 16); !ok {
+		log.Printf("Failed to parse serial number in affected serials file (line: %s)", line)
+		return
+	}
+	serials.Add(serialInt)
+}
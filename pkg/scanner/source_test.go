@@ -0,0 +1,114 @@
+package scanner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// serialStrings returns the serial numbers in s in lower case hex, sorted.
+func serialStrings(s SerialSet) []string {
+	var serials []string
+	s.Stream(func(serial *big.Int) error {
+		serials = append(serials, serial.Text(16))
+		return nil
+	})
+	sort.Strings(serials)
+	return serials
+}
+
+func TestReadSerialsFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "Let's Encrypt file",
+			format: FormatLECAA,
+			in: "serial 03e9a1b2c3d4e5f60718293a4b5c6d7e8f90 names example.com,www.example.com\n" +
+				"serial 04a1b2c3d4e5f60718293a4b5c6d7e8f9012 names example.org\n",
+			want: []string{"3e9a1b2c3d4e5f60718293a4b5c6d7e8f90", "4a1b2c3d4e5f60718293a4b5c6d7e8f9012"},
+		},
+		{
+			name: "default format",
+			in:   "serial 0a names example.com\n",
+			want: []string{"a"},
+		},
+		{
+			name:   "malformed lines skipped",
+			format: FormatLECAA,
+			in:     "# comment\nserial zz names example.com\n03e9 names example.com\n\nserial 0b\n",
+			want:   []string{"b"},
+		},
+		{
+			name:   "hex",
+			format: FormatHex,
+			in:     "03e9a1\n\n  04:A1:B2  \nnot-hex\n",
+			want:   []string{"3e9a1", "4a1b2"},
+		},
+		{
+			name:    "unknown format",
+			format:  "csv",
+			in:      "serial 01\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadSerialsFormat(strings.NewReader(tt.in), int64(len(tt.in)), tt.format)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ReadSerialsFormat() returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadSerialsFormat() error = %v", err)
+			}
+			if g := serialStrings(got); strings.Join(g, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("ReadSerialsFormat() = %q, want %q", g, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSerialsLongLine(t *testing.T) {
+	// The rest of a line longer than the read buffer is discarded, rather
+	// than parsed as the next line.
+	in := "serial 01 names " + strings.Repeat("a", serialsReadBufferSize) + " serial 02\nserial 03\n"
+	got, err := ReadSerials(strings.NewReader(in), -1)
+	if err != nil {
+		t.Fatalf("ReadSerials() error = %v", err)
+	}
+	if g := serialStrings(got); strings.Join(g, " ") != "1 3" {
+		t.Errorf("ReadSerials() = %q, want [1 3]", g)
+	}
+}
+
+func TestLoadFileFormat(t *testing.T) {
+	f, err := ioutil.TempFile("", "serials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("serial 00ff names example.com\n")
+	f.Close()
+
+	got, err := LoadFile(f.Name())
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	// Leading zeroes are not significant.
+	if !got.Contains(big.NewInt(0xff)) || len(got) != 1 {
+		t.Errorf("LoadFile() = %q, want [ff]", serialStrings(got))
+	}
+	if _, err := LoadFileFormat(f.Name()+".missing", FormatLECAA); err == nil {
+		t.Errorf("LoadFileFormat() of a missing file returned no error")
+	}
+}
@@ -0,0 +1,32 @@
+package scanner
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+// stagingIssuerPrefixes are the prefixes of the common names of the
+// intermediates of the Let's Encrypt staging environment, such as
+// "Fake LE Intermediate X1" and "(STAGING) Artificial Apricot R3".
+var stagingIssuerPrefixes = []string{"Fake LE ", "(STAGING) "}
+
+// stagingIssuerOrganization is the organization of the newer intermediates of
+// the Let's Encrypt staging environment.
+const stagingIssuerOrganization = "(STAGING) Let's Encrypt"
+
+// IsStagingCertificate returns true if cert was issued by the Let's Encrypt
+// staging environment. Staging certificates are not trusted by browsers, so
+// they are only used for testing, and renewing them is a waste of time.
+func IsStagingCertificate(cert *x509.Certificate) bool {
+	for _, o := range cert.Issuer.Organization {
+		if o == stagingIssuerOrganization {
+			return true
+		}
+	}
+	for _, prefix := range stagingIssuerPrefixes {
+		if strings.HasPrefix(cert.Issuer.CommonName, prefix) {
+			return true
+		}
+	}
+	return false
+}
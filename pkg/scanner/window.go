@@ -0,0 +1,15 @@
+package scanner
+
+import "time"
+
+// Window is a period of time, such as the one in which the certificates
+// affected by an incident were issued.
+type Window struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains returns true if t falls within the window.
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.From) && !t.After(w.To)
+}
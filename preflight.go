@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	lecaa "github.com/jetstack/letsencrypt-caa-bug-checker/pkg/apis/lecaa/v1alpha1"
+	authorization "k8s.io/api/authorization/v1"
+	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var skipRBACPreflight bool
+
+func init() {
+	flag.BoolVar(&skipRBACPreflight, "skip-rbac-preflight", false, "If true, do not check that the tool has the RBAC permissions it needs before starting.")
+}
+
+// checkPermissions uses SelfSubjectAccessReviews to check that the current
+// identity has the permissions needed in the given mode, which is one of
+// "scan", "secrets", "watch", "serve", "inventory", "fix-stuck" or "doctor". All missing permissions are returned in a
+// single error, so that they can be granted at once rather than discovered
+// one at a time part way through a run.
+func checkPermissions(cfg *rest.Config, mode string) error {
+	if skipRBACPreflight {
+		return nil
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building Kubernetes client: %w", err)
+	}
+
+	type namespacedRules struct {
+		namespace string
+		rules     []rbac.PolicyRule
+	}
+	checks := []namespacedRules{{rules: clusterRoleRules(mode)}}
+	if mode == "serve" && leaderElect {
+		namespace, err := leaderElectionLeaseNamespace()
+		if err != nil {
+			return err
+		}
+		checks = append(checks, namespacedRules{namespace: namespace, rules: leaderElectionRules()})
+	}
+	if runLockRequired() {
+		checks = append(checks, namespacedRules{namespace: runLockNamespace, rules: leaderElectionRules()})
+	}
+	if certManagerPreflightRequired() && mode != "inventory" {
+		checks = append(checks, namespacedRules{namespace: certManagerNamespace, rules: certManagerPreflightRules()})
+	}
+	// CAABugScans are only reconciled if the CRD is installed, so their
+	// permissions are not required otherwise.
+	_, err = kubeClient.Discovery().ServerResourcesForGroupVersion(lecaa.SchemeGroupVersion.String())
+	caaBugScansInstalled := err == nil
+
+	var missing []string
+	for _, check := range checks {
+		for _, rule := range check.rules {
+			for _, group := range rule.APIGroups {
+				if group == lecaa.GroupName && !caaBugScansInstalled {
+					continue
+				}
+				for _, resource := range rule.Resources {
+					subresource := ""
+					if i := strings.Index(resource, "/"); i >= 0 {
+						resource, subresource = resource[:i], resource[i+1:]
+					}
+					for _, verb := range rule.Verbs {
+						review := &authorization.SelfSubjectAccessReview{
+							Spec: authorization.SelfSubjectAccessReviewSpec{
+								ResourceAttributes: &authorization.ResourceAttributes{
+									Namespace:   check.namespace,
+									Verb:        verb,
+									Group:       group,
+									Resource:    resource,
+									Subresource: subresource,
+								},
+							},
+						}
+						review, err := kubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+						if err != nil {
+							return fmt.Errorf("error checking RBAC permissions: %w", err)
+						}
+						if !review.Status.Allowed {
+							missing = append(missing, describePermission(review.Spec.ResourceAttributes))
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing RBAC permissions (set --skip-rbac-preflight to skip this check):\n  * %s", strings.Join(missing, "\n  * "))
+	}
+	log.Printf("RBAC preflight checks passed")
+	return nil
+}
+
+// describePermission formats attrs as, for example,
+// "update secrets in all namespaces".
+func describePermission(attrs *authorization.ResourceAttributes) string {
+	resource := attrs.Resource
+	if attrs.Group != "" {
+		resource += "." + attrs.Group
+	}
+	if attrs.Subresource != "" {
+		resource += "/" + attrs.Subresource
+	}
+	namespace := "all namespaces"
+	if attrs.Namespace != "" {
+		namespace = "namespace " + attrs.Namespace
+	}
+	return fmt.Sprintf("%s %s in %s", attrs.Verb, resource, namespace)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+var healthProbeAddr string
+
+func init() {
+	flag.StringVar(&healthProbeAddr, "health-probe-addr", ":8081", "The address to serve the /healthz and /readyz endpoints on when running the 'serve' command. Set to 0 to disable.")
+}
+
+// probes serves the liveness and readiness endpoints used by Kubernetes. It
+// is started before the affected serials are loaded so that the liveness
+// probe succeeds while they are, which can take some time.
+type probes struct {
+	discovery discovery.DiscoveryInterface
+	// datasetLoaded is set to 1 once the affected serials have been loaded.
+	datasetLoaded int32
+}
+
+// startProbes starts serving the /healthz and /readyz endpoints on
+// --health-probe-addr, if set.
+func startProbes(cfg *rest.Config) (*probes, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error building discovery client: %w", err)
+	}
+	p := &probes{discovery: dc}
+	if healthProbeAddr == "" || healthProbeAddr == "0" {
+		return p, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", http.StripPrefix("/healthz", &healthz.Handler{Checks: map[string]healthz.Checker{
+		"ping": healthz.Ping,
+	}}))
+	mux.Handle("/readyz", http.StripPrefix("/readyz", &healthz.Handler{Checks: map[string]healthz.Checker{
+		"dataset":   p.checkDataset,
+		"apiserver": p.checkAPIServer,
+	}}))
+	go func() {
+		log.Printf("Serving health probes on %s", healthProbeAddr)
+		if err := http.ListenAndServe(healthProbeAddr, mux); err != nil {
+			log.Printf("Health probe server failed: %v", err)
+		}
+	}()
+	return p, nil
+}
+
+// markDatasetLoaded records that the affected serials have been loaded.
+func (p *probes) markDatasetLoaded() {
+	atomic.StoreInt32(&p.datasetLoaded, 1)
+}
+
+func (p *probes) checkDataset(_ *http.Request) error {
+	if atomic.LoadInt32(&p.datasetLoaded) == 0 {
+		return errors.New("affected serials have not been loaded yet")
+	}
+	return nil
+}
+
+func (p *probes) checkAPIServer(_ *http.Request) error {
+	if _, err := p.discovery.ServerVersion(); err != nil {
+		return fmt.Errorf("unable to reach API server: %w", err)
+	}
+	return nil
+}
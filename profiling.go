@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+var (
+	pprofAddr       string
+	cpuProfileFile  string
+	heapProfileFile string
+)
+
+func init() {
+	flag.StringVar(&pprofAddr, "pprof-addr", "", "If set, serve net/http/pprof profiling endpoints on this address (e.g. 'localhost:6060') while the tool runs.")
+	flag.StringVar(&cpuProfileFile, "cpu-profile", "", "If set, write a CPU profile covering the whole run to this file.")
+	flag.StringVar(&heapProfileFile, "heap-profile", "", "If set, write a heap profile to this file at the end of the run.")
+}
+
+// startProfiling starts any profiling enabled by flags. The returned function
+// must be called before the tool exits to write out any profiles.
+func startProfiling() (func(), error) {
+	if pprofAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go func() {
+			log.Printf("Serving pprof endpoints on http://%s/debug/pprof/", pprofAddr)
+			if err := http.ListenAndServe(pprofAddr, mux); err != nil {
+				log.Printf("Failed to serve pprof endpoints: %v", err)
+			}
+		}()
+	}
+
+	var cpuProfile *os.File
+	if cpuProfileFile != "" {
+		f, err := os.Create(cpuProfileFile)
+		if err != nil {
+			return nil, fmt.Errorf("error creating CPU profile: %w", err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error starting CPU profile: %w", err)
+		}
+		cpuProfile = f
+	}
+
+	return func() {
+		if cpuProfile != nil {
+			rpprof.StopCPUProfile()
+			cpuProfile.Close()
+			log.Printf("Wrote CPU profile to %q", cpuProfileFile)
+		}
+		if heapProfileFile != "" {
+			if err := writeHeapProfile(heapProfileFile); err != nil {
+				log.Printf("Failed to write heap profile: %v", err)
+				return
+			}
+			log.Printf("Wrote heap profile to %q", heapProfileFile)
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Run a garbage collection first so the profile reflects live memory.
+	runtime.GC()
+	return rpprof.WriteHeapProfile(f)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var readOnly bool
+
+func init() {
+	flag.BoolVar(&readOnly, "read-only", false, "If true, the tool is guaranteed not to modify the cluster: every request to the API server other than a read is refused before it is sent, and flags that would need to write, such as --renew, are rejected. For audits.")
+}
+
+func validateReadOnlyFlags() error {
+	if !readOnly {
+		return nil
+	}
+	if renew {
+		return fmt.Errorf("--read-only cannot be combined with --renew")
+	}
+	if namespaceReports {
+		return fmt.Errorf("--read-only cannot be combined with --namespace-reports, as the reports are written to ConfigMaps")
+	}
+	return nil
+}
+
+// readOnlyTransport refuses every request that could modify the cluster,
+// so that --read-only is enforced for every client built from the same
+// configuration rather than relying on each caller to check the flag.
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func wrapReadOnly(rt http.RoundTripper) http.RoundTripper {
+	return &readOnlyTransport{next: rt}
+}
+
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !readOnlyRequest(req) {
+		return nil, fmt.Errorf("refusing to send %s %s, as --read-only is set", req.Method, req.URL.Path)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// readOnlyRequest returns true if req cannot modify the cluster. Reviews of
+// the caller's own permissions are created with POST, but are not persisted.
+func readOnlyRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		return strings.HasPrefix(req.URL.Path, "/apis/authorization.k8s.io/") &&
+			(strings.HasSuffix(req.URL.Path, "/selfsubjectaccessreviews") || strings.HasSuffix(req.URL.Path, "/selfsubjectrulesreviews"))
+	default:
+		return false
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	redact         bool
+	redactSaltFile string
+)
+
+func init() {
+	flag.BoolVar(&redact, "redact", false, "If true, namespaces, names and DNS names are replaced with salted hashes in reports and in notifications sent to webhooks, Slack, Teams, PagerDuty and Opsgenie, so that they can be shared outside the organisation.")
+	flag.StringVar(&redactSaltFile, "redact-salt-file", "", "Path to a file holding the secret salt used by --redact. The same salt always gives the same hashes, so that the results of different runs can be compared. If not set, a random salt is used for each run.")
+}
+
+// outputRedactor redacts reports and notifications if --redact is set. It is
+// set up by setupRedaction.
+var outputRedactor *redactor
+
+// setupRedaction sets up outputRedactor from flags.
+func setupRedaction() error {
+	outputRedactor = nil
+	if !redact {
+		return nil
+	}
+	var salt []byte
+	if redactSaltFile != "" {
+		data, err := ioutil.ReadFile(redactSaltFile)
+		if err != nil {
+			return fmt.Errorf("error reading --redact-salt-file: %w", err)
+		}
+		if salt = bytes.TrimSpace(data); len(salt) == 0 {
+			return fmt.Errorf("--redact-salt-file %q is empty", redactSaltFile)
+		}
+	} else {
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		log.Printf("WARNING: --redact-salt-file is not set, so a random salt is used and redacted names will not match those of other runs")
+	}
+	outputRedactor = &redactor{salt: salt, known: make(map[string]bool)}
+	return nil
+}
+
+// redactedPrefix starts every redacted value, so that redacted reports are
+// recognisable, and so that redacting twice is harmless.
+const redactedPrefix = "redacted-"
+
+var (
+	// quotedPattern matches the quoted names in error messages, which are
+	// formatted with %q.
+	quotedPattern = regexp.MustCompile(`"[^"\\]*"`)
+	// dnsNamePattern matches DNS names in free text.
+	dnsNamePattern = regexp.MustCompile(`\b(?:\*\.)?(?:[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?\.)+[A-Za-z]{2,}\b`)
+	// refPattern matches namespace/name references in free text.
+	refPattern = regexp.MustCompile(`[A-Za-z0-9][A-Za-z0-9.-]*/[A-Za-z0-9][A-Za-z0-9.-]*`)
+)
+
+// redactor replaces names with stable salted hashes. It remembers every name
+// it has replaced, so that references to them can also be replaced in free
+// text such as error messages. It is safe for concurrent use.
+type redactor struct {
+	salt  []byte
+	lock  sync.Mutex
+	known map[string]bool
+}
+
+// name returns the redacted form of a namespace or name.
+func (r *redactor) name(s string) string {
+	if s == "" || strings.HasPrefix(s, redactedPrefix) {
+		return s
+	}
+	r.lock.Lock()
+	r.known[s] = true
+	r.lock.Unlock()
+	return r.hash(s)
+}
+
+func (r *redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(s))
+	return redactedPrefix + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// ref returns the redacted form of a namespace/name reference, redacting each
+// part separately so that it still matches the redacted namespace and name.
+func (r *redactor) ref(s string) string {
+	parts := strings.Split(s, "/")
+	for i := range parts {
+		parts[i] = r.name(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func (r *redactor) names(names []string) []string {
+	if names == nil {
+		return nil
+	}
+	redacted := make([]string, len(names))
+	for i, s := range names {
+		redacted[i] = r.ref(s)
+	}
+	return redacted
+}
+
+// text redacts free text, such as an error message. Quoted strings, DNS names
+// and namespace/name references to a namespace redacted so far are replaced,
+// which errs on the side of redacting too much. Other words are left alone
+// even if they are names, as short names are often also English words.
+func (r *redactor) text(s string) string {
+	if s == "" {
+		return s
+	}
+	s = quotedPattern.ReplaceAllStringFunc(s, func(q string) string {
+		return `"` + r.ref(q[1:len(q)-1]) + `"`
+	})
+	s = refPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		// A reference may end a sentence.
+		trimmed := strings.TrimRight(ref, ".")
+		r.lock.Lock()
+		known := r.known[strings.SplitN(trimmed, "/", 2)[0]]
+		r.lock.Unlock()
+		if !known {
+			return ref
+		}
+		return r.ref(trimmed) + ref[len(trimmed):]
+	})
+	return dnsNamePattern.ReplaceAllStringFunc(s, r.name)
+}
+
+// report redacts a report in place. Names are redacted before free text, so
+// that they are recognised in it.
+func (r *redactor) report(rep *report) {
+	if rep.Redacted {
+		return
+	}
+	rep.Redacted = true
+	for i := range rep.Affected {
+		a := &rep.Affected[i]
+		a.Namespace = r.name(a.Namespace)
+		a.Name = r.name(a.Name)
+		a.SecretName = r.name(a.SecretName)
+		a.ReplicatedFrom = r.ref(a.ReplicatedFrom)
+		a.SharesSecretWith = r.names(a.SharesSecretWith)
+	}
+	for i := range rep.InProgress {
+		c := &rep.InProgress[i]
+		c.Namespace = r.name(c.Namespace)
+		c.Name = r.name(c.Name)
+		c.SecretName = r.name(c.SecretName)
+	}
+	rep.TerminatingNamespaces = r.names(rep.TerminatingNamespaces)
+	for i := range rep.Conflicts {
+		c := &rep.Conflicts[i]
+		c.Namespace = r.name(c.Namespace)
+		c.SecretName = r.name(c.SecretName)
+		c.Certificates = r.names(c.Certificates)
+	}
+	for i := range rep.FailedNamespaces {
+		rep.FailedNamespaces[i].Namespace = r.name(rep.FailedNamespaces[i].Namespace)
+	}
+	for i := range rep.AffectedAccounts {
+		a := &rep.AffectedAccounts[i]
+		a.Namespace = r.name(a.Namespace)
+		a.Name = r.name(a.Name)
+	}
+	for i := range rep.IssuerBlockers {
+		b := &rep.IssuerBlockers[i]
+		b.Namespace = r.name(b.Namespace)
+		b.Name = r.name(b.Name)
+		b.Certificates = r.names(b.Certificates)
+	}
+	if c := rep.ConfigMaps; c != nil {
+		for i := range c.Affected {
+			c.Affected[i].Namespace = r.name(c.Affected[i].Namespace)
+			c.Affected[i].Name = r.name(c.Affected[i].Name)
+		}
+		for i := range c.FailedNamespaces {
+			c.FailedNamespaces[i].Namespace = r.name(c.FailedNamespaces[i].Namespace)
+		}
+	}
+	if rep.Timings != nil {
+		for i := range rep.Timings.SlowestNamespaces {
+			rep.Timings.SlowestNamespaces[i].Namespace = r.name(rep.Timings.SlowestNamespaces[i].Namespace)
+		}
+	}
+
+	for i := range rep.Affected {
+		a := &rep.Affected[i]
+		a.Reason = r.text(a.Reason)
+		a.Warning = r.text(a.Warning)
+		a.IssuerProblem = r.text(a.IssuerProblem)
+		a.DryRunError = r.text(a.DryRunError)
+		if a.Rotation != nil {
+			a.Rotation.Problem = r.text(a.Rotation.Problem)
+		}
+		for j, s := range a.Suggestions {
+			a.Suggestions[j] = r.text(s)
+		}
+	}
+	for i := range rep.IssuerBlockers {
+		rep.IssuerBlockers[i].Problem = r.text(rep.IssuerBlockers[i].Problem)
+	}
+	for i := range rep.FailedNamespaces {
+		rep.FailedNamespaces[i].Error = r.text(rep.FailedNamespaces[i].Error)
+	}
+	if c := rep.ConfigMaps; c != nil {
+		for i := range c.Affected {
+			c.Affected[i].Reason = r.text(c.Affected[i].Reason)
+		}
+		for i := range c.FailedNamespaces {
+			c.FailedNamespaces[i].Error = r.text(c.FailedNamespaces[i].Error)
+		}
+	}
+}
+
+// outcomes redacts the outcomes of a run in place.
+func (r *redactor) outcomes(o *outcomes) {
+	o.Redacted = true
+	for i := range o.Outcomes {
+		c := &o.Outcomes[i]
+		c.Namespace = r.name(c.Namespace)
+		c.Name = r.name(c.Name)
+		c.SecretName = r.name(c.SecretName)
+		c.CertificateRequest = r.name(c.CertificateRequest)
+		c.DeletedCertificateRequests = r.names(c.DeletedCertificateRequests)
+	}
+	for i := range o.Outcomes {
+		o.Outcomes[i].Error = r.text(o.Outcomes[i].Error)
+	}
+}
+
+// inventory redacts an inventory in place. Names are redacted before free
+// text, so that they are recognised in it. The names of CAs are free text,
+// so that those of self-signed certificates, which are often DNS names, are
+// redacted.
+func (r *redactor) inventory(inv *inventory) {
+	if inv.Redacted {
+		return
+	}
+	inv.Redacted = true
+	byIssuerRef := make(map[string]int, len(inv.ByIssuerRef))
+	for ref, n := range inv.ByIssuerRef {
+		byIssuerRef[r.issuerRef(ref)] += n
+	}
+	inv.ByIssuerRef = byIssuerRef
+	for i := range inv.Certificates {
+		c := &inv.Certificates[i]
+		c.Namespace = r.name(c.Namespace)
+		c.SecretName = r.name(c.SecretName)
+		c.Certificates = r.names(c.Certificates)
+		c.IssuerRef = r.issuerRef(c.IssuerRef)
+	}
+	for i := range inv.FailedNamespaces {
+		inv.FailedNamespaces[i].Namespace = r.name(inv.FailedNamespaces[i].Namespace)
+	}
+
+	byIssuer := make(map[string]int, len(inv.ByIssuer))
+	for ca, n := range inv.ByIssuer {
+		byIssuer[r.text(ca)] += n
+	}
+	inv.ByIssuer = byIssuer
+	for i := range inv.Certificates {
+		c := &inv.Certificates[i]
+		c.Subject = r.text(c.Subject)
+		c.Issuer = r.text(c.Issuer)
+		c.CA = r.text(c.CA)
+		for j, n := range c.DNSNames {
+			c.DNSNames[j] = r.text(n)
+		}
+		c.Problem = r.text(c.Problem)
+	}
+	for i := range inv.FailedNamespaces {
+		inv.FailedNamespaces[i].Error = r.text(inv.FailedNamespaces[i].Error)
+	}
+}
+
+// issuerRef redacts the name in a kind/name issuer reference.
+func (r *redactor) issuerRef(ref string) string {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 {
+		return r.name(ref)
+	}
+	return parts[0] + "/" + r.name(parts[1])
+}
+
+// finding returns a redacted copy of f.
+func (r *redactor) finding(f finding) finding {
+	f.Namespace = r.name(f.Namespace)
+	f.Name = r.name(f.Name)
+	f.SecretName = r.name(f.SecretName)
+	f.IssuerName = r.name(f.IssuerName)
+	f.CertificateRequest = r.name(f.CertificateRequest)
+	f.ReplicatedFrom = r.ref(f.ReplicatedFrom)
+	f.SharesSecretWith = r.names(f.SharesSecretWith)
+	f.Reason = r.text(f.Reason)
+	f.Warning = r.text(f.Warning)
+	f.IssuerProblem = r.text(f.IssuerProblem)
+	f.RenewalError = r.text(f.RenewalError)
+	if f.Rotation != nil {
+		rotation := *f.Rotation
+		rotation.Problem = r.text(rotation.Problem)
+		f.Rotation = &rotation
+	}
+	if f.Suggestions != nil {
+		suggestions := make([]string, len(f.Suggestions))
+		for i, s := range f.Suggestions {
+			suggestions[i] = r.text(s)
+		}
+		f.Suggestions = suggestions
+	}
+	return f
+}
+
+// notification returns a redacted copy of n.
+func (r *redactor) notification(n *scanNotification) *scanNotification {
+	redacted := *n
+	// Every name is known before any free text is redacted.
+	for _, f := range n.Certificates {
+		r.name(f.Namespace)
+		r.name(f.Name)
+		r.name(f.SecretName)
+	}
+	redacted.Summary.FailedNamespaces = r.names(n.Summary.FailedNamespaces)
+	redacted.Certificates = make([]finding, len(n.Certificates))
+	for i, f := range n.Certificates {
+		redacted.Certificates[i] = r.finding(f)
+	}
+	redacted.Summary.Error = r.text(n.Summary.Error)
+	return &redacted
+}
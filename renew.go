@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	remediatorName             string
+	remediatorCommand          string
+	remediatorTimeout          time.Duration
+	remediatorWebhookURL       string
+	remediatorWebhookTokenFile string
+)
+
+func init() {
+	flag.StringVar(&remediatorName, "remediator", "cert-manager", "How affected Certificates are fixed when --renew is set: 'cert-manager' triggers a renewal by cert-manager, 'exec' runs --remediator-command and 'webhook' calls --remediator-webhook-url.")
+	flag.StringVar(&remediatorCommand, "remediator-command", "", "The command (and any space separated arguments) run for each affected Certificate if --remediator=exec. It is given a JSON description of the Certificate on stdin, and must exit successfully once it has been remediated.")
+	flag.DurationVar(&remediatorTimeout, "remediator-timeout", time.Minute, "How long --remediator-command may run for each Certificate.")
+	flag.StringVar(&remediatorWebhookURL, "remediator-webhook-url", "", "The URL a JSON description of each affected Certificate is POSTed to if --remediator=webhook.")
+	flag.StringVar(&remediatorWebhookTokenFile, "remediator-webhook-token-file", "", "Optional path to a file containing a bearer token sent to --remediator-webhook-url.")
+}
+
+// validateRemediatorFlags checks that the flags needed by --remediator are
+// set.
+func validateRemediatorFlags() error {
+	switch remediatorName {
+	case "cert-manager":
+	case "exec":
+		if len(strings.Fields(remediatorCommand)) == 0 {
+			return fmt.Errorf("--remediator-command must be set when --remediator=exec")
+		}
+	case "webhook":
+		if remediatorWebhookURL == "" {
+			return fmt.Errorf("--remediator-webhook-url must be set when --remediator=webhook")
+		}
+	default:
+		return fmt.Errorf("unknown --remediator %q, must be one of cert-manager, exec or webhook", remediatorName)
+	}
+	if fixturesDir != "" && remediatorName != "cert-manager" {
+		return fmt.Errorf("--fixtures can only simulate --remediator=cert-manager")
+	}
+	return nil
+}
+
+// newRemediator returns the Remediator selected by --remediator.
+func newRemediator(cl client.Client) (renewer.Remediator, error) {
+	r, err := newBaseRemediator(cl)
+	if err != nil {
+		return nil, err
+	}
+	r = wrapRenewHooks(cl, r)
+	if serveHealthCheck != nil {
+		// The check is made before the hooks, which are not run if the
+		// renewal cannot go through.
+		r = &healthCheckedRemediator{health: serveHealthCheck, next: r}
+	}
+	return r, nil
+}
+
+func newBaseRemediator(cl client.Client) (renewer.Remediator, error) {
+	switch remediatorName {
+	case "exec":
+		args := strings.Fields(remediatorCommand)
+		return withRemediationEvents(&renewer.ExecRemediator{Command: args[0], Args: args[1:], Timeout: remediatorTimeout, RunID: runID}), nil
+	case "webhook":
+		w := &renewer.WebhookRemediator{URL: remediatorWebhookURL, RunID: runID}
+		if remediatorWebhookTokenFile != "" {
+			token, err := readKeyFile(remediatorWebhookTokenFile, "remediator webhook token")
+			if err != nil {
+				return nil, err
+			}
+			w.Headers = map[string]string{"Authorization": "Bearer " + token}
+		}
+		return withRemediationEvents(w), nil
+	default:
+		return newRenewer(cl), nil
+	}
+}
+
+// newRenewer returns a Renewer configured from flags.
+func newRenewer(cl client.Client) *renewer.Renewer {
+	return &renewer.Renewer{
+		Client:                cl,
+		CleanupFailedRequests: cleanupFailedRequests,
+		Tracer:                libraryTracer{},
+		Audit:                 auditLog.record,
+		Annotations:           map[string]string{runIDAnnotation: runID},
+		Events:                scannerEvents(),
+	}
+}
+
+// renewCertificate remediates cert using the Remediator selected by
+// --remediator.
+func renewCertificate(ctx context.Context, cl client.Client, cert capi.Certificate) error {
+	r, err := newRemediator(cl)
+	if err != nil {
+		return err
+	}
+	return r.Remediate(ctx, cert)
+}
+
+// listFailedCertificateRequests logs the failed or denied CertificateRequests
+// that would be deleted by --cleanup-failed-requests, without deleting them.
+func listFailedCertificateRequests(ctx context.Context, cl client.Client, affected []capi.Certificate) error {
+	log.Println()
+	log.Printf("The following failed CertificateRequests would be deleted if --renew is set:")
+	r := newRenewer(cl)
+	found := 0
+	for _, cert := range affected {
+		requests, err := r.FailedCertificateRequests(ctx, cert)
+		if err != nil {
+			return fmt.Errorf("error listing CertificateRequest resources: %w", err)
+		}
+		for _, req := range requests {
+			log.Printf("  * %s/%s (Certificate: %s)", req.Namespace, req.Name, cert.Name)
+			found++
+		}
+	}
+	if found == 0 {
+		log.Printf("  (none)")
+	}
+	return nil
+}
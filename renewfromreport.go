@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var fromReportFile string
+
+func init() {
+	flag.StringVar(&fromReportFile, "from-report", "", "The report written by --report-file of an earlier scan, whose affected Certificates are renewed by the 'renew' command without scanning the cluster again.")
+}
+
+// runRenewFromReport implements the 'renew' command, which runs the renewal
+// phase of a scan against the affected Certificates in --from-report instead
+// of scanning the cluster, for example when the scan was run earlier by a
+// read-only identity and the renewals have since been approved. As with a
+// scan, renewals are only triggered if --renew is set. Certificates whose
+// Secret no longer holds the affected certificate in the report have been
+// renewed some other way since, and are skipped.
+func runRenewFromReport(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	if fromReportFile == "" {
+		return fmt.Errorf("--from-report must be specified")
+	}
+	start := time.Now()
+	if err := validateRenewFromReportFlags(); err != nil {
+		return err
+	}
+	previous, err := readReport(fromReportFile)
+	if err != nil {
+		return err
+	}
+	switch {
+	case previous.Redacted:
+		return fmt.Errorf("report %q was written with --redact, so the Certificates in it cannot be found", fromReportFile)
+	case previous.SecretsOnly:
+		return fmt.Errorf("report %q was written with --secrets-only, and Secrets that are not managed by cert-manager cannot be renewed by this tool", fromReportFile)
+	case previous.Estimate != nil:
+		return fmt.Errorf("report %q was written by a sampled scan, so it does not list every affected Certificate. Run a full scan instead", fromReportFile)
+	}
+	log.Printf("Renewing the %d affected Certificates in report %q, generated %s ago", len(previous.Affected), fromReportFile, time.Since(previous.GeneratedAt).Round(time.Minute))
+	if previous.Partial {
+		log.Printf("WARNING: report %q is partial, as %d namespace(s) could not be scanned, so affected Certificates in them will not be renewed", fromReportFile, len(previous.FailedNamespaces))
+	}
+	if renew {
+		log.Printf("!!!!! --renew has been set to TRUE. The affected certificates in the report will have a renewal triggered !!!!!")
+	}
+
+	var cl client.Client
+	if fixturesDir != "" {
+		sim, err := loadFixtures(fixturesDir)
+		if err != nil {
+			return err
+		}
+		defer sim.logSummary()
+		if !sim.hasIssuers {
+			log.Printf("The fixtures contain no Issuers or ClusterIssuers, so the issuers of affected Certificates will not be checked")
+			fixturesHaveIssuers = false
+		}
+		cl = sim
+	} else {
+		cfg := restConfig()
+		if cl, err = newClient(cfg); err != nil {
+			return err
+		}
+		if err := checkCertificateCRD(cfg); err != nil {
+			return err
+		}
+		if err := checkPermissions(cfg, "scan"); err != nil {
+			return err
+		}
+		if renew {
+			closeAuditLog, err := openAuditLog()
+			if err != nil {
+				return err
+			}
+			defer closeAuditLog()
+			releaseRunLock, err := acquireRunLock(cfg)
+			if err != nil {
+				return err
+			}
+			defer releaseRunLock()
+		}
+	}
+	closeEventStream, err := openEventStream()
+	if err != nil {
+		return err
+	}
+	defer closeEventStream()
+	// The serials are only needed to check the certificates issued to
+	// canaries.
+	serials, _, err := loadSerials()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	report := &scanner.Report{}
+	for _, a := range previous.Affected {
+		if affected, ok := reportedAffected(ctx, cl, a); ok {
+			report.Affected = append(report.Affected, affected)
+		}
+	}
+	report.Checked = len(report.Affected)
+	log.Printf("%d of the %d Certificates in the report still hold the affected certificate", len(report.Affected), len(previous.Affected))
+	results := &scanResults{Report: report, timings: newTimings(), scanner: newScanner(cl, serials)}
+	err = renewAffected(ctx, cl, results)
+	checkRotations(ctx, cl, results)
+	if renew {
+		if err := writeOutcomes("renew", start, renewalOutcomes(ctx, cl, results)); err != nil {
+			return fmt.Errorf("error writing outcomes: %w", err)
+		}
+	}
+	return err
+}
+
+// validateRenewFromReportFlags checks the flags used by the renewal phase,
+// which are otherwise only checked before a scan.
+func validateRenewFromReportFlags() error {
+	if err := validateRemediatorFlags(); err != nil {
+		return err
+	}
+	if err := validateDryRunFlags(); err != nil {
+		return err
+	}
+	if err := validateCanaryFlags(); err != nil {
+		return err
+	}
+	if canaryCount > 0 && affectedSerialsFile == "" && newDetector() == nil {
+		return fmt.Errorf("--canary requires --affected-serials-file or a detector, to check the certificates issued to the canaries")
+	}
+	if err := validateIssuerConcurrencyFlags(); err != nil {
+		return err
+	}
+	if err := validateRotationFlags(); err != nil {
+		return err
+	}
+	return validateRenewHookFlags()
+}
+
+// reportedAffected returns a, an affected Certificate in a report, as it is
+// now, so that it is renewed as it would be after a scan. It returns false if
+// the Certificate has been deleted, or its Secret no longer holds the
+// certificate in the report.
+func reportedAffected(ctx context.Context, cl client.Reader, a reportCertificate) (scanner.AffectedCertificate, bool) {
+	key := a.Namespace + "/" + a.Name
+	var crt capi.Certificate
+	err := cl.Get(ctx, client.ObjectKey{Namespace: a.Namespace, Name: a.Name}, &crt)
+	switch {
+	case apierrors.IsNotFound(err):
+		log.Printf("Certificate %s has been deleted since the report was generated, skipping...", key)
+		return scanner.AffectedCertificate{}, false
+	case err != nil:
+		log.Printf("WARNING: unable to get Certificate %s, skipping: %v", key, err)
+		return scanner.AffectedCertificate{}, false
+	}
+	switch serial := secretSerial(ctx, cl, crt); serial {
+	case a.Serial:
+	case "":
+		log.Printf("WARNING: unable to read the certificate in Secret %s/%s of Certificate %s, skipping...", crt.Namespace, crt.Spec.SecretName, key)
+		return scanner.AffectedCertificate{}, false
+	default:
+		log.Printf("Certificate %s now holds serial number %s rather than %s from the report, so it has already been renewed, skipping...", key, serial, a.Serial)
+		return scanner.AffectedCertificate{}, false
+	}
+	return scanner.AffectedCertificate{
+		Certificate:      crt,
+		Serial:           a.Serial,
+		Reason:           a.Reason,
+		ReplicatedFrom:   a.ReplicatedFrom,
+		SharesSecretWith: a.SharesSecretWith,
+		PausedBy:         a.PausedBy,
+		Warning:          a.Warning,
+		IssuerProblem:    a.IssuerProblem,
+	}, true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/renewer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	preRenewHook      string
+	postRenewHook     string
+	renewHookTimeout  time.Duration
+	postRenewHookWait time.Duration
+)
+
+func init() {
+	flag.StringVar(&preRenewHook, "pre-renew-hook", "", "The command (and any space separated arguments) run before each affected Certificate is renewed, with the Certificate described in LECAA_* environment variables and as JSON on stdin. The Certificate is not renewed if it fails.")
+	flag.StringVar(&postRenewHook, "post-renew-hook", "", "The command (and any space separated arguments) run after each affected Certificate is renewed, whether or not the renewal succeeded, with the Certificate described as for --pre-renew-hook, and LECAA_RENEWAL_RESULT set to 'success' or 'failure'.")
+	flag.DurationVar(&renewHookTimeout, "renew-hook-timeout", time.Minute, "How long --pre-renew-hook and --post-renew-hook may run for each Certificate.")
+	flag.DurationVar(&postRenewHookWait, "post-renew-hook-wait", 15*time.Minute, "How long to wait for cert-manager to issue the new certificate before running --post-renew-hook. Set to 0 to run it as soon as the renewal has been triggered.")
+}
+
+func validateRenewHookFlags() error {
+	if preRenewHook == "" && postRenewHook == "" {
+		return nil
+	}
+	if renewHookTimeout <= 0 {
+		return fmt.Errorf("--renew-hook-timeout must be positive")
+	}
+	if postRenewHookWait < 0 {
+		return fmt.Errorf("--post-renew-hook-wait must not be negative")
+	}
+	return nil
+}
+
+// newHook returns the Hook running command, or nil if it is not set.
+func newHook(command string) *renewer.Hook {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+	return &renewer.Hook{Command: args[0], Args: args[1:], Timeout: renewHookTimeout, RunID: runID}
+}
+
+// wrapRenewHooks returns r, running --pre-renew-hook and --post-renew-hook
+// around each remediation if they are set.
+func wrapRenewHooks(cl client.Client, r renewer.Remediator) renewer.Remediator {
+	pre, post := newHook(preRenewHook), newHook(postRenewHook)
+	if pre == nil && post == nil {
+		return r
+	}
+	return &hookRemediator{cl: cl, next: r, pre: pre, post: post}
+}
+
+// hookRemediator is a Remediator running the renewal hooks around another.
+type hookRemediator struct {
+	cl        client.Client
+	next      renewer.Remediator
+	pre, post *renewer.Hook
+}
+
+// Remediate implements renewer.Remediator. The post-renewal hook is run even
+// if remediation fails, as the pre-renewal hook may have taken servers out of
+// service that it puts back.
+func (h *hookRemediator) Remediate(ctx context.Context, crt capi.Certificate) error {
+	name := crt.Namespace + "/" + crt.Name
+	if h.pre != nil {
+		if fixturesDir != "" {
+			log.Printf("[simulation] Would run --pre-renew-hook for Certificate %s", name)
+		} else {
+			log.Printf("Running --pre-renew-hook for Certificate %s", name)
+			if err := h.pre.Run(ctx, crt, "LECAA_HOOK=pre"); err != nil {
+				return fmt.Errorf("not renewing, as --pre-renew-hook failed: %w", err)
+			}
+		}
+	}
+	previous := secretSerial(ctx, h.cl, crt)
+	since := time.Now()
+	err := h.next.Remediate(ctx, crt)
+	if h.post == nil {
+		return err
+	}
+	if fixturesDir != "" {
+		log.Printf("[simulation] Would run --post-renew-hook for Certificate %s", name)
+		return err
+	}
+	// Only cert-manager is known to store the new certificate in the Secret.
+	if _, ok := h.next.(*renewer.Renewer); ok && err == nil && postRenewHookWait > 0 {
+		log.Printf("Waiting up to %s for Certificate %s to be issued a new certificate before running --post-renew-hook", postRenewHookWait, name)
+		_, err = newRenewer(h.cl).WaitForIssuance(ctx, crt, previous, since, postRenewHookWait)
+	}
+	result := []string{"LECAA_HOOK=post", "LECAA_RENEWAL_RESULT=success"}
+	if err != nil {
+		result = []string{"LECAA_HOOK=post", "LECAA_RENEWAL_RESULT=failure", "LECAA_RENEWAL_ERROR=" + err.Error()}
+	}
+	log.Printf("Running --post-renew-hook for Certificate %s", name)
+	if hookErr := h.post.Run(ctx, crt, result...); hookErr != nil {
+		if err != nil {
+			log.Printf("WARNING: --post-renew-hook failed for Certificate %s: %v", name, hookErr)
+			return err
+		}
+		return fmt.Errorf("renewed, but --post-renew-hook failed: %w", hookErr)
+	}
+	return err
+}
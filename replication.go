@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// renewalTargets returns the Certificates that need to be renewed to fix the
+// given affected Certificates, each at most once. Certificates whose Secret
+// is a replica are fixed by renewing the Certificate of the source Secret
+// instead, which is looked up if it was not itself found to be affected, for
+// example because its namespace was not scanned.
+func renewalTargets(ctx context.Context, cl client.Reader, affected []scanner.AffectedCertificate) []capi.Certificate {
+	targets, _ := selectRenewalTargets(ctx, cl, affected)
+	return targets
+}
+
+// selectRenewalTargets is renewalTargets, also returning why each affected
+// Certificate that will not be fixed, by renewing it or the source of its
+// Secret, is refused, keyed by namespace/name.
+func selectRenewalTargets(ctx context.Context, cl client.Reader, affected []scanner.AffectedCertificate) ([]capi.Certificate, map[string]string) {
+	var targets []capi.Certificate
+	refused := make(map[string]string)
+	blockers := newIssuerBlockers(cl)
+	defer blockers.log()
+	duplicates := newDuplicateChecker(cl)
+	// decided holds the refusal of each Certificate already considered, or
+	// "" if it is a target.
+	decided := make(map[types.NamespacedName]string)
+	add := func(crt capi.Certificate) string {
+		key := types.NamespacedName{Namespace: crt.Namespace, Name: crt.Name}
+		if reason, ok := decided[key]; ok {
+			return reason
+		}
+		reason := ""
+		if annotation, ok := scanner.PausedBy(&crt, pausedAnnotations()); ok {
+			logPaused(crt, annotation)
+			reason = fmt.Sprintf("it is paused by annotation %q", annotation)
+		} else if problem := blockers.blocks(ctx, crt); problem != "" {
+			reason = "it is unrenewable: " + problem
+		} else if reason = duplicates.blocks(ctx, crt); reason == "" {
+			targets = append(targets, crt)
+		}
+		decided[key] = reason
+		return reason
+	}
+	var sources []string
+	replicas := make(map[string][]string)
+	for _, a := range affected {
+		key := a.Certificate.Namespace + "/" + a.Certificate.Name
+		if len(a.SharesSecretWith) > 0 {
+			logSharedSecret(a.Certificate, a.SharesSecretWith)
+			refused[key] = fmt.Sprintf("its Secret %q is also used by Certificate(s) %s", a.Certificate.Spec.SecretName, strings.Join(a.SharesSecretWith, ", "))
+			continue
+		}
+		if a.Warning != "" {
+			// Decided so that it is not renewed as the source of a replica
+			// either.
+			reason := "it matched the affected serials, but " + a.Warning
+			decided[types.NamespacedName{Namespace: a.Certificate.Namespace, Name: a.Certificate.Name}] = reason
+			refused[key] = reason
+			logUntrusted(a.Certificate, a.Warning)
+			continue
+		}
+		if a.ReplicatedFrom == "" {
+			if reason := add(a.Certificate); reason != "" {
+				refused[key] = reason
+			}
+			continue
+		}
+		log.Printf("Certificate %s uses a replica of Secret %s, and will be fixed by replication", key, a.ReplicatedFrom)
+		if _, ok := replicas[a.ReplicatedFrom]; !ok {
+			sources = append(sources, a.ReplicatedFrom)
+		}
+		replicas[a.ReplicatedFrom] = append(replicas[a.ReplicatedFrom], key)
+	}
+	for _, source := range sources {
+		reason := ""
+		if crt, ok := sourceCertificate(ctx, cl, affected, source); !ok {
+			log.Printf("WARNING: no Certificate manages Secret %s, which is replicated to affected Certificates, so it must be replaced manually", source)
+			reason = fmt.Sprintf("its Secret is a replica of Secret %s, which no Certificate manages", source)
+		} else if r := add(crt); r != "" {
+			reason = fmt.Sprintf("its Secret is a replica of that of Certificate %s/%s, which is not renewed, as %s", crt.Namespace, crt.Name, r)
+		}
+		if reason != "" {
+			for _, key := range replicas[source] {
+				refused[key] = reason
+			}
+		}
+	}
+	return targets, refused
+}
+
+// logPaused explains why crt, which is paused by the given annotation, will
+// not be renewed.
+func logPaused(crt capi.Certificate, annotation string) {
+	log.Printf("WARNING: NOT renewing Certificate %s/%s, as it is paused by annotation %q, so cert-manager would ignore the renewal. "+
+		"Remove the annotation and run again.", crt.Namespace, crt.Name, annotation)
+}
+
+// logUntrusted explains why crt, which matched the affected serials but with
+// the given warning, will not be renewed.
+func logUntrusted(crt capi.Certificate, warning string) {
+	log.Printf("WARNING: NOT renewing Certificate %s/%s, as it matched the affected serials, but %s. "+
+		"Check that the dataset is correct, and renew the Certificate by hand if it really is affected.", crt.Namespace, crt.Name, warning)
+}
+
+// logSharedSecret explains why crt, whose Secret is also used by the
+// Certificates named others, will not be renewed.
+func logSharedSecret(crt capi.Certificate, others []string) {
+	log.Printf("WARNING: NOT renewing Certificate %s/%s, as its Secret %q is also used by Certificate(s) %s in the same namespace. "+
+		"cert-manager would reconcile them against each other, and triggering a renewal would make them fight over the Secret. "+
+		"Give each Certificate its own spec.secretName, or delete the duplicates, and run again.",
+		crt.Namespace, crt.Name, crt.Spec.SecretName, strings.Join(others, ", "))
+}
+
+// countReplicas returns the number of affected Certificates whose Secret is
+// a replica.
+func countReplicas(affected []scanner.AffectedCertificate) int {
+	n := 0
+	for _, a := range affected {
+		if a.ReplicatedFrom != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// countPaused returns the number of affected Certificates that are paused.
+func countPaused(affected []scanner.AffectedCertificate) int {
+	n := 0
+	for _, a := range affected {
+		if a.PausedBy != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// countUntrusted returns the number of affected Certificates with a warning.
+func countUntrusted(affected []scanner.AffectedCertificate) int {
+	n := 0
+	for _, a := range affected {
+		if a.Warning != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// sourceCertificate returns the Certificate that manages the Secret with the
+// given namespace/name, preferring one found to be affected by the scan.
+func sourceCertificate(ctx context.Context, cl client.Reader, affected []scanner.AffectedCertificate, secret string) (capi.Certificate, bool) {
+	for _, a := range affected {
+		if a.ReplicatedFrom == "" && a.Certificate.Namespace+"/"+a.Certificate.Spec.SecretName == secret {
+			return a.Certificate, true
+		}
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(secret)
+	if err != nil {
+		return capi.Certificate{}, false
+	}
+	var certs capi.CertificateList
+	if err := cl.List(ctx, &certs, client.InNamespace(namespace)); err != nil {
+		log.Printf("Failed to list Certificates in namespace %q to find the source of Secret %s: %v", namespace, secret, err)
+		return capi.Certificate{}, false
+	}
+	for _, crt := range certs.Items {
+		if crt.Spec.SecretName == name {
+			log.Printf("Found Certificate %s/%s managing Secret %s", crt.Namespace, crt.Name, secret)
+			return crt, true
+		}
+	}
+	return capi.Certificate{}, false
+}
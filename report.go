@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var reportFile string
+
+func init() {
+	flag.StringVar(&reportFile, "report-file", "", "If set, write a JSON report of the scan results to this file.")
+}
+
+// report is the machine-readable summary of a scan written by --report-file.
+type report struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+	// Shards lists the shards covered by this report. It is empty if the scan
+	// was not sharded.
+	Shards []reportShard `json:"shards,omitempty"`
+	// SecretsOnly is true if TLS Secrets were checked instead of
+	// Certificates, in which case each affected entry names a Secret.
+	SecretsOnly bool `json:"secretsOnly,omitempty"`
+	Checked     int  `json:"checked"`
+	Skipped     int  `json:"skipped"`
+	// SkippedByCode is the number of Certificates skipped with each error
+	// code.
+	SkippedByCode map[scanner.Code]int `json:"skippedByCode,omitempty"`
+	// Temporary is the number of Certificates holding a temporary
+	// certificate while cert-manager issues the real one.
+	Temporary int `json:"temporary,omitempty"`
+	// Staging is the number of Certificates holding a Let's Encrypt
+	// staging certificate, which were not checked.
+	Staging int `json:"staging,omitempty"`
+	// InProgress lists the Certificates that were being issued, and need
+	// to be checked again later.
+	InProgress []reportInProgress `json:"inProgress,omitempty"`
+	// Terminating is the number of Certificates that were being deleted, and
+	// TerminatingNamespaces the namespaces that were not scanned because
+	// they were being deleted.
+	Terminating           int                 `json:"terminating,omitempty"`
+	TerminatingNamespaces []string            `json:"terminatingNamespaces,omitempty"`
+	Affected              []reportCertificate `json:"affected"`
+	// Conflicts lists the Secrets used by more than one Certificate.
+	Conflicts []reportConflict `json:"conflicts,omitempty"`
+	// Partial is true if some namespaces could not be scanned, which are
+	// listed in FailedNamespaces.
+	Partial          bool                    `json:"partial,omitempty"`
+	FailedNamespaces []reportFailedNamespace `json:"failedNamespaces,omitempty"`
+	// AffectedAccounts lists the issuers whose ACME accounts are affected,
+	// if --affected-accounts-file was set.
+	AffectedAccounts []affectedAccount `json:"affectedAccounts,omitempty"`
+	// IssuerBlockers lists the issuers that are missing, not Ready or
+	// unreachable, and the affected Certificates that will not be renewed
+	// until they are fixed.
+	IssuerBlockers []reportIssuerBlocker `json:"issuerBlockers,omitempty"`
+	// ConfigMaps holds the certificates found in ConfigMaps, if
+	// --scan-configmaps was set. They are not counted in Checked, Skipped or
+	// Affected.
+	ConfigMaps *reportConfigMaps `json:"configMaps,omitempty"`
+	// Estimate is set if only a sample of Certificates was checked.
+	Estimate *estimate `json:"estimate,omitempty"`
+	// Timings is omitted from merged reports, as the timings of separate
+	// invocations cannot be meaningfully combined.
+	Timings *reportTimings `json:"timings,omitempty"`
+	// Run records how the report was produced. Like Timings, it is omitted
+	// from merged reports.
+	Run *reportRun `json:"run,omitempty"`
+	// Redacted is true if names have been replaced with salted hashes by
+	// --redact.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// reportRun records the version of the tool and the flags a report was
+// produced with, for the record of an incident response.
+type reportRun struct {
+	RunID       string            `json:"runId"`
+	ToolVersion string            `json:"toolVersion"`
+	Parameters  map[string]string `json:"parameters"`
+}
+
+// reportTimings records how long each phase of a run took, in seconds.
+type reportTimings struct {
+	Phases            map[string]float64      `json:"phases"`
+	SlowestNamespaces []reportNamespaceTiming `json:"slowestNamespaces"`
+}
+
+type reportNamespaceTiming struct {
+	Namespace string  `json:"namespace"`
+	Seconds   float64 `json:"seconds"`
+}
+
+type reportShard struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// reportCertificate is an affected Certificate in a report.
+type reportCertificate struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	SecretName string `json:"secretName"`
+	Serial     string `json:"serial"`
+	Reason     string `json:"reason,omitempty"`
+	// ReplicatedFrom is set if the Secret is a replica, to the
+	// namespace/name of its source.
+	ReplicatedFrom string `json:"replicatedFrom,omitempty"`
+	// SharesSecretWith lists the other Certificates using the same Secret.
+	SharesSecretWith []string `json:"sharesSecretWith,omitempty"`
+	// PausedBy is the annotation pausing the Certificate, if it is paused.
+	PausedBy string `json:"pausedBy,omitempty"`
+	// Warning is set if the Certificate may not really be affected.
+	Warning string `json:"warning,omitempty"`
+	// IssuerProblem is set if the issuer of the Certificate is missing or
+	// not Ready.
+	IssuerProblem string `json:"issuerProblem,omitempty"`
+	// DryRunError is set if --dry-run-renewals was set and the server-side
+	// dry-run of the changes renewing the Certificate failed, with the code
+	// of the failure in DryRunErrorCode.
+	DryRunError     string `json:"dryRunError,omitempty"`
+	DryRunErrorCode string `json:"dryRunErrorCode,omitempty"`
+	// Suggestions lists the steps that could be taken to fix the
+	// Certificate, if it cannot be renewed automatically.
+	Suggestions []string `json:"suggestions,omitempty"`
+	// Rotation compares the certificate in the Secret before and after the
+	// renewal, if the Certificate was renewed.
+	Rotation *rotationDiff `json:"rotation,omitempty"`
+}
+
+// reportInProgress is a Certificate that was being issued.
+type reportInProgress struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	SecretName string `json:"secretName"`
+}
+
+// reportFailedNamespace is a namespace that could not be scanned.
+type reportFailedNamespace struct {
+	Namespace string `json:"namespace"`
+	Error     string `json:"error"`
+}
+
+// reportConflict is a Secret used by more than one Certificate.
+type reportConflict struct {
+	Namespace    string   `json:"namespace"`
+	SecretName   string   `json:"secretName"`
+	Certificates []string `json:"certificates"`
+}
+
+// newReport builds a report from the given scan results.
+func newReport(results *scanResults) *report {
+	r := &report{
+		SchemaVersion: reportSchemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Checked:       results.Checked,
+		Skipped:       results.Skipped,
+		SkippedByCode: results.SkippedByCode,
+		Temporary:     results.Temporary,
+		Staging:       results.Staging,
+		Terminating:   results.Terminating,
+		SecretsOnly:   secretsOnly,
+		Affected:      []reportCertificate{},
+
+		TerminatingNamespaces: results.TerminatingNamespaces,
+	}
+	if shardCount > 1 {
+		r.Shards = []reportShard{{Index: shardIndex, Count: shardCount}}
+	}
+	if sampleRate != 0 {
+		e := estimateAffected(results.Total(), results.Checked, len(results.AffectedCertificates()))
+		r.Estimate = &e
+	}
+	r.Run = &reportRun{RunID: runID, ToolVersion: toolVersion(), Parameters: runParameters()}
+	r.Timings = &reportTimings{Phases: make(map[string]float64)}
+	for phase, d := range results.timings.phaseDurations() {
+		r.Timings.Phases[phase] = d.Seconds()
+	}
+	for _, ns := range results.timings.slowestNamespaces(slowestNamespacesReported) {
+		r.Timings.SlowestNamespaces = append(r.Timings.SlowestNamespaces, reportNamespaceTiming{Namespace: ns.Namespace, Seconds: ns.Duration.Seconds()})
+	}
+	for _, a := range results.Affected {
+		c := reportCertificate{
+			Namespace:        a.Certificate.Namespace,
+			Name:             a.Certificate.Name,
+			SecretName:       a.Certificate.Spec.SecretName,
+			Serial:           a.Serial,
+			Reason:           a.Reason,
+			ReplicatedFrom:   a.ReplicatedFrom,
+			SharesSecretWith: a.SharesSecretWith,
+			PausedBy:         a.PausedBy,
+			Warning:          a.Warning,
+			IssuerProblem:    a.IssuerProblem,
+			Suggestions:      suggestRemediation(a),
+		}
+		key := a.Certificate.Namespace + "/" + a.Certificate.Name
+		if err := results.dryRunErrors[key]; err != nil {
+			c.DryRunError = scrubSecrets(err.Error())
+			c.DryRunErrorCode = string(scanner.ErrorCode(err))
+		}
+		c.Rotation = results.rotationDiff(key)
+		r.Affected = append(r.Affected, c)
+	}
+	for _, crt := range results.InProgress {
+		r.InProgress = append(r.InProgress, reportInProgress{Namespace: crt.Namespace, Name: crt.Name, SecretName: crt.Spec.SecretName})
+	}
+	for _, f := range results.FailedNamespaces {
+		r.Partial = true
+		r.FailedNamespaces = append(r.FailedNamespaces, reportFailedNamespace{Namespace: f.Namespace, Error: scrubSecrets(f.Err.Error())})
+	}
+	r.AffectedAccounts = results.affectedAccounts
+	if results.configMaps != nil {
+		r.ConfigMaps = newReportConfigMaps(results.configMaps)
+	}
+	r.IssuerBlockers = issuerBlockersOf(results.Affected)
+	for _, c := range results.Conflicts {
+		r.Conflicts = append(r.Conflicts, reportConflict{Namespace: c.Namespace, SecretName: c.SecretName, Certificates: c.Certificates})
+	}
+	return r
+}
+
+func writeReport(path string, r *report) error {
+	if outputRedactor != nil {
+		outputRedactor.report(r)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return signReport(path, data, r)
+}
+
+func readReport(path string) (*report, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &report{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("error decoding report %q: %w", path, err)
+	}
+	if err := checkSchemaVersion(fmt.Sprintf("report %q", path), r.SchemaVersion, reportSchemaVersion); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// mergeReports combines the reports produced by several sharded scans into a
+// single report. It warns if the reports do not cover every shard exactly
+// once.
+func mergeReports(reports []*report) *report {
+	merged := &report{SchemaVersion: reportSchemaVersion, GeneratedAt: time.Now().UTC(), Affected: []reportCertificate{}}
+	seen := make(map[reportShard]bool)
+	count := 0
+	for _, r := range reports {
+		merged.Checked += r.Checked
+		merged.Skipped += r.Skipped
+		merged.Temporary += r.Temporary
+		merged.Staging += r.Staging
+		merged.InProgress = append(merged.InProgress, r.InProgress...)
+		merged.Terminating += r.Terminating
+		merged.TerminatingNamespaces = append(merged.TerminatingNamespaces, r.TerminatingNamespaces...)
+		for code, n := range r.SkippedByCode {
+			if merged.SkippedByCode == nil {
+				merged.SkippedByCode = make(map[scanner.Code]int)
+			}
+			merged.SkippedByCode[code] += n
+		}
+		merged.Affected = append(merged.Affected, r.Affected...)
+		merged.Conflicts = append(merged.Conflicts, r.Conflicts...)
+		merged.Partial = merged.Partial || r.Partial
+		merged.SecretsOnly = merged.SecretsOnly || r.SecretsOnly
+		merged.FailedNamespaces = append(merged.FailedNamespaces, r.FailedNamespaces...)
+		merged.AffectedAccounts = append(merged.AffectedAccounts, r.AffectedAccounts...)
+		merged.IssuerBlockers = append(merged.IssuerBlockers, r.IssuerBlockers...)
+		if c := r.ConfigMaps; c != nil {
+			if merged.ConfigMaps == nil {
+				merged.ConfigMaps = &reportConfigMaps{Affected: []reportConfigMap{}}
+			}
+			merged.ConfigMaps.Checked += c.Checked
+			merged.ConfigMaps.Skipped += c.Skipped
+			merged.ConfigMaps.Affected = append(merged.ConfigMaps.Affected, c.Affected...)
+			merged.ConfigMaps.FailedNamespaces = append(merged.ConfigMaps.FailedNamespaces, c.FailedNamespaces...)
+		}
+		for _, s := range r.Shards {
+			if seen[s] {
+				log.Printf("WARNING: shard %d of %d appears in more than one report, results will be counted twice", s.Index, s.Count)
+			}
+			if count != 0 && s.Count != count {
+				log.Printf("WARNING: reports were generated with different values of --shard-count (%d and %d)", count, s.Count)
+			}
+			seen[s] = true
+			count = s.Count
+			merged.Shards = append(merged.Shards, s)
+		}
+	}
+	for i := 0; i < count; i++ {
+		if !seen[reportShard{Index: i, Count: count}] {
+			log.Printf("WARNING: no report found for shard %d of %d, merged results are incomplete", i, count)
+		}
+	}
+	sort.Slice(merged.Shards, func(i, j int) bool { return merged.Shards[i].Index < merged.Shards[j].Index })
+	merged.IssuerBlockers = mergeIssuerBlockers(merged.IssuerBlockers)
+	sortMergedReport(merged)
+	return merged
+}
+
+// sortMergedReport sorts the lists in a merged report in the same order as
+// those in a report of a single scan, so that merging the same reports in a
+// different order gives the same result.
+func sortMergedReport(r *report) {
+	sort.Slice(r.Affected, func(i, j int) bool {
+		a, b := r.Affected[i], r.Affected[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	sort.Slice(r.InProgress, func(i, j int) bool {
+		a, b := r.InProgress[i], r.InProgress[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	sort.Slice(r.Conflicts, func(i, j int) bool {
+		a, b := r.Conflicts[i], r.Conflicts[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.SecretName < b.SecretName
+	})
+	sort.Slice(r.FailedNamespaces, func(i, j int) bool { return r.FailedNamespaces[i].Namespace < r.FailedNamespaces[j].Namespace })
+	sort.Strings(r.TerminatingNamespaces)
+	sortAffectedAccounts(r.AffectedAccounts)
+	if c := r.ConfigMaps; c != nil {
+		sort.Slice(c.Affected, func(i, j int) bool {
+			a, b := c.Affected[i], c.Affected[j]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Key < b.Key
+		})
+		sort.Slice(c.FailedNamespaces, func(i, j int) bool { return c.FailedNamespaces[i].Namespace < c.FailedNamespaces[j].Namespace })
+	}
+}
+
+// runMergeReports implements the 'merge-reports' command, which merges the
+// report files given as arguments into the file specified by --report-file.
+func runMergeReports(args []string) error {
+	if reportFile == "" {
+		return fmt.Errorf("--report-file must be specified to write the merged report to")
+	}
+	if err := setupRedaction(); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("at least one report file to merge must be given")
+	}
+	var reports []*report
+	for _, path := range args {
+		r, err := readReport(path)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, r)
+	}
+	merged := mergeReports(reports)
+	if err := writeReport(reportFile, merged); err != nil {
+		return fmt.Errorf("error writing merged report: %w", err)
+	}
+	log.Printf("Merged %d reports into %q: %d checked, %d skipped, %d affected", len(reports), reportFile, merged.Checked, merged.Skipped, len(merged.Affected))
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+var reportSigningKey string
+
+func init() {
+	flag.StringVar(&reportSigningKey, "report-signing-key", "", "Optional path to an unencrypted PEM-encoded ECDSA or RSA private key. If set, --report-file is signed, writing a cosign-compatible signature to <report-file>.sig and a signed in-toto attestation of the scan result to <report-file>.intoto.json.")
+}
+
+const (
+	// inTotoStatementType is the type of the in-toto statement attesting to
+	// a report.
+	inTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	// reportPredicateType identifies the predicate of the statement.
+	reportPredicateType = "https://github.com/jetstack/letsencrypt-caa-bug-checker/scan-result/v1"
+	// dssePayloadType is the DSSE payload type of in-toto statements.
+	dssePayloadType = "application/vnd.in-toto+json"
+)
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     reportPredicate `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// reportPredicate summarises the report being attested to, so that the
+// attestation alone shows whether the cluster was clean.
+type reportPredicate struct {
+	Cluster     string    `json:"cluster,omitempty"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Checked     int       `json:"checked"`
+	Skipped     int       `json:"skipped"`
+	Affected    int       `json:"affected"`
+	Partial     bool      `json:"partial,omitempty"`
+	// Clean is true if nothing was found to be affected and every namespace
+	// was scanned.
+	Clean bool `json:"clean"`
+	// DatasetSHA256 is the hash of the affected serials file the scan was
+	// run with, if any.
+	DatasetSHA256 string `json:"datasetSHA256,omitempty"`
+}
+
+// dsseEnvelope is a signed attestation, in the format written by
+// 'cosign attest-blob'.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// validateReportSigningFlags checks that --report-signing-key can be used
+// before scanning, rather than failing once the scan has finished.
+func validateReportSigningFlags() error {
+	if reportSigningKey == "" {
+		return nil
+	}
+	if reportFile == "" {
+		return fmt.Errorf("--report-signing-key requires --report-file")
+	}
+	if _, err := loadSigningKey(reportSigningKey); err != nil {
+		return fmt.Errorf("error loading --report-signing-key: %w", err)
+	}
+	return nil
+}
+
+// signReport writes a signature over data, the contents of the report r
+// written to path, and a signed attestation of its result, if
+// --report-signing-key is set.
+func signReport(path string, data []byte, r *report) error {
+	if reportSigningKey == "" {
+		return nil
+	}
+	key, err := loadSigningKey(reportSigningKey)
+	if err != nil {
+		return fmt.Errorf("error loading --report-signing-key: %w", err)
+	}
+	sig, err := signBlob(key, data)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".sig", []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644); err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(data)
+	statement := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []inTotoSubject{{Name: filepath.Base(path), Digest: map[string]string{"sha256": hex.EncodeToString(digest[:])}}},
+		PredicateType: reportPredicateType,
+		Predicate: reportPredicate{
+			Cluster:     clusterName,
+			GeneratedAt: r.GeneratedAt,
+			Checked:     r.Checked,
+			Skipped:     r.Skipped,
+			Affected:    len(r.Affected),
+			Partial:     r.Partial,
+			Clean:       len(r.Affected) == 0 && !r.Partial,
+		},
+	}
+	if affectedSerialsFile != "" {
+		if statement.Predicate.DatasetSHA256, err = fileSHA256(affectedSerialsFile); err != nil {
+			return fmt.Errorf("error hashing affected serials file: %w", err)
+		}
+	}
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	sig, err = signBlob(key, dssePAE(dssePayloadType, payload))
+	if err != nil {
+		return err
+	}
+	envelope, err := json.MarshalIndent(dsseEnvelope{
+		PayloadType: dssePayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".intoto.json", append(envelope, '\n'), 0644); err != nil {
+		return err
+	}
+	log.Printf("Signed report %q, writing %q and %q", path, path+".sig", path+".intoto.json")
+	return nil
+}
+
+// loadSigningKey reads an unencrypted PEM-encoded ECDSA or RSA private key.
+func loadSigningKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%q does not contain a PEM-encoded private key", path)
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case *ecdsa.PrivateKey:
+			return key, nil
+		case *rsa.PrivateKey:
+			return key, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q, the key must be unencrypted", block.Type)
+	}
+}
+
+// signBlob signs the SHA-256 digest of data, in the same way as
+// 'cosign sign-blob', so that the signature can be checked with
+// 'cosign verify-blob' or --dataset-signature.
+func signBlob(key crypto.Signer, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// dssePAE returns the DSSE pre-authentication encoding of payload, which is
+// what is signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var rotationWait time.Duration
+
+func init() {
+	flag.DurationVar(&rotationWait, "rotation-wait", time.Minute, "How long to wait, once renewals have been triggered, for cert-manager to store a new certificate in the Secret of each renewed Certificate, before comparing it with the certificate that was there before. The serial numbers and expiry dates before and after are logged and included in reports. Set to 0 to compare without waiting.")
+}
+
+func validateRotationFlags() error {
+	if rotationWait < 0 {
+		return fmt.Errorf("--rotation-wait must not be negative")
+	}
+	return nil
+}
+
+// rotation compares the certificate in the Secret of a Certificate when its
+// renewal was triggered with the one in it afterwards.
+type rotation struct {
+	crt capi.Certificate
+	// action is the outcomeAction that triggered the renewal.
+	action      string
+	triggeredAt time.Time
+	before      *x509.Certificate
+	after       *x509.Certificate
+	// checked is set once checkRotations has read the Secret again, at
+	// checkedAt.
+	checked   bool
+	checkedAt time.Time
+	// problem explains why the rotation could not be confirmed, if it was
+	// not.
+	problem string
+}
+
+// rotationDiff is the rotation of a renewed Certificate in reports.
+type rotationDiff struct {
+	OldSerial   string     `json:"oldSerial,omitempty"`
+	OldNotAfter *time.Time `json:"oldNotAfter,omitempty"`
+	NewSerial   string     `json:"newSerial,omitempty"`
+	NewNotAfter *time.Time `json:"newNotAfter,omitempty"`
+	// Rotated is true if the Secret holds a different certificate than it
+	// did when the renewal was triggered.
+	Rotated bool   `json:"rotated"`
+	Problem string `json:"problem,omitempty"`
+}
+
+func (rot *rotation) diff() *rotationDiff {
+	d := &rotationDiff{Problem: rot.problem}
+	if c := rot.before; c != nil {
+		notAfter := c.NotAfter.UTC()
+		d.OldSerial, d.OldNotAfter = fmt.Sprintf("%x", c.SerialNumber), &notAfter
+	}
+	if c := rot.after; c != nil {
+		notAfter := c.NotAfter.UTC()
+		d.NewSerial, d.NewNotAfter = fmt.Sprintf("%x", c.SerialNumber), &notAfter
+	}
+	d.Rotated = d.OldSerial != "" && d.NewSerial != "" && d.OldSerial != d.NewSerial
+	return d
+}
+
+// startRotation records the certificate in the Secret of crt just before its
+// renewal is triggered by action, and returns its serial number, or "" if it
+// cannot be read.
+func (r *scanResults) startRotation(ctx context.Context, cl client.Reader, crt capi.Certificate, action string) string {
+	rot := &rotation{crt: crt, action: action, triggeredAt: time.Now(), before: secretCertificate(ctx, cl, crt)}
+	if rot.before == nil {
+		rot.problem = "unable to read the certificate in the Secret before the renewal"
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.rotations == nil {
+		r.rotations = make(map[string]*rotation)
+	}
+	r.rotations[crt.Namespace+"/"+crt.Name] = rot
+	if rot.before == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", rot.before.SerialNumber)
+}
+
+// rotationDiff returns the rotation of the Certificate with the given
+// namespace/name, or nil if it was not renewed or has not been checked.
+func (r *scanResults) rotationDiff(key string) *rotationDiff {
+	rot, ok := r.rotations[key]
+	if !ok || !rot.checked {
+		return nil
+	}
+	return rot.diff()
+}
+
+// checkRotations reads the Secret of each Certificate whose renewal was
+// triggered successfully again, once cert-manager has stored a new
+// certificate in it or --rotation-wait has passed, and logs how the
+// certificate compares with the one there before. It is called once every
+// renewal has been triggered.
+func checkRotations(ctx context.Context, cl client.Client, results *scanResults) {
+	results.lock.Lock()
+	defer results.lock.Unlock()
+	var pending []*rotation
+	for key, rot := range results.rotations {
+		if err, ok := results.renewals[key]; ok && err == nil && !rot.checked {
+			pending = append(pending, rot)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	wait := rotationWait
+	switch {
+	case fixturesDir != "":
+		log.Printf("[simulation] Not waiting for the renewed Certificates to be issued new certificates, as cert-manager is not running")
+		wait = 0
+	case remediatorName != "cert-manager":
+		log.Printf("Not waiting for the renewed Certificates to be issued new certificates, as --remediator is %s", remediatorName)
+		wait = 0
+	case wait > 0:
+		log.Printf("Waiting up to %s for %d renewed Certificate(s) to be issued new certificates...", wait, len(pending))
+	}
+
+	r := newRenewer(cl)
+	deadline := time.Now().Add(wait)
+	for waiting := pending; len(waiting) > 0 && time.Now().Before(deadline); {
+		var still []*rotation
+		for _, rot := range waiting {
+			previous := ""
+			if rot.before != nil {
+				previous = fmt.Sprintf("%x", rot.before.SerialNumber)
+			}
+			secret, err := r.Issued(ctx, rot.crt, previous, rot.triggeredAt)
+			switch {
+			case err != nil:
+				rot.problem = scrubSecrets(err.Error())
+			case secret == nil:
+				still = append(still, rot)
+			}
+		}
+		if waiting = still; len(waiting) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			deadline = time.Now()
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	for _, rot := range pending {
+		rot.checked, rot.checkedAt = true, time.Now()
+		rot.after = secretCertificate(ctx, cl, rot.crt)
+		if rot.problem != "" {
+			continue
+		}
+		switch d := rot.diff(); {
+		case rot.after == nil:
+			rot.problem = "unable to read the certificate in the Secret after the renewal"
+		case !d.Rotated && wait > 0:
+			rot.problem = fmt.Sprintf("no new certificate had been stored in the Secret after %s", wait)
+		}
+	}
+	logRotations(pending)
+}
+
+// logRotations logs the certificate in the Secret of each renewed
+// Certificate before and after its renewal.
+func logRotations(rotations []*rotation) {
+	sortRotations(rotations)
+	rotated := 0
+	log.Println()
+	log.Printf("Certificates in the Secrets of renewed Certificates:")
+	for _, rot := range rotations {
+		d := rot.diff()
+		name := rot.crt.Namespace + "/" + rot.crt.Name
+		switch {
+		case d.Rotated:
+			rotated++
+			log.Printf("  * %s: serial %s (expires %s) -> %s (expires %s)", name, d.OldSerial, d.OldNotAfter.Format(time.RFC3339), d.NewSerial, d.NewNotAfter.Format(time.RFC3339))
+		case d.Problem != "":
+			log.Printf("  * %s: NOT rotated, %s", name, d.Problem)
+		default:
+			log.Printf("  * %s: NOT rotated yet, still serial %s", name, d.OldSerial)
+		}
+	}
+	log.Printf("%d of %d renewed Certificate(s) are confirmed to hold a new certificate", rotated, len(rotations))
+}
+
+func sortRotations(rotations []*rotation) {
+	sort.Slice(rotations, func(i, j int) bool {
+		a, b := rotations[i].crt, rotations[j].crt
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"log"
+)
+
+var runID string
+
+func init() {
+	flag.StringVar(&runID, "run-id", "", "An ID for this run, recorded on every log line, the audit log, the API trace, reports, notifications and the Secrets it annotates, so that every change can be traced back to the run that made it. If not set, a random ID is generated.")
+}
+
+// runIDAnnotation is set to the run ID on each Secret annotated to trigger a
+// renewal and each namespace report ConfigMap written, recording which run
+// last changed it.
+const runIDAnnotation = "lecaa.jetstack.io/run-id"
+
+// setupRunID generates a run ID unless --run-id is set, and prefixes every
+// log line with it. It must be called once flags have been parsed.
+func setupRunID() {
+	if runID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatalf("Failed to generate a run ID: %v", err)
+		}
+		runID = hex.EncodeToString(b)
+	}
+	log.SetPrefix("run=" + runID + " ")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var (
+	runLock          bool
+	runLockNamespace string
+	runLockName      string
+)
+
+func init() {
+	flag.BoolVar(&runLock, "run-lock", true, "If true, runs that change the cluster, because --renew, --namespace-reports or --reset is set, hold a Lease for as long as they run, and refuse to start if another run holds it. This stops two operators triggering renewals and deleting each other's CertificateRequests at the same time.")
+	flag.StringVar(&runLockNamespace, "run-lock-namespace", "kube-system", "The namespace of the Lease held by --run-lock. Every run against a cluster must use the same namespace and --run-lock-name for the lock to be effective.")
+	flag.StringVar(&runLockName, "run-lock-name", "letsencrypt-caa-bug-checker-run", "The name of the Lease held by --run-lock.")
+}
+
+const (
+	// runLockLeaseDuration is how long the Lease of a run that exited
+	// without releasing it, for example because it was killed, stops other
+	// runs from starting.
+	runLockLeaseDuration = time.Minute
+	runLockRenewDeadline = 40 * time.Second
+	runLockRetryPeriod   = 10 * time.Second
+)
+
+// runLockRequired returns true if the run lock must be held, which is the
+// case for every run that changes the cluster.
+func runLockRequired() bool {
+	return runLock && (renew || namespaceReports || resetStuck)
+}
+
+// acquireRunLock acquires the run lock Lease if runLockRequired, returning a
+// function that releases it. An error is returned without waiting if another
+// run holds the Lease. If the Lease is lost part way through the run, the
+// process exits, as another run may then start making changes.
+func acquireRunLock(cfg *rest.Config) (func(), error) {
+	if !runLockRequired() {
+		return func() {}, nil
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error building Kubernetes client: %w", err)
+	}
+	id := runLockIdentity()
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, runLockNamespace, runLockName, kubeClient.CoreV1(), kubeClient.CoordinationV1(), resourcelock.ResourceLockConfig{
+		Identity: id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating run lock: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan struct{})
+	heldBy := make(chan string, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   runLockLeaseDuration,
+		RenewDeadline:   runLockRenewDeadline,
+		RetryPeriod:     runLockRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				close(acquired)
+			},
+			OnStoppedLeading: func() {
+				// ctx is only cancelled once the lock has been given up or
+				// was never acquired.
+				if ctx.Err() != nil {
+					return
+				}
+				log.Fatalf("Lost the run lock Lease %s/%s, exiting so that another run cannot make changes at the same time", runLockNamespace, runLockName)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					select {
+					case heldBy <- identity:
+					default:
+					}
+				}
+			},
+		},
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error creating run lock: %w", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		elector.Run(ctx)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+
+	select {
+	case <-acquired:
+		log.Printf("Acquired the run lock Lease %s/%s as %s", runLockNamespace, runLockName, id)
+		return func() {
+			stop()
+			log.Printf("Released the run lock Lease %s/%s", runLockNamespace, runLockName)
+		}, nil
+	case holder := <-heldBy:
+		stop()
+		return nil, fmt.Errorf("another run, %s, holds the run lock Lease %s/%s, and both runs would make changes at the same time. Wait for it to finish, or, if it was killed, for the Lease to expire within %s. Set --run-lock=false to run anyway", holder, runLockNamespace, runLockName, runLockLeaseDuration)
+	case <-time.After(runLockLeaseDuration):
+		stop()
+		return nil, fmt.Errorf("timed out acquiring the run lock Lease %s/%s, check that the namespace exists and that leases can be created in it", runLockNamespace, runLockName)
+	}
+}
+
+// runLockIdentity identifies this run in the run lock Lease, so that anyone
+// whose run is refused can tell who is holding it.
+func runLockIdentity() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	id := host + "_" + string(uuid.NewUUID())
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		id = u.Username + "@" + id
+	}
+	return id
+}
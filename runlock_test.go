@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	authorization "k8s.io/api/authorization/v1"
+	coordination "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// fakeLeaseServer is a Kubernetes API server that stores a single Lease and
+// answers SelfSubjectAccessReviews, allowing everything but Leases.
+type fakeLeaseServer struct {
+	lock  sync.Mutex
+	lease *coordination.Lease
+	// reviews lists the resources of the SelfSubjectAccessReviews received.
+	reviews []string
+}
+
+func (s *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/selfsubjectaccessreviews"):
+		var review authorization.SelfSubjectAccessReview
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		attrs := review.Spec.ResourceAttributes
+		s.reviews = append(s.reviews, attrs.Verb+" "+attrs.Resource+" "+attrs.Namespace)
+		review.Status.Allowed = attrs.Resource != "leases"
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&review)
+	case strings.Contains(r.URL.Path, "/apis/coordination.k8s.io/v1/namespaces/kube-system/leases"):
+		switch r.Method {
+		case http.MethodGet:
+			if s.lease == nil {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+				return
+			}
+			json.NewEncoder(w).Encode(s.lease)
+		case http.MethodPost, http.MethodPut:
+			lease := &coordination.Lease{}
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, lease); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			lease.ResourceVersion = "1"
+			s.lease = lease
+			if r.Method == http.MethodPost {
+				w.WriteHeader(http.StatusCreated)
+			}
+			json.NewEncoder(w).Encode(lease)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+	}
+}
+
+func (s *fakeLeaseServer) holder() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lease == nil || s.lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *s.lease.Spec.HolderIdentity
+}
+
+// setResetFlags sets the flags of 'fix-stuck-renewals --reset' without
+// --renew, returning a function that restores them.
+func setResetFlags() func() {
+	oldRunLock, oldRenew, oldReports, oldReset, oldNamespace, oldName, oldSkip := runLock, renew, namespaceReports, resetStuck, runLockNamespace, runLockName, skipRBACPreflight
+	runLock, renew, namespaceReports, resetStuck = true, false, false, true
+	runLockNamespace, runLockName = "kube-system", "letsencrypt-caa-bug-checker-run"
+	skipRBACPreflight = false
+	return func() {
+		runLock, renew, namespaceReports, resetStuck, runLockNamespace, runLockName, skipRBACPreflight = oldRunLock, oldRenew, oldReports, oldReset, oldNamespace, oldName, oldSkip
+	}
+}
+
+func TestRunLockRequired(t *testing.T) {
+	defer setResetFlags()()
+	tests := []struct {
+		name                           string
+		runLock, renew, reports, reset bool
+		want                           bool
+	}{
+		{name: "read only", runLock: true},
+		{name: "renew", runLock: true, renew: true, want: true},
+		{name: "namespace reports", runLock: true, reports: true, want: true},
+		{name: "reset without renew", runLock: true, reset: true, want: true},
+		{name: "reset with --run-lock=false", reset: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runLock, renew, namespaceReports, resetStuck = tt.runLock, tt.renew, tt.reports, tt.reset
+			if got := runLockRequired(); got != tt.want {
+				t.Errorf("runLockRequired() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunLockTakenForReset(t *testing.T) {
+	defer setResetFlags()()
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	cfg := &rest.Config{Host: srv.URL}
+
+	release, err := acquireRunLock(cfg)
+	if err != nil {
+		t.Fatalf("acquiring the run lock: %v", err)
+	}
+	holder := fake.holder()
+	if holder == "" {
+		t.Fatalf("the run lock Lease was not taken for --reset without --renew")
+	}
+
+	// A second reset must refuse to start while the first holds the Lease.
+	if _, err := acquireRunLock(cfg); err == nil || !strings.Contains(err.Error(), holder) {
+		t.Errorf("second acquireRunLock() error = %v, want one naming the holder %q", err, holder)
+	}
+	release()
+	if got := fake.holder(); got != "" {
+		t.Errorf("Lease still held by %q after release", got)
+	}
+}
+
+func TestCheckPermissionsIncludesRunLockForReset(t *testing.T) {
+	defer setResetFlags()()
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	err := checkPermissions(&rest.Config{Host: srv.URL}, "fix-stuck")
+	if err == nil || !strings.Contains(err.Error(), "leases.coordination.k8s.io in namespace kube-system") {
+		t.Errorf("checkPermissions() error = %v, want missing Lease permissions in kube-system", err)
+	}
+	for _, review := range fake.reviews {
+		if review == "delete certificaterequests " {
+			return
+		}
+	}
+	t.Errorf("delete certificaterequests was not checked, reviews: %v", fake.reviews)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sampleFlag is the fraction of Certificates to check, parsed from a value
+// such as "5%" or "0.05". A value of 0 disables sampling.
+type sampleFlag float64
+
+func (f *sampleFlag) String() string {
+	if *f == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*f)*100, 'f', -1, 64) + "%"
+}
+
+func (f *sampleFlag) Set(value string) error {
+	var rate float64
+	var err error
+	if strings.HasSuffix(value, "%") {
+		rate, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		rate /= 100
+	} else {
+		rate, err = strconv.ParseFloat(value, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid sample rate %q: %w", value, err)
+	}
+	if rate <= 0 || rate > 1 {
+		return fmt.Errorf("sample rate must be greater than 0%% and at most 100%%")
+	}
+	*f = sampleFlag(rate)
+	return nil
+}
+
+var (
+	sampleRate sampleFlag
+
+	sampleRandLock sync.Mutex
+	sampleRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func init() {
+	flag.Var(&sampleRate, "sample", "If set, only check a random sample of Certificates (e.g. '5%') and estimate the total number of affected Certificates from it. Cannot be combined with --renew.")
+}
+
+// sampled returns true if a Certificate should be checked under --sample.
+func sampled() bool {
+	if sampleRate == 0 {
+		return true
+	}
+	sampleRandLock.Lock()
+	defer sampleRandLock.Unlock()
+	return sampleRand.Float64() < float64(sampleRate)
+}
+
+// sampleZ is the z-score for a 95% confidence interval.
+const sampleZ = 1.96
+
+// estimate is an extrapolated count of affected Certificates.
+type estimate struct {
+	SampleRate float64 `json:"sampleRate"`
+	Total      int     `json:"total"`
+	Estimated  int     `json:"estimated"`
+	Lower      int     `json:"lower"`
+	Upper      int     `json:"upper"`
+}
+
+// estimateAffected extrapolates the number of affected Certificates among
+// total from a sample in which affected of checked were affected, using the
+// Wilson score interval at 95% confidence.
+func estimateAffected(total, checked, affected int) estimate {
+	e := estimate{SampleRate: float64(sampleRate), Total: total}
+	if checked == 0 {
+		e.Upper = total
+		return e
+	}
+	n := float64(checked)
+	p := float64(affected) / n
+	denom := 1 + sampleZ*sampleZ/n
+	centre := (p + sampleZ*sampleZ/(2*n)) / denom
+	margin := sampleZ * math.Sqrt(p*(1-p)/n+sampleZ*sampleZ/(4*n*n)) / denom
+	e.Estimated = int(math.Round(p * float64(total)))
+	e.Lower = int(math.Floor(math.Max(0, centre-margin) * float64(total)))
+	e.Upper = int(math.Ceil(math.Min(1, centre+margin) * float64(total)))
+	// The sample itself is a lower bound on the true count.
+	if e.Lower < affected {
+		e.Lower = affected
+	}
+	return e
+}
+
+func logEstimate(e estimate) {
+	log.Printf("  Estimated affected certificates (from a %s sample of %d): %d (95%% confidence: %d-%d)",
+		(&sampleRate).String(), e.Total, e.Estimated, e.Lower, e.Upper)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// scanResults holds the report of a scan along with the outcome of any
+// renewals attempted afterwards. It is safe for concurrent use.
+type scanResults struct {
+	*scanner.Report
+
+	lock sync.Mutex
+	// renewals records the outcome of each renewal attempted after the scan,
+	// keyed by the namespace/name of the Certificate. A nil error means the
+	// renewal was triggered successfully.
+	renewals map[string]error
+	// dryRunErrors records why the server-side dry-run of the renewal of
+	// each Certificate failed, if --dry-run-renewals is set, keyed by
+	// namespace/name.
+	dryRunErrors map[string]error
+	// affectedAccounts lists the issuers whose ACME accounts are affected,
+	// if --affected-accounts-file is set.
+	affectedAccounts []affectedAccount
+	// configMaps holds the certificates found in ConfigMaps, if
+	// --scan-configmaps is set.
+	configMaps *scanner.ConfigMapReport
+	// rotations records the certificate in the Secret of each Certificate
+	// when its renewal was triggered, and afterwards, keyed by
+	// namespace/name.
+	rotations map[string]*rotation
+
+	timings *timings
+	// scanner is the Scanner that produced the results, used to check the
+	// certificates issued by renewals.
+	scanner *scanner.Scanner
+}
+
+func (r *scanResults) recordRenewal(crt capi.Certificate, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.renewals == nil {
+		r.renewals = make(map[string]error)
+	}
+	r.renewals[crt.Namespace+"/"+crt.Name] = err
+}
+
+func (r *scanResults) recordDryRun(crt capi.Certificate, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.dryRunErrors == nil {
+		r.dryRunErrors = make(map[string]error)
+	}
+	r.dryRunErrors[crt.Namespace+"/"+crt.Name] = err
+}
+
+// findings returns every affected Certificate along with the outcome of any
+// renewal attempted, sorted by namespace and name.
+func (r *scanResults) findings() []finding {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	m := make(map[string]finding)
+	now := time.Now().UTC()
+	for _, a := range r.Affected {
+		crt := a.Certificate
+		key := crt.Namespace + "/" + crt.Name
+		f := finding{Namespace: crt.Namespace, Name: crt.Name, SecretName: crt.Spec.SecretName, IssuerName: crt.Spec.IssuerRef.Name, IssuerKind: crt.Spec.IssuerRef.Kind, Serial: a.Serial, Reason: a.Reason, ReplicatedFrom: a.ReplicatedFrom, SharesSecretWith: a.SharesSecretWith, PausedBy: a.PausedBy, Warning: a.Warning, IssuerProblem: a.IssuerProblem, Suggestions: suggestRemediation(a), FoundAt: now}
+		if err, ok := r.renewals[key]; ok {
+			if err != nil {
+				f.setRenewalError(err)
+			} else {
+				f.RenewalTriggered = true
+			}
+		}
+		f.Rotation = r.rotationDiff(key)
+		m[key] = f
+	}
+	return sortFindings(m)
+}
+
+// newScanner returns a Scanner configured from flags, which reads from cl
+// and checks Certificates against serials.
+func newScanner(cl client.Reader, serials scanner.SerialSource) *scanner.Scanner {
+	s := &scanner.Scanner{
+		Client:          cl,
+		Serials:         serials,
+		NamespaceFilter: inShard,
+		Concurrency:     scanConcurrency,
+		PageSize:        pageSize,
+		Tracer:          libraryTracer{},
+	}
+	if d := newDetector(); d != nil {
+		s.Detector = d
+	}
+	if cl != nil {
+		s.KeystorePassword = keystorePassword(cl)
+	}
+	s.PausedAnnotations = pausedAnnotations()
+	s.IssuanceWindow = issuanceWindow()
+	s.CheckIssuers = checkIssuers()
+	s.ACMEServers = acmeServers()
+	s.IncludeStaging = includeStaging
+	s.ErrorBudget = errorBudget / 100
+	s.FailFast = failFast
+	s.Events = scannerEvents()
+	if sampleRate != 0 {
+		s.CertificateFilter = func(capi.Certificate) bool { return sampled() }
+	}
+	return s
+}
+
+var (
+	recheckInProgress    time.Duration
+	pausedAnnotationsRaw string
+	errorBudget          float64
+	failFast             bool
+	includeStaging       bool
+)
+
+func init() {
+	flag.DurationVar(&recheckInProgress, "recheck-in-progress", 0, "If set, Certificates that could not be checked because they are in the middle of an issuance are checked again after waiting this long, at the end of the scan.")
+	flag.Float64Var(&errorBudget, "error-budget", 50, fmt.Sprintf("The percentage of Certificates that may fail to be checked, for example because their Secrets cannot be read or decoded, before the scan is aborted with a diagnosis of the likely cause. It is only applied once %d Certificates have been attempted. Set to 100 to never abort.", scanner.DefaultErrorBudgetMinimum))
+	flag.BoolVar(&includeStaging, "include-staging", false, "If true, certificates issued by the Let's Encrypt staging environment are checked and renewed like any other. By default they are counted separately and never reported as affected, as they are only used for testing.")
+	flag.BoolVar(&failFast, "fail-fast", false, "If true, the scan is aborted as soon as any Certificate fails to be checked.")
+	flag.StringVar(&pausedAnnotationsRaw, "paused-annotations", strings.Join(scanner.DefaultPausedAnnotations, ","), "Comma-separated annotations that, when set to \"true\" on a Certificate, mark it as paused. Paused Certificates are reported as requiring unpausing instead of being renewed.")
+}
+
+// pausedAnnotations returns the annotations given by --paused-annotations.
+func pausedAnnotations() []string {
+	annotations := []string{}
+	for _, a := range strings.Split(pausedAnnotationsRaw, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			annotations = append(annotations, a)
+		}
+	}
+	return annotations
+}
+
+// runScan runs s, recording how long each phase took.
+func runScan(ctx context.Context, s *scanner.Scanner) (*scanResults, error) {
+	if shardCount > 1 {
+		log.Printf("Scanning shard %d of %d", shardIndex, shardCount)
+	}
+	t := newTimings()
+	timed := *s
+	timed.Timer = t
+	scan := timed.Scan
+	if secretsOnly {
+		scan = timed.ScanSecrets
+	}
+	report, err := scan(ctx)
+	if errors.Is(err, scanner.ErrErrorBudgetExceeded) {
+		return nil, fmt.Errorf("%w Fix the cause and run again, or raise --error-budget to scan the rest of the cluster regardless", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(report.InProgress) > 0 && recheckInProgress > 0 {
+		log.Printf("Waiting %s to re-check %d Certificates that are being issued", recheckInProgress, len(report.InProgress))
+		select {
+		case <-time.After(recheckInProgress):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if err := timed.Recheck(ctx, report); err != nil {
+			return nil, fmt.Errorf("error re-checking Certificates being issued: %w", err)
+		}
+	}
+	return &scanResults{Report: report, timings: t, scanner: s}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	lecaa "github.com/jetstack/letsencrypt-caa-bug-checker/pkg/apis/lecaa/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// setupScanController starts reconciling CAABugScan resources, if the
+// CustomResourceDefinition for them has been installed.
+func setupScanController(mgr ctrl.Manager, d *dataset) error {
+	gk := lecaa.SchemeGroupVersion.WithKind("CAABugScan").GroupKind()
+	if _, err := mgr.GetRESTMapper().RESTMapping(gk, lecaa.SchemeGroupVersion.Version); err != nil {
+		if meta.IsNoMatchError(err) {
+			log.Printf("CAABugScan CustomResourceDefinition is not installed, CAABugScan resources will not be reconciled")
+			return nil
+		}
+		return err
+	}
+
+	r := &scanReconciler{client: mgr.GetClient(), reader: mgr.GetAPIReader(), dataset: d}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&lecaa.CAABugScan{}).
+		Complete(r)
+}
+
+// scanReconciler runs the scan described by each CAABugScan resource and
+// records the results in its status.
+type scanReconciler struct {
+	client client.Client
+	// reader reads directly from the API server, so that scans do not need
+	// to cache every Certificate and Secret in the cluster.
+	reader  client.Reader
+	dataset *dataset
+}
+
+func (r *scanReconciler) Reconcile(req reconcile.Request) (reconcile.Result, error) {
+	ctx := context.Background()
+	var scan lecaa.CAABugScan
+	if err := r.client.Get(ctx, req.NamespacedName, &scan); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Only re-run the scan if the spec has changed or the rescan interval has
+	// elapsed since the last scan.
+	if scan.Status.ObservedGeneration == scan.Generation && scan.Status.LastScanTime != nil {
+		if scan.Spec.RescanInterval == nil {
+			return reconcile.Result{}, nil
+		}
+		next := scan.Status.LastScanTime.Add(scan.Spec.RescanInterval.Duration)
+		if wait := time.Until(next); wait > 0 {
+			return reconcile.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	log.Printf("Running scan for CAABugScan %q", scan.Name)
+	s := newScanner(r.reader, r.dataset.get())
+	s.Namespaces = scan.Spec.Namespaces
+	results, err := runScan(ctx, s)
+
+	now := metav1.Now()
+	status := lecaa.CAABugScanStatus{
+		ObservedGeneration: scan.Generation,
+		LastScanTime:       &now,
+	}
+	if err != nil {
+		log.Printf("Scan for CAABugScan %q failed: %v", scan.Name, err)
+		status.Error = scrubSecrets(err.Error())
+	} else {
+		status.Checked = results.Checked
+		status.Skipped = results.Skipped
+		// Renewals are chosen as for any other run, so the Certificate of the
+		// source of a replicated Secret is renewed instead, and those that
+		// would not be renewed automatically are refused. Renewals are
+		// recorded by the Secret they fix.
+		var refused map[string]string
+		renewed := make(map[string]error)
+		if scan.Spec.RenewalPolicy == lecaa.RenewalPolicyRenew && renew {
+			var targets []capi.Certificate
+			targets, refused = selectRenewalTargets(ctx, r.reader, results.Affected)
+			for _, crt := range targets {
+				log.Printf("Triggering renewal of Certificate %s/%s for CAABugScan %q", crt.Namespace, crt.Name, scan.Name)
+				renewed[crt.Namespace+"/"+crt.Spec.SecretName] = renewCertificate(ctx, r.client, crt)
+			}
+		}
+		for _, a := range results.Affected {
+			crt := a.Certificate
+			affected := lecaa.AffectedCertificate{Namespace: crt.Namespace, Name: crt.Name, Serial: a.Serial}
+			switch {
+			case scan.Spec.RenewalPolicy != lecaa.RenewalPolicyRenew:
+			case !renew:
+				// --renew is the switch for every renewal the tool triggers,
+				// so creating a CAABugScan cannot bypass it.
+				log.Printf("NOT renewing Certificate %s/%s for CAABugScan %q, as --renew is not set", crt.Namespace, crt.Name, scan.Name)
+				affected.RenewalError = "--renew is not set on the controller"
+			default:
+				if reason, ok := refused[crt.Namespace+"/"+crt.Name]; ok {
+					affected.RenewalError = "not renewed, as " + scrubSecrets(reason)
+					break
+				}
+				secret := a.ReplicatedFrom
+				if secret == "" {
+					secret = crt.Namespace + "/" + crt.Spec.SecretName
+				}
+				if err, ok := renewed[secret]; ok && err != nil {
+					affected.RenewalError = scrubSecrets(err.Error())
+				} else if ok {
+					affected.RenewalTriggered = true
+				}
+			}
+			status.Affected = append(status.Affected, affected)
+		}
+		log.Printf("Scan for CAABugScan %q complete: %d checked, %d skipped, %d affected", scan.Name, status.Checked, status.Skipped, len(status.Affected))
+	}
+
+	scan.Status = status
+	err = r.client.Status().Update(ctx, &scan)
+	if auditErr := auditLog.record("update-status", "CAABugScan", scan.Namespace, scan.Name, map[string]interface{}{"status": status}, "record scan results", err); auditErr != nil {
+		return reconcile.Result{}, auditErr
+	}
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("error updating CAABugScan status: %w", err)
+	}
+	if scan.Spec.RescanInterval != nil {
+		return reconcile.Result{RequeueAfter: scan.Spec.RescanInterval.Duration}, nil
+	}
+	return reconcile.Result{}, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	lecaa "github.com/jetstack/letsencrypt-caa-bug-checker/pkg/apis/lecaa/v1alpha1"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// testCertificatePEM returns a self-signed certificate for dnsName with the
+// given serial number, issued by an intermediate named like those of Let's
+// Encrypt during the incident.
+func testCertificatePEM(t *testing.T, serial int64, dnsName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsName},
+		Issuer:       pkix.Name{CommonName: "R3"},
+		NotBefore:    time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Now().Add(30 * 24 * time.Hour),
+		DNSNames:     []string{dnsName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// reconcileTestScan reconciles a CAABugScan with renewalPolicy Renew over
+// an affected Certificate that can be renewed and one that is paused,
+// returning the status of the scan by Certificate name.
+func reconcileTestScan(t *testing.T) map[string]lecaa.AffectedCertificate {
+	objs := []runtime.Object{
+		&core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&lecaa.CAABugScan{ObjectMeta: metav1.ObjectMeta{Name: "production", Generation: 1}, Spec: lecaa.CAABugScanSpec{RenewalPolicy: lecaa.RenewalPolicyRenew}},
+	}
+	serials := scanner.SerialSet{}
+	for i, name := range []string{"plain", "paused"} {
+		crt := &capi.Certificate{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: name, UID: types.UID("uid-" + name)},
+			Spec:       capi.CertificateSpec{SecretName: name + "-tls", DNSNames: []string{name + ".example.com"}, IssuerRef: cmmeta.ObjectReference{Name: "letsencrypt", Kind: "ClusterIssuer"}},
+		}
+		if name == "paused" {
+			crt.Annotations = map[string]string{scanner.DefaultPausedAnnotations[0]: "true"}
+		}
+		serial := int64(0x1000 + i)
+		serials.Add(big.NewInt(serial))
+		objs = append(objs, crt, &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: name + "-tls"},
+			Data:       map[string][]byte{core.TLSCertKey: testCertificatePEM(t, serial, name+".example.com")},
+		})
+	}
+	cl := fake.NewFakeClientWithScheme(serveScheme, objs...)
+	r := &scanReconciler{client: cl, reader: cl, dataset: &dataset{serials: serials}}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "production"}}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var scan lecaa.CAABugScan
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: "production"}, &scan); err != nil {
+		t.Fatal(err)
+	}
+	if scan.Status.Error != "" {
+		t.Fatalf("scan failed: %s", scan.Status.Error)
+	}
+	affected := make(map[string]lecaa.AffectedCertificate)
+	for _, a := range scan.Status.Affected {
+		affected[a.Name] = a
+	}
+	if len(affected) != 2 {
+		t.Fatalf("got %d affected Certificates, want 2: %+v", len(affected), scan.Status.Affected)
+	}
+	return affected
+}
+
+func TestScanReconcilerRenewals(t *testing.T) {
+	oldRenew, oldUnready, oldRemediator, oldCommand := renew, renewUnreadyIssuers, remediatorName, remediatorCommand
+	defer func() {
+		renew, renewUnreadyIssuers, remediatorName, remediatorCommand = oldRenew, oldUnready, oldRemediator, oldCommand
+	}()
+	// The fixtures do not include the issuer, and cert-manager is not
+	// running to complete a renewal.
+	renewUnreadyIssuers = true
+	remediatorName, remediatorCommand = "exec", "true"
+
+	t.Run("without --renew", func(t *testing.T) {
+		renew = false
+		for name, a := range reconcileTestScan(t) {
+			if a.RenewalTriggered || a.RenewalError != "--renew is not set on the controller" {
+				t.Errorf("%s: RenewalTriggered = %t, RenewalError = %q, want no renewal as --renew is not set", name, a.RenewalTriggered, a.RenewalError)
+			}
+		}
+	})
+	t.Run("with --renew", func(t *testing.T) {
+		renew = true
+		affected := reconcileTestScan(t)
+		if a := affected["plain"]; !a.RenewalTriggered || a.RenewalError != "" {
+			t.Errorf("plain: RenewalTriggered = %t, RenewalError = %q, want a renewal", a.RenewalTriggered, a.RenewalError)
+		}
+		if a := affected["paused"]; a.RenewalTriggered || !strings.Contains(a.RenewalError, "paused by annotation") {
+			t.Errorf("paused: RenewalTriggered = %t, RenewalError = %q, want it refused as paused", a.RenewalTriggered, a.RenewalError)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var scanSchedule string
+
+func init() {
+	flag.StringVar(&scanSchedule, "scan-schedule", "", "If set, the 'serve' command will also perform a full scan of the cluster on this cron schedule (for example \"0 */6 * * *\"), in addition to checking Certificates as they change.")
+}
+
+// runHistorySize is the number of scheduled scan summaries retained.
+const runHistorySize = 20
+
+// runSummary records the outcome of a scan.
+type runSummary struct {
+	RunID            string    `json:"runId,omitempty"`
+	StartedAt        time.Time `json:"startedAt"`
+	DurationSeconds  float64   `json:"durationSeconds"`
+	Checked          int       `json:"checked"`
+	Skipped          int       `json:"skipped"`
+	Affected         int       `json:"affected"`
+	RenewalTriggered int       `json:"renewalTriggered"`
+	RenewalFailed    int       `json:"renewalFailed"`
+	// FailedNamespaces lists the namespaces that could not be scanned, if
+	// the results are partial.
+	FailedNamespaces []string `json:"failedNamespaces,omitempty"`
+	Error            string   `json:"error,omitempty"`
+}
+
+// runHistory holds the summaries of the most recent scheduled scans. It is
+// safe for concurrent use.
+type runHistory struct {
+	lock sync.Mutex
+	runs []runSummary
+}
+
+func (h *runHistory) add(s runSummary) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.runs = append(h.runs, s)
+	if len(h.runs) > runHistorySize {
+		h.runs = h.runs[len(h.runs)-runHistorySize:]
+	}
+}
+
+// list returns the retained summaries, oldest first.
+func (h *runHistory) list() []runSummary {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]runSummary{}, h.runs...)
+}
+
+// scheduledScanner performs a full scan of the cluster on --scan-schedule.
+type scheduledScanner struct {
+	schedule *cronSchedule
+	client   client.Client
+	// reader reads directly from the API server, so that scans do not need
+	// to cache every Certificate and Secret in the cluster.
+	reader   client.Reader
+	dataset  *dataset
+	findings *findings
+	history  *runHistory
+}
+
+// Start implements manager.Runnable, so that scheduled scans only run on
+// the leader when leader election is enabled.
+func (s *scheduledScanner) Start(stopCh <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	for {
+		next := s.schedule.next(time.Now())
+		log.Printf("Next scheduled scan at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stopCh:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		s.history.add(s.run(ctx))
+	}
+}
+
+// run performs a single scheduled scan, triggering renewals of affected
+// Certificates if --renew is set.
+func (s *scheduledScanner) run(ctx context.Context) runSummary {
+	start := time.Now()
+	log.Printf("Starting scheduled scan")
+	if refreshDataset {
+		if err := s.dataset.refresh(); err != nil {
+			log.Printf("Failed to refresh affected serials, using previously loaded serials: %v", err)
+		}
+	}
+
+	results, err := runScan(ctx, newScanner(s.reader, s.dataset.get()))
+	if err != nil {
+		log.Printf("Scheduled scan failed: %v", err)
+		n := newScanNotification(start, nil, err)
+		notifyScanComplete(ctx, n)
+		return n.Summary
+	}
+	recordScanMetrics(results)
+	if renew {
+		for _, crt := range renewalTargets(ctx, s.reader, results.Affected) {
+			log.Printf("Triggering renewal of Certificate %s/%s", crt.Namespace, crt.Name)
+			err := renewCertificate(ctx, s.client, crt)
+			results.recordRenewal(crt, err)
+			if err != nil {
+				log.Printf("Failed to renew certificate %s/%s: %v", crt.Namespace, crt.Name, err)
+				metricRenewals.WithLabelValues("failed").Inc()
+				continue
+			}
+			metricRenewals.WithLabelValues("triggered").Inc()
+		}
+	}
+
+	n := newScanNotification(start, results, nil)
+	for _, f := range n.Certificates {
+		if s.findings.record(f) {
+			notifyCertificateAffected(ctx, f)
+		}
+	}
+	notifyScanComplete(ctx, n)
+	if namespaceReports {
+		if err := writeNamespaceReports(ctx, s.client, results); err != nil {
+			log.Printf("Failed to write namespace reports: %v", err)
+		}
+	}
+	log.Printf("Scheduled scan complete in %s: %d checked, %d skipped, %d affected, %d renewals triggered, %d renewals failed",
+		time.Since(start).Round(time.Second), n.Summary.Checked, n.Summary.Skipped, n.Summary.Affected, n.Summary.RenewalTriggered, n.Summary.RenewalFailed)
+	return n.Summary
+}
+
+// newScheduledScanner parses --scan-schedule, returning nil if it is not
+// set.
+func newScheduledScanner(cl client.Client, reader client.Reader, d *dataset, f *findings, h *runHistory) (*scheduledScanner, error) {
+	if scanSchedule == "" {
+		return nil, nil
+	}
+	schedule, err := parseCronSchedule(scanSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --scan-schedule: %w", err)
+	}
+	if schedule.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid --scan-schedule: %q never matches", scanSchedule)
+	}
+	return &scheduledScanner{schedule: schedule, client: cl, reader: reader, dataset: d, findings: f, history: h}, nil
+}
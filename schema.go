@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+// The schemaVersion of each machine-readable output. Within a version, fields
+// are only ever added: existing fields are not removed, renamed or given a
+// different meaning, so consumers should ignore fields they do not recognise.
+// Any other change increases the version. The JSON Schema for each version is
+// kept in the schemas directory.
+const (
+	reportSchemaVersion             = 1
+	namespaceReportSchemaVersion    = 1
+	auditRecordSchemaVersion        = 1
+	webhookSchemaVersion            = 1
+	apiTraceRecordSchemaVersion     = 1
+	verificationReportSchemaVersion = 1
+	inventorySchemaVersion          = 1
+	eventSchemaVersion              = 1
+	outcomesSchemaVersion           = 1
+)
+
+// checkSchemaVersion returns an error if what, which was written with the
+// given schemaVersion, is too new to be read. Outputs written before
+// schemaVersion was added have a version of 0, and are compatible with
+// version 1.
+func checkSchemaVersion(what string, version, supported int) error {
+	if version > supported {
+		return fmt.Errorf("%s has schemaVersion %d, but this version of the tool only supports versions up to %d", what, version, supported)
+	}
+	return nil
+}
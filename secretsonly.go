@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+var secretsOnly bool
+
+func init() {
+	flag.BoolVar(&secretsOnly, "secrets-only", false, "If true, every Secret of type kubernetes.io/tls is checked instead of the Secrets of cert-manager Certificates, for clusters without cert-manager where certificates are obtained by other means, such as certbot sidecars. Affected Secrets are reported but cannot be renewed. Requires permission to list Secrets.")
+}
+
+func validateSecretsOnlyFlags() error {
+	if !secretsOnly {
+		return nil
+	}
+	if renew && !adoptOrphanedSecrets {
+		return fmt.Errorf("--secrets-only cannot be combined with --renew, as Secrets that are not managed by cert-manager cannot be renewed by this tool, unless --adopt-orphaned-secrets is set")
+	}
+	if watchMode {
+		return fmt.Errorf("--secrets-only cannot be combined with --watch")
+	}
+	if cleanupFailedRequests {
+		return fmt.Errorf("--secrets-only cannot be combined with --cleanup-failed-requests")
+	}
+	if cacheFile != "" {
+		return fmt.Errorf("--secrets-only cannot be combined with --cache-file, as Secrets are listed rather than fetched individually")
+	}
+	return nil
+}
+
+// checkCertificateCRD returns an error explaining how to proceed if the
+// cert-manager Certificate CRD is not installed, instead of failing part way
+// through the scan with an obscure error from the first List call.
+func checkCertificateCRD(cfg *rest.Config) error {
+	installed, err := certificateCRDInstalled(cfg)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("the cert-manager Certificate CRD (%s) is not installed in this cluster, so there are no Certificates to check. "+
+			"If certificates are obtained some other way, for example by certbot sidecars or by hand, run again with --secrets-only "+
+			"to check every Secret of type kubernetes.io/tls instead", capi.SchemeGroupVersion)
+	}
+	return nil
+}
+
+// certificateCRDInstalled uses discovery to find out whether the API server
+// serves cert-manager Certificates.
+func certificateCRDInstalled(cfg *rest.Config) (bool, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return false, fmt.Errorf("error building discovery client: %w", err)
+	}
+	resources, err := dc.ServerResourcesForGroupVersion(capi.SchemeGroupVersion.String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error discovering cert-manager resources: %w", err)
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == "certificates" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// logSecretsOnly explains why the affected Secrets found by a --secrets-only
+// scan are not renewed.
+func logSecretsOnly(n int) {
+	log.Println()
+	log.Printf("WARNING: %d affected TLS Secret(s) are not managed by cert-manager, so they will NOT be renewed. "+
+		"Replace their certificates using whatever issued them, for example by forcing certbot to renew.", n)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	lecaa "github.com/jetstack/letsencrypt-caa-bug-checker/pkg/apis/lecaa/v1alpha1"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/reconciler"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// serveScheme contains the types used by the 'serve' command.
+var serveScheme = runtime.NewScheme()
+
+func init() {
+	clientgoscheme.AddToScheme(serveScheme)
+	capi.AddToScheme(serveScheme)
+	lecaa.AddToScheme(serveScheme)
+}
+
+// runServe implements the 'serve' command, which runs the tool as a
+// long-lived controller that continuously reconciles Certificates against the
+// affected serials, triggering renewals if --renew is set.
+func runServe(args []string) error {
+	requireAffectedSerialsFile()
+	if readOnly {
+		return fmt.Errorf("--read-only is not supported by the 'serve' command, which writes Leases and the status of CAABugScans")
+	}
+	if err := validateRemediatorFlags(); err != nil {
+		return err
+	}
+	if err := validateRenewHookFlags(); err != nil {
+		return err
+	}
+	if err := loadTemplates(); err != nil {
+		return err
+	}
+	if renew {
+		log.Printf("!!!!! --renew has been set to TRUE. Any affected certificates will have a renewal automatically triggered when found !!!!!")
+	}
+	stopTracing, err := startTracing()
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+	closeAPITrace, err := openAPITrace()
+	if err != nil {
+		return err
+	}
+	defer closeAPITrace()
+	if err := setupNotifiers(); err != nil {
+		return err
+	}
+	if err := setupRedaction(); err != nil {
+		return err
+	}
+	closeAuditLog, err := openAuditLog()
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+	closeEventStream, err := openEventStream()
+	if err != nil {
+		return err
+	}
+	defer closeEventStream()
+	cfg := restConfig()
+	if err := checkPermissions(cfg, "serve"); err != nil {
+		return err
+	}
+	probes, err := startProbes(cfg)
+	if err != nil {
+		return err
+	}
+	d, err := loadDataset()
+	if err != nil {
+		return err
+	}
+	probes.markDatasetLoaded()
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             serveScheme,
+		MetricsBindAddress: serveMetricsAddrOrDisabled(),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating controller manager: %w", err)
+	}
+	if certManagerPreflightRequired() {
+		reader := mgr.GetAPIReader()
+		serveHealthCheck = newCachedHealthCheck(func(ctx context.Context) error {
+			return checkCertManagerHealth(ctx, cfg, reader)
+		})
+	}
+	findings := newFindings()
+	if err := registerCertificateMetrics(findings); err != nil {
+		return err
+	}
+	history := &runHistory{}
+	if err := setupCertificateController(mgr, d, findings); err != nil {
+		return err
+	}
+	if err := setupScanController(mgr, d); err != nil {
+		return err
+	}
+	scheduled, err := newScheduledScanner(mgr.GetClient(), mgr.GetAPIReader(), d, findings, history)
+	if err != nil {
+		return err
+	}
+	if scheduled != nil {
+		if err := mgr.Add(scheduled); err != nil {
+			return err
+		}
+	}
+	// Findings are only served once the controllers are running, which may
+	// never happen on a standby replica.
+	if err := mgr.Add(manager.RunnableFunc(func(stopCh <-chan struct{}) error {
+		findings.setActive(true)
+		<-stopCh
+		findings.setActive(false)
+		return nil
+	})); err != nil {
+		return err
+	}
+	if err := startAPIServer(mgr.GetClient(), findings, history); err != nil {
+		return err
+	}
+	if err := startDashboard(mgr.GetClient(), findings); err != nil {
+		return err
+	}
+
+	stopCh := ctrl.SetupSignalHandler()
+	if leaderElect {
+		return runWithLeaderElection(cfg, stopCh, func(stopCh <-chan struct{}) error {
+			return startController(cfg, mgr, stopCh)
+		})
+	}
+	return startController(cfg, mgr, stopCh)
+}
+
+// startController starts mgr, holding the run lock while it runs so that a
+// one-off run cannot make changes at the same time as the controller.
+func startController(cfg *rest.Config, mgr ctrl.Manager, stopCh <-chan struct{}) error {
+	releaseRunLock, err := acquireRunLock(cfg)
+	if err != nil {
+		return err
+	}
+	defer releaseRunLock()
+	log.Printf("Starting controller")
+	return mgr.Start(stopCh)
+}
+
+// setupCertificateController adds a controller that checks whether each
+// Certificate is affected whenever it or its Secret changes, recording the
+// results in findings.
+func setupCertificateController(mgr ctrl.Manager, d *dataset, findings *findings) error {
+	r := &reconciler.Reconciler{
+		Serials:                 d.get,
+		Detector:                newDetector(),
+		KeystorePassword:        keystorePassword(mgr.GetAPIReader()),
+		PausedAnnotations:       pausedAnnotations(),
+		IssuanceWindow:          issuanceWindow(),
+		CheckIssuers:            checkIssuers(),
+		ACMEServers:             acmeServers(),
+		MaxConcurrentReconciles: scanConcurrency,
+		Events:                  scannerEvents(),
+		OnAffected: func(ctx context.Context, a scanner.AffectedCertificate, renewalTriggered bool, renewalErr error) {
+			crt := a.Certificate
+			f := finding{Namespace: crt.Namespace, Name: crt.Name, SecretName: crt.Spec.SecretName, IssuerName: crt.Spec.IssuerRef.Name, IssuerKind: crt.Spec.IssuerRef.Kind, Serial: a.Serial, Reason: a.Reason, ReplicatedFrom: a.ReplicatedFrom, SharesSecretWith: a.SharesSecretWith, PausedBy: a.PausedBy, Warning: a.Warning, IssuerProblem: a.IssuerProblem, Suggestions: suggestRemediation(a), RenewalTriggered: renewalTriggered}
+			if renewalErr != nil {
+				f.setRenewalError(renewalErr)
+				metricRenewals.WithLabelValues("failed").Inc()
+			} else if renewalTriggered {
+				metricRenewals.WithLabelValues("triggered").Inc()
+			}
+			// Notifications are only sent when a Certificate newly becomes
+			// affected.
+			if findings.record(f) {
+				notifyCertificateAffected(ctx, f)
+			}
+		},
+		OnUnaffected: func(_ context.Context, key types.NamespacedName) {
+			findings.remove(key.String())
+		},
+	}
+	if renew {
+		remediator, err := newRemediator(mgr.GetClient())
+		if err != nil {
+			return err
+		}
+		r.Remediator = remediator
+		// The certificates issued are listed afresh for each Certificate, as
+		// they change while the controller runs, and Orders are not cached.
+		reader := mgr.GetAPIReader()
+		r.CheckDuplicates = func(ctx context.Context, crt capi.Certificate) string {
+			return newDuplicateChecker(reader).blocks(ctx, crt)
+		}
+	}
+	return r.SetupWithManager(mgr)
+}
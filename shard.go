@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+)
+
+var (
+	shardIndex int
+	shardCount int
+)
+
+func init() {
+	flag.IntVar(&shardIndex, "shard-index", 0, "The index of the shard of namespaces to scan, from 0 to --shard-count-1.")
+	flag.IntVar(&shardCount, "shard-count", 1, "The number of shards to split namespaces into. Each invocation of the tool with a different --shard-index will scan a disjoint set of namespaces.")
+}
+
+func validateShardFlags() error {
+	if shardCount < 1 {
+		return fmt.Errorf("--shard-count must be at least 1")
+	}
+	if shardIndex < 0 || shardIndex >= shardCount {
+		return fmt.Errorf("--shard-index must be between 0 and %d", shardCount-1)
+	}
+	return nil
+}
+
+// inShard returns true if the given namespace belongs to the shard selected
+// with --shard-index. Namespaces are assigned to shards by a hash of their
+// name, so every invocation agrees on the assignment without coordination.
+func inShard(namespace string) bool {
+	if shardCount <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	return int(h.Sum32()%uint32(shardCount)) == shardIndex
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+var (
+	datasetSignature string
+	datasetKey       string
+)
+
+func init() {
+	flag.StringVar(&datasetSignature, "dataset-signature", "", "Path or http(s) URL of a detached signature over the affected serials file, after decompression. If set, the file is only loaded if the signature was made with --dataset-key. Both GPG signatures (armored or binary) and cosign blob signatures are supported.")
+	flag.StringVar(&datasetKey, "dataset-key", "", "Path to the public key that --dataset-signature must have been made with: either a GPG public keyring (armored or binary), or a PEM-encoded ECDSA or RSA public key for cosign signatures.")
+}
+
+// verifyDatasetSignature checks the affected serials file at path against
+// --dataset-signature, so that a tampered copy, for example one served by a
+// compromised mirror with affected serials removed, is refused rather than
+// reporting affected certificates as unaffected.
+func verifyDatasetSignature(path string) error {
+	if datasetSignature == "" && datasetKey == "" {
+		return nil
+	}
+	if datasetSignature == "" || datasetKey == "" {
+		return fmt.Errorf("--dataset-signature and --dataset-key must be set together")
+	}
+	key, err := ioutil.ReadFile(datasetKey)
+	if err != nil {
+		return fmt.Errorf("error reading --dataset-key: %w", err)
+	}
+	sig, err := readSignature(datasetSignature)
+	if err != nil {
+		return fmt.Errorf("error reading --dataset-signature: %w", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	signer, err := verifySignature(key, sig, f)
+	if err != nil {
+		return fmt.Errorf("refusing to load affected serials file %q, as its signature could not be verified: %w", path, err)
+	}
+	log.Printf("Verified the signature of the affected serials file, made by %s", signer)
+	return nil
+}
+
+// readSignature reads the signature at location, which is either a path or
+// an http(s) URL.
+func readSignature(location string) ([]byte, error) {
+	if !isHTTPURL(location) {
+		return ioutil.ReadFile(location)
+	}
+	resp, err := http.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	// Signatures are small, so anything larger is not one.
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func isHTTPURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// verifySignature checks that sig is a valid signature over signed, made with
+// key, and returns a description of the signer. The kind of signature is
+// chosen by the kind of key.
+func verifySignature(key, sig []byte, signed io.Reader) (string, error) {
+	if block, _ := pem.Decode(key); block != nil && block.Type == "PUBLIC KEY" {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("error parsing public key: %w", err)
+		}
+		if err := verifyCosignSignature(pub, sig, signed); err != nil {
+			return "", err
+		}
+		return "the public key in " + datasetKey, nil
+	}
+	return verifyGPGSignature(key, sig, signed)
+}
+
+// verifyGPGSignature checks a detached GPG signature made by a key in
+// keyring, either of which may be armored.
+func verifyGPGSignature(keyring, sig []byte, signed io.Reader) (string, error) {
+	var keys openpgp.EntityList
+	var err error
+	if bytes.Contains(keyring, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(keyring))
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading GPG keyring: %w", err)
+	}
+	var signer *openpgp.Entity
+	if bytes.Contains(sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keys, signed, bytes.NewReader(sig))
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keys, signed, bytes.NewReader(sig))
+	}
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for name, id := range signer.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+			names = []string{name}
+			break
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("GPG key %X", signer.PrimaryKey.Fingerprint), nil
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("GPG key %X (%s)", signer.PrimaryKey.Fingerprint, names[0]), nil
+}
+
+// verifyCosignSignature checks a signature made with 'cosign sign-blob',
+// which is the base64-encoded signature of the SHA-256 digest of signed.
+func verifyCosignSignature(pub crypto.PublicKey, sig []byte, signed io.Reader) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("error decoding cosign signature: %w", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, signed); err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var esig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(raw, &esig); err != nil || len(rest) > 0 {
+			return errors.New("malformed ECDSA signature")
+		}
+		if !ecdsa.Verify(pub, digest, esig.R, esig.S) {
+			return errors.New("ECDSA signature does not match")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, raw)
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
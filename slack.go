@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	slackWebhookURL      string
+	slackTokenFile       string
+	slackChannel         string
+	slackThreadStateFile string
+)
+
+func init() {
+	flag.StringVar(&slackWebhookURL, "slack-webhook-url", "", "If set, a summary of each scan is posted to this Slack incoming webhook URL.")
+	flag.StringVar(&slackTokenFile, "slack-token-file", "", "The path to a file containing a Slack bot token. If set along with --slack-channel, a summary of each scan is posted to the channel, with summaries of later scans posted as replies to the first.")
+	flag.StringVar(&slackChannel, "slack-channel", "", "The Slack channel to post to when --slack-token-file is set.")
+	flag.StringVar(&slackThreadStateFile, "slack-thread-state-file", "", "Optional path to a file used to remember the Slack message that later scans are threaded under, so that separate runs of the tool share a thread.")
+}
+
+const (
+	slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+	// slackTopNamespaces is the number of namespaces listed in summaries.
+	slackTopNamespaces = 5
+	// slackMaxFailures is the number of failed renewals listed in summaries,
+	// keeping messages within Slack's size limits.
+	slackMaxFailures = 10
+)
+
+// slackNotifier posts a summary of each scan to Slack, using either an
+// incoming webhook or a bot token. Only bot tokens support threading.
+type slackNotifier struct {
+	webhookURL string
+	token      string
+	channel    string
+	stateFile  string
+
+	lock sync.Mutex
+	// threadTS is the timestamp of the first message posted, which later
+	// messages are posted as replies to.
+	threadTS string
+}
+
+// slackThreadState is stored in --slack-thread-state-file.
+type slackThreadState struct {
+	Channel  string `json:"channel"`
+	ThreadTS string `json:"threadTS"`
+}
+
+func newSlackNotifier() (*slackNotifier, error) {
+	n := &slackNotifier{webhookURL: slackWebhookURL, channel: slackChannel, stateFile: slackThreadStateFile}
+	if slackTokenFile == "" {
+		return n, nil
+	}
+	if slackChannel == "" {
+		return nil, fmt.Errorf("--slack-channel must be set when --slack-token-file is set")
+	}
+	token, err := ioutil.ReadFile(slackTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Slack token file: %w", err)
+	}
+	n.token = strings.TrimSpace(string(token))
+	if n.stateFile != "" {
+		data, err := ioutil.ReadFile(n.stateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading Slack thread state file: %w", err)
+		}
+		var state slackThreadState
+		if err == nil {
+			if err := json.Unmarshal(data, &state); err != nil {
+				return nil, fmt.Errorf("error reading Slack thread state file: %w", err)
+			}
+		}
+		if state.Channel == slackChannel {
+			n.threadTS = state.ThreadTS
+		}
+	}
+	return n, nil
+}
+
+func (s *slackNotifier) name() string { return "Slack" }
+
+// slackMessage is the subset of the Slack message payload that is used.
+type slackMessage struct {
+	Channel  string       `json:"channel,omitempty"`
+	ThreadTS string       `json:"thread_ts,omitempty"`
+	Text     string       `json:"text"`
+	Blocks   []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func slackSection(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+}
+
+// slackSummary formats n as a Slack message.
+func slackSummary(n *scanNotification) slackMessage {
+	msg := slackMessage{Text: n.title()}
+	msg.Blocks = append(msg.Blocks, slackSection("*"+n.title()+"*"))
+	if n.Summary.Error != "" {
+		msg.Blocks = append(msg.Blocks, slackSection(fmt.Sprintf("Error: `%s`", n.Summary.Error)))
+		return msg
+	}
+	msg.Blocks = append(msg.Blocks, slackSection(fmt.Sprintf(
+		"Checked: *%d*  Skipped: *%d*  Affected: *%d*\nRenewals triggered: *%d*  Renewals failed: *%d*",
+		n.Summary.Checked, n.Summary.Skipped, n.Summary.Affected, n.Summary.RenewalTriggered, n.Summary.RenewalFailed)))
+	if top := n.topNamespaces(slackTopNamespaces); len(top) > 0 {
+		var b strings.Builder
+		b.WriteString("*Top namespaces*")
+		for _, ns := range top {
+			fmt.Fprintf(&b, "\n• `%s`: %d affected", ns.Namespace, ns.Affected)
+		}
+		msg.Blocks = append(msg.Blocks, slackSection(b.String()))
+	}
+	var failed strings.Builder
+	listed := 0
+	for _, c := range n.Certificates {
+		if c.RenewalError == "" {
+			continue
+		}
+		if listed == slackMaxFailures {
+			fmt.Fprintf(&failed, "\n…and %d more", n.Summary.RenewalFailed-listed)
+			break
+		}
+		fmt.Fprintf(&failed, "\n• `%s/%s`: %s", c.Namespace, c.Name, c.RenewalError)
+		listed++
+	}
+	if failed.Len() > 0 {
+		msg.Blocks = append(msg.Blocks, slackSection("*Failed renewals*"+failed.String()))
+	}
+	return msg
+}
+
+// slackMessageFor formats n with --slack-template, or as slackSummary if it
+// is not set.
+func slackMessageFor(n *scanNotification) (slackMessage, error) {
+	if slackMessageTemplate == nil {
+		return slackSummary(n), nil
+	}
+	text, err := executeTemplate(slackMessageTemplate, n)
+	if err != nil {
+		return slackMessage{}, err
+	}
+	return slackMessage{Text: n.title(), Blocks: []slackBlock{slackSection(text)}}, nil
+}
+
+func (s *slackNotifier) scanComplete(ctx context.Context, n *scanNotification) error {
+	msg, err := slackMessageFor(n)
+	if err != nil {
+		return err
+	}
+	if s.webhookURL != "" {
+		if err := postJSON(ctx, s.webhookURL, msg, nil, nil); err != nil {
+			return err
+		}
+	}
+	if s.token == "" {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	msg.Channel = s.channel
+	msg.ThreadTS = s.threadTS
+	var resp struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+		TS    string `json:"ts"`
+	}
+	if err := postJSON(ctx, slackPostMessageURL, msg, map[string]string{"Authorization": "Bearer " + s.token}, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("error posting Slack message: %s", resp.Error)
+	}
+	if s.threadTS != "" {
+		return nil
+	}
+	s.threadTS = resp.TS
+	if s.stateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(slackThreadState{Channel: s.channel, ThreadTS: s.threadTS})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.stateFile, data, 0644); err != nil {
+		return fmt.Errorf("error writing Slack thread state file: %w", err)
+	}
+	return nil
+}
+
+// certificateAffected does nothing, as Slack only receives summaries.
+func (s *slackNotifier) certificateAffected(context.Context, finding) error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+// gitOpsOwners are the labels and annotations set by GitOps tools on the
+// objects they apply, and the tool each names. Changes made to such objects
+// with kubectl are reverted, so they must be made in their source instead.
+var gitOpsOwners = []struct {
+	key  string
+	tool string
+}{
+	{"argocd.argoproj.io/instance", "Argo CD application"},
+	{"argocd.argoproj.io/tracking-id", "Argo CD application"},
+	{"kustomize.toolkit.fluxcd.io/name", "Flux Kustomization"},
+	{"helm.toolkit.fluxcd.io/name", "Flux HelmRelease"},
+	{"meta.helm.sh/release-name", "Helm release"},
+}
+
+// gitOpsOwner describes the GitOps tool managing crt, such as
+// `Argo CD application "web"`, or returns "" if there is none.
+func gitOpsOwner(crt capi.Certificate) string {
+	for _, o := range gitOpsOwners {
+		name := crt.Labels[o.key]
+		if name == "" {
+			name = crt.Annotations[o.key]
+		}
+		if name == "" {
+			continue
+		}
+		// Argo CD tracking IDs are of the form app:group/kind:namespace/name.
+		name = strings.SplitN(name, ":", 2)[0]
+		return fmt.Sprintf("%s %q", o.tool, name)
+	}
+	return ""
+}
+
+// suggestRemediation returns the steps an engineer could take to fix a, if
+// it cannot be renewed automatically, as commands or changes to manifests.
+// It returns nil if there is nothing to suggest.
+func suggestRemediation(a scanner.AffectedCertificate) []string {
+	crt := a.Certificate
+	ns := crt.Namespace
+	var steps []string
+	if secretsOnly {
+		steps = append(steps,
+			fmt.Sprintf("Replace the certificate using whatever issued it, for example with `certbot renew --force-renewal --cert-name <name>`, then update the Secret with `kubectl create secret tls %s -n %s --cert=fullchain.pem --key=privkey.pem --dry-run=client -o yaml | kubectl apply -f -`", crt.Spec.SecretName, ns),
+			"Or, if cert-manager is installed, let it take over the Secret by running again with `--secrets-only --adopt-orphaned-secrets --adopt-issuer ClusterIssuer/<name> --renew`",
+		)
+		return steps
+	}
+	// Changes to the Certificate itself must be made in its source if a
+	// GitOps tool manages it, or they would be reverted.
+	edit := func(kubectl, manifest string) string {
+		if owner := gitOpsOwner(crt); owner != "" {
+			return fmt.Sprintf("%s in the source of %s, which manages the Certificate", manifest, owner)
+		}
+		return fmt.Sprintf("with `%s`", kubectl)
+	}
+	switch {
+	case a.ReplicatedFrom != "":
+		steps = append(steps, fmt.Sprintf("Renew the Certificate of the source Secret %s, for example by running again with `--renew`, then check that the serial number shown by `kubectl get secret %s -n %s -o jsonpath='{.data.tls\\.crt}' | base64 -d | openssl x509 -noout -serial` has changed once it has been replicated", a.ReplicatedFrom, crt.Spec.SecretName, ns))
+	case len(a.SharesSecretWith) > 0:
+		steps = append(steps, fmt.Sprintf("Give each Certificate its own Secret by changing `spec.secretName` of %s (and of the workloads mounting it), so that only one Certificate writes to Secret %s/%s, then run again with `--renew`", strings.Join(a.SharesSecretWith, ", "), ns, crt.Spec.SecretName))
+		if owner := gitOpsOwner(crt); owner != "" {
+			steps = append(steps, fmt.Sprintf("Certificate %s/%s is managed by %s, so make the change in its source", ns, crt.Name, owner))
+		}
+	case a.Warning != "":
+		steps = append(steps, fmt.Sprintf("Check the serial number and dates of the certificate with `kubectl get secret %s -n %s -o jsonpath='{.data.tls\\.crt}' | base64 -d | openssl x509 -noout -serial -dates`, and compare them with the affected serials. If it is affected, renew it with `kubectl annotate secret %s -n %s --overwrite cert-manager.io/issuer-name=force-renewal-triggered`", crt.Spec.SecretName, ns, crt.Spec.SecretName, ns))
+	case a.PausedBy != "":
+		steps = append(steps, "Unpause the Certificate "+edit(
+			fmt.Sprintf("kubectl annotate certificate %s -n %s %s-", crt.Name, ns, a.PausedBy),
+			fmt.Sprintf("by removing the %s annotation", a.PausedBy),
+		)+", then run again with `--renew`")
+	case a.IssuerProblem != "":
+		ref := crt.Spec.IssuerRef
+		kind := ref.Kind
+		if kind == "" {
+			kind = capi.IssuerKind
+		}
+		describe := fmt.Sprintf("kubectl describe %s %s", strings.ToLower(kind), ref.Name)
+		if kind == capi.IssuerKind {
+			describe += " -n " + ns
+		}
+		fix := fmt.Sprintf("Fix %s %q, whose conditions and events are shown by `%s`", kind, ref.Name, describe)
+		if strings.HasPrefix(a.IssuerProblem, "issuer missing") {
+			fix = fmt.Sprintf("Recreate %s %q", kind, ref.Name)
+		}
+		steps = append(steps, fix+", or point the Certificate at a working issuer "+edit(
+			fmt.Sprintf(`kubectl patch certificate %s -n %s --type merge -p '{"spec":{"issuerRef":{"kind":"ClusterIssuer","name":"<name>"}}}'`, crt.Name, ns),
+			"by changing `spec.issuerRef`",
+		)+", then run again with `--renew`")
+	}
+	return steps
+}
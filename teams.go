@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+var teamsWebhookURL string
+
+func init() {
+	flag.StringVar(&teamsWebhookURL, "teams-webhook-url", "", "If set, a summary of each scan is posted to this Microsoft Teams incoming webhook URL as an adaptive card.")
+}
+
+// teamsNotifier posts a summary of each scan to a Microsoft Teams incoming
+// webhook.
+type teamsNotifier struct {
+	webhookURL string
+}
+
+func (t *teamsNotifier) name() string { return "Teams" }
+
+// teamsMessage is the subset of the Teams webhook payload that is used.
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string        `json:"$schema"`
+	Type    string        `json:"type"`
+	Version string        `json:"version"`
+	Body    []interface{} `json:"body"`
+}
+
+type teamsTextBlock struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Size   string `json:"size,omitempty"`
+	Weight string `json:"weight,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Wrap   bool   `json:"wrap"`
+}
+
+type teamsFactSet struct {
+	Type  string      `json:"type"`
+	Facts []teamsFact `json:"facts"`
+}
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+func teamsText(text string) teamsTextBlock {
+	return teamsTextBlock{Type: "TextBlock", Text: text, Wrap: true}
+}
+
+func teamsFacts(facts ...teamsFact) teamsFactSet {
+	return teamsFactSet{Type: "FactSet", Facts: facts}
+}
+
+// teamsSummary formats n as an adaptive card, with the same content as
+// slackSummary.
+func teamsSummary(n *scanNotification) teamsMessage {
+	title := teamsText(n.title())
+	title.Size, title.Weight = "Large", "Bolder"
+	if n.Summary.Error != "" || n.Summary.Affected > 0 {
+		title.Color = "Attention"
+	}
+	body := []interface{}{title}
+	if n.Summary.Error != "" {
+		body = append(body, teamsText(fmt.Sprintf("Error: `%s`", n.Summary.Error)))
+	} else {
+		body = append(body, teamsFacts(
+			teamsFact{Title: "Checked", Value: fmt.Sprint(n.Summary.Checked)},
+			teamsFact{Title: "Skipped", Value: fmt.Sprint(n.Summary.Skipped)},
+			teamsFact{Title: "Affected", Value: fmt.Sprint(n.Summary.Affected)},
+			teamsFact{Title: "Renewals triggered", Value: fmt.Sprint(n.Summary.RenewalTriggered)},
+			teamsFact{Title: "Renewals failed", Value: fmt.Sprint(n.Summary.RenewalFailed)},
+		))
+		if top := n.topNamespaces(slackTopNamespaces); len(top) > 0 {
+			heading := teamsText("Top namespaces")
+			heading.Weight = "Bolder"
+			facts := teamsFacts()
+			for _, ns := range top {
+				facts.Facts = append(facts.Facts, teamsFact{Title: ns.Namespace, Value: fmt.Sprintf("%d affected", ns.Affected)})
+			}
+			body = append(body, heading, facts)
+		}
+		failed := teamsFacts()
+		for _, c := range n.Certificates {
+			if c.RenewalError == "" {
+				continue
+			}
+			if len(failed.Facts) == slackMaxFailures {
+				failed.Facts = append(failed.Facts, teamsFact{Title: "…", Value: fmt.Sprintf("and %d more", n.Summary.RenewalFailed-slackMaxFailures)})
+				break
+			}
+			failed.Facts = append(failed.Facts, teamsFact{Title: c.Namespace + "/" + c.Name, Value: c.RenewalError})
+		}
+		if len(failed.Facts) > 0 {
+			heading := teamsText("Failed renewals")
+			heading.Weight = "Bolder"
+			body = append(body, heading, failed)
+		}
+	}
+	return teamsCardMessage(body)
+}
+
+// teamsMessageFor formats n with --teams-template, or as teamsSummary if it
+// is not set.
+func teamsMessageFor(n *scanNotification) (teamsMessage, error) {
+	if teamsMessageTemplate == nil {
+		return teamsSummary(n), nil
+	}
+	text, err := executeTemplate(teamsMessageTemplate, n)
+	if err != nil {
+		return teamsMessage{}, err
+	}
+	return teamsCardMessage([]interface{}{teamsText(text)}), nil
+}
+
+// teamsCardMessage returns a message holding an adaptive card with body.
+func teamsCardMessage(body []interface{}) teamsMessage {
+	return teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: teamsCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body:    body,
+			},
+		}},
+	}
+}
+
+func (t *teamsNotifier) scanComplete(ctx context.Context, n *scanNotification) error {
+	msg, err := teamsMessageFor(n)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, t.webhookURL, msg, nil, nil)
+}
+
+// certificateAffected does nothing, as Teams only receives summaries.
+func (t *teamsNotifier) certificateAffected(context.Context, finding) error {
+	return nil
+}
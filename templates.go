@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	reportTemplateFile string
+	emailTemplateFile  string
+	slackTemplateFile  string
+	teamsTemplateFile  string
+	markdownReportFile string
+
+	// The templates loaded from the flags above by loadTemplates, or nil if
+	// the built in format is used.
+	reportTemplate       *template.Template
+	customEmailTemplate  *htmltemplate.Template
+	slackMessageTemplate *template.Template
+	teamsMessageTemplate *template.Template
+)
+
+func init() {
+	flag.StringVar(&reportTemplateFile, "report-template", "", "Optional path to a Go text/template used instead of the built in Markdown report attached to emails and written to --markdown-report-file.")
+	flag.StringVar(&emailTemplateFile, "email-template", "", "Optional path to a Go html/template used instead of the built in body of the emails sent with --smtp-addr.")
+	flag.StringVar(&slackTemplateFile, "slack-template", "", "Optional path to a Go text/template producing the mrkdwn text of Slack messages, used instead of the built in summary.")
+	flag.StringVar(&teamsTemplateFile, "teams-template", "", "Optional path to a Go text/template producing the Markdown text of Microsoft Teams cards, used instead of the built in summary.")
+	flag.StringVar(&markdownReportFile, "markdown-report-file", "", "If set, the human-readable report of the scan, as attached to emails, is written to this file.")
+}
+
+// templateFuncs are the functions available to every template, in addition
+// to the Go template builtins.
+var templateFuncs = map[string]interface{}{
+	"title":         (*scanNotification).title,
+	"renewalStatus": renewalStatus,
+	"topNamespaces": (*scanNotification).topNamespaces,
+	"byNamespace":   (*scanNotification).byNamespace,
+	"join":          strings.Join,
+	// duration formats a number of seconds, such as Summary.DurationSeconds,
+	// rounded to the second.
+	"duration": func(seconds float64) string {
+		return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+	},
+	// formatTime formats t as RFC 3339, in UTC.
+	"formatTime": func(t time.Time) string {
+		return t.UTC().Format(time.RFC3339)
+	},
+}
+
+// loadTemplates parses the templates given by flags, so that a mistake in
+// one is found before the scan rather than when its results are sent.
+func loadTemplates() error {
+	var err error
+	if reportTemplate, err = parseTextTemplate(reportTemplateFile); err != nil {
+		return err
+	}
+	if slackMessageTemplate, err = parseTextTemplate(slackTemplateFile); err != nil {
+		return err
+	}
+	if teamsMessageTemplate, err = parseTextTemplate(teamsTemplateFile); err != nil {
+		return err
+	}
+	if emailTemplateFile != "" {
+		data, err := ioutil.ReadFile(emailTemplateFile)
+		if err != nil {
+			return fmt.Errorf("error reading template: %w", err)
+		}
+		if customEmailTemplate, err = htmltemplate.New(emailTemplateFile).Funcs(templateFuncs).Parse(string(data)); err != nil {
+			return fmt.Errorf("error parsing template %q: %w", emailTemplateFile, err)
+		}
+	}
+	return nil
+}
+
+// parseTextTemplate parses the text/template at path, returning nil if path
+// is not set.
+func parseTextTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template: %w", err)
+	}
+	t, err := template.New(path).Funcs(templateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %q: %w", path, err)
+	}
+	return t, nil
+}
+
+// executeTemplate renders t with n.
+func executeTemplate(t *template.Template, n *scanNotification) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, n); err != nil {
+		return "", fmt.Errorf("error executing template %q: %w", t.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// humanReport formats n with --report-template, or as the built in Markdown
+// report if it is not set.
+func humanReport(n *scanNotification) (string, error) {
+	if reportTemplate == nil {
+		return markdownReport(n), nil
+	}
+	return executeTemplate(reportTemplate, n)
+}
+
+// writeMarkdownReport writes the human-readable report of n to path.
+func writeMarkdownReport(path string, n *scanNotification) error {
+	if outputRedactor != nil {
+		n = outputRedactor.notification(n)
+	}
+	text, err := humanReport(n)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(text), 0644)
+}
+
+// namespaceFindings are the affected Certificates in a namespace.
+type namespaceFindings struct {
+	Namespace    string
+	Certificates []finding
+}
+
+// byNamespace returns the affected Certificates in n grouped by namespace,
+// sorted by name.
+func (n *scanNotification) byNamespace() []namespaceFindings {
+	index := make(map[string]int)
+	var groups []namespaceFindings
+	for _, c := range n.Certificates {
+		i, ok := index[c.Namespace]
+		if !ok {
+			i = len(groups)
+			index[c.Namespace] = i
+			groups = append(groups, namespaceFindings{Namespace: c.Namespace})
+		}
+		groups[i].Certificates = append(groups[i].Certificates, c)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Namespace < groups[j].Namespace })
+	return groups
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxThrottleRetries is the maximum number of times a single request will
+	// be retried after being rejected with 429 Too Many Requests.
+	maxThrottleRetries = 10
+	// maxThrottleBackoff caps the delay between retries.
+	maxThrottleBackoff = time.Minute
+)
+
+// throttledNanos is the total time spent backing off from API server
+// throttling, accessed atomically.
+var throttledNanos int64
+
+// totalThrottleDelay returns the total time spent backing off from API server
+// throttling so far.
+func totalThrottleDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&throttledNanos))
+}
+
+// throttleRetryTransport retries requests that are rejected by the API server
+// with 429 Too Many Requests, as happens when API Priority and Fairness or the
+// max-in-flight filter are shedding load. It waits for the duration given in
+// the Retry-After header, falling back to an exponential backoff if the header
+// is absent, so that the run slows down instead of failing.
+type throttleRetryTransport struct {
+	next http.RoundTripper
+}
+
+func wrapThrottleRetry(rt http.RoundTripper) http.RoundTripper {
+	return &throttleRetryTransport{next: rt}
+}
+
+func (t *throttleRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt > maxThrottleRetries {
+			return resp, err
+		}
+		// Requests with a body can only be retried if it can be replayed.
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := throttleDelay(resp, attempt)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		log.Printf("API server is throttling requests (%s %s), backing off for %s (retry %d of %d, %s spent backing off in total)",
+			req.Method, req.URL.Path, delay, attempt, maxThrottleRetries, totalThrottleDelay()+delay)
+		atomic.AddInt64(&throttledNanos, int64(delay))
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// throttleDelay returns how long to wait before retrying a throttled request.
+func throttleDelay(resp *http.Response, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		delay := time.Duration(secs) * time.Second
+		if delay > maxThrottleBackoff {
+			delay = maxThrottleBackoff
+		}
+		return delay
+	}
+	delay := time.Second << uint(attempt-1)
+	if delay > maxThrottleBackoff {
+		delay = maxThrottleBackoff
+	}
+	return delay
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+// Phases of a run that are timed, in addition to those of the scan itself.
+const (
+	phaseLoadSerials = "load-serials"
+	phaseRenew       = "renew"
+)
+
+// phaseOrder is the order phases are reported in.
+var phaseOrder = []string{phaseLoadSerials, scanner.PhaseList, scanner.PhaseFetch, scanner.PhaseDecode, scanner.PhaseMatch, phaseRenew}
+
+// slowestNamespacesReported is the number of namespaces included in the list
+// of slowest namespaces.
+const slowestNamespacesReported = 10
+
+// timings records how long each phase of a run took, overall and for each
+// namespace scanned. Because namespaces are scanned concurrently, the phase
+// durations are the sum of the time spent by every worker and may add up to
+// more than the wall-clock duration of the run. It is safe for concurrent use.
+type timings struct {
+	lock       sync.Mutex
+	phases     map[string]time.Duration
+	namespaces map[string]time.Duration
+}
+
+func newTimings() *timings {
+	return &timings{
+		phases:     make(map[string]time.Duration),
+		namespaces: make(map[string]time.Duration),
+	}
+}
+
+// AddPhase and AddNamespace implement scanner.Timer.
+func (t *timings) AddPhase(phase string, d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.phases[phase] += d
+}
+
+func (t *timings) AddNamespace(namespace string, d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.namespaces[namespace] += d
+}
+
+// phaseDurations returns a copy of the total time spent in each phase.
+func (t *timings) phaseDurations() map[string]time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	phases := make(map[string]time.Duration, len(t.phases))
+	for phase, d := range t.phases {
+		phases[phase] = d
+	}
+	return phases
+}
+
+type namespaceTiming struct {
+	Namespace string
+	Duration  time.Duration
+}
+
+// slowestNamespaces returns up to n namespaces that took the longest to scan,
+// slowest first.
+func (t *timings) slowestNamespaces(n int) []namespaceTiming {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var all []namespaceTiming
+	for ns, d := range t.namespaces {
+		all = append(all, namespaceTiming{Namespace: ns, Duration: d})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Duration != all[j].Duration {
+			return all[i].Duration > all[j].Duration
+		}
+		return all[i].Namespace < all[j].Namespace
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+func logTimings(t *timings) {
+	log.Printf("  Time spent in each phase:")
+	phases := t.phaseDurations()
+	for _, phase := range phaseOrder {
+		if d, ok := phases[phase]; ok {
+			log.Printf("    %s: %s", phase, d.Round(time.Millisecond))
+		}
+	}
+	slowest := t.slowestNamespaces(slowestNamespacesReported)
+	if len(slowest) == 0 {
+		return
+	}
+	log.Printf("  Slowest namespaces to scan:")
+	for _, ns := range slowest {
+		log.Printf("    %s: %s", ns.Namespace, ns.Duration.Round(time.Millisecond))
+	}
+}
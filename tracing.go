@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var (
+	otlpEndpoint string
+	otlpHeaders  string
+)
+
+func init() {
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "If set, trace spans for scans and renewals are exported to this OTLP/HTTP traces endpoint, for example http://otel-collector:4318/v1/traces.")
+	flag.StringVar(&otlpHeaders, "otlp-headers", "", "Comma separated key=value headers to send with each OTLP export request, for example to authenticate with a tracing backend.")
+}
+
+const (
+	tracingServiceName = "letsencrypt-caa-bug-checker"
+	// tracingBatchSize is the maximum number of spans sent in a single
+	// export request.
+	tracingBatchSize = 512
+	// tracingFlushInterval is how often buffered spans are exported.
+	tracingFlushInterval = 5 * time.Second
+)
+
+// tracer is the exporter used by startSpan. It is nil if tracing is
+// disabled, in which case spans are not recorded.
+var tracer *spanExporter
+
+// span is a single timed operation within a trace. A nil *span is valid and
+// does nothing, so callers do not need to check whether tracing is enabled.
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+
+	lock  sync.Mutex
+	attrs []otlpAttribute
+	err   error
+}
+
+type spanContextKey struct{}
+
+// startSpan starts a span as a child of the span in ctx, if any, returning
+// a context containing the new span.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{spanID: randomHex(8), name: name, start: time.Now()}
+	if parent, ok := ctx.Value(spanContextKey{}).(*span); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// setAttribute records a string, integer or boolean attribute on the span.
+func (s *span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	var v otlpValue
+	switch val := value.(type) {
+	case string:
+		v.StringValue = &val
+	case int:
+		i := strconv.Itoa(val)
+		v.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(val, 10)
+		v.IntValue = &i
+	case bool:
+		v.BoolValue = &val
+	default:
+		str := fmt.Sprint(val)
+		v.StringValue = &str
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attrs = append(s.attrs, otlpAttribute{Key: key, Value: v})
+}
+
+// finish ends the span, marking it as failed if err is not nil, and queues
+// it for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.end = time.Now()
+	s.err = err
+	s.lock.Unlock()
+	tracer.add(s)
+}
+
+// SetAttribute and Finish implement scanner.Span.
+func (s *span) SetAttribute(key string, value interface{}) { s.setAttribute(key, value) }
+func (s *span) Finish(err error)                           { s.finish(err) }
+
+// libraryTracer implements scanner.Tracer using startSpan, so that the
+// library packages are traced along with the rest of the tool.
+type libraryTracer struct{}
+
+func (libraryTracer) Start(ctx context.Context, name string) (context.Context, scanner.Span) {
+	return startSpan(ctx, name)
+}
+
+// spanExporter buffers finished spans and exports them to --otlp-endpoint
+// in batches.
+type spanExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+
+	lock    sync.Mutex
+	pending []*span
+	flushCh chan struct{}
+	done    chan struct{}
+}
+
+// startTracing starts exporting spans if --otlp-endpoint is set, returning
+// a function that flushes any remaining spans.
+func startTracing() (func(), error) {
+	if otlpEndpoint == "" {
+		return func() {}, nil
+	}
+	headers := make(map[string]string)
+	if otlpHeaders != "" {
+		for _, h := range strings.Split(otlpHeaders, ",") {
+			kv := strings.SplitN(h, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid --otlp-headers entry %q, expected key=value", h)
+			}
+			headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	tracer = &spanExporter{
+		endpoint: otlpEndpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		flushCh:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	stopCh := make(chan struct{})
+	go tracer.run(stopCh)
+	log.Printf("Exporting trace spans to %q", otlpEndpoint)
+	return func() {
+		close(stopCh)
+		<-tracer.done
+	}, nil
+}
+
+func (e *spanExporter) add(s *span) {
+	e.lock.Lock()
+	e.pending = append(e.pending, s)
+	full := len(e.pending) >= tracingBatchSize
+	e.lock.Unlock()
+	if full {
+		select {
+		case e.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *spanExporter) run(stopCh <-chan struct{}) {
+	defer close(e.done)
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flushCh:
+		case <-stopCh:
+			e.flush()
+			return
+		}
+		e.flush()
+	}
+}
+
+// flush exports every pending span. Spans that fail to export are dropped,
+// so that an unavailable tracing backend cannot cause unbounded memory use.
+func (e *spanExporter) flush() {
+	for {
+		e.lock.Lock()
+		n := len(e.pending)
+		if n > tracingBatchSize {
+			n = tracingBatchSize
+		}
+		batch := e.pending[:n]
+		e.pending = e.pending[n:]
+		e.lock.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("Failed to export %d trace spans: %v", len(batch), err)
+		}
+	}
+}
+
+func (e *spanExporter) export(spans []*span) error {
+	resource := []otlpAttribute{stringAttribute("service.name", tracingServiceName), stringAttribute("lecaa.run_id", runID)}
+	if clusterName != "" {
+		resource = append(resource, stringAttribute("k8s.cluster.name", clusterName))
+	}
+	req := otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: tracingServiceName},
+		}},
+	}}}
+	for _, s := range spans {
+		s.lock.Lock()
+		out := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.err != nil {
+			out.Status = &otlpStatus{Code: otlpStatusCodeError, Message: s.err.Error()}
+		}
+		s.lock.Unlock()
+		req.ResourceSpans[0].ScopeSpans[0].Spans = append(req.ResourceSpans[0].ScopeSpans[0].Spans, out)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		httpReq.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// The types below implement the JSON encoding of the OTLP trace export
+// request, as accepted by OTLP/HTTP receivers.
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+)
+
+var (
+	previousReportFile     string
+	verificationReportFile string
+)
+
+func init() {
+	flag.StringVar(&previousReportFile, "previous-report", "", "The report written by --report-file of an earlier scan, whose affected Certificates are checked by the 'verify' command.")
+	flag.StringVar(&verificationReportFile, "verification-report-file", "", "If set, the 'verify' command writes a JSON report of whether each previously affected Certificate has been remediated to this file.")
+}
+
+// Outcomes of verifying a previously affected Certificate.
+const (
+	// verifyRemediated means the Secret holds a certificate with a new
+	// serial number, which is not affected.
+	verifyRemediated = "Remediated"
+	// verifyDeleted means the Certificate or its Secret has been deleted,
+	// so there is no longer an affected certificate to serve.
+	verifyDeleted = "Deleted"
+	// verifyUnchanged means the Secret still holds the affected certificate.
+	verifyUnchanged = "Unchanged"
+	// verifyStillAffected means the Secret holds a new certificate, which
+	// is also affected.
+	verifyStillAffected = "StillAffected"
+	// verifyFailed means the Certificate could not be checked.
+	verifyFailed = "Failed"
+)
+
+// verificationReport is written by --verification-report-file, recording
+// whether every Certificate affected in a previous report has been
+// remediated, for sign-off of an incident response.
+type verificationReport struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+	RunID         string    `json:"runId"`
+	// PreviousReport is the path of the report that was verified, and
+	// PreviousRunID the ID of the run that wrote it.
+	PreviousReport string `json:"previousReport"`
+	PreviousRunID  string `json:"previousRunId,omitempty"`
+	// Passed is true if every Certificate was Remediated or Deleted.
+	Passed       bool                      `json:"passed"`
+	Counts       map[string]int            `json:"counts"`
+	Certificates []verificationCertificate `json:"certificates"`
+}
+
+// verificationCertificate is the outcome of verifying a single previously
+// affected Certificate.
+type verificationCertificate struct {
+	Namespace      string `json:"namespace"`
+	Name           string `json:"name"`
+	SecretName     string `json:"secretName"`
+	PreviousSerial string `json:"previousSerial"`
+	// Serial is the serial number of the certificate in the Secret now, if
+	// it could be read.
+	Serial  string `json:"serial,omitempty"`
+	Outcome string `json:"outcome"`
+	// Detail explains the outcome.
+	Detail string `json:"detail,omitempty"`
+}
+
+// runVerify implements the 'verify' command, which re-reads the Secret of
+// every Certificate affected in --previous-report and confirms that each now
+// holds a new certificate that is not affected. It returns an error if any
+// has not been remediated, so that it can gate sign-off in CI.
+func runVerify(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+	if previousReportFile == "" {
+		return fmt.Errorf("--previous-report must be specified")
+	}
+	previous, err := readReport(previousReportFile)
+	if err != nil {
+		return err
+	}
+	if previous.Redacted {
+		return fmt.Errorf("report %q was written with --redact, so the Certificates in it cannot be found", previousReportFile)
+	}
+	requireAffectedSerialsFile()
+
+	var cl client.Client
+	if fixturesDir != "" {
+		sim, err := loadFixtures(fixturesDir)
+		if err != nil {
+			return err
+		}
+		cl = sim
+	} else {
+		cfg := restConfig()
+		if cl, err = newClient(cfg); err != nil {
+			return err
+		}
+	}
+	serials, _, err := loadSerials()
+	if err != nil {
+		return err
+	}
+	s := newScanner(cl, serials)
+
+	ctx := context.Background()
+	r := &verificationReport{
+		SchemaVersion:  verificationReportSchemaVersion,
+		GeneratedAt:    time.Now().UTC(),
+		RunID:          runID,
+		PreviousReport: previousReportFile,
+		Passed:         true,
+		Counts:         make(map[string]int),
+		Certificates:   []verificationCertificate{},
+	}
+	if previous.Run != nil {
+		r.PreviousRunID = previous.Run.RunID
+	}
+	for _, a := range previous.Affected {
+		v := verifyCertificate(ctx, cl, s, a, previous.SecretsOnly)
+		key := a.Namespace + "/" + a.Name
+		switch v.Outcome {
+		case verifyRemediated, verifyDeleted:
+			log.Printf("%s: %s, %s", key, v.Outcome, v.Detail)
+		default:
+			r.Passed = false
+			log.Printf("!!!!! %s: %s, %s !!!!!", key, v.Outcome, v.Detail)
+		}
+		r.Counts[v.Outcome]++
+		r.Certificates = append(r.Certificates, v)
+	}
+
+	if verificationReportFile != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(verificationReportFile, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("error writing verification report: %w", err)
+		}
+		log.Printf("Wrote verification report to %q", verificationReportFile)
+	}
+	remediated := r.Counts[verifyRemediated] + r.Counts[verifyDeleted]
+	if !r.Passed {
+		return fmt.Errorf("verification FAILED: %d of %d previously affected Certificates have been remediated", remediated, len(r.Certificates))
+	}
+	log.Printf("Verification PASSED: all %d previously affected Certificates have been remediated", len(r.Certificates))
+	return nil
+}
+
+// verifyCertificate checks whether a, an affected Certificate from a
+// previous report, has been remediated. If secretsOnly is set, a names a
+// Secret rather than a Certificate.
+func verifyCertificate(ctx context.Context, cl client.Client, s *scanner.Scanner, a reportCertificate, secretsOnly bool) verificationCertificate {
+	v := verificationCertificate{Namespace: a.Namespace, Name: a.Name, SecretName: a.SecretName, PreviousSerial: a.Serial}
+	crt := capi.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: a.Namespace, Name: a.Name},
+		Spec:       capi.CertificateSpec{SecretName: a.SecretName},
+	}
+	if !secretsOnly {
+		err := cl.Get(ctx, client.ObjectKey{Namespace: a.Namespace, Name: a.Name}, &crt)
+		switch {
+		case apierrors.IsNotFound(err):
+			v.Outcome, v.Detail = verifyDeleted, "the Certificate has been deleted"
+			return v
+		case err != nil:
+			v.Outcome, v.Detail = verifyFailed, scrubSecrets(err.Error())
+			return v
+		}
+		v.SecretName = crt.Spec.SecretName
+	}
+	secret := &core.Secret{}
+	err := cl.Get(ctx, client.ObjectKey{Namespace: a.Namespace, Name: v.SecretName}, secret)
+	switch {
+	case apierrors.IsNotFound(err):
+		v.Outcome, v.Detail = verifyDeleted, fmt.Sprintf("Secret %s has been deleted", v.SecretName)
+		return v
+	case err != nil:
+		v.Outcome, v.Detail = verifyFailed, scrubSecrets(err.Error())
+		return v
+	}
+	serial, verdict, err := s.CheckSecret(ctx, crt, secret)
+	if serial != nil {
+		v.Serial = fmt.Sprintf("%x", serial)
+	}
+	switch {
+	case err != nil:
+		v.Outcome, v.Detail = verifyFailed, scrubSecrets(err.Error())
+	case v.Serial == a.Serial:
+		v.Outcome, v.Detail = verifyUnchanged, "the Secret still holds the affected certificate"
+	case verdict.Affected:
+		v.Outcome, v.Detail = verifyStillAffected, fmt.Sprintf("the new certificate is also affected: %s", verdict.Reason)
+	default:
+		v.Outcome, v.Detail = verifyRemediated, fmt.Sprintf("new serial number %s is not affected", v.Serial)
+	}
+	return v
+}
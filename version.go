@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"net/url"
+	"runtime/debug"
+)
+
+// version is the version of the tool, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = ""
+
+// toolVersion returns the version of the tool, falling back to the module
+// version it was built from, such as when installed with 'go get'.
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// secretFlags are the flags whose values may contain credentials, such as
+// webhook URLs that embed a token.
+var secretFlags = map[string]bool{
+	"notify-webhook-url":     true,
+	"slack-webhook-url":      true,
+	"teams-webhook-url":      true,
+	"remediator-webhook-url": true,
+	"otlp-headers":           true,
+}
+
+// runParameters returns the flags the tool was run with, other than those
+// left at their defaults. Values that may contain credentials are replaced,
+// and credentials are removed from any other URLs.
+func runParameters() map[string]string {
+	params := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] {
+			value = "redacted"
+		} else if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+			if u.User != nil {
+				u.User = url.User("redacted")
+			}
+			if u.RawQuery != "" {
+				u.RawQuery = "redacted"
+			}
+			value = u.String()
+		}
+		params[f.Name] = value
+	})
+	return params
+}
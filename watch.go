@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+
+	capi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1alpha2"
+	cmclient "github.com/jetstack/cert-manager/pkg/client/clientset/versioned"
+	cminformers "github.com/jetstack/cert-manager/pkg/client/informers/externalversions"
+	cmlisters "github.com/jetstack/cert-manager/pkg/client/listers/certmanager/v1alpha2"
+	"github.com/jetstack/letsencrypt-caa-bug-checker/pkg/scanner"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// certificatesBySecretIndex indexes Certificates by the namespace/name of the
+// Secret they store their certificate in.
+const certificatesBySecretIndex = "secret"
+
+// watcher keeps an in-memory index of which Certificates are affected, using
+// shared informers to re-evaluate only the Certificates and Secrets that
+// change rather than periodically re-listing everything.
+type watcher struct {
+	client  client.Client
+	serials scanner.SerialSource
+
+	certIndexer  cache.Indexer
+	certLister   cmlisters.CertificateLister
+	secretLister corelisters.SecretLister
+	queue        workqueue.RateLimitingInterface
+
+	// affected holds the serial number of each currently affected Certificate,
+	// keyed by namespace/name. It is only accessed by the worker goroutine.
+	affected map[string]string
+}
+
+// runWatch starts the informer based incremental mode, and blocks until ctx
+// is cancelled.
+func runWatch(ctx context.Context, cfg *rest.Config, cl client.Client, serials scanner.SerialSource) error {
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building Kubernetes client: %w", err)
+	}
+	cmClient, err := cmclient.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building cert-manager client: %w", err)
+	}
+	kubeFactory := informers.NewSharedInformerFactory(kubeClient, watchResyncPeriod)
+	cmFactory := cminformers.NewSharedInformerFactory(cmClient, watchResyncPeriod)
+	certInformer := cmFactory.Certmanager().V1alpha2().Certificates()
+	secretInformer := kubeFactory.Core().V1().Secrets()
+
+	w := &watcher{
+		client:       cl,
+		serials:      serials,
+		certIndexer:  certInformer.Informer().GetIndexer(),
+		certLister:   certInformer.Lister(),
+		secretLister: secretInformer.Lister(),
+		queue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "certificates"),
+		affected:     make(map[string]string),
+	}
+	defer w.queue.ShutDown()
+
+	if err := certInformer.Informer().AddIndexers(cache.Indexers{
+		certificatesBySecretIndex: func(obj interface{}) ([]string, error) {
+			crt := obj.(*capi.Certificate)
+			return []string{crt.Namespace + "/" + crt.Spec.SecretName}, nil
+		},
+	}); err != nil {
+		return err
+	}
+	certInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueue,
+		UpdateFunc: func(_, obj interface{}) { w.enqueue(obj) },
+		DeleteFunc: w.enqueue,
+	})
+	secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueueCertificatesForSecret,
+		UpdateFunc: func(_, obj interface{}) { w.enqueueCertificatesForSecret(obj) },
+		DeleteFunc: w.enqueueCertificatesForSecret,
+	})
+
+	synced := []cache.InformerSynced{certInformer.Informer().HasSynced, secretInformer.Informer().HasSynced}
+	watching := "Certificates and Secrets"
+	if watchIssuance {
+		requestInformer := cmFactory.Certmanager().V1alpha2().CertificateRequests()
+		synced = append(synced, w.watchIssuedCertificates(ctx, requestInformer.Informer(), requestInformer.Lister()))
+		watching = "Certificates, Secrets and CertificateRequests"
+	}
+
+	kubeFactory.Start(ctx.Done())
+	cmFactory.Start(ctx.Done())
+	log.Printf("Waiting for informer caches to sync...")
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("timed out waiting for informer caches to sync")
+	}
+	log.Printf("Informer caches synced, watching for changes to %s", watching)
+
+	go wait.Until(func() {
+		for w.processNextItem(ctx) {
+		}
+	}, time.Second, ctx.Done())
+	<-ctx.Done()
+	return nil
+}
+
+// watchResyncPeriod is how often the informers re-deliver every object, as a
+// safety net against missed events.
+const watchResyncPeriod = time.Hour
+
+func (w *watcher) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	w.queue.Add(key)
+}
+
+func (w *watcher) enqueueCertificatesForSecret(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	certs, err := w.certIndexer.ByIndex(certificatesBySecretIndex, key)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, crt := range certs {
+		w.enqueue(crt)
+	}
+}
+
+func (w *watcher) processNextItem(ctx context.Context) bool {
+	key, shutdown := w.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.queue.Done(key)
+	if err := w.evaluate(ctx, key.(string)); err != nil {
+		log.Printf("Failed to evaluate Certificate %s: %v", key, err)
+		w.queue.AddRateLimited(key)
+		return true
+	}
+	w.queue.Forget(key)
+	return true
+}
+
+// evaluate re-checks the Certificate with the given namespace/name key and
+// logs any change in whether it is affected. If --renew is set, a renewal is
+// triggered for Certificates that have newly become affected.
+func (w *watcher) evaluate(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	crt, err := w.certLister.Certificates(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		w.markUnaffected(key, "Certificate has been deleted")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if crt.DeletionTimestamp != nil {
+		w.markUnaffected(key, "Certificate is being deleted")
+		return nil
+	}
+	secret, err := w.secretLister.Secrets(namespace).Get(crt.Spec.SecretName)
+	if apierrors.IsNotFound(err) {
+		w.markUnaffected(key, "Secret has been deleted")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cert, err := scanner.DecodeCertificate(secret)
+	if err != nil {
+		log.Printf("Unable to check Secret %s/%s for Certificate %s: %v", secret.Namespace, secret.Name, key, err)
+		return nil
+	}
+	s := newScanner(w.client, w.serials)
+	checked := cert.SerialNumber
+	verdict, err := s.Check(ctx, *crt, cert)
+	if err == nil && !verdict.Affected {
+		var ks *big.Int
+		if ks, verdict, err = s.CheckKeystores(ctx, *crt, secret); verdict.Affected {
+			checked = ks
+		}
+	}
+	if err != nil {
+		return err
+	}
+	serial := fmt.Sprintf("%x", checked)
+	eventStream.OnCertificateChecked(*crt, checked, verdict)
+	if !verdict.Affected {
+		if err := scanner.VerifyKeyPair(secret, cert); err != nil {
+			log.Printf("Secret %s/%s for Certificate %s needs manual intervention: %v", secret.Namespace, secret.Name, key, err)
+		}
+		w.markUnaffected(key, fmt.Sprintf("new serial number %s is not affected", serial))
+		return nil
+	}
+	previous, reissued := w.affected[key]
+	if previous == serial {
+		return nil
+	}
+	w.affected[key] = serial
+	eventStream.OnAffectedFound(scanner.AffectedCertificate{Certificate: *crt, Serial: serial, Reason: verdict.Reason, Warning: verdict.Warning})
+	if reissued {
+		log.Printf("!!!!! Certificate %s was issued a new certificate, but it is still AFFECTED (serial number: %s, previously %s, reason: %s) !!!!!", key, serial, previous, verdict.Reason)
+	} else {
+		log.Printf("!!!!! Certificate %s is AFFECTED (serial number: %s, reason: %s), %d affected certificates in total !!!!!", key, serial, verdict.Reason, len(w.affected))
+	}
+	f := finding{Namespace: crt.Namespace, Name: crt.Name, SecretName: crt.Spec.SecretName, IssuerName: crt.Spec.IssuerRef.Name, IssuerKind: crt.Spec.IssuerRef.Kind, Serial: serial, Reason: verdict.Reason, Warning: verdict.Warning, Reissued: reissued, FoundAt: time.Now().UTC()}
+	if source, ok := scanner.ReplicationSource(secret); ok {
+		log.Printf("Secret %s/%s is a replica of Secret %s, and will be fixed by replication", secret.Namespace, secret.Name, source)
+		f.ReplicatedFrom = source.String()
+	}
+	certs, err := w.certLister.Certificates(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, other := range certs {
+		if other.Name != crt.Name && other.Spec.SecretName == crt.Spec.SecretName {
+			f.SharesSecretWith = append(f.SharesSecretWith, other.Name)
+		}
+	}
+	sort.Strings(f.SharesSecretWith)
+	if renew && len(f.SharesSecretWith) > 0 {
+		logSharedSecret(*crt, f.SharesSecretWith)
+	}
+	if renew && f.Warning != "" && len(f.SharesSecretWith) == 0 {
+		logUntrusted(*crt, f.Warning)
+	}
+	if annotation, ok := scanner.PausedBy(crt, pausedAnnotations()); ok {
+		f.PausedBy = annotation
+		if renew && len(f.SharesSecretWith) == 0 && f.ReplicatedFrom == "" && f.Warning == "" {
+			logPaused(*crt, annotation)
+		}
+	}
+	if checkIssuers() {
+		problem, err := scanner.IssuerProblem(ctx, w.client, acmeServers(), crt)
+		if err != nil {
+			log.Printf("WARNING: unable to check the issuer of Certificate %s: %v", key, err)
+		}
+		f.IssuerProblem = problem
+		if renew && problem != "" && len(f.SharesSecretWith) == 0 && f.ReplicatedFrom == "" && f.Warning == "" && f.PausedBy == "" {
+			logIssuerProblem(*crt, problem)
+		}
+	}
+	f.Suggestions = suggestRemediation(scanner.AffectedCertificate{Certificate: *crt, ReplicatedFrom: f.ReplicatedFrom, SharesSecretWith: f.SharesSecretWith, PausedBy: f.PausedBy, Warning: f.Warning, IssuerProblem: f.IssuerProblem})
+	if !renew || f.ReplicatedFrom != "" || len(f.SharesSecretWith) > 0 || f.PausedBy != "" || f.Warning != "" || f.IssuerProblem != "" {
+		notifyCertificateAffected(ctx, f)
+		return nil
+	}
+	if reason := newDuplicateChecker(w.client).blocks(ctx, *crt); reason != "" {
+		// Forget the serial so that the renewal is considered again at the
+		// next resync, once older certificates may have left the window.
+		delete(w.affected, key)
+		f.RenewalError = "not renewed, as " + reason
+		notifyCertificateAffected(ctx, f)
+		return nil
+	}
+	log.Printf("Triggering renewal of Certificate %s", key)
+	if err := renewCertificate(ctx, w.client, *crt.DeepCopy()); err != nil {
+		// Forget the serial so that the renewal is retried.
+		delete(w.affected, key)
+		return fmt.Errorf("failed to renew certificate: %w", err)
+	}
+	f.RenewalTriggered = true
+	notifyCertificateAffected(ctx, f)
+	return nil
+}
+
+func (w *watcher) markUnaffected(key, reason string) {
+	if _, ok := w.affected[key]; !ok {
+		return
+	}
+	delete(w.affected, key)
+	log.Printf("Certificate %s is no longer affected (%s), %d affected certificates remaining", key, reason, len(w.affected))
+}